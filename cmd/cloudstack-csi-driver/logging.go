@@ -0,0 +1,23 @@
+package main
+
+import (
+	flag "github.com/spf13/pflag"
+	logsapi "k8s.io/component-base/logs/api/v1"
+)
+
+// addLoggingFlags adds the logging flags of Kubernetes components to fs,
+// and returns the configuration they set. --log-format is accepted as an
+// alias of --logging-format.
+func addLoggingFlags(fs *flag.FlagSet) *logsapi.LoggingConfiguration {
+	c := logsapi.NewLoggingConfiguration()
+	logsapi.AddFlags(c, fs)
+	fs.SetNormalizeFunc(func(_ *flag.FlagSet, name string) flag.NormalizedName {
+		if name == "log-format" {
+			name = "logging-format"
+		}
+
+		return flag.NormalizedName(name)
+	})
+
+	return c
+}
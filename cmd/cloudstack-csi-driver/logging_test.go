@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	flag "github.com/spf13/pflag"
+	logsapi "k8s.io/component-base/logs/api/v1"
+)
+
+func TestAddLoggingFlags(t *testing.T) {
+	cases := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{"default", nil, logsapi.DefaultLogFormat},
+		{"logging-format", []string{"--logging-format=json"}, logsapi.JSONLogFormat},
+		{"log-format", []string{"--log-format=json"}, logsapi.JSONLogFormat},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			config := addLoggingFlags(fs)
+			if err := fs.Parse(c.args); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config.Format != c.expected {
+				t.Errorf("expected log format %q, got %q", c.expected, config.Format)
+			}
+		})
+	}
+}
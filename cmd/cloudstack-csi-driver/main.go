@@ -3,15 +3,22 @@
 // To get usage information:
 //
 //	cloudstack-csi-driver -h
+//
+// To find the CloudStack volumes whose PersistentVolume was deleted:
+//
+//	cloudstack-csi-driver reconcile -h
 package main
 
 import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	flag "github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/component-base/featuregate"
 	"k8s.io/component-base/logs"
 	logsapi "k8s.io/component-base/logs/api/v1"
@@ -40,8 +47,7 @@ func main() {
 		klog.ErrorS(err, "failed to add feature gates")
 	}
 
-	c := logsapi.NewLoggingConfiguration()
-	logsapi.AddFlags(c, fs)
+	c := addLoggingFlags(fs)
 
 	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
 		cmd = os.Args[1]
@@ -51,8 +57,12 @@ func main() {
 	switch cmd {
 	case string(driver.ControllerMode), string(driver.NodeMode), string(driver.AllMode):
 		options.Mode = driver.Mode(cmd)
+	case reconcileCommand:
+		runReconcile(args)
+
+		return
 	default:
-		klog.Errorf("Unknown driver mode %s: Expected %s, %s, or %s", cmd, driver.ControllerMode, driver.NodeMode, driver.AllMode)
+		klog.Errorf("Unknown driver mode %s: Expected %s, %s, %s, or %s", cmd, driver.ControllerMode, driver.NodeMode, driver.AllMode, reconcileCommand)
 		klog.FlushAndExit(klog.ExitFlushTimeout, 0)
 	}
 
@@ -91,9 +101,27 @@ func main() {
 		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 	}
 	logger.Info("Successfully read CloudStack configuration", "cloudstackconfig", options.CloudStackConfig)
-
-	ctx := klog.NewContext(context.Background(), logger)
+	config.RetryBackoff = wait.Backoff{
+		Duration: options.APIRetryDelay,
+		Factor:   cloud.DefaultRetryBackoff.Factor,
+		Jitter:   options.APIRetryJitter,
+		Steps:    options.APIRetryAttempts,
+	}
+	config.AsyncJobPollInterval = options.AsyncJobPollInterval
+	config.AsyncJobTimeout = options.AsyncJobTimeout
+	config.MaxInFlightCalls = options.APIMaxInFlight
+	config.QPS = options.APIQPS
+	config.Burst = options.APIBurst
+	config.NodeID = options.NodeID
+	config.ZoneCacheTTL = options.ZoneCacheTTL
+
+	// Stop on SIGTERM, letting the calls in progress complete.
+	ctx, stop := signal.NotifyContext(klog.NewContext(context.Background(), logger), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 	csConnector := cloud.New(config)
+	if options.CloudStackConfigReloadInterval > 0 {
+		go cloud.WatchConfig(ctx, csConnector, options.CloudStackConfig, options.CloudStackConfigReloadInterval)
+	}
 
 	d, err := driver.New(ctx, csConnector, &options, nil)
 	if err != nil {
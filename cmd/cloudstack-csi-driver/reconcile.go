@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	flag "github.com/spf13/pflag"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/component-base/logs"
+	logsapi "k8s.io/component-base/logs/api/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud"
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/reconcile"
+)
+
+const reconcileCommand = "reconcile"
+
+// runReconcile runs the reconcile subcommand, which finds, and optionally
+// deletes, the CloudStack volumes whose PersistentVolume no longer exists.
+func runReconcile(args []string) {
+	fs := flag.NewFlagSet("cloudstack-csi-driver "+reconcileCommand, flag.ExitOnError)
+	var (
+		cloudStackConfig = fs.String("cloudstack-config", "./cloud-config", "Path to CloudStack configuration file")
+		kubeconfig       = fs.String("kubeconfig", "", "Path to the Kubernetes configuration file. Defaults to the in-cluster configuration.")
+		config           reconcile.Config
+	)
+	fs.StringVar(&config.ClusterID, "cluster-id", "", "Only consider the volumes tagged with this cluster ID, as set by the controller --cluster-id flag.")
+	fs.DurationVar(&config.MinAge, "min-age", reconcile.DefaultMinAge, "Age under which volumes are never considered orphaned, as their PersistentVolume may not be created yet.")
+	fs.BoolVar(&config.DryRun, "dry-run", true, "Only report the orphaned volumes, without deleting them.")
+	c := addLoggingFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		klog.ErrorS(err, "Failed to parse options")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+
+	logs.InitLogs()
+	logger := klog.Background()
+	if err := logsapi.ValidateAndApply(c, nil); err != nil {
+		logger.Error(err, "LoggingConfiguration is invalid")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+
+	csConfig, err := cloud.ReadConfig(*cloudStackConfig)
+	if err != nil {
+		logger.Error(err, "Cannot read CloudStack configuration")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+	restConfig, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	if err != nil {
+		logger.Error(err, "Cannot read Kubernetes configuration")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+	restConfig.UserAgent = "cloudstack-csi-driver-" + reconcileCommand
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		logger.Error(err, "Cannot create Kubernetes client")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+
+	ctx, stop := signal.NotifyContext(klog.NewContext(context.Background(), logger), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	orphans, err := reconcile.Run(ctx, cloud.New(csConfig), kubeClient, config)
+	if err != nil {
+		logger.Error(err, "Reconciliation failed")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+	logger.Info("Reconciliation completed", "orphanedVolumes", len(orphans), "dryRun", config.DryRun)
+}
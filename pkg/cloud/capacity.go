@@ -0,0 +1,92 @@
+package cloud
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"k8s.io/klog/v2"
+)
+
+// storagePoolUp is the state of the primary storages accepting new volumes.
+const storagePoolUp = "Up"
+
+// GetAvailableCapacity returns the size, in bytes, that can still be
+// allocated on the primary storages of the zone matching the storage
+// tags of the disk offering. Empty zoneID or diskOfferingID match all
+// the zones or all the primary storages.
+func (c *client) GetAvailableCapacity(ctx context.Context, zoneID, diskOfferingID string) (int64, error) {
+	logger := klog.FromContext(ctx)
+
+	var tags []string
+	if diskOfferingID != "" {
+		offering, err := c.GetDiskOfferingByID(ctx, diskOfferingID)
+		if err != nil {
+			return 0, err
+		}
+		tags = splitTags(offering.Tags)
+	}
+
+	p := c.Pool.NewListStoragePoolsParams()
+	params := map[string]string{}
+	if zoneID != "" {
+		p.SetZoneid(zoneID)
+		params["zoneid"] = zoneID
+	}
+	logger.V(2).Info("CloudStack API call", "command", "ListStoragePools", "params", params)
+	var l *cloudstack.ListStoragePoolsResponse
+	err := c.retry(ctx, "ListStoragePools", isTransient, func() (err error) {
+		l, err = c.Pool.ListStoragePools(p)
+
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var available int64
+	for _, pool := range l.StoragePools {
+		if pool.State != storagePoolUp || !hasTags(splitTags(pool.Tags), tags) {
+			continue
+		}
+		available += poolAvailableCapacity(pool)
+	}
+
+	return available, nil
+}
+
+// poolAvailableCapacity returns the size left to allocate on the pool,
+// taking its over-provisioning factor into account.
+func poolAvailableCapacity(pool *cloudstack.StoragePool) int64 {
+	factor, err := strconv.ParseFloat(pool.Overprovisionfactor, 64)
+	if err != nil || factor <= 0 {
+		factor = 1
+	}
+
+	return max(int64(float64(pool.Disksizetotal)*factor)-pool.Disksizeallocated, 0)
+}
+
+// splitTags splits a comma-separated list of storage tags.
+func splitTags(tags string) []string {
+	var result []string
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			result = append(result, tag)
+		}
+	}
+
+	return result
+}
+
+// hasTags tells if all the wanted tags are in tags.
+func hasTags(tags, wanted []string) bool {
+	for _, w := range wanted {
+		if !slices.Contains(tags, w) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,72 @@
+package cloud
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/golang/mock/gomock"
+)
+
+func TestGetAvailableCapacity(t *testing.T) {
+	const (
+		zoneID         = "a1887604-237c-4212-a9cd-94620b7880fa"
+		diskOfferingID = "9743fd77-0f5d-4ef9-b2f8-f194235c769c"
+		gib            = 1024 * 1024 * 1024
+	)
+	pools := []*cloudstack.StoragePool{
+		// 200 GiB with a factor of 2, 150 GiB allocated: 250 GiB left.
+		{Id: "pool-1", State: "Up", Tags: "ssd", Disksizetotal: 200 * gib, Disksizeallocated: 150 * gib, Overprovisionfactor: "2.0"},
+		// Overcommitted: nothing left.
+		{Id: "pool-2", State: "Up", Tags: "ssd,fast", Disksizetotal: 100 * gib, Disksizeallocated: 120 * gib, Overprovisionfactor: "1"},
+		{Id: "pool-3", State: "Up", Tags: "hdd", Disksizetotal: 500 * gib, Disksizeallocated: 100 * gib},
+		{Id: "pool-4", State: "Maintenance", Tags: "ssd", Disksizetotal: 500 * gib},
+	}
+
+	cases := []struct {
+		name           string
+		diskOfferingID string
+		tags           string
+		expected       int64
+	}{
+		{"all storages", "", "", 650 * gib},
+		{"offering without tags", diskOfferingID, "", 650 * gib},
+		{"offering with tags", diskOfferingID, "ssd", 250 * gib},
+		{"offering with several tags", diskOfferingID, "fast, ssd", 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			cs := cloudstack.NewMockClient(ctrl)
+			ps := cs.Pool.(*cloudstack.MockPoolServiceIface)
+			ds := cs.DiskOffering.(*cloudstack.MockDiskOfferingServiceIface)
+
+			if c.diskOfferingID != "" {
+				offeringParams := &cloudstack.ListDiskOfferingsParams{}
+				ds.EXPECT().NewListDiskOfferingsParams().Return(offeringParams)
+				ds.EXPECT().ListDiskOfferings(offeringParams).Return(&cloudstack.ListDiskOfferingsResponse{
+					Count:         1,
+					DiskOfferings: []*cloudstack.DiskOffering{{Id: c.diskOfferingID, Tags: c.tags}},
+				}, nil)
+			}
+			params := &cloudstack.ListStoragePoolsParams{}
+			ps.EXPECT().NewListStoragePoolsParams().Return(params)
+			ps.EXPECT().ListStoragePools(params).Return(&cloudstack.ListStoragePoolsResponse{
+				Count:        len(pools),
+				StoragePools: pools,
+			}, nil)
+
+			client := &client{CloudStackClient: cs}
+			available, err := client.GetAvailableCapacity(context.Background(), zoneID, c.diskOfferingID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if available != c.expected {
+				t.Errorf("expected %d bytes available, got %d", c.expected, available)
+			}
+			if got, _ := params.GetZoneid(); got != zoneID {
+				t.Errorf("expected zone %s, got %s", zoneID, got)
+			}
+		})
+	}
+}
@@ -4,25 +4,47 @@ package cloud
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"net/http"
+	"time"
 
 	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 // Interface is the CloudStack client interface.
 type Interface interface {
 	GetNodeInfo(ctx context.Context, vmName string) (*VM, error)
 	GetVMByID(ctx context.Context, vmID string) (*VM, error)
+	GetHostByID(ctx context.Context, hostID string) (*Host, error)
 
 	ListZonesID(ctx context.Context) ([]string, error)
+	Ping(ctx context.Context) error
+
+	GetDiskOfferingByID(ctx context.Context, diskOfferingID string) (*DiskOffering, error)
+	GetDiskOfferingByName(ctx context.Context, name string) (string, error)
+	GetAvailableCapacity(ctx context.Context, zoneID, diskOfferingID string) (int64, error)
 
 	GetVolumeByID(ctx context.Context, volumeID string) (*Volume, error)
 	GetVolumeByName(ctx context.Context, name string) (*Volume, error)
+	ListVolumes(ctx context.Context, page, pageSize int) ([]*Volume, int, error)
 	CreateVolume(ctx context.Context, diskOfferingID, zoneID, name string, sizeInGB int64) (string, error)
+	CreateVolumeFromSnapshot(ctx context.Context, zoneID, name, snapshotID string) (string, error)
+	CloneVolume(ctx context.Context, volumeID, name string) (string, error)
+	CreateVolumeTags(ctx context.Context, volumeID string, tags map[string]string) error
 	DeleteVolume(ctx context.Context, id string) error
 	AttachVolume(ctx context.Context, volumeID, vmID string) (string, error)
+	AttachVolumeToDevice(ctx context.Context, volumeID, vmID string, deviceID int64) (string, error)
 	DetachVolume(ctx context.Context, volumeID string) error
+	ForceDetachVolume(ctx context.Context, volumeID string) error
 	ExpandVolume(ctx context.Context, volumeID string, newSizeInGB int64) error
+
+	GetSnapshotByID(ctx context.Context, snapshotID string) (*Snapshot, error)
+	GetSnapshotByName(ctx context.Context, name string) (*Snapshot, error)
+	ListSnapshots(ctx context.Context, volumeID string) ([]*Snapshot, error)
+	CreateSnapshot(ctx context.Context, volumeID, name string) (*Snapshot, error)
+	DeleteSnapshot(ctx context.Context, snapshotID string) error
 }
 
 // Volume represents a CloudStack volume.
@@ -33,37 +55,188 @@ type Volume struct {
 	// Size in Bytes
 	Size int64
 
+	State string
+
 	DiskOfferingID string
 	ZoneID         string
 
 	VirtualMachineID string
 	DeviceID         string
+
+	// Hypervisor is the hypervisor of the primary storage of the volume,
+	// e.g. KVM. It is empty until the volume is created on a storage.
+	Hypervisor string
+
+	// SnapshotID is the ID of the snapshot the volume was created from, if any.
+	SnapshotID string
+
+	Tags      map[string]string
+	CreatedAt time.Time
+}
+
+// Snapshot represents a CloudStack volume snapshot.
+type Snapshot struct {
+	ID   string
+	Name string
+
+	VolumeID string
+	ZoneID   string
+
+	// Size in Bytes
+	Size int64
+
+	State     string
+	CreatedAt time.Time
+}
+
+// DiskOffering represents a CloudStack disk offering.
+type DiskOffering struct {
+	ID   string
+	Name string
+
+	// Customized is true when the size of the volumes is chosen at
+	// creation, rather than set by the offering.
+	Customized bool
+	// SizeStrict is true when the size of the volumes cannot be changed.
+	SizeStrict bool
+	// Size in GB of the volumes, for offerings that are not customized.
+	SizeInGB int64
+
+	// Tags is the comma-separated list of storage tags.
+	Tags string
+
+	// ProvisioningType is how the volumes are allocated on the primary
+	// storage: thin, sparse or fat. It is empty when not reported.
+	ProvisioningType string
 }
 
+// Volume states.
+const (
+	// VolumeAllocated is the state of the volumes not created on a
+	// primary storage yet, i.e. never attached.
+	VolumeAllocated = "Allocated"
+	// VolumeReady is the state of the volumes created on a primary storage.
+	VolumeReady = "Ready"
+	// VolumeExpunging is the state of the volumes being deleted.
+	VolumeExpunging = "Expunging"
+)
+
+// VMRunning is the state of the running VMs.
+const VMRunning = "Running"
+
+// Snapshot states.
+const (
+	// SnapshotBackedUp is the state of the snapshots that are ready to use.
+	SnapshotBackedUp = "BackedUp"
+)
+
 // VM represents a CloudStack Virtual Machine.
 type VM struct {
 	ID     string
 	ZoneID string
+
+	// HostID is the ID of the host running the VM. It is only known to
+	// administrators.
+	HostID string
+
+	State string
+
+	// Hypervisor is the type of the hypervisor running the VM, e.g. KVM.
+	Hypervisor string
+}
+
+// Host represents a CloudStack hypervisor host.
+type Host struct {
+	ID        string
+	PodID     string
+	ClusterID string
 }
 
 // Specific errors.
 var (
-	ErrNotFound       = errors.New("not found")
-	ErrTooManyResults = errors.New("too many results")
+	ErrNotFound          = errors.New("not found")
+	ErrTooManyResults    = errors.New("too many results")
+	ErrMaxVolumesReached = errors.New("maximum number of attached volumes reached")
+	ErrDeviceIDInUse     = errors.New("device ID in use")
 )
 
 // client is the implementation of Interface.
 type client struct {
 	*cloudstack.CloudStackClient
-	projectID string
+	projectID       string
+	domainID        string
+	account         string
+	nodeID          string
+	retryBackoff    wait.Backoff
+	jobPollInterval time.Duration
+	jobTimeout      time.Duration
+	limiter         *limiter
+	zones           zoneCache
+
+	// verifySSL, tlsConfig, signer and failover are kept to reload the
+	// API URLs and keys, see WatchConfig.
+	verifySSL bool
+	tlsConfig *tls.Config
+	signer    *signingTransport
+	failover  *failoverTransport
+}
+
+// ownedParams are the parameters of the API calls listing or creating
+// resources owned by an account.
+type ownedParams interface {
+	SetAccount(account string)
+	SetDomainid(domainID string)
+}
+
+// setOwner scopes p to the configured domain and account, if any.
+func (c *client) setOwner(p ownedParams) {
+	if c.domainID == "" {
+		return
+	}
+	p.SetDomainid(c.domainID)
+	if c.account != "" {
+		p.SetAccount(c.account)
+	}
 }
 
 // New creates a new cloud connector, given its configuration.
 func New(config *Config) Interface {
 	csClient := &client{
-		projectID: config.ProjectID,
+		projectID:       config.ProjectID,
+		domainID:        config.DomainID,
+		account:         config.Account,
+		nodeID:          config.NodeID,
+		retryBackoff:    config.RetryBackoff,
+		jobPollInterval: config.AsyncJobPollInterval,
+		jobTimeout:      config.AsyncJobTimeout,
+		limiter:         newLimiter(config.MaxInFlightCalls, config.QPS, config.Burst),
+		zones:           zoneCache{ttl: config.ZoneCacheTTL},
+	}
+	if csClient.retryBackoff.Steps == 0 {
+		csClient.retryBackoff = DefaultRetryBackoff
+	}
+	if csClient.jobPollInterval == 0 {
+		csClient.jobPollInterval = DefaultAsyncJobPollInterval
+	}
+	if csClient.jobTimeout == 0 {
+		csClient.jobTimeout = DefaultAsyncJobTimeout
+	}
+	csClient.verifySSL = config.VerifySSL
+	csClient.tlsConfig = config.TLSConfig
+	if csClient.tlsConfig == nil {
+		csClient.tlsConfig = &tls.Config{InsecureSkipVerify: !config.VerifySSL} //nolint:gosec
+	}
+	csClient.failover = newFailoverTransport(parseEndpoints(config), csClient.tlsConfig)
+	csClient.signer = newSigningTransport(config.APIKey, config.SecretKey, csClient.failover)
+	httpClient := &http.Client{
+		Transport: &apiTransport{base: csClient.signer},
+		Timeout:   60 * time.Second,
 	}
-	csClient.CloudStackClient = cloudstack.NewAsyncClient(config.APIURL, config.APIKey, config.SecretKey, config.VerifySSL)
+	// The async jobs are polled by the client itself, see waitForJob.
+	csClient.CloudStackClient = cloudstack.NewClient(config.APIURL, config.APIKey, config.SecretKey, config.VerifySSL, cloudstack.WithHTTPClient(httpClient))
+	// The requests are signed again by the signingTransport, which only
+	// handles GET requests.
+	csClient.HTTPGETOnly = true
 
 	return csClient
 }
@@ -1,9 +1,14 @@
 package cloud
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net/url"
+	"time"
 
 	"gopkg.in/gcfg.v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 // Config holds CloudStack connection configuration.
@@ -13,6 +18,47 @@ type Config struct {
 	SecretKey string
 	VerifySSL bool
 	ProjectID string
+
+	// TLSConfig is the TLS configuration of the connections to the
+	// management servers, with the CA and client certificates of the
+	// configuration file. When nil, only VerifySSL is applied.
+	TLSConfig *tls.Config
+
+	// DomainID scopes the volumes, snapshots, disk offerings and VMs to
+	// a domain, and Account to an account of this domain.
+	DomainID string
+	Account  string
+
+	// FallbackAPIURLs are the API URLs of other management servers,
+	// used when the one at APIURL cannot be reached.
+	FallbackAPIURLs []string
+
+	// RetryBackoff is the backoff used to retry the API calls failing
+	// with a transient error. Defaults to DefaultRetryBackoff when
+	// Steps is zero.
+	RetryBackoff wait.Backoff
+
+	// AsyncJobPollInterval is the delay between two polls of the result
+	// of an async job, and AsyncJobTimeout the maximum time to wait for
+	// it. They default to DefaultAsyncJobPollInterval and
+	// DefaultAsyncJobTimeout.
+	AsyncJobPollInterval time.Duration
+	AsyncJobTimeout      time.Duration
+
+	// MaxInFlightCalls is the maximum number of API calls made at the
+	// same time, QPS the maximum rate of the calls, allowing bursts of
+	// Burst calls. Zero values disable the limits.
+	MaxInFlightCalls int
+	QPS              float64
+	Burst            int
+
+	// NodeID is the ID of the VM of the node, when known. It is used
+	// instead of looking it up in the metadata.
+	NodeID string
+
+	// ZoneCacheTTL is the time during which the list of zones is reused,
+	// e.g. DefaultZoneCacheTTL. Zero disables the cache.
+	ZoneCacheTTL time.Duration
 }
 
 // csConfig wraps the config for the CloudStack cloud provider.
@@ -21,12 +67,18 @@ type Config struct {
 // and in this cloudstack-csi-driver.
 type csConfig struct {
 	Global struct {
-		APIURL      string `gcfg:"api-url"`
-		APIKey      string `gcfg:"api-key"`
-		SecretKey   string `gcfg:"secret-key"`
-		SSLNoVerify bool   `gcfg:"ssl-no-verify"`
-		ProjectID   string `gcfg:"project-id"`
-		Zone        string `gcfg:"zone"`
+		APIURL      string   `gcfg:"api-url"`
+		FallbackURL []string `gcfg:"fallback-api-url"`
+		APIKey      string   `gcfg:"api-key"`
+		SecretKey   string   `gcfg:"secret-key"`
+		SSLNoVerify bool     `gcfg:"ssl-no-verify"`
+		CAFile      string   `gcfg:"ca-file"`
+		CertFile    string   `gcfg:"client-cert-file"`
+		KeyFile     string   `gcfg:"client-key-file"`
+		ProjectID   string   `gcfg:"project-id"`
+		DomainID    string   `gcfg:"domain-id"`
+		Account     string   `gcfg:"account"`
+		Zone        string   `gcfg:"zone"`
 	}
 }
 
@@ -38,11 +90,33 @@ func ReadConfig(configFilePath string) (*Config, error) {
 		return nil, fmt.Errorf("could not parse CloudStack config: %w", err)
 	}
 
+	for _, u := range append([]string{cfg.Global.APIURL}, cfg.Global.FallbackURL...) {
+		if _, err := url.Parse(u); err != nil {
+			return nil, fmt.Errorf("invalid CloudStack API URL: %w", err)
+		}
+	}
+
+	if cfg.Global.Account != "" && cfg.Global.DomainID == "" {
+		return nil, errors.New("invalid CloudStack config: account requires domain-id")
+	}
+	if cfg.Global.Account != "" && cfg.Global.ProjectID != "" {
+		return nil, errors.New("invalid CloudStack config: account and project-id are mutually exclusive")
+	}
+
+	tlsConfig, err := newTLSConfig(!cfg.Global.SSLNoVerify, cfg.Global.CAFile, cfg.Global.CertFile, cfg.Global.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CloudStack config: %w", err)
+	}
+
 	return &Config{
-		APIURL:    cfg.Global.APIURL,
-		APIKey:    cfg.Global.APIKey,
-		SecretKey: cfg.Global.SecretKey,
-		VerifySSL: !cfg.Global.SSLNoVerify,
-		ProjectID: cfg.Global.ProjectID,
+		APIURL:          cfg.Global.APIURL,
+		APIKey:          cfg.Global.APIKey,
+		SecretKey:       cfg.Global.SecretKey,
+		VerifySSL:       !cfg.Global.SSLNoVerify,
+		TLSConfig:       tlsConfig,
+		ProjectID:       cfg.Global.ProjectID,
+		DomainID:        cfg.Global.DomainID,
+		Account:         cfg.Global.Account,
+		FallbackAPIURLs: cfg.Global.FallbackURL,
 	}, nil
 }
@@ -0,0 +1,161 @@
+package cloud
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"k8s.io/klog/v2"
+)
+
+// credentials are the API keys signing the requests.
+type credentials struct {
+	apiKey    string
+	secretKey string
+}
+
+// signingTransport signs the API requests with the current credentials,
+// so that they can be rotated while requests are made. The requests must
+// be GET requests, whose parameters are all in the query.
+type signingTransport struct {
+	credentials atomic.Pointer[credentials]
+	base        http.RoundTripper
+}
+
+func newSigningTransport(apiKey, secretKey string, base http.RoundTripper) *signingTransport {
+	t := &signingTransport{base: base}
+	t.setCredentials(apiKey, secretKey)
+
+	return t
+}
+
+func (t *signingTransport) setCredentials(apiKey, secretKey string) {
+	t.credentials.Store(&credentials{apiKey: apiKey, secretKey: secretKey})
+}
+
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	creds := t.credentials.Load()
+	params := req.URL.Query()
+	params.Set("apiKey", creds.apiKey)
+	params.Del("signature")
+
+	// Same signature as cloudstack-go: HMAC SHA1 of the lowercased
+	// parameters, sorted by key.
+	s := cloudstack.EncodeValues(params)
+	mac := hmac.New(sha1.New, []byte(creds.secretKey))
+	mac.Write([]byte(strings.ToLower(s)))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	r := req.Clone(req.Context())
+	r.URL.RawQuery = s + "&signature=" + url.QueryEscape(signature)
+
+	return t.base.RoundTrip(r)
+}
+
+// WatchConfig reads the configuration file again every interval, until
+// ctx is done. When the API URLs or keys change, e.g. because they were
+// rotated in a mounted secret, the connector switches to them once they
+// are validated by an API call. Other settings, including the TLS
+// certificates, require a restart.
+func WatchConfig(ctx context.Context, connector Interface, configFilePath string, interval time.Duration) {
+	c, ok := connector.(*client)
+	if !ok {
+		return
+	}
+	logger := klog.FromContext(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := c.reloadConfig(ctx, configFilePath); err != nil {
+			logger.Error(err, "Cannot reload CloudStack configuration, keeping the current one", "cloudstackconfig", configFilePath)
+		}
+	}
+}
+
+// reloadConfig switches to the API URLs and keys of the configuration
+// file, if they changed and are valid.
+func (c *client) reloadConfig(ctx context.Context, configFilePath string) error {
+	logger := klog.FromContext(ctx)
+	config, err := ReadConfig(configFilePath)
+	if err != nil {
+		return err
+	}
+	if c.signer == nil || c.failover == nil {
+		return errors.New("connector does not support reloading")
+	}
+	endpoints := parseEndpoints(config)
+	if len(endpoints) == 0 {
+		return errors.New("no valid CloudStack API URL")
+	}
+
+	creds := c.signer.credentials.Load()
+	current := *c.failover.endpoints.Load()
+	if creds.apiKey == config.APIKey && creds.secretKey == config.SecretKey && sameEndpoints(current, endpoints) {
+		return nil
+	}
+
+	// Validate the new configuration with a client of its own.
+	httpClient := &http.Client{
+		Transport: &apiTransport{base: newSigningTransport(config.APIKey, config.SecretKey, newFailoverTransport(endpoints, c.tlsConfig))},
+		Timeout:   60 * time.Second,
+	}
+	cs := cloudstack.NewClient(config.APIURL, config.APIKey, config.SecretKey, c.verifySSL, cloudstack.WithHTTPClient(httpClient))
+	cs.HTTPGETOnly = true
+	p := cs.Zone.NewListZonesParams()
+	if err := c.call(ctx, "ListZones", func() error {
+		_, err := cs.Zone.ListZones(p)
+
+		return err
+	}); err != nil {
+		return fmt.Errorf("new CloudStack configuration not valid: %w", err)
+	}
+
+	c.signer.setCredentials(config.APIKey, config.SecretKey)
+	c.failover.setEndpoints(endpoints)
+	logger.Info("Reloaded CloudStack configuration", "cloudstackconfig", configFilePath)
+
+	return nil
+}
+
+// parseEndpoints returns the valid API URLs of the configuration.
+func parseEndpoints(config *Config) []*url.URL {
+	var endpoints []*url.URL
+	for _, u := range append([]string{config.APIURL}, config.FallbackAPIURLs...) {
+		endpoint, err := url.Parse(u)
+		if err != nil {
+			klog.ErrorS(err, "Ignoring invalid CloudStack API URL", "url", u)
+
+			continue
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	return endpoints
+}
+
+func sameEndpoints(a, b []*url.URL) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].String() != b[i].String() {
+			return false
+		}
+	}
+
+	return true
+}
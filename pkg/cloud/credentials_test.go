@@ -0,0 +1,134 @@
+package cloud
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+)
+
+// newSigningAPIServer returns a fake management server accepting the
+// requests signed with the given keys, and recording the API keys used.
+func newSigningAPIServer(t *testing.T, keys map[string]string) (*httptest.Server, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var used []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		apiKey := params.Get("apiKey")
+		signature := params.Get("signature")
+		params.Del("signature")
+		mac := hmac.New(sha1.New, []byte(keys[apiKey]))
+		mac.Write([]byte(strings.ToLower(cloudstack.EncodeValues(params))))
+		w.Header().Set("Content-Type", "application/json")
+		if _, ok := keys[apiKey]; !ok || signature != base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"listzonesresponse":{"errorcode":401,"errortext":"unable to verify user credentials"}}`))
+
+			return
+		}
+		mu.Lock()
+		used = append(used, apiKey)
+		mu.Unlock()
+		_, _ = w.Write([]byte(listZonesBody))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]string(nil), used...)
+	}
+}
+
+func writeConfig(t *testing.T, path, apiURL, apiKey, secretKey string) {
+	t.Helper()
+	writeFile(t, path, "[Global]\napi-url = "+apiURL+"\napi-key = "+apiKey+"\nsecret-key = "+secretKey+"\n")
+}
+
+func TestReloadConfig(t *testing.T) {
+	srv, used := newSigningAPIServer(t, map[string]string{
+		"key-1": "secret-1",
+		"key-2": "secret-2",
+	})
+	apiURL := srv.URL + "/client/api"
+	path := filepath.Join(t.TempDir(), "cloud-config")
+	writeConfig(t, path, apiURL, "key-1", "secret-1")
+
+	config, err := ReadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := New(config).(*client)
+	ctx := context.Background()
+	if _, err := c.ListZonesID(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Rotated keys.
+	writeConfig(t, path, apiURL, "key-2", "secret-2")
+	if err := c.reloadConfig(ctx, path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.ListZonesID(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Invalid keys are not used.
+	writeConfig(t, path, apiURL, "key-3", "secret-3")
+	if err := c.reloadConfig(ctx, path); err == nil {
+		t.Error("expected invalid keys to be refused")
+	}
+	if _, err := c.ListZonesID(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// key-2 is used twice: once to validate it, once by the call.
+	expected := []string{"key-1", "key-2", "key-2", "key-2"}
+	if got := used(); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected API keys %v, got %v", expected, got)
+	}
+}
+
+func TestReloadConfigURL(t *testing.T) {
+	keys := map[string]string{"key-1": "secret-1"}
+	oldSrv, oldUsed := newSigningAPIServer(t, keys)
+	newSrv, newUsed := newSigningAPIServer(t, keys)
+	path := filepath.Join(t.TempDir(), "cloud-config")
+	writeConfig(t, path, oldSrv.URL+"/client/api", "key-1", "secret-1")
+
+	config, err := ReadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := New(config).(*client)
+
+	writeConfig(t, path, newSrv.URL+"/client/api", "key-1", "secret-1")
+	if err := c.reloadConfig(context.Background(), path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.ListZonesID(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(oldUsed()) != 0 || len(newUsed()) != 2 {
+		t.Errorf("expected calls to the new URL only, got %d to the old one and %d to the new one", len(oldUsed()), len(newUsed()))
+	}
+
+	// An unreadable file keeps the current configuration.
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.reloadConfig(context.Background(), path); err == nil {
+		t.Error("expected an error")
+	}
+}
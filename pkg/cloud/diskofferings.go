@@ -0,0 +1,92 @@
+package cloud
+
+import (
+	"context"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"k8s.io/klog/v2"
+)
+
+func (c *client) GetDiskOfferingByID(ctx context.Context, diskOfferingID string) (*DiskOffering, error) {
+	logger := klog.FromContext(ctx)
+	p := c.DiskOffering.NewListDiskOfferingsParams()
+	p.SetId(diskOfferingID)
+	if c.projectID != "" {
+		p.SetProjectid(c.projectID)
+	}
+	if c.domainID != "" {
+		p.SetDomainid(c.domainID)
+	}
+	logger.V(2).Info("CloudStack API call", "command", "ListDiskOfferings", "params", map[string]string{
+		"id": diskOfferingID,
+	})
+	var l *cloudstack.ListDiskOfferingsResponse
+	err := c.retry(ctx, "ListDiskOfferings", isTransient, func() (err error) {
+		l, err = c.DiskOffering.ListDiskOfferings(p)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if l.Count == 0 {
+		return nil, ErrNotFound
+	}
+	if l.Count > 1 {
+		return nil, ErrTooManyResults
+	}
+
+	offering := l.DiskOfferings[0]
+
+	return &DiskOffering{
+		ID:         offering.Id,
+		Name:       offering.Name,
+		Customized: offering.Iscustomized,
+		SizeStrict: offering.Disksizestrictness,
+		SizeInGB:   offering.Disksize,
+		Tags:       offering.Tags,
+
+		ProvisioningType: offering.Provisioningtype,
+	}, nil
+}
+
+func (c *client) GetDiskOfferingByName(ctx context.Context, name string) (string, error) {
+	logger := klog.FromContext(ctx)
+	p := c.DiskOffering.NewListDiskOfferingsParams()
+	p.SetName(name)
+	if c.projectID != "" {
+		p.SetProjectid(c.projectID)
+	}
+	if c.domainID != "" {
+		p.SetDomainid(c.domainID)
+	}
+	logger.V(2).Info("CloudStack API call", "command", "ListDiskOfferings", "params", map[string]string{
+		"name": name,
+	})
+	var l *cloudstack.ListDiskOfferingsResponse
+	err := c.retry(ctx, "ListDiskOfferings", isTransient, func() (err error) {
+		l, err = c.DiskOffering.ListDiskOfferings(p)
+
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Depending on the CloudStack version, the name filter may also
+	// match offerings whose name only contains the given name.
+	var ids []string
+	for _, offering := range l.DiskOfferings {
+		if offering.Name == name {
+			ids = append(ids, offering.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return "", ErrNotFound
+	}
+	if len(ids) > 1 {
+		return "", ErrTooManyResults
+	}
+
+	return ids[0], nil
+}
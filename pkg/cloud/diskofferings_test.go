@@ -0,0 +1,155 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/golang/mock/gomock"
+)
+
+func TestGetDiskOfferingByName(t *testing.T) {
+	errAPI := errors.New("connection refused")
+	cases := []struct {
+		name       string
+		offerings  []*cloudstack.DiskOffering
+		apiErr     error
+		expectedID string
+		expectErr  error
+	}{
+		{
+			name: "single match",
+			offerings: []*cloudstack.DiskOffering{
+				{Id: "f1b1b9f3-9ed1-4c2d-9e0b-0d2a2b4e8c11", Name: "custom"},
+			},
+			expectedID: "f1b1b9f3-9ed1-4c2d-9e0b-0d2a2b4e8c11",
+		},
+		{
+			name: "partial matches ignored",
+			offerings: []*cloudstack.DiskOffering{
+				{Id: "0c6f7c86-8fd5-4d4c-b1a5-2f9d7a9b0e21", Name: "custom-ssd"},
+				{Id: "f1b1b9f3-9ed1-4c2d-9e0b-0d2a2b4e8c11", Name: "custom"},
+			},
+			expectedID: "f1b1b9f3-9ed1-4c2d-9e0b-0d2a2b4e8c11",
+		},
+		{
+			name:      "no match",
+			offerings: []*cloudstack.DiskOffering{},
+			expectErr: ErrNotFound,
+		},
+		{
+			name: "several matches",
+			offerings: []*cloudstack.DiskOffering{
+				{Id: "f1b1b9f3-9ed1-4c2d-9e0b-0d2a2b4e8c11", Name: "custom"},
+				{Id: "5c3ab8c0-49f6-4a31-a6f1-5f0d7b6c2e90", Name: "custom"},
+			},
+			expectErr: ErrTooManyResults,
+		},
+		{
+			name:      "API error",
+			apiErr:    errAPI,
+			expectErr: errAPI,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			cs := cloudstack.NewMockClient(ctrl)
+			ds := cs.DiskOffering.(*cloudstack.MockDiskOfferingServiceIface)
+
+			params := &cloudstack.ListDiskOfferingsParams{}
+			ds.EXPECT().NewListDiskOfferingsParams().Return(params)
+			var resp *cloudstack.ListDiskOfferingsResponse
+			if c.apiErr == nil {
+				resp = &cloudstack.ListDiskOfferingsResponse{Count: len(c.offerings), DiskOfferings: c.offerings}
+			}
+			ds.EXPECT().ListDiskOfferings(params).Return(resp, c.apiErr)
+
+			client := &client{CloudStackClient: cs, projectID: "8b3a9c4d-0e2f-4a6b-9c1d-3e5f7a9b1c2d"}
+			id, err := client.GetDiskOfferingByName(context.Background(), "custom")
+			switch {
+			case c.expectErr == nil && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case c.expectErr != nil && err == nil:
+				t.Fatalf("expected error %v, got none", c.expectErr)
+			case c.expectErr != nil && !errors.Is(err, c.expectErr):
+				t.Fatalf("expected error %v, got %v", c.expectErr, err)
+			}
+			if id != c.expectedID {
+				t.Errorf("expected ID %q, got %q", c.expectedID, id)
+			}
+
+			if name, _ := params.GetName(); name != "custom" {
+				t.Errorf("expected name filter %q, got %q", "custom", name)
+			}
+			if projectID, _ := params.GetProjectid(); projectID != client.projectID {
+				t.Errorf("expected project ID %q, got %q", client.projectID, projectID)
+			}
+		})
+	}
+}
+
+func TestGetDiskOfferingByID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cs := cloudstack.NewMockClient(ctrl)
+	ds := cs.DiskOffering.(*cloudstack.MockDiskOfferingServiceIface)
+
+	params := &cloudstack.ListDiskOfferingsParams{}
+	ds.EXPECT().NewListDiskOfferingsParams().Return(params)
+	ds.EXPECT().ListDiskOfferings(params).Return(&cloudstack.ListDiskOfferingsResponse{
+		Count: 1,
+		DiskOfferings: []*cloudstack.DiskOffering{
+			{Id: "f1b1b9f3-9ed1-4c2d-9e0b-0d2a2b4e8c11", Name: "small", Disksize: 10, Tags: "ssd", Provisioningtype: "thin"},
+		},
+	}, nil)
+
+	client := &client{CloudStackClient: cs}
+	offering, err := client.GetDiskOfferingByID(context.Background(), "f1b1b9f3-9ed1-4c2d-9e0b-0d2a2b4e8c11")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := DiskOffering{ID: "f1b1b9f3-9ed1-4c2d-9e0b-0d2a2b4e8c11", Name: "small", SizeInGB: 10, Tags: "ssd", ProvisioningType: "thin"}
+	if *offering != expected {
+		t.Errorf("expected %+v, got %+v", expected, *offering)
+	}
+	if id, _ := params.GetId(); id != expected.ID {
+		t.Errorf("expected ID filter %q, got %q", expected.ID, id)
+	}
+}
+
+func TestDomainScopedDiskOfferings(t *testing.T) {
+	const domainID = "2e7a4c1b-9f3d-4b6a-8e5c-7d1f3a9b5c2e"
+
+	ctrl := gomock.NewController(t)
+	cs := cloudstack.NewMockClient(ctrl)
+	ds := cs.DiskOffering.(*cloudstack.MockDiskOfferingServiceIface)
+
+	byName := &cloudstack.ListDiskOfferingsParams{}
+	byID := &cloudstack.ListDiskOfferingsParams{}
+	offerings := &cloudstack.ListDiskOfferingsResponse{
+		Count:         1,
+		DiskOfferings: []*cloudstack.DiskOffering{{Id: "f1b1b9f3-9ed1-4c2d-9e0b-0d2a2b4e8c11", Name: "custom"}},
+	}
+	gomock.InOrder(
+		ds.EXPECT().NewListDiskOfferingsParams().Return(byName),
+		ds.EXPECT().ListDiskOfferings(byName).Return(offerings, nil),
+		ds.EXPECT().NewListDiskOfferingsParams().Return(byID),
+		ds.EXPECT().ListDiskOfferings(byID).Return(offerings, nil),
+	)
+
+	client := &client{CloudStackClient: cs, domainID: domainID}
+	id, err := client.GetDiskOfferingByName(context.Background(), "custom")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.GetDiskOfferingByID(context.Background(), id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, params := range []*cloudstack.ListDiskOfferingsParams{byName, byID} {
+		if got, _ := params.GetDomainid(); got != domainID {
+			t.Errorf("expected domain ID %q, got %q", domainID, got)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package cloud
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// endpointTimeout is the time given to a management endpoint to accept
+// a connection, before failing over to the next one.
+const endpointTimeout = 5 * time.Second
+
+// failoverTransport sends the API requests to the first reachable of
+// several management endpoints. Only connection errors trigger a
+// failover: API errors are returned by all the endpoints alike, and the
+// requests that may have reached an endpoint, e.g. when the connection
+// is reset afterwards, are not sent again as they may not be idempotent.
+type failoverTransport struct {
+	endpoints atomic.Pointer[[]*url.URL]
+	// current is the index of the endpoint tried first, the last one
+	// that could be reached.
+	current atomic.Int32
+	base    http.RoundTripper
+}
+
+func newFailoverTransport(endpoints []*url.URL, tlsConfig *tls.Config) *failoverTransport {
+	t := &failoverTransport{
+		base: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   endpointTimeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   endpointTimeout,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+	t.setEndpoints(endpoints)
+
+	return t
+}
+
+// setEndpoints replaces the management endpoints, the first one being
+// tried first.
+func (t *failoverTransport) setEndpoints(endpoints []*url.URL) {
+	t.endpoints.Store(&endpoints)
+	t.current.Store(0)
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoints := *t.endpoints.Load()
+	if len(endpoints) == 0 {
+		return nil, errors.New("no CloudStack API URL")
+	}
+	first := int(t.current.Load()) % len(endpoints)
+	var err error
+	for i := range endpoints {
+		n := (first + i) % len(endpoints)
+		endpoint := endpoints[n]
+
+		r := req.Clone(req.Context())
+		r.URL.Scheme = endpoint.Scheme
+		r.URL.Host = endpoint.Host
+		r.URL.Path = endpoint.Path
+		r.Host = endpoint.Host
+		if i > 0 && req.GetBody != nil {
+			if r.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		var resp *http.Response
+		resp, err = t.base.RoundTrip(r)
+		if err == nil {
+			if n != first {
+				klog.InfoS("Failed over to another CloudStack management endpoint", "endpoint", endpoint.Host)
+				t.current.Store(int32(n)) //nolint:gosec
+			}
+
+			return resp, nil
+		}
+		if req.Context().Err() != nil || !isConnectError(err) {
+			return nil, err
+		}
+		klog.InfoS("CloudStack management endpoint unreachable", "endpoint", endpoint.Host, "err", err)
+	}
+
+	return nil, err
+}
+
+// isConnectError tells whether err happened before the request could be
+// sent: while dialing the endpoint or during the TLS handshake.
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	if errors.As(err, &certErr) || errors.As(err, &recordErr) || errors.As(err, &alertErr) {
+		return true
+	}
+	// The error of a handshake timeout is not exported by net/http.
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && netErr.Timeout() && strings.Contains(err.Error(), "TLS handshake timeout")
+}
@@ -0,0 +1,193 @@
+package cloud
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// unreachableURL returns the URL of a port nothing listens on.
+func unreachableURL(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	return "http://" + addr + "/client/api"
+}
+
+// newAPIServer returns a fake management server answering every
+// request with the given status and body, and counting the requests.
+func newAPIServer(t *testing.T, code int, body string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &hits
+}
+
+const listZonesBody = `{"listzonesresponse":{"count":1,"zone":[{"id":"a1887604-237c-4212-a9cd-94620b7880fa"}]}}`
+
+func TestFailover(t *testing.T) {
+	srv, hits := newAPIServer(t, http.StatusOK, listZonesBody)
+	c := New(&Config{
+		APIURL:          unreachableURL(t),
+		FallbackAPIURLs: []string{srv.URL + "/client/api"},
+	})
+
+	for i := 0; i < 2; i++ {
+		zones, err := c.ListZonesID(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if expected := []string{"a1887604-237c-4212-a9cd-94620b7880fa"}; !reflect.DeepEqual(zones, expected) {
+			t.Errorf("expected zones %v, got %v", expected, zones)
+		}
+	}
+	if hits.Load() != 2 {
+		t.Errorf("expected 2 requests to the fallback endpoint, got %d", hits.Load())
+	}
+}
+
+func TestNoFailoverOnAPIError(t *testing.T) {
+	primary, primaryHits := newAPIServer(t, 431, `{"listzonesresponse":{"errorcode":431,"errortext":"Unable to execute API command"}}`)
+	fallback, fallbackHits := newAPIServer(t, http.StatusOK, listZonesBody)
+	c := New(&Config{
+		APIURL:          primary.URL + "/client/api",
+		FallbackAPIURLs: []string{fallback.URL + "/client/api"},
+	})
+
+	if _, err := c.ListZonesID(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+	if primaryHits.Load() != 1 || fallbackHits.Load() != 0 {
+		t.Errorf("expected only the primary endpoint to be called, got %d and %d requests", primaryHits.Load(), fallbackHits.Load())
+	}
+}
+
+// resetURL returns the URL of a server reading a request, then resetting
+// the connection instead of answering, and counts the requests read.
+func resetURL(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	var hits atomic.Int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if _, err := http.ReadRequest(bufio.NewReader(conn)); err == nil {
+				hits.Add(1)
+			}
+			_ = conn.(*net.TCPConn).SetLinger(0)
+			_ = conn.Close()
+		}
+	}()
+
+	return "http://" + l.Addr().String() + "/client/api", &hits
+}
+
+func TestNoFailoverAfterRequestSent(t *testing.T) {
+	primaryURL, primaryHits := resetURL(t)
+	fallback, fallbackHits := newAPIServer(t, http.StatusOK, listZonesBody)
+	endpoints := parseEndpoints(&Config{APIURL: primaryURL, FallbackAPIURLs: []string{fallback.URL + "/client/api"}})
+	client := &http.Client{Transport: newFailoverTransport(endpoints, nil)}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, primaryURL, strings.NewReader("command=createVolume"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("expected an error")
+	}
+	if primaryHits.Load() != 1 || fallbackHits.Load() != 0 {
+		t.Errorf("expected the request to be sent to the primary endpoint only, got %d and %d requests", primaryHits.Load(), fallbackHits.Load())
+	}
+}
+
+func TestReadConfigFallbackURLs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cloud-config")
+	content := `[Global]
+api-url = https://cloudstack-1.example.com/client/api
+fallback-api-url = https://cloudstack-2.example.com/client/api
+fallback-api-url = https://cloudstack-3.example.com/client/api
+api-key = key
+secret-key = secret
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config, err := ReadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"https://cloudstack-2.example.com/client/api", "https://cloudstack-3.example.com/client/api"}
+	if !reflect.DeepEqual(config.FallbackAPIURLs, expected) {
+		t.Errorf("expected fallback URLs %v, got %v", expected, config.FallbackAPIURLs)
+	}
+}
+
+func TestReadConfigDomain(t *testing.T) {
+	cases := []struct {
+		name      string
+		content   string
+		domainID  string
+		account   string
+		expectErr bool
+	}{
+		{"domain", "domain-id = 2e7a4c1b-9f3d-4b6a-8e5c-7d1f3a9b5c2e\n", "2e7a4c1b-9f3d-4b6a-8e5c-7d1f3a9b5c2e", "", false},
+		{"domain and account", "domain-id = 2e7a4c1b-9f3d-4b6a-8e5c-7d1f3a9b5c2e\naccount = kubernetes\n", "2e7a4c1b-9f3d-4b6a-8e5c-7d1f3a9b5c2e", "kubernetes", false},
+		{"account without domain", "account = kubernetes\n", "", "", true},
+		{"account and project", "domain-id = 2e7a4c1b-9f3d-4b6a-8e5c-7d1f3a9b5c2e\naccount = kubernetes\nproject-id = 8b3a9c4d-0e2f-4a6b-9c1d-3e5f7a9b1c2d\n", "", "", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cloud-config")
+			content := "[Global]\napi-url = https://cloudstack.example.com/client/api\napi-key = key\nsecret-key = secret\n" + c.content
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			config, err := ReadConfig(path)
+			if c.expectErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config.DomainID != c.domainID || config.Account != c.account {
+				t.Errorf("expected domain %q and account %q, got %q and %q", c.domainID, c.account, config.DomainID, config.Account)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package fake_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/status"
+
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud/fake"
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/driver"
+)
+
+// The controller service can be exercised without CloudStack, e.g. to
+// check how it handles API errors.
+func ExampleWithError() {
+	connector := fake.New(fake.WithError("AttachVolume", errors.New("host has no capacity")))
+	cs := driver.NewControllerServer(connector, &driver.Options{})
+
+	_, err := cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId: "ace9f28b-3081-40c1-8353-4cc3e3014072",
+		NodeId:   "0d7107a3-94d2-44e7-89b8-8930881309a5",
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	})
+	fmt.Println(status.Code(err))
+	// Output: Internal
+}
+
+// Async jobs outliving the deadline of the request fail as with the
+// real connector.
+func ExampleWithJobDuration() {
+	connector := fake.New(fake.WithJobDuration(time.Minute))
+	cs := driver.NewControllerServer(connector, &driver.Options{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name: "pvc-slow",
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}},
+		Parameters: map[string]string{driver.DiskOfferingKey: "9743fd77-0f5d-4ef9-b2f8-f194235c769c"},
+	})
+	fmt.Println(status.Code(err))
+	// Output: DeadlineExceeded
+}
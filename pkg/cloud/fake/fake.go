@@ -4,6 +4,12 @@ package fake
 
 import (
 	"context"
+	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/go-uuid"
 
@@ -11,39 +17,143 @@ import (
 	"github.com/leaseweb/cloudstack-csi-driver/pkg/util"
 )
 
-const zoneID = "a1887604-237c-4212-a9cd-94620b7880fa"
+const (
+	zoneID           = "a1887604-237c-4212-a9cd-94620b7880fa"
+	hostID           = "4e5c2a1b-8f3d-4c6e-9a7b-2d1f0e9c8b7a"
+	podID            = "f3b2c1d0-5e4f-4a3b-8c2d-1e0f9a8b7c6d"
+	clusterID        = "7a6b5c4d-3e2f-4a1b-9c8d-7e6f5a4b3c2d"
+	diskOfferingID   = "9743fd77-0f5d-4ef9-b2f8-f194235c769c"
+	diskOfferingName = "custom"
+
+	// fixedDiskOfferingID is a disk offering of 10 GB volumes.
+	fixedDiskOfferingID   = "3b7c1d52-6a2e-4f0b-9d8e-5c4a1f2b7e90"
+	fixedDiskOfferingName = "small"
+	fixedDiskOfferingSize = 10
+
+	// availableCapacity is the size left on the primary storages of the zone.
+	availableCapacity = 100 * 1024 * 1024 * 1024
+
+	// maxDataVolumes is the number of volumes attachable to a VM, matching
+	// the default of the node service.
+	maxDataVolumes = 22
+)
+
+var diskOfferings = []cloud.DiskOffering{
+	{ID: diskOfferingID, Name: diskOfferingName, Customized: true, Tags: "ssd", ProvisioningType: "thin"},
+	{ID: fixedDiskOfferingID, Name: fixedDiskOfferingName, SizeInGB: fixedDiskOfferingSize, ProvisioningType: "fat"},
+}
 
 type fakeConnector struct {
-	node          *cloud.VM
-	volumesByID   map[string]cloud.Volume
-	volumesByName map[string]cloud.Volume
+	// mu guards the volumes and snapshots.
+	mu              sync.Mutex
+	node            *cloud.VM
+	diskOfferings   []cloud.DiskOffering
+	volumesByID     map[string]cloud.Volume
+	volumesByName   map[string]cloud.Volume
+	snapshotsByID   map[string]cloud.Snapshot
+	snapshotsByName map[string]cloud.Snapshot
+
+	// errors are returned by the methods named by their keys.
+	errors map[string]error
+	// jobDuration is the time taken by the async jobs.
+	jobDuration time.Duration
+}
+
+// Option configures the fake connector.
+type Option func(*fakeConnector)
+
+// WithError makes the method of the connector named method, e.g.
+// "AttachVolume", fail with err.
+func WithError(method string, err error) Option {
+	return func(f *fakeConnector) {
+		f.errors[method] = err
+	}
+}
+
+// WithJobDuration makes the methods running CloudStack async jobs, such
+// as CreateVolume or AttachVolume, take d to complete. They fail with
+// context.DeadlineExceeded when the context is done before, as the real
+// connector does.
+func WithJobDuration(d time.Duration) Option {
+	return func(f *fakeConnector) {
+		f.jobDuration = d
+	}
+}
+
+// WithDiskOfferings replaces the disk offerings of the connector.
+func WithDiskOfferings(offerings ...cloud.DiskOffering) Option {
+	return func(f *fakeConnector) {
+		f.diskOfferings = offerings
+	}
 }
 
 // New returns a new fake implementation of the
 // CloudStack connector.
-func New() cloud.Interface {
+//
+// It holds a volume, vol-1, and a node in a single zone, and the disk
+// offerings "custom", customized with storage tag "ssd" and thin
+// provisioning, and "small", of fat provisioned 10 GB volumes.
+func New(opts ...Option) cloud.Interface {
 	volume := cloud.Volume{
 		ID:               "ace9f28b-3081-40c1-8353-4cc3e3014072",
 		Name:             "vol-1",
 		Size:             10,
-		DiskOfferingID:   "9743fd77-0f5d-4ef9-b2f8-f194235c769c",
+		State:            cloud.VolumeReady,
+		DiskOfferingID:   diskOfferingID,
 		ZoneID:           zoneID,
 		VirtualMachineID: "",
 		DeviceID:         "",
 	}
 	node := &cloud.VM{
-		ID:     "0d7107a3-94d2-44e7-89b8-8930881309a5",
-		ZoneID: zoneID,
+		ID:         "0d7107a3-94d2-44e7-89b8-8930881309a5",
+		ZoneID:     zoneID,
+		HostID:     hostID,
+		State:      cloud.VMRunning,
+		Hypervisor: "KVM",
+	}
+
+	f := &fakeConnector{
+		node:            node,
+		diskOfferings:   diskOfferings,
+		volumesByID:     map[string]cloud.Volume{volume.ID: volume},
+		volumesByName:   map[string]cloud.Volume{volume.Name: volume},
+		snapshotsByID:   map[string]cloud.Snapshot{},
+		snapshotsByName: map[string]cloud.Snapshot{},
+		errors:          map[string]error{},
+	}
+	for _, opt := range opts {
+		opt(f)
 	}
 
-	return &fakeConnector{
-		node:          node,
-		volumesByID:   map[string]cloud.Volume{volume.ID: volume},
-		volumesByName: map[string]cloud.Volume{volume.Name: volume},
+	return f
+}
+
+// fail returns the error injected for method, if any.
+func (f *fakeConnector) fail(method string) error {
+	return f.errors[method]
+}
+
+// job simulates the async job of method, returning the error injected
+// for it, if any.
+func (f *fakeConnector) job(ctx context.Context, method string) error {
+	if f.jobDuration > 0 {
+		timer := time.NewTimer(f.jobDuration)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return fmt.Errorf("%s job did not complete in time: %w", method, ctx.Err())
+		}
 	}
+
+	return f.fail(method)
 }
 
 func (f *fakeConnector) GetVMByID(_ context.Context, vmID string) (*cloud.VM, error) {
+	if err := f.fail("GetVMByID"); err != nil {
+		return nil, err
+	}
+
 	if vmID == f.node.ID {
 		return f.node, nil
 	}
@@ -52,14 +162,96 @@ func (f *fakeConnector) GetVMByID(_ context.Context, vmID string) (*cloud.VM, er
 }
 
 func (f *fakeConnector) GetNodeInfo(_ context.Context, _ string) (*cloud.VM, error) {
+	if err := f.fail("GetNodeInfo"); err != nil {
+		return nil, err
+	}
+
 	return f.node, nil
 }
 
 func (f *fakeConnector) ListZonesID(_ context.Context) ([]string, error) {
+	if err := f.fail("ListZonesID"); err != nil {
+		return nil, err
+	}
+
 	return []string{zoneID}, nil
 }
 
+func (f *fakeConnector) GetHostByID(_ context.Context, id string) (*cloud.Host, error) {
+	if err := f.fail("GetHostByID"); err != nil {
+		return nil, err
+	}
+
+	if id != hostID {
+		return nil, cloud.ErrNotFound
+	}
+
+	return &cloud.Host{ID: hostID, PodID: podID, ClusterID: clusterID}, nil
+}
+
+func (f *fakeConnector) Ping(_ context.Context) error {
+	if err := f.fail("Ping"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (f *fakeConnector) GetDiskOfferingByID(_ context.Context, id string) (*cloud.DiskOffering, error) {
+	if err := f.fail("GetDiskOfferingByID"); err != nil {
+		return nil, err
+	}
+
+	if offering := f.diskOffering(id); offering != nil {
+		return offering, nil
+	}
+
+	return nil, cloud.ErrNotFound
+}
+
+func (f *fakeConnector) diskOffering(id string) *cloud.DiskOffering {
+	for _, offering := range f.diskOfferings {
+		if offering.ID == id {
+			return &offering
+		}
+	}
+
+	return nil
+}
+
+func (f *fakeConnector) GetDiskOfferingByName(_ context.Context, name string) (string, error) {
+	if err := f.fail("GetDiskOfferingByName"); err != nil {
+		return "", err
+	}
+
+	for _, offering := range f.diskOfferings {
+		if offering.Name == name {
+			return offering.ID, nil
+		}
+	}
+
+	return "", cloud.ErrNotFound
+}
+
+func (f *fakeConnector) GetAvailableCapacity(_ context.Context, zoneID, _ string) (int64, error) {
+	if err := f.fail("GetAvailableCapacity"); err != nil {
+		return 0, err
+	}
+
+	if zoneID != "" && zoneID != f.node.ZoneID {
+		return 0, nil
+	}
+
+	return availableCapacity, nil
+}
+
 func (f *fakeConnector) GetVolumeByID(_ context.Context, volumeID string) (*cloud.Volume, error) {
+	if err := f.fail("GetVolumeByID"); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
 	vol, ok := f.volumesByID[volumeID]
 	if ok {
 		return &vol, nil
@@ -69,6 +261,12 @@ func (f *fakeConnector) GetVolumeByID(_ context.Context, volumeID string) (*clou
 }
 
 func (f *fakeConnector) GetVolumeByName(_ context.Context, name string) (*cloud.Volume, error) {
+	if err := f.fail("GetVolumeByName"); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
 	vol, ok := f.volumesByName[name]
 	if ok {
 		return &vol, nil
@@ -77,14 +275,108 @@ func (f *fakeConnector) GetVolumeByName(_ context.Context, name string) (*cloud.
 	return nil, cloud.ErrNotFound
 }
 
-func (f *fakeConnector) CreateVolume(_ context.Context, diskOfferingID, zoneID, name string, sizeInGB int64) (string, error) {
+func (f *fakeConnector) ListVolumes(_ context.Context, page, pageSize int) ([]*cloud.Volume, int, error) {
+	if err := f.fail("ListVolumes"); err != nil {
+		return nil, 0, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	volumes := make([]*cloud.Volume, 0, len(f.volumesByID))
+	for _, vol := range f.volumesByID {
+		vol := vol
+		volumes = append(volumes, &vol)
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].ID < volumes[j].ID
+	})
+	total := len(volumes)
+	if pageSize > 0 {
+		start := min((page-1)*pageSize, total)
+		volumes = volumes[start:min(start+pageSize, total)]
+	}
+
+	return volumes, total, nil
+}
+
+func (f *fakeConnector) CreateVolume(ctx context.Context, diskOfferingID, zoneID, name string, sizeInGB int64) (string, error) {
+	if err := f.job(ctx, "CreateVolume"); err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if sizeInGB == 0 {
+		offering := f.diskOffering(diskOfferingID)
+		if offering == nil {
+			return "", cloud.ErrNotFound
+		}
+		sizeInGB = offering.SizeInGB
+	}
 	id, _ := uuid.GenerateUUID()
 	vol := cloud.Volume{
 		ID:             id,
 		Name:           name,
 		Size:           util.GigaBytesToBytes(sizeInGB),
+		State:          cloud.VolumeReady,
 		DiskOfferingID: diskOfferingID,
 		ZoneID:         zoneID,
+		CreatedAt:      time.Now(),
+	}
+	f.volumesByID[vol.ID] = vol
+	f.volumesByName[vol.Name] = vol
+
+	return vol.ID, nil
+}
+
+func (f *fakeConnector) CreateVolumeFromSnapshot(ctx context.Context, zoneID, name, snapshotID string) (string, error) {
+	if err := f.job(ctx, "CreateVolumeFromSnapshot"); err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	snap, ok := f.snapshotsByID[snapshotID]
+	if !ok {
+		return "", cloud.ErrNotFound
+	}
+	id, _ := uuid.GenerateUUID()
+	vol := cloud.Volume{
+		ID:             id,
+		Name:           name,
+		Size:           snap.Size,
+		State:          cloud.VolumeReady,
+		DiskOfferingID: f.volumesByID[snap.VolumeID].DiskOfferingID,
+		ZoneID:         zoneID,
+		SnapshotID:     snapshotID,
+	}
+	f.volumesByID[vol.ID] = vol
+	f.volumesByName[vol.Name] = vol
+
+	return vol.ID, nil
+}
+
+func (f *fakeConnector) CloneVolume(ctx context.Context, volumeID, name string) (string, error) {
+	if err := f.job(ctx, "CloneVolume"); err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	src, ok := f.volumesByID[volumeID]
+	if !ok {
+		return "", cloud.ErrNotFound
+	}
+	id, _ := uuid.GenerateUUID()
+	snapshotID, _ := uuid.GenerateUUID()
+	vol := cloud.Volume{
+		ID:             id,
+		Name:           name,
+		Size:           src.Size,
+		State:          cloud.VolumeReady,
+		DiskOfferingID: src.DiskOfferingID,
+		ZoneID:         src.ZoneID,
+		SnapshotID:     snapshotID,
 	}
 	f.volumesByID[vol.ID] = vol
 	f.volumesByName[vol.Name] = vol
@@ -92,7 +384,34 @@ func (f *fakeConnector) CreateVolume(_ context.Context, diskOfferingID, zoneID,
 	return vol.ID, nil
 }
 
-func (f *fakeConnector) DeleteVolume(_ context.Context, id string) error {
+func (f *fakeConnector) CreateVolumeTags(_ context.Context, volumeID string, tags map[string]string) error {
+	if err := f.fail("CreateVolumeTags"); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	vol, ok := f.volumesByID[volumeID]
+	if !ok {
+		return cloud.ErrNotFound
+	}
+	merged := make(map[string]string, len(vol.Tags)+len(tags))
+	maps.Copy(merged, vol.Tags)
+	maps.Copy(merged, tags)
+	vol.Tags = merged
+	f.volumesByID[vol.ID] = vol
+	f.volumesByName[vol.Name] = vol
+
+	return nil
+}
+
+func (f *fakeConnector) DeleteVolume(ctx context.Context, id string) error {
+	if err := f.job(ctx, "DeleteVolume"); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
 	if vol, ok := f.volumesByID[id]; ok {
 		name := vol.Name
 		delete(f.volumesByName, name)
@@ -102,15 +421,86 @@ func (f *fakeConnector) DeleteVolume(_ context.Context, id string) error {
 	return nil
 }
 
-func (f *fakeConnector) AttachVolume(_ context.Context, _, _ string) (string, error) {
-	return "1", nil
+func (f *fakeConnector) AttachVolume(ctx context.Context, volumeID, vmID string) (string, error) {
+	return f.AttachVolumeToDevice(ctx, volumeID, vmID, 0)
+}
+
+func (f *fakeConnector) AttachVolumeToDevice(ctx context.Context, volumeID, vmID string, deviceID int64) (string, error) {
+	if err := f.job(ctx, "AttachVolume"); err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	vol, ok := f.volumesByID[volumeID]
+	if !ok {
+		return "", cloud.ErrNotFound
+	}
+	attached := 0
+	for _, v := range f.volumesByID {
+		if v.VirtualMachineID == vmID {
+			attached++
+			if deviceID > 0 && v.DeviceID == strconv.FormatInt(deviceID, 10) {
+				return "", cloud.ErrDeviceIDInUse
+			}
+		}
+	}
+	if attached >= maxDataVolumes {
+		return "", cloud.ErrMaxVolumesReached
+	}
+	vol.VirtualMachineID = vmID
+	vol.DeviceID = "1"
+	vol.Hypervisor = f.node.Hypervisor
+	if deviceID > 0 {
+		vol.DeviceID = strconv.FormatInt(deviceID, 10)
+	}
+	f.volumesByID[vol.ID] = vol
+	f.volumesByName[vol.Name] = vol
+
+	return vol.DeviceID, nil
+}
+
+func (f *fakeConnector) DetachVolume(ctx context.Context, volumeID string) error {
+	if err := f.job(ctx, "DetachVolume"); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if vol, ok := f.volumesByID[volumeID]; ok {
+		vol.VirtualMachineID = ""
+		vol.DeviceID = ""
+		f.volumesByID[vol.ID] = vol
+		f.volumesByName[vol.Name] = vol
+	}
+
+	return nil
 }
 
-func (f *fakeConnector) DetachVolume(_ context.Context, _ string) error {
+func (f *fakeConnector) ForceDetachVolume(_ context.Context, volumeID string) error {
+	if err := f.fail("ForceDetachVolume"); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if vol, ok := f.volumesByID[volumeID]; ok {
+		vol.VirtualMachineID = ""
+		vol.DeviceID = ""
+		f.volumesByID[vol.ID] = vol
+		f.volumesByName[vol.Name] = vol
+	}
+
 	return nil
 }
 
-func (f *fakeConnector) ExpandVolume(_ context.Context, volumeID string, newSizeInGB int64) error {
+func (f *fakeConnector) ExpandVolume(ctx context.Context, volumeID string, newSizeInGB int64) error {
+	if err := f.job(ctx, "ExpandVolume"); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
 	if vol, ok := f.volumesByID[volumeID]; ok {
 		newSizeInBytes := newSizeInGB * 1024 * 1024 * 1024
 		if newSizeInBytes > vol.Size {
@@ -124,3 +514,98 @@ func (f *fakeConnector) ExpandVolume(_ context.Context, volumeID string, newSize
 
 	return cloud.ErrNotFound
 }
+
+func (f *fakeConnector) GetSnapshotByID(_ context.Context, snapshotID string) (*cloud.Snapshot, error) {
+	if err := f.fail("GetSnapshotByID"); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	snap, ok := f.snapshotsByID[snapshotID]
+	if ok {
+		return &snap, nil
+	}
+
+	return nil, cloud.ErrNotFound
+}
+
+func (f *fakeConnector) GetSnapshotByName(_ context.Context, name string) (*cloud.Snapshot, error) {
+	if err := f.fail("GetSnapshotByName"); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	snap, ok := f.snapshotsByName[name]
+	if ok {
+		return &snap, nil
+	}
+
+	return nil, cloud.ErrNotFound
+}
+
+func (f *fakeConnector) ListSnapshots(_ context.Context, volumeID string) ([]*cloud.Snapshot, error) {
+	if err := f.fail("ListSnapshots"); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	snapshots := make([]*cloud.Snapshot, 0, len(f.snapshotsByID))
+	for _, snap := range f.snapshotsByID {
+		if volumeID == "" || snap.VolumeID == volumeID {
+			snap := snap
+			snapshots = append(snapshots, &snap)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].ID < snapshots[j].ID
+	})
+
+	return snapshots, nil
+}
+
+func (f *fakeConnector) CreateSnapshot(ctx context.Context, volumeID, name string) (*cloud.Snapshot, error) {
+	if err := f.job(ctx, "CreateSnapshot"); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	vol, ok := f.volumesByID[volumeID]
+	if !ok {
+		return nil, cloud.ErrNotFound
+	}
+	id, _ := uuid.GenerateUUID()
+	snap := cloud.Snapshot{
+		ID:        id,
+		Name:      name,
+		VolumeID:  volumeID,
+		ZoneID:    vol.ZoneID,
+		Size:      vol.Size,
+		State:     cloud.SnapshotBackedUp,
+		CreatedAt: time.Now(),
+	}
+	f.snapshotsByID[snap.ID] = snap
+	f.snapshotsByName[snap.Name] = snap
+
+	return &snap, nil
+}
+
+func (f *fakeConnector) DeleteSnapshot(ctx context.Context, snapshotID string) error {
+	if err := f.job(ctx, "DeleteSnapshot"); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	snap, ok := f.snapshotsByID[snapshotID]
+	if !ok {
+		return cloud.ErrNotFound
+	}
+	delete(f.snapshotsByName, snap.Name)
+	delete(f.snapshotsByID, snapshotID)
+
+	return nil
+}
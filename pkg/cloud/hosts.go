@@ -0,0 +1,41 @@
+package cloud
+
+import (
+	"context"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"k8s.io/klog/v2"
+)
+
+// GetHostByID returns the host with the given ID. Hosts are only listed
+// for root administrators.
+func (c *client) GetHostByID(ctx context.Context, hostID string) (*Host, error) {
+	logger := klog.FromContext(ctx)
+	p := c.Host.NewListHostsParams()
+	p.SetId(hostID)
+	logger.V(2).Info("CloudStack API call", "command", "ListHosts", "params", map[string]string{
+		"id": hostID,
+	})
+	var l *cloudstack.ListHostsResponse
+	err := c.retry(ctx, "ListHosts", isTransient, func() (err error) {
+		l, err = c.Host.ListHosts(p)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if l.Count == 0 {
+		return nil, ErrNotFound
+	}
+	if l.Count > 1 {
+		return nil, ErrTooManyResults
+	}
+	host := l.Hosts[0]
+
+	return &Host{
+		ID:        host.Id,
+		PodID:     host.Podid,
+		ClusterID: host.Clusterid,
+	}, nil
+}
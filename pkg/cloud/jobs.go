@@ -0,0 +1,120 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
+
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/util"
+)
+
+// Defaults of the polling of the asynchronous jobs.
+const (
+	DefaultAsyncJobPollInterval = 2 * time.Second
+	DefaultAsyncJobTimeout      = 5 * time.Minute
+)
+
+// Async job statuses.
+const (
+	jobSucceeded = 1
+	jobFailed    = 2
+)
+
+type jobReporterKey struct{}
+
+// WithJobReporter returns a copy of ctx whose CloudStack async jobs are
+// passed to report, with their ID, once they are waited for.
+func WithJobReporter(ctx context.Context, report func(jobID string)) context.Context {
+	return context.WithValue(ctx, jobReporterKey{}, report)
+}
+
+// ReportJob passes jobID to the reporter of ctx, if any.
+func ReportJob(ctx context.Context, jobID string) {
+	if report, ok := ctx.Value(jobReporterKey{}).(func(string)); ok {
+		report(jobID)
+	}
+}
+
+// waitForJob polls the async job until it completes, then unmarshals its
+// result into result, unless nil. It gives up with an error wrapping
+// context.DeadlineExceeded when the job does not complete within the job
+// timeout or the deadline of ctx. An empty jobID means the call completed
+// synchronously: there is nothing to wait for.
+func (c *client) waitForJob(ctx context.Context, command, jobID string, result interface{}) (err error) {
+	if jobID == "" {
+		return nil
+	}
+	ReportJob(ctx, jobID)
+	ctx, span := tracer.Start(ctx, "CloudStack "+command+" job", trace.WithAttributes(attribute.String("job.id", jobID)))
+	defer func() { util.EndSpan(span, err) }()
+	logger := klog.FromContext(ctx)
+	if c.jobTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.jobTimeout)
+		defer cancel()
+	}
+
+	for {
+		p := c.Asyncjob.NewQueryAsyncJobResultParams(jobID)
+		var r *cloudstack.QueryAsyncJobResultResponse
+		err := c.call(ctx, "QueryAsyncJobResult", func() (err error) {
+			r, err = c.Asyncjob.QueryAsyncJobResult(p)
+
+			return err
+		})
+		switch {
+		case err != nil && !isTransient(err):
+			return fmt.Errorf("failed to poll %s job %s: %w", command, jobID, err)
+		case err != nil:
+			// The job keeps running, polling again is enough.
+			logger.V(2).Info("Failed to poll async job", "command", command, "jobID", jobID, "err", err)
+		case r.Jobstatus == jobSucceeded:
+			return unmarshalJobResult(r.Jobresult, result)
+		case r.Jobstatus == jobFailed:
+			return jobError(command, jobID, r.Jobresult)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s job %s did not complete in time: %w", command, jobID, ctx.Err())
+		case <-time.After(c.jobPollInterval):
+		}
+	}
+}
+
+// unmarshalJobResult unmarshals the result of a job, wrapped in an
+// object named after its type, e.g. {"volume": {...}}.
+func unmarshalJobResult(raw json.RawMessage, result interface{}) error {
+	if result == nil {
+		return nil
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return fmt.Errorf("invalid async job result: %w", err)
+	}
+	if len(m) == 1 {
+		for _, v := range m {
+			raw = v
+		}
+	}
+
+	return json.Unmarshal(raw, result)
+}
+
+func jobError(command, jobID string, raw json.RawMessage) error {
+	var e struct {
+		ErrorCode int    `json:"errorcode"`
+		ErrorText string `json:"errortext"`
+	}
+	if err := json.Unmarshal(raw, &e); err != nil || e.ErrorText == "" {
+		return fmt.Errorf("%s job %s failed: %s", command, jobID, string(raw))
+	}
+
+	return fmt.Errorf("%s job %s failed: CloudStack API error %d: %s", command, jobID, e.ErrorCode, e.ErrorText)
+}
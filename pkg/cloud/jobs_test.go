@@ -0,0 +1,112 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/golang/mock/gomock"
+)
+
+const testJobID = "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+
+func TestAttachVolumeJob(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cs := cloudstack.NewMockClient(ctrl)
+	vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+	as := cs.Asyncjob.(*cloudstack.MockAsyncjobServiceIface)
+
+	params := &cloudstack.AttachVolumeParams{}
+	vs.EXPECT().NewAttachVolumeParams(testVolumeID, "vm").Return(params)
+	vs.EXPECT().AttachVolume(params).Return(&cloudstack.AttachVolumeResponse{JobID: testJobID}, nil)
+	jobParams := &cloudstack.QueryAsyncJobResultParams{}
+	as.EXPECT().NewQueryAsyncJobResultParams(testJobID).Return(jobParams).Times(3)
+	gomock.InOrder(
+		as.EXPECT().QueryAsyncJobResult(jobParams).Return(&cloudstack.QueryAsyncJobResultResponse{}, nil).Times(2),
+		as.EXPECT().QueryAsyncJobResult(jobParams).Return(&cloudstack.QueryAsyncJobResultResponse{
+			Jobstatus: jobSucceeded,
+			Jobresult: []byte(`{"volume":{"id":"` + testVolumeID + `","deviceid":3}}`),
+		}, nil),
+	)
+
+	c := &client{CloudStackClient: cs, jobPollInterval: time.Millisecond, jobTimeout: time.Minute}
+	var reported []string
+	ctx := WithJobReporter(context.Background(), func(jobID string) { reported = append(reported, jobID) })
+	deviceID, err := c.AttachVolume(ctx, testVolumeID, "vm")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deviceID != "3" {
+		t.Errorf("expected device ID 3, got %s", deviceID)
+	}
+	if len(reported) != 1 || reported[0] != testJobID {
+		t.Errorf("expected job %s to be reported, got %v", testJobID, reported)
+	}
+}
+
+func TestFailedJob(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cs := cloudstack.NewMockClient(ctrl)
+	vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+	as := cs.Asyncjob.(*cloudstack.MockAsyncjobServiceIface)
+
+	params := &cloudstack.AttachVolumeParams{}
+	vs.EXPECT().NewAttachVolumeParams(testVolumeID, "vm").Return(params)
+	vs.EXPECT().AttachVolume(params).Return(&cloudstack.AttachVolumeResponse{JobID: testJobID}, nil)
+	jobParams := &cloudstack.QueryAsyncJobResultParams{}
+	as.EXPECT().NewQueryAsyncJobResultParams(testJobID).Return(jobParams)
+	as.EXPECT().QueryAsyncJobResult(jobParams).Return(&cloudstack.QueryAsyncJobResultResponse{
+		Jobstatus: jobFailed,
+		Jobresult: []byte(`{"errorcode":530,"errortext":"Failed to attach volume: maximum number of data disks reached"}`),
+	}, nil)
+
+	c := &client{CloudStackClient: cs, jobPollInterval: time.Millisecond, jobTimeout: time.Minute}
+	_, err := c.AttachVolume(context.Background(), testVolumeID, "vm")
+	if !errors.Is(err, ErrMaxVolumesReached) {
+		t.Errorf("expected ErrMaxVolumesReached, got %v", err)
+	}
+	if err != nil && !strings.Contains(err.Error(), "530") {
+		t.Errorf("expected the CloudStack error code in %q", err.Error())
+	}
+}
+
+func TestJobTimeout(t *testing.T) {
+	cases := []struct {
+		name       string
+		jobTimeout time.Duration
+		ctxTimeout time.Duration
+	}{
+		{"job timeout", 20 * time.Millisecond, time.Minute},
+		{"context deadline", time.Minute, 20 * time.Millisecond},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			cs := cloudstack.NewMockClient(ctrl)
+			vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+			as := cs.Asyncjob.(*cloudstack.MockAsyncjobServiceIface)
+
+			params := &cloudstack.DetachVolumeParams{}
+			vs.EXPECT().NewDetachVolumeParams().Return(params)
+			vs.EXPECT().DetachVolume(params).Return(&cloudstack.DetachVolumeResponse{JobID: testJobID}, nil)
+			jobParams := &cloudstack.QueryAsyncJobResultParams{}
+			as.EXPECT().NewQueryAsyncJobResultParams(testJobID).Return(jobParams).MinTimes(1)
+			as.EXPECT().QueryAsyncJobResult(jobParams).Return(&cloudstack.QueryAsyncJobResultResponse{}, nil).MinTimes(1)
+
+			ctx, cancel := context.WithTimeout(context.Background(), c.ctxTimeout)
+			defer cancel()
+			client := &client{CloudStackClient: cs, jobPollInterval: 5 * time.Millisecond, jobTimeout: c.jobTimeout}
+			start := time.Now()
+			err := client.DetachVolume(ctx, testVolumeID)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("expected deadline exceeded error, got %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("expected to give up after 20ms, waited %s", elapsed)
+			}
+		})
+	}
+}
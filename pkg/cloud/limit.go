@@ -0,0 +1,75 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/time/rate"
+
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/util"
+)
+
+// limiter caps the number of CloudStack API calls in flight and their
+// rate, so that bursts of requests do not overwhelm the management
+// server. A nil limiter, or a zero limit, lets all the calls through.
+type limiter struct {
+	inFlight chan struct{}
+	rate     *rate.Limiter
+}
+
+func newLimiter(maxInFlight int, qps float64, burst int) *limiter {
+	l := &limiter{}
+	if maxInFlight > 0 {
+		l.inFlight = make(chan struct{}, maxInFlight)
+	}
+	if qps > 0 {
+		l.rate = rate.NewLimiter(rate.Limit(qps), max(burst, 1))
+	}
+
+	return l
+}
+
+// acquire waits until a call may be made, or ctx is done. Every
+// successful acquire must be followed by a release.
+func (l *limiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	if l.rate != nil {
+		if err := l.rate.Wait(ctx); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			// The wait would exceed the deadline of ctx.
+			return fmt.Errorf("%w: %v", context.DeadlineExceeded, err)
+		}
+	}
+	if l.inFlight != nil {
+		select {
+		case l.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+func (l *limiter) release() {
+	if l != nil && l.inFlight != nil {
+		<-l.inFlight
+	}
+}
+
+// call makes the API call fn once the limiter lets it.
+func (c *client) call(ctx context.Context, command string, fn func() error) (err error) {
+	_, span := tracer.Start(ctx, "CloudStack "+command)
+	defer func() { util.EndSpan(span, err) }()
+
+	if err := c.limiter.acquire(ctx); err != nil {
+		return fmt.Errorf("%s call not made: %w", command, err)
+	}
+	defer c.limiter.release()
+
+	return fn()
+}
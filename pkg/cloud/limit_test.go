@@ -0,0 +1,103 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/golang/mock/gomock"
+)
+
+func TestLimiterMaxInFlight(t *testing.T) {
+	const (
+		maxInFlight = 3
+		calls       = 20
+	)
+
+	ctrl := gomock.NewController(t)
+	cs := cloudstack.NewMockClient(ctrl)
+	vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+
+	var inFlight, maxSeen atomic.Int32
+	vs.EXPECT().NewListVolumesParams().DoAndReturn(func() *cloudstack.ListVolumesParams {
+		return &cloudstack.ListVolumesParams{}
+	}).Times(calls)
+	vs.EXPECT().ListVolumes(gomock.Any()).DoAndReturn(func(*cloudstack.ListVolumesParams) (*cloudstack.ListVolumesResponse, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			seen := maxSeen.Load()
+			if n <= seen || maxSeen.CompareAndSwap(seen, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		return &cloudstack.ListVolumesResponse{Count: 1, Volumes: []*cloudstack.Volume{{Id: testVolumeID}}}, nil
+	}).Times(calls)
+
+	c := &client{CloudStackClient: cs, retryBackoff: testRetryBackoff, limiter: newLimiter(maxInFlight, 0, 0)}
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.GetVolumeByID(context.Background(), testVolumeID); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := maxSeen.Load(); n > maxInFlight {
+		t.Errorf("expected at most %d calls in flight, got %d", maxInFlight, n)
+	}
+}
+
+func TestLimiterContext(t *testing.T) {
+	t.Run("in flight", func(t *testing.T) {
+		l := newLimiter(1, 0, 0)
+		if err := l.acquire(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer l.release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := l.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected deadline exceeded error, got %v", err)
+		}
+	})
+
+	t.Run("rate", func(t *testing.T) {
+		l := newLimiter(0, 0.1, 1)
+		if err := l.acquire(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := l.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected deadline exceeded error, got %v", err)
+		}
+	})
+
+	t.Run("no call made", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		c := &client{limiter: newLimiter(1, 0, 0)}
+		c.limiter.inFlight <- struct{}{}
+		err := c.call(ctx, "ListVolumes", func() error {
+			t.Error("unexpected call")
+
+			return nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected canceled error, got %v", err)
+		}
+	})
+}
@@ -5,33 +5,69 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/hashicorp/go-uuid"
 	"k8s.io/klog/v2"
 )
 
 const (
+	cloudStackCloudName = "cloudstack"
+
+	// metadataServerTimeout bounds the request to the metadata server,
+	// which is not reachable from every network.
+	metadataServerTimeout = 5 * time.Second
+)
+
+// Sources of the instance ID, variables to be overridden in tests.
+var (
 	cloudInitInstanceFilePath = "/run/cloud-init/instance-data.json"
 	ignitionMetadataFilePath  = "/run/metadata/coreos"
-	cloudStackCloudName       = "cloudstack"
+	// metadataServerURL is the instance ID on the metadata server of
+	// the virtual router of the network.
+	metadataServerURL = "http://data-server/latest/instance-id"
+	// dmiProductUUIDPath is the system UUID, which is the VM ID on KVM.
+	dmiProductUUIDPath = "/sys/class/dmi/id/product_uuid"
 )
 
-// metadataInstanceID tries to find the instance ID from either the environment variable NODE_ID,
-// or cloud-init or ignition metadata. Returns empty string if not found in any of these sources.
+// metadataInstanceID tries to find the instance ID from, in order, the
+// configured node ID, the environment variable NODE_ID, the metadata
+// server, cloud-init or ignition metadata, and the DMI system UUID.
+// Returns empty string if not found in any of these sources.
 func (c *client) metadataInstanceID(ctx context.Context) string {
 	logger := klog.FromContext(ctx)
-	logger.V(4).Info("Attempting to retrieve metadata from envvar NODE_ID")
+
+	// Try the configured node ID
+	if c.nodeID != "" {
+		logger.Info("Using configured CloudStack VM ID", "nodeID", c.nodeID)
+
+		return c.nodeID
+	}
 
 	// Try a NODE_ID environment variable
+	logger.V(4).Info("Attempting to retrieve metadata from envvar NODE_ID")
 	if envNodeID := os.Getenv("NODE_ID"); envNodeID != "" {
 		logger.Info("Found CloudStack VM ID from envvar NODE_ID", "nodeID", envNodeID)
 
 		return envNodeID
 	}
 
+	// Try the metadata server
+	logger.V(4).Info("Environment variable NODE_ID not found, trying with the metadata server")
+	if instanceID, err := readMetadataServer(ctx, metadataServerURL); err != nil {
+		logger.V(4).Info("Cannot read instance ID from the metadata server", "url", metadataServerURL, "err", err)
+	} else {
+		logger.Info("Found CloudStack VM ID from the metadata server", "nodeID", instanceID)
+
+		return instanceID
+	}
+
 	// Try cloud-init
-	logger.V(4).Info("Environment variable NODE_ID not found, trying with cloud-init")
+	logger.V(4).Info("Trying with cloud-init")
 	if _, err := os.Stat(cloudInitInstanceFilePath); err == nil {
 		logger.V(4).Info("File " + cloudInitInstanceFilePath + " exists")
 		ciData, err := c.readCloudInit(ctx, cloudInitInstanceFilePath)
@@ -68,6 +104,16 @@ func (c *client) metadataInstanceID(ctx context.Context) string {
 		logger.Error(err, "Cannot read file "+ignitionMetadataFilePath)
 	}
 
+	// Try the DMI system UUID
+	logger.V(4).Info("Trying with the DMI system UUID")
+	if instanceID, err := readDMIProductUUID(dmiProductUUIDPath); err != nil {
+		logger.V(4).Info("Cannot read the DMI system UUID", "err", err)
+	} else {
+		logger.Info("Found CloudStack VM ID from the DMI system UUID", "nodeID", instanceID)
+
+		return instanceID
+	}
+
 	logger.Info("CloudStack VM ID not found in meta-data")
 
 	return ""
@@ -140,3 +186,46 @@ func (c *client) readIgnition(ctx context.Context, instanceFilePath string) (str
 
 	return instanceID, nil
 }
+
+// readMetadataServer returns the instance ID served by the metadata server.
+func readMetadataServer(ctx context.Context, url string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, metadataServerTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+	instanceID := strings.TrimSpace(string(b))
+	if _, err := uuid.ParseUUID(instanceID); err != nil {
+		return "", fmt.Errorf("invalid instance ID %q: %w", instanceID, err)
+	}
+
+	return instanceID, nil
+}
+
+// readDMIProductUUID returns the system UUID, set by KVM to the VM ID.
+// Other hypervisors, such as VMware, set UUIDs of their own.
+func readDMIProductUUID(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	instanceID := strings.ToLower(strings.TrimSpace(string(b)))
+	if _, err := uuid.ParseUUID(instanceID); err != nil {
+		return "", fmt.Errorf("invalid system UUID %q: %w", instanceID, err)
+	}
+
+	return instanceID, nil
+}
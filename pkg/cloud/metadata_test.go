@@ -0,0 +1,143 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/golang/mock/gomock"
+)
+
+const (
+	testNodeID   = "0d7107a3-94d2-44e7-89b8-8930881309a5"
+	testSystemID = "5f1c9a3e-2b7d-4e8a-9c6f-1d3b5a7e9c2f"
+)
+
+// metadataSources points the instance ID sources to a temporary
+// directory and to a metadata server answering with the given status.
+func metadataSources(t *testing.T, serverStatus int) string {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/latest/instance-id" || serverStatus != http.StatusOK {
+			http.Error(w, "not found", http.StatusNotFound)
+
+			return
+		}
+		_, _ = w.Write([]byte(testNodeID + "\n"))
+	}))
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	saved := []string{cloudInitInstanceFilePath, ignitionMetadataFilePath, metadataServerURL, dmiProductUUIDPath}
+	t.Cleanup(func() {
+		cloudInitInstanceFilePath, ignitionMetadataFilePath, metadataServerURL, dmiProductUUIDPath = saved[0], saved[1], saved[2], saved[3]
+	})
+	cloudInitInstanceFilePath = filepath.Join(dir, "instance-data.json")
+	ignitionMetadataFilePath = filepath.Join(dir, "coreos")
+	metadataServerURL = srv.URL + "/latest/instance-id"
+	dmiProductUUIDPath = filepath.Join(dir, "product_uuid")
+	t.Setenv("NODE_ID", "")
+
+	return dir
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("cannot write %s: %v", path, err)
+	}
+}
+
+func TestMetadataInstanceID(t *testing.T) {
+	t.Run("configured node ID", func(t *testing.T) {
+		metadataSources(t, http.StatusOK)
+		c := &client{nodeID: "b4a1c7e2-3f5d-4a9b-8c6e-2d1f0a9b8c7d"}
+		if id := c.metadataInstanceID(context.Background()); id != c.nodeID {
+			t.Errorf("expected %s, got %q", c.nodeID, id)
+		}
+	})
+
+	t.Run("metadata server", func(t *testing.T) {
+		dir := metadataSources(t, http.StatusOK)
+		writeFile(t, filepath.Join(dir, "product_uuid"), testSystemID)
+		if id := (&client{}).metadataInstanceID(context.Background()); id != testNodeID {
+			t.Errorf("expected %s, got %q", testNodeID, id)
+		}
+	})
+
+	t.Run("cloud-init", func(t *testing.T) {
+		dir := metadataSources(t, http.StatusNotFound)
+		writeFile(t, filepath.Join(dir, "instance-data.json"), `{"v1": {"cloud_name": "cloudstack", "instance_id": "`+testNodeID+`"}}`)
+		writeFile(t, filepath.Join(dir, "product_uuid"), testSystemID)
+		if id := (&client{}).metadataInstanceID(context.Background()); id != testNodeID {
+			t.Errorf("expected %s, got %q", testNodeID, id)
+		}
+	})
+
+	t.Run("DMI system UUID", func(t *testing.T) {
+		dir := metadataSources(t, http.StatusNotFound)
+		writeFile(t, filepath.Join(dir, "product_uuid"), "5F1C9A3E-2B7D-4E8A-9C6F-1D3B5A7E9C2F\n")
+		if id := (&client{}).metadataInstanceID(context.Background()); id != testSystemID {
+			t.Errorf("expected %s, got %q", testSystemID, id)
+		}
+	})
+
+	t.Run("invalid DMI system UUID", func(t *testing.T) {
+		dir := metadataSources(t, http.StatusNotFound)
+		writeFile(t, filepath.Join(dir, "product_uuid"), "Not Settable\n")
+		if id := (&client{}).metadataInstanceID(context.Background()); id != "" {
+			t.Errorf("expected no ID, got %q", id)
+		}
+	})
+}
+
+func TestGetNodeInfo(t *testing.T) {
+	t.Run("no source", func(t *testing.T) {
+		metadataSources(t, http.StatusNotFound)
+		c := &client{CloudStackClient: cloudstack.NewMockClient(gomock.NewController(t))}
+		if _, err := c.GetNodeInfo(context.Background(), ""); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected not found error, got %v", err)
+		}
+	})
+
+	t.Run("system UUID not a VM ID", func(t *testing.T) {
+		dir := metadataSources(t, http.StatusNotFound)
+		writeFile(t, filepath.Join(dir, "product_uuid"), testSystemID)
+
+		ctrl := gomock.NewController(t)
+		cs := cloudstack.NewMockClient(ctrl)
+		vms := cs.VirtualMachine.(*cloudstack.MockVirtualMachineServiceIface)
+		byID := &cloudstack.ListVirtualMachinesParams{}
+		byName := &cloudstack.ListVirtualMachinesParams{}
+		gomock.InOrder(
+			vms.EXPECT().NewListVirtualMachinesParams().Return(byID),
+			vms.EXPECT().ListVirtualMachines(byID).Return(&cloudstack.ListVirtualMachinesResponse{}, nil),
+			vms.EXPECT().NewListVirtualMachinesParams().Return(byName),
+			vms.EXPECT().ListVirtualMachines(byName).Return(&cloudstack.ListVirtualMachinesResponse{
+				Count:           1,
+				VirtualMachines: []*cloudstack.VirtualMachine{{Id: testNodeID, Zoneid: "a1887604-237c-4212-a9cd-94620b7880fa"}},
+			}, nil),
+		)
+
+		c := &client{CloudStackClient: cs}
+		vm, err := c.GetNodeInfo(context.Background(), "node-1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if vm.ID != testNodeID {
+			t.Errorf("expected VM %s, got %s", testNodeID, vm.ID)
+		}
+		if id, _ := byID.GetId(); id != testSystemID {
+			t.Errorf("expected lookup of %s, got %q", testSystemID, id)
+		}
+		if name, _ := byName.GetName(); name != "node-1" {
+			t.Errorf("expected lookup of node-1, got %q", name)
+		}
+	})
+}
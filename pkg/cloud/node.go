@@ -2,6 +2,8 @@ package cloud
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"k8s.io/klog/v2"
 )
@@ -15,7 +17,14 @@ func (c *client) GetNodeInfo(ctx context.Context, vmName string) (*VM, error) {
 		logger.V(4).Info("Looking up node info using VM ID found in metadata", "nodeID", id)
 
 		// Use CloudStack API to get VM info
-		return c.GetVMByID(ctx, id)
+		vm, err := c.GetVMByID(ctx, id)
+		if !errors.Is(err, ErrNotFound) || vmName == "" {
+			return vm, err
+		}
+		// e.g. a system UUID not matching the VM ID, on other hypervisors than KVM.
+		logger.Error(nil, "VM not found using VM ID found in metadata", "nodeID", id)
+	} else if vmName == "" {
+		return nil, fmt.Errorf("%w: no VM ID in node ID, NODE_ID, metadata server, cloud-init, ignition or DMI system UUID, and no node name", ErrNotFound)
 	}
 
 	// VM ID was not found using metadata, fall back to using VM name instead.
@@ -0,0 +1,94 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// DefaultRetryBackoff is the backoff used to retry the CloudStack API
+// calls failing with a transient error. Its Steps are the maximum
+// number of attempts of a call.
+var DefaultRetryBackoff = wait.Backoff{
+	Duration: 1 * time.Second,
+	Factor:   2,
+	Jitter:   0.5,
+	Steps:    4,
+}
+
+// ErrAPIUnavailable is returned when the management server, or a load
+// balancer in front of it, answers that it cannot handle requests.
+var ErrAPIUnavailable = errors.New("CloudStack API unavailable")
+
+// apiTransport turns the responses of unavailable management servers
+// into errors, so they can be retried.
+type apiTransport struct {
+	base http.RoundTripper
+}
+
+func (t *apiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		_ = resp.Body.Close()
+
+		return nil, fmt.Errorf("%w: %s", ErrAPIUnavailable, resp.Status)
+	}
+
+	return resp, nil
+}
+
+// isTransient tells if err is caused by the management server being
+// temporarily unreachable or unavailable.
+func isTransient(err error) bool {
+	var netErr net.Error
+
+	return errors.Is(err, ErrAPIUnavailable) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		(errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// notSubmitted tells if err guarantees that the call was not processed
+// by the management server, so that even calls that are not idempotent
+// can be retried.
+func notSubmitted(err error) bool {
+	return errors.Is(err, ErrAPIUnavailable) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// retry calls fn until it succeeds, fails with an error that retryable
+// does not accept, or the attempts are exhausted. It does not wait past
+// the deadline of ctx. Each attempt is subject to the limiter.
+func (c *client) retry(ctx context.Context, command string, retryable func(error) bool, fn func() error) error {
+	logger := klog.FromContext(ctx)
+	backoff := c.retryBackoff
+	for {
+		err := c.call(ctx, command, fn)
+		if err == nil || ctx.Err() != nil || !retryable(err) || backoff.Steps <= 1 {
+			return err
+		}
+		delay := backoff.Step()
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+		logger.V(2).Info("Retrying CloudStack API call", "command", command, "delay", delay, "err", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
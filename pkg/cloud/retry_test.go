@@ -0,0 +1,185 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/golang/mock/gomock"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+var testRetryBackoff = wait.Backoff{
+	Duration: time.Millisecond,
+	Factor:   1,
+	Steps:    3,
+}
+
+func connectionError(err error) error {
+	return &url.Error{Op: "Get", URL: "https://cloudstack.example.com/client/api", Err: err}
+}
+
+func TestRetryTransientErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cs := cloudstack.NewMockClient(ctrl)
+	vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+
+	params := &cloudstack.ListVolumesParams{}
+	vs.EXPECT().NewListVolumesParams().Return(params)
+	gomock.InOrder(
+		vs.EXPECT().ListVolumes(params).Return(nil, connectionError(syscall.ECONNRESET)),
+		vs.EXPECT().ListVolumes(params).Return(nil, fmt.Errorf("%w: 503 Service Unavailable", ErrAPIUnavailable)),
+		vs.EXPECT().ListVolumes(params).Return(&cloudstack.ListVolumesResponse{
+			Count:   1,
+			Volumes: []*cloudstack.Volume{{Id: testVolumeID}},
+		}, nil),
+	)
+
+	c := &client{CloudStackClient: cs, retryBackoff: testRetryBackoff}
+	vol, err := c.GetVolumeByID(context.Background(), testVolumeID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if vol.ID != testVolumeID {
+		t.Errorf("expected volume %s, got %s", testVolumeID, vol.ID)
+	}
+}
+
+func TestRetryAttemptsExhausted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cs := cloudstack.NewMockClient(ctrl)
+	vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+
+	params := &cloudstack.ListVolumesParams{}
+	vs.EXPECT().NewListVolumesParams().Return(params)
+	vs.EXPECT().ListVolumes(params).Return(nil, connectionError(syscall.ECONNREFUSED)).Times(testRetryBackoff.Steps)
+
+	c := &client{CloudStackClient: cs, retryBackoff: testRetryBackoff}
+	if _, err := c.GetVolumeByID(context.Background(), testVolumeID); !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("expected connection refused error, got %v", err)
+	}
+}
+
+func TestRetryAPIErrorNotRetried(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cs := cloudstack.NewMockClient(ctrl)
+	vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+
+	params := &cloudstack.ListVolumesParams{}
+	vs.EXPECT().NewListVolumesParams().Return(params)
+	vs.EXPECT().ListVolumes(params).Return(nil, errors.New("CloudStack API error 431 (CSExceptionErrorCode: 9999): invalid parameter"))
+
+	c := &client{CloudStackClient: cs, retryBackoff: testRetryBackoff}
+	if _, err := c.GetVolumeByID(context.Background(), testVolumeID); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestRetryCreateVolume(t *testing.T) {
+	t.Run("not submitted", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		cs := cloudstack.NewMockClient(ctrl)
+		vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+
+		params := &cloudstack.CreateVolumeParams{}
+		vs.EXPECT().NewCreateVolumeParams().Return(params)
+		gomock.InOrder(
+			vs.EXPECT().CreateVolume(params).Return(nil, connectionError(syscall.ECONNREFUSED)),
+			vs.EXPECT().CreateVolume(params).Return(&cloudstack.CreateVolumeResponse{Id: testVolumeID}, nil),
+		)
+
+		c := &client{CloudStackClient: cs, retryBackoff: testRetryBackoff}
+		id, err := c.CreateVolume(context.Background(), "offering", "zone", "pvc-1", 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if id != testVolumeID {
+			t.Errorf("expected volume %s, got %s", testVolumeID, id)
+		}
+	})
+
+	t.Run("job poll failure", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		cs := cloudstack.NewMockClient(ctrl)
+		vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+		as := cs.Asyncjob.(*cloudstack.MockAsyncjobServiceIface)
+
+		params := &cloudstack.CreateVolumeParams{}
+		vs.EXPECT().NewCreateVolumeParams().Return(params)
+		// The job was submitted: only its polling is retried.
+		vs.EXPECT().CreateVolume(params).Return(&cloudstack.CreateVolumeResponse{Id: testVolumeID, JobID: testJobID}, nil)
+		jobParams := &cloudstack.QueryAsyncJobResultParams{}
+		as.EXPECT().NewQueryAsyncJobResultParams(testJobID).Return(jobParams).Times(2)
+		gomock.InOrder(
+			as.EXPECT().QueryAsyncJobResult(jobParams).Return(nil, connectionError(syscall.ECONNREFUSED)),
+			as.EXPECT().QueryAsyncJobResult(jobParams).Return(&cloudstack.QueryAsyncJobResultResponse{
+				Jobstatus: jobSucceeded,
+				Jobresult: []byte(`{"volume":{"id":"` + testVolumeID + `"}}`),
+			}, nil),
+		)
+
+		c := &client{CloudStackClient: cs, retryBackoff: testRetryBackoff}
+		id, err := c.CreateVolume(context.Background(), "offering", "zone", "pvc-1", 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if id != testVolumeID {
+			t.Errorf("expected volume %s, got %s", testVolumeID, id)
+		}
+	})
+}
+
+func TestRetryContextDeadline(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cs := cloudstack.NewMockClient(ctrl)
+	vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+
+	params := &cloudstack.ListVolumesParams{}
+	vs.EXPECT().NewListVolumesParams().Return(params)
+	vs.EXPECT().ListVolumes(params).Return(nil, connectionError(syscall.ECONNRESET))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c := &client{CloudStackClient: cs, retryBackoff: wait.Backoff{Duration: time.Minute, Steps: 3}}
+	start := time.Now()
+	if _, err := c.GetVolumeByID(ctx, testVolumeID); !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("expected connection reset error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected no wait past the deadline, waited %s", elapsed)
+	}
+}
+
+func TestRetryUnavailableServer(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if hits.Add(1) == 1 {
+			http.Error(w, "<html>Service Unavailable</html>", http.StatusServiceUnavailable)
+
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(listZonesBody))
+	}))
+	t.Cleanup(srv.Close)
+
+	c := New(&Config{APIURL: srv.URL + "/client/api", RetryBackoff: testRetryBackoff})
+	zones, err := c.ListZonesID(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(zones) != 1 {
+		t.Errorf("expected 1 zone, got %v", zones)
+	}
+	if hits.Load() != 2 {
+		t.Errorf("expected 2 requests, got %d", hits.Load())
+	}
+}
@@ -0,0 +1,160 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"k8s.io/klog/v2"
+)
+
+// apiTimeLayout is the layout of the dates returned by the CloudStack API.
+const apiTimeLayout = "2006-01-02T15:04:05-0700"
+
+func toSnapshot(snap *cloudstack.Snapshot) *Snapshot {
+	createdAt, _ := time.Parse(apiTimeLayout, snap.Created)
+
+	return &Snapshot{
+		ID:        snap.Id,
+		Name:      snap.Name,
+		VolumeID:  snap.Volumeid,
+		ZoneID:    snap.Zoneid,
+		Size:      snap.Virtualsize,
+		State:     snap.State,
+		CreatedAt: createdAt,
+	}
+}
+
+func (c *client) listSnapshots(ctx context.Context, p *cloudstack.ListSnapshotsParams) ([]*Snapshot, error) {
+	if c.projectID != "" {
+		p.SetProjectid(c.projectID)
+	}
+	c.setOwner(p)
+	var l *cloudstack.ListSnapshotsResponse
+	err := c.retry(ctx, "ListSnapshots", isTransient, func() (err error) {
+		l, err = c.Snapshot.ListSnapshots(p)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]*Snapshot, 0, len(l.Snapshots))
+	for _, snap := range l.Snapshots {
+		snapshots = append(snapshots, toSnapshot(snap))
+	}
+
+	return snapshots, nil
+}
+
+func (c *client) getSnapshot(ctx context.Context, p *cloudstack.ListSnapshotsParams) (*Snapshot, error) {
+	snapshots, err := c.listSnapshots(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) == 0 {
+		return nil, ErrNotFound
+	}
+	if len(snapshots) > 1 {
+		return nil, ErrTooManyResults
+	}
+
+	return snapshots[0], nil
+}
+
+func (c *client) GetSnapshotByID(ctx context.Context, snapshotID string) (*Snapshot, error) {
+	logger := klog.FromContext(ctx)
+	p := c.Snapshot.NewListSnapshotsParams()
+	p.SetId(snapshotID)
+	logger.V(2).Info("CloudStack API call", "command", "ListSnapshots", "params", map[string]string{
+		"id": snapshotID,
+	})
+
+	return c.getSnapshot(ctx, p)
+}
+
+func (c *client) GetSnapshotByName(ctx context.Context, name string) (*Snapshot, error) {
+	logger := klog.FromContext(ctx)
+	p := c.Snapshot.NewListSnapshotsParams()
+	p.SetName(name)
+	logger.V(2).Info("CloudStack API call", "command", "ListSnapshots", "params", map[string]string{
+		"name": name,
+	})
+
+	return c.getSnapshot(ctx, p)
+}
+
+func (c *client) ListSnapshots(ctx context.Context, volumeID string) ([]*Snapshot, error) {
+	logger := klog.FromContext(ctx)
+	p := c.Snapshot.NewListSnapshotsParams()
+	if volumeID != "" {
+		p.SetVolumeid(volumeID)
+	}
+	logger.V(2).Info("CloudStack API call", "command", "ListSnapshots", "params", map[string]string{
+		"volumeid": volumeID,
+	})
+
+	return c.listSnapshots(ctx, p)
+}
+
+func (c *client) CreateSnapshot(ctx context.Context, volumeID, name string) (*Snapshot, error) {
+	logger := klog.FromContext(ctx)
+	p := c.Snapshot.NewCreateSnapshotParams(volumeID)
+	p.SetName(name)
+	// Return as soon as the snapshot is taken on the primary storage,
+	// its backup to the secondary storage is reported by its state.
+	p.SetAsyncbackup(true)
+	logger.V(2).Info("CloudStack API call", "command", "CreateSnapshot", "params", map[string]string{
+		"volumeid":    volumeID,
+		"name":        name,
+		"asyncbackup": "true",
+	})
+	var snap *cloudstack.CreateSnapshotResponse
+	err := c.retry(ctx, "CreateSnapshot", notSubmitted, func() (err error) {
+		snap, err = c.Snapshot.CreateSnapshot(p)
+
+		return err
+	})
+	if err == nil {
+		err = c.waitForJob(ctx, "CreateSnapshot", snap.JobID, snap)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		// The job is still running, the snapshot is reported as not ready.
+		logger.Info("Snapshot creation still in progress", "name", name, "volumeID", volumeID)
+
+		return c.GetSnapshotByName(ctx, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return toSnapshot(&cloudstack.Snapshot{
+		Id:          snap.Id,
+		Name:        snap.Name,
+		Volumeid:    snap.Volumeid,
+		Zoneid:      snap.Zoneid,
+		Virtualsize: snap.Virtualsize,
+		State:       snap.State,
+		Created:     snap.Created,
+	}), nil
+}
+
+func (c *client) DeleteSnapshot(ctx context.Context, snapshotID string) error {
+	logger := klog.FromContext(ctx)
+	p := c.Snapshot.NewDeleteSnapshotParams(snapshotID)
+	logger.V(2).Info("CloudStack API call", "command", "DeleteSnapshot", "params", map[string]string{
+		"id": snapshotID,
+	})
+	var r *cloudstack.DeleteSnapshotResponse
+	err := c.retry(ctx, "DeleteSnapshot", isTransient, func() (err error) {
+		r, err = c.Snapshot.DeleteSnapshot(p)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.waitForJob(ctx, "DeleteSnapshot", r.JobID, nil)
+}
@@ -0,0 +1,115 @@
+package cloud
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/golang/mock/gomock"
+)
+
+const (
+	testVolumeID   = "ace9f28b-3081-40c1-8353-4cc3e3014072"
+	testSnapshotID = "3f5c2a1b-7d4e-4b6a-9c8d-0e1f2a3b4c5d"
+)
+
+func TestCreateSnapshot(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cs := cloudstack.NewMockClient(ctrl)
+	ss := cs.Snapshot.(*cloudstack.MockSnapshotServiceIface)
+
+	params := &cloudstack.CreateSnapshotParams{}
+	ss.EXPECT().NewCreateSnapshotParams(testVolumeID).Return(params)
+	ss.EXPECT().CreateSnapshot(params).Return(&cloudstack.CreateSnapshotResponse{
+		Id:          testSnapshotID,
+		Name:        "snap-1",
+		Volumeid:    testVolumeID,
+		Virtualsize: 10 << 30,
+		State:       "BackedUp",
+		Created:     "2024-05-02T10:20:30+0000",
+	}, nil)
+
+	c := &client{CloudStackClient: cs}
+	snap, err := c.CreateSnapshot(context.Background(), testVolumeID, "snap-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if snap.ID != testSnapshotID || snap.VolumeID != testVolumeID || snap.Size != 10<<30 || snap.State != SnapshotBackedUp {
+		t.Errorf("unexpected snapshot %+v", snap)
+	}
+	if expected := time.Date(2024, 5, 2, 10, 20, 30, 0, time.UTC); !snap.CreatedAt.Equal(expected) {
+		t.Errorf("expected creation time %v, got %v", expected, snap.CreatedAt)
+	}
+	if name, _ := params.GetName(); name != "snap-1" {
+		t.Errorf("expected name %q, got %q", "snap-1", name)
+	}
+	if asyncBackup, _ := params.GetAsyncbackup(); !asyncBackup {
+		t.Error("expected asynchronous backup")
+	}
+}
+
+func TestCreateSnapshotInProgress(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cs := cloudstack.NewMockClient(ctrl)
+	ss := cs.Snapshot.(*cloudstack.MockSnapshotServiceIface)
+	as := cs.Asyncjob.(*cloudstack.MockAsyncjobServiceIface)
+
+	createParams := &cloudstack.CreateSnapshotParams{}
+	ss.EXPECT().NewCreateSnapshotParams(testVolumeID).Return(createParams)
+	ss.EXPECT().CreateSnapshot(createParams).Return(&cloudstack.CreateSnapshotResponse{JobID: testJobID}, nil)
+	jobParams := &cloudstack.QueryAsyncJobResultParams{}
+	as.EXPECT().NewQueryAsyncJobResultParams(testJobID).Return(jobParams).MinTimes(1)
+	as.EXPECT().QueryAsyncJobResult(jobParams).Return(&cloudstack.QueryAsyncJobResultResponse{}, nil).MinTimes(1)
+	listParams := &cloudstack.ListSnapshotsParams{}
+	ss.EXPECT().NewListSnapshotsParams().Return(listParams)
+	ss.EXPECT().ListSnapshots(listParams).Return(&cloudstack.ListSnapshotsResponse{
+		Count: 1,
+		Snapshots: []*cloudstack.Snapshot{
+			{Id: testSnapshotID, Name: "snap-1", Volumeid: testVolumeID, State: "Creating"},
+		},
+	}, nil)
+
+	c := &client{CloudStackClient: cs, jobPollInterval: time.Millisecond, jobTimeout: 10 * time.Millisecond}
+	snap, err := c.CreateSnapshot(context.Background(), testVolumeID, "snap-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if snap.ID != testSnapshotID || snap.State != "Creating" {
+		t.Errorf("unexpected snapshot %+v", snap)
+	}
+	if name, _ := listParams.GetName(); name != "snap-1" {
+		t.Errorf("expected lookup by name %q, got %q", "snap-1", name)
+	}
+}
+
+func TestListSnapshots(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cs := cloudstack.NewMockClient(ctrl)
+	ss := cs.Snapshot.(*cloudstack.MockSnapshotServiceIface)
+
+	params := &cloudstack.ListSnapshotsParams{}
+	ss.EXPECT().NewListSnapshotsParams().Return(params)
+	ss.EXPECT().ListSnapshots(params).Return(&cloudstack.ListSnapshotsResponse{
+		Count: 2,
+		Snapshots: []*cloudstack.Snapshot{
+			{Id: testSnapshotID, Volumeid: testVolumeID, State: "BackedUp"},
+			{Id: "9a8b7c6d-5e4f-4a3b-2c1d-0e9f8a7b6c5d", Volumeid: testVolumeID, State: "BackingUp"},
+		},
+	}, nil)
+
+	c := &client{CloudStackClient: cs, projectID: "8b3a9c4d-0e2f-4a6b-9c1d-3e5f7a9b1c2d"}
+	snapshots, err := c.ListSnapshots(context.Background(), testVolumeID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(snapshots) != 2 || snapshots[1].State != "BackingUp" {
+		t.Errorf("unexpected snapshots %+v", snapshots)
+	}
+	if volumeID, _ := params.GetVolumeid(); volumeID != testVolumeID {
+		t.Errorf("expected volume ID filter %q, got %q", testVolumeID, volumeID)
+	}
+	if projectID, _ := params.GetProjectid(); projectID != c.projectID {
+		t.Errorf("expected project ID %q, got %q", c.projectID, projectID)
+	}
+}
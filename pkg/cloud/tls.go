@@ -0,0 +1,44 @@
+package cloud
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// newTLSConfig returns the TLS configuration of the connections to the
+// management servers. When caFile is set, the server certificates are
+// verified against its CA certificates instead of the system ones. When
+// certFile and keyFile are set, the client presents this certificate.
+func newTLSConfig(verifySSL bool, caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: !verifySSL, //nolint:gosec
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid CA certificate found in %s", caFile)
+		}
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("client-cert-file and client-key-file must be set together")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,150 @@
+package cloud
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeClientCertificate writes a self-signed client certificate and its
+// key, and returns it.
+func writeClientCertificate(t *testing.T, certFile, keyFile string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "cloudstack-csi-driver"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeFile(t, certFile, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	writeFile(t, keyFile, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+
+	return cert
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	clientCert := writeClientCertificate(t, certFile, keyFile)
+
+	// A management server with a self-signed certificate, requiring a
+	// client certificate.
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(listZonesBody))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs, MinVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	writeFile(t, caFile, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})))
+
+	tests := []struct {
+		name        string
+		settings    string
+		expectedErr bool
+	}{
+		{
+			name:     "CA and client certificate",
+			settings: "ca-file = " + caFile + "\nclient-cert-file = " + certFile + "\nclient-key-file = " + keyFile + "\n",
+		},
+		{
+			name:     "no verify and client certificate",
+			settings: "ssl-no-verify = true\nclient-cert-file = " + certFile + "\nclient-key-file = " + keyFile + "\n",
+		},
+		{
+			name:        "no client certificate",
+			settings:    "ca-file = " + caFile + "\n",
+			expectedErr: true,
+		},
+		{
+			name:        "system CAs",
+			settings:    "client-cert-file = " + certFile + "\nclient-key-file = " + keyFile + "\n",
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cloud-config")
+			writeFile(t, path, "[Global]\napi-url = "+srv.URL+"/client/api\napi-key = key\nsecret-key = secret\n"+tt.settings)
+			config, err := ReadConfig(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			config.RetryBackoff = testRetryBackoff
+
+			_, err = New(config).ListZonesID(context.Background())
+			if tt.expectedErr && err == nil {
+				t.Error("expected an error")
+			}
+			if !tt.expectedErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestReadConfigTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	writeClientCertificate(t, certFile, keyFile)
+	invalidFile := filepath.Join(dir, "invalid.pem")
+	writeFile(t, invalidFile, "not a certificate")
+
+	tests := []struct {
+		name     string
+		settings string
+	}{
+		{"missing CA file", "ca-file = " + filepath.Join(dir, "missing.crt") + "\n"},
+		{"invalid CA file", "ca-file = " + invalidFile + "\n"},
+		{"certificate without key", "client-cert-file = " + certFile + "\n"},
+		{"key without certificate", "client-key-file = " + keyFile + "\n"},
+		{"invalid key", "client-cert-file = " + certFile + "\nclient-key-file = " + invalidFile + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cloud-config")
+			writeFile(t, path, "[Global]\napi-url = https://cloudstack.example.com/client/api\n"+tt.settings)
+			if _, err := ReadConfig(path); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package cloud
 import (
 	"context"
 
+	"github.com/apache/cloudstack-go/v2/cloudstack"
 	"k8s.io/klog/v2"
 )
 
@@ -14,10 +15,16 @@ func (c *client) GetVMByID(ctx context.Context, vmID string) (*VM, error) {
 	if c.projectID != "" {
 		p.SetProjectid(c.projectID)
 	}
+	c.setOwner(p)
 	logger.V(2).Info("CloudStack API call", "command", "ListVirtualMachines", "params", map[string]string{
 		"id": vmID,
 	})
-	l, err := c.VirtualMachine.ListVirtualMachines(p)
+	var l *cloudstack.ListVirtualMachinesResponse
+	err := c.retry(ctx, "ListVirtualMachines", isTransient, func() (err error) {
+		l, err = c.VirtualMachine.ListVirtualMachines(p)
+
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -30,8 +37,11 @@ func (c *client) GetVMByID(ctx context.Context, vmID string) (*VM, error) {
 	vm := l.VirtualMachines[0]
 
 	return &VM{
-		ID:     vm.Id,
-		ZoneID: vm.Zoneid,
+		ID:         vm.Id,
+		ZoneID:     vm.Zoneid,
+		HostID:     vm.Hostid,
+		State:      vm.State,
+		Hypervisor: vm.Hypervisor,
 	}, nil
 }
 
@@ -43,10 +53,16 @@ func (c *client) getVMByName(ctx context.Context, name string) (*VM, error) {
 	if c.projectID != "" {
 		p.SetProjectid(c.projectID)
 	}
+	c.setOwner(p)
 	logger.V(2).Info("CloudStack API call", "command", "ListVirtualMachines", "params", map[string]string{
 		"name": name,
 	})
-	l, err := c.VirtualMachine.ListVirtualMachines(p)
+	var l *cloudstack.ListVirtualMachinesResponse
+	err := c.retry(ctx, "ListVirtualMachines", isTransient, func() (err error) {
+		l, err = c.VirtualMachine.ListVirtualMachines(p)
+
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -59,7 +75,10 @@ func (c *client) getVMByName(ctx context.Context, name string) (*VM, error) {
 	vm := l.VirtualMachines[0]
 
 	return &VM{
-		ID:     vm.Id,
-		ZoneID: vm.Zoneid,
+		ID:         vm.Id,
+		ZoneID:     vm.Zoneid,
+		HostID:     vm.Hostid,
+		State:      vm.State,
+		Hypervisor: vm.Hypervisor,
 	}, nil
 }
@@ -2,9 +2,11 @@ package cloud
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/apache/cloudstack-go/v2/cloudstack"
 	"k8s.io/klog/v2"
@@ -12,8 +14,13 @@ import (
 	"github.com/leaseweb/cloudstack-csi-driver/pkg/util"
 )
 
-func (c *client) listVolumes(p *cloudstack.ListVolumesParams) (*Volume, error) {
-	l, err := c.Volume.ListVolumes(p)
+func (c *client) listVolumes(ctx context.Context, p *cloudstack.ListVolumesParams) (*Volume, error) {
+	var l *cloudstack.ListVolumesResponse
+	err := c.retry(ctx, "ListVolumes", isTransient, func() (err error) {
+		l, err = c.Volume.ListVolumes(p)
+
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -23,18 +30,39 @@ func (c *client) listVolumes(p *cloudstack.ListVolumesParams) (*Volume, error) {
 	if l.Count > 1 {
 		return nil, ErrTooManyResults
 	}
-	vol := l.Volumes[0]
-	v := Volume{
+
+	return toVolume(l.Volumes[0]), nil
+}
+
+func toVolume(vol *cloudstack.Volume) *Volume {
+	var tags map[string]string
+	if len(vol.Tags) > 0 {
+		tags = make(map[string]string, len(vol.Tags))
+		for _, tag := range vol.Tags {
+			tags[tag.Key] = tag.Value
+		}
+	}
+	createdAt, _ := time.Parse(apiTimeLayout, vol.Created)
+	hypervisor := vol.Hypervisor
+	if hypervisor == "None" {
+		// Allocated volumes are on no storage yet.
+		hypervisor = ""
+	}
+
+	return &Volume{
 		ID:               vol.Id,
 		Name:             vol.Name,
 		Size:             vol.Size,
+		State:            vol.State,
 		DiskOfferingID:   vol.Diskofferingid,
 		ZoneID:           vol.Zoneid,
 		VirtualMachineID: vol.Virtualmachineid,
 		DeviceID:         strconv.FormatInt(vol.Deviceid, 10),
+		Hypervisor:       hypervisor,
+		SnapshotID:       vol.Snapshotid,
+		Tags:             tags,
+		CreatedAt:        createdAt,
 	}
-
-	return &v, nil
 }
 
 func (c *client) GetVolumeByID(ctx context.Context, volumeID string) (*Volume, error) {
@@ -44,11 +72,12 @@ func (c *client) GetVolumeByID(ctx context.Context, volumeID string) (*Volume, e
 	if c.projectID != "" {
 		p.SetProjectid(c.projectID)
 	}
+	c.setOwner(p)
 	logger.V(2).Info("CloudStack API call", "command", "ListVolumes", "params", map[string]string{
 		"id": volumeID,
 	})
 
-	return c.listVolumes(p)
+	return c.listVolumes(ctx, p)
 }
 
 func (c *client) GetVolumeByName(ctx context.Context, name string) (*Volume, error) {
@@ -58,44 +87,206 @@ func (c *client) GetVolumeByName(ctx context.Context, name string) (*Volume, err
 	if c.projectID != "" {
 		p.SetProjectid(c.projectID)
 	}
+	c.setOwner(p)
 	logger.V(2).Info("CloudStack API call", "command", "ListVolumes", "params", map[string]string{
 		"name": name,
 	})
 
-	return c.listVolumes(p)
+	return c.listVolumes(ctx, p)
+}
+
+func (c *client) ListVolumes(ctx context.Context, page, pageSize int) ([]*Volume, int, error) {
+	logger := klog.FromContext(ctx)
+	p := c.Volume.NewListVolumesParams()
+	// Root disks are not managed by the driver.
+	p.SetType("DATADISK")
+	params := map[string]string{
+		"type": "DATADISK",
+	}
+	if pageSize > 0 {
+		p.SetPage(page)
+		p.SetPagesize(pageSize)
+		params["page"] = strconv.Itoa(page)
+		params["pagesize"] = strconv.Itoa(pageSize)
+	}
+	if c.projectID != "" {
+		p.SetProjectid(c.projectID)
+	}
+	c.setOwner(p)
+	logger.V(2).Info("CloudStack API call", "command", "ListVolumes", "params", params)
+	var l *cloudstack.ListVolumesResponse
+	err := c.retry(ctx, "ListVolumes", isTransient, func() (err error) {
+		l, err = c.Volume.ListVolumes(p)
+
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	volumes := make([]*Volume, 0, len(l.Volumes))
+	for _, vol := range l.Volumes {
+		volumes = append(volumes, toVolume(vol))
+	}
+
+	return volumes, l.Count, nil
 }
 
+// CreateVolume creates a volume of sizeInGB with a customized disk
+// offering. A sizeInGB of 0 creates a volume of the size of the offering,
+// which must then not be customized.
 func (c *client) CreateVolume(ctx context.Context, diskOfferingID, zoneID, name string, sizeInGB int64) (string, error) {
 	logger := klog.FromContext(ctx)
 	p := c.Volume.NewCreateVolumeParams()
 	p.SetDiskofferingid(diskOfferingID)
 	p.SetZoneid(zoneID)
 	p.SetName(name)
-	p.SetSize(sizeInGB)
+	params := map[string]string{
+		"diskofferingid": diskOfferingID,
+		"zoneid":         zoneID,
+		"name":           name,
+	}
+	if sizeInGB > 0 {
+		p.SetSize(sizeInGB)
+		params["size"] = strconv.FormatInt(sizeInGB, 10)
+	}
 	if c.projectID != "" {
 		p.SetProjectid(c.projectID)
 	}
+	c.setOwner(p)
+	logger.V(2).Info("CloudStack API call", "command", "CreateVolume", "params", params)
+	var vol *cloudstack.CreateVolumeResponse
+	err := c.retry(ctx, "CreateVolume", notSubmitted, func() (err error) {
+		vol, err = c.Volume.CreateVolume(p)
+
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := c.waitForJob(ctx, "CreateVolume", vol.JobID, nil); err != nil {
+		return "", err
+	}
+
+	return vol.Id, nil
+}
+
+func (c *client) CreateVolumeFromSnapshot(ctx context.Context, zoneID, name, snapshotID string) (string, error) {
+	logger := klog.FromContext(ctx)
+	p := c.Volume.NewCreateVolumeParams()
+	p.SetZoneid(zoneID)
+	p.SetName(name)
+	p.SetSnapshotid(snapshotID)
+	if c.projectID != "" {
+		p.SetProjectid(c.projectID)
+	}
+	c.setOwner(p)
 	logger.V(2).Info("CloudStack API call", "command", "CreateVolume", "params", map[string]string{
-		"diskofferingid": diskOfferingID,
-		"zoneid":         zoneID,
-		"name":           name,
-		"size":           strconv.FormatInt(sizeInGB, 10),
+		"zoneid":     zoneID,
+		"name":       name,
+		"snapshotid": snapshotID,
+	})
+	var vol *cloudstack.CreateVolumeResponse
+	err := c.retry(ctx, "CreateVolume", notSubmitted, func() (err error) {
+		vol, err = c.Volume.CreateVolume(p)
+
+		return err
 	})
-	vol, err := c.Volume.CreateVolume(p)
 	if err != nil {
 		return "", err
 	}
+	if err := c.waitForJob(ctx, "CreateVolume", vol.JobID, nil); err != nil {
+		return "", err
+	}
 
 	return vol.Id, nil
 }
 
+// CloneVolume creates a copy of a volume, named name. CloudStack cannot
+// copy volumes directly: a snapshot of the volume is taken and restored,
+// then deleted.
+func (c *client) CloneVolume(ctx context.Context, volumeID, name string) (string, error) {
+	logger := klog.FromContext(ctx)
+
+	// Reuse the snapshot of a previous attempt, if any.
+	snap, err := c.GetSnapshotByName(ctx, name)
+	if errors.Is(err, ErrNotFound) {
+		p := c.Snapshot.NewCreateSnapshotParams(volumeID)
+		p.SetName(name)
+		logger.V(2).Info("CloudStack API call", "command", "CreateSnapshot", "params", map[string]string{
+			"volumeid": volumeID,
+			"name":     name,
+		})
+		var r *cloudstack.CreateSnapshotResponse
+		err = c.retry(ctx, "CreateSnapshot", notSubmitted, func() (err error) {
+			r, err = c.Snapshot.CreateSnapshot(p)
+
+			return err
+		})
+		if err == nil {
+			err = c.waitForJob(ctx, "CreateSnapshot", r.JobID, r)
+		}
+		if err == nil {
+			snap = &Snapshot{ID: r.Id, VolumeID: r.Volumeid, ZoneID: r.Zoneid, State: r.State}
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to snapshot volume %s: %w", volumeID, err)
+	}
+	if snap.VolumeID != volumeID {
+		return "", fmt.Errorf("snapshot %s is not a snapshot of volume %s", name, volumeID)
+	}
+
+	volID, err := c.CreateVolumeFromSnapshot(ctx, snap.ZoneID, name, snap.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to create volume from snapshot %s: %w", snap.ID, err)
+	}
+
+	if err := c.DeleteSnapshot(ctx, snap.ID); err != nil {
+		logger.Error(err, "Failed to delete temporary snapshot", "snapshotID", snap.ID)
+	}
+
+	return volID, nil
+}
+
+func (c *client) CreateVolumeTags(ctx context.Context, volumeID string, tags map[string]string) error {
+	logger := klog.FromContext(ctx)
+	p := c.Resourcetags.NewCreateTagsParams([]string{volumeID}, "Volume", tags)
+	logger.V(2).Info("CloudStack API call", "command", "CreateTags", "params", map[string]interface{}{
+		"resourceids":  volumeID,
+		"resourcetype": "Volume",
+		"tags":         tags,
+	})
+	var r *cloudstack.CreateTagsResponse
+	err := c.retry(ctx, "CreateTags", notSubmitted, func() (err error) {
+		r, err = c.Resourcetags.CreateTags(p)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.waitForJob(ctx, "CreateTags", r.JobID, nil)
+}
+
 func (c *client) DeleteVolume(ctx context.Context, id string) error {
 	logger := klog.FromContext(ctx)
+	if c.projectID != "" || c.domainID != "" {
+		// deleteVolume has no projectid or domainid parameter: make sure
+		// the volume belongs to the project or domain before deleting it.
+		if _, err := c.GetVolumeByID(ctx, id); err != nil {
+			return err
+		}
+	}
 	p := c.Volume.NewDeleteVolumeParams(id)
 	logger.V(2).Info("CloudStack API call", "command", "DeleteVolume", "params", map[string]string{
 		"id": id,
 	})
-	_, err := c.Volume.DeleteVolume(p)
+	err := c.retry(ctx, "DeleteVolume", isTransient, func() error {
+		_, err := c.Volume.DeleteVolume(p)
+
+		return err
+	})
 	if err != nil && strings.Contains(err.Error(), "4350") {
 		// CloudStack error InvalidParameterValueException
 		return ErrNotFound
@@ -105,13 +296,40 @@ func (c *client) DeleteVolume(ctx context.Context, id string) error {
 }
 
 func (c *client) AttachVolume(ctx context.Context, volumeID, vmID string) (string, error) {
+	return c.AttachVolumeToDevice(ctx, volumeID, vmID, 0)
+}
+
+// AttachVolumeToDevice attaches the volume with the given device ID, the
+// slot of the disk in the VM, or the one chosen by CloudStack if 0. It
+// returns ErrDeviceIDInUse when CloudStack refuses the device ID, e.g.
+// when used by another disk.
+func (c *client) AttachVolumeToDevice(ctx context.Context, volumeID, vmID string, deviceID int64) (string, error) {
 	logger := klog.FromContext(ctx)
 	p := c.Volume.NewAttachVolumeParams(volumeID, vmID)
-	logger.V(2).Info("CloudStack API call", "command", "AttachVolume", "params", map[string]string{
+	params := map[string]string{
 		"id":               volumeID,
 		"virtualmachineid": vmID,
+	}
+	if deviceID > 0 {
+		p.SetDeviceid(deviceID)
+		params["deviceid"] = strconv.FormatInt(deviceID, 10)
+	}
+	logger.V(2).Info("CloudStack API call", "command", "AttachVolume", "params", params)
+	var r *cloudstack.AttachVolumeResponse
+	err := c.retry(ctx, "AttachVolume", isTransient, func() (err error) {
+		r, err = c.Volume.AttachVolume(p)
+
+		return err
 	})
-	r, err := c.Volume.AttachVolume(p)
+	if err == nil {
+		err = c.waitForJob(ctx, "AttachVolume", r.JobID, r)
+	}
+	if err != nil && strings.Contains(err.Error(), "maximum number of data disks") {
+		return "", fmt.Errorf("%w: %w", ErrMaxVolumesReached, err)
+	}
+	if err != nil && deviceID > 0 && isDeviceIDConflict(err) {
+		return "", fmt.Errorf("%w: %w", ErrDeviceIDInUse, err)
+	}
 	if err != nil {
 		return "", err
 	}
@@ -119,6 +337,14 @@ func (c *client) AttachVolume(ctx context.Context, volumeID, vmID string) (strin
 	return strconv.FormatInt(r.Deviceid, 10), nil
 }
 
+// isDeviceIDConflict tells whether CloudStack refused the device ID of an
+// attachment, used by another disk or reserved, e.g. for the CD-ROM.
+func isDeviceIDConflict(err error) bool {
+	msg := strings.ToLower(err.Error())
+
+	return strings.Contains(msg, "deviceid") && (strings.Contains(msg, "is used") || strings.Contains(msg, "reserved"))
+}
+
 func (c *client) DetachVolume(ctx context.Context, volumeID string) error {
 	logger := klog.FromContext(ctx)
 	p := c.Volume.NewDetachVolumeParams()
@@ -126,19 +352,67 @@ func (c *client) DetachVolume(ctx context.Context, volumeID string) error {
 	logger.V(2).Info("CloudStack API call", "command", "DetachVolume", "params", map[string]string{
 		"id": volumeID,
 	})
-	_, err := c.Volume.DetachVolume(p)
+	var r *cloudstack.DetachVolumeResponse
+	err := c.retry(ctx, "DetachVolume", isTransient, func() (err error) {
+		r, err = c.Volume.DetachVolume(p)
 
-	return err
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.waitForJob(ctx, "DetachVolume", r.JobID, nil)
+}
+
+// ForceDetachVolume issues the detachment of the volume by its ID, without
+// waiting for the job to complete. CloudStack detaches the volumes of
+// stopped VMs without the hypervisor, but a job may still be queued behind
+// one stuck on an unreachable host.
+func (c *client) ForceDetachVolume(ctx context.Context, volumeID string) error {
+	logger := klog.FromContext(ctx)
+	p := c.Volume.NewDetachVolumeParams()
+	p.SetId(volumeID)
+	logger.V(2).Info("CloudStack API call", "command", "DetachVolume", "params", map[string]string{
+		"id": volumeID,
+	})
+
+	return c.retry(ctx, "DetachVolume", isTransient, func() error {
+		r, err := c.Volume.DetachVolume(p)
+		if err == nil {
+			logger.V(4).Info("Detachment issued", "volumeID", volumeID, "jobID", r.JobID)
+		}
+
+		return err
+	})
 }
 
 // ExpandVolume expands the volume to new size.
 func (c *client) ExpandVolume(ctx context.Context, volumeID string, newSizeInGB int64) error {
 	logger := klog.FromContext(ctx)
-	volume, _, err := c.Volume.GetVolumeByID(volumeID)
+	lp := c.Volume.NewListVolumesParams()
+	lp.SetId(volumeID)
+	if c.projectID != "" {
+		lp.SetProjectid(c.projectID)
+	}
+	c.setOwner(lp)
+	var l *cloudstack.ListVolumesResponse
+	err := c.retry(ctx, "ListVolumes", isTransient, func() (err error) {
+		l, err = c.Volume.ListVolumes(lp)
+
+		return err
+	})
+	if err == nil && l.Count == 0 {
+		err = ErrNotFound
+	}
+	if err == nil && l.Count > 1 {
+		err = ErrTooManyResults
+	}
 	if err != nil {
 		return fmt.Errorf("failed to retrieve volume '%s': %w", volumeID, err)
 	}
-	if volume.State != "Allocated" && volume.State != "Ready" {
+	volume := l.Volumes[0]
+	if volume.State != VolumeAllocated && volume.State != VolumeReady {
 		return fmt.Errorf("volume '%s' is not in 'Allocated' or 'Ready' state to get resized", volumeID)
 	}
 	currentSize := volume.Size
@@ -154,7 +428,15 @@ func (c *client) ExpandVolume(ctx context.Context, volumeID string, newSizeInGB
 		"requested_size": strconv.FormatInt(newSizeInGB, 10),
 	})
 	// Execute the API call to resize the volume.
-	_, err = c.Volume.ResizeVolume(p)
+	var r *cloudstack.ResizeVolumeResponse
+	err = c.retry(ctx, "ResizeVolume", isTransient, func() (err error) {
+		r, err = c.Volume.ResizeVolume(p)
+
+		return err
+	})
+	if err == nil {
+		err = c.waitForJob(ctx, "ResizeVolume", r.JobID, nil)
+	}
 	if err != nil {
 		// Handle the error accordingly
 		return fmt.Errorf("failed to expand volume '%s': %w", volumeID, err)
@@ -0,0 +1,351 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/apache/cloudstack-go/v2/cloudstack"
+	"github.com/golang/mock/gomock"
+)
+
+func TestCreateVolumeTags(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cs := cloudstack.NewMockClient(ctrl)
+	rs := cs.Resourcetags.(*cloudstack.MockResourcetagsServiceIface)
+
+	volumeID := "ace9f28b-3081-40c1-8353-4cc3e3014072"
+	tags := map[string]string{
+		"kubernetes.io/created-for/pvc/name":      "data",
+		"kubernetes.io/created-for/pvc/namespace": "default",
+	}
+	params := &cloudstack.CreateTagsParams{}
+	rs.EXPECT().NewCreateTagsParams([]string{volumeID}, "Volume", tags).Return(params)
+	rs.EXPECT().CreateTags(params).Return(&cloudstack.CreateTagsResponse{Success: true}, nil)
+
+	c := &client{CloudStackClient: cs}
+	if err := c.CreateVolumeTags(context.Background(), volumeID, tags); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestForceDetachVolume(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cs := cloudstack.NewMockClient(ctrl)
+	vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+
+	// The job is not polled.
+	params := &cloudstack.DetachVolumeParams{}
+	vs.EXPECT().NewDetachVolumeParams().Return(params)
+	vs.EXPECT().DetachVolume(params).Return(&cloudstack.DetachVolumeResponse{JobID: testJobID}, nil)
+
+	c := &client{CloudStackClient: cs, retryBackoff: testRetryBackoff}
+	if err := c.ForceDetachVolume(context.Background(), testVolumeID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id, _ := params.GetId(); id != testVolumeID {
+		t.Errorf("expected volume ID %s, got %q", testVolumeID, id)
+	}
+}
+
+func TestCloneVolume(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cs := cloudstack.NewMockClient(ctrl)
+	ss := cs.Snapshot.(*cloudstack.MockSnapshotServiceIface)
+	vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+
+	const (
+		sourceID   = "ace9f28b-3081-40c1-8353-4cc3e3014072"
+		snapshotID = "3f5c2a1b-7d4e-4b6a-9c8d-0e1f2a3b4c5d"
+		cloneID    = "5e6f7a8b-9c0d-4e1f-a2b3-c4d5e6f7a8b9"
+		zoneID     = "a1887604-237c-4212-a9cd-94620b7880fa"
+	)
+
+	listParams := &cloudstack.ListSnapshotsParams{}
+	ss.EXPECT().NewListSnapshotsParams().Return(listParams)
+	ss.EXPECT().ListSnapshots(listParams).Return(&cloudstack.ListSnapshotsResponse{}, nil)
+	snapParams := &cloudstack.CreateSnapshotParams{}
+	ss.EXPECT().NewCreateSnapshotParams(sourceID).Return(snapParams)
+	ss.EXPECT().CreateSnapshot(snapParams).Return(&cloudstack.CreateSnapshotResponse{
+		Id: snapshotID, Volumeid: sourceID, Zoneid: zoneID, State: "BackedUp",
+	}, nil)
+	volParams := &cloudstack.CreateVolumeParams{}
+	vs.EXPECT().NewCreateVolumeParams().Return(volParams)
+	vs.EXPECT().CreateVolume(volParams).Return(&cloudstack.CreateVolumeResponse{Id: cloneID}, nil)
+	deleteParams := &cloudstack.DeleteSnapshotParams{}
+	ss.EXPECT().NewDeleteSnapshotParams(snapshotID).Return(deleteParams)
+	ss.EXPECT().DeleteSnapshot(deleteParams).Return(&cloudstack.DeleteSnapshotResponse{Success: true}, nil)
+
+	c := &client{CloudStackClient: cs}
+	id, err := c.CloneVolume(context.Background(), sourceID, "clone")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id != cloneID {
+		t.Errorf("expected volume %s, got %s", cloneID, id)
+	}
+	if got, _ := volParams.GetSnapshotid(); got != snapshotID {
+		t.Errorf("expected volume created from snapshot %s, got %s", snapshotID, got)
+	}
+	if got, _ := volParams.GetZoneid(); got != zoneID {
+		t.Errorf("expected volume created in zone %s, got %s", zoneID, got)
+	}
+}
+
+func TestProjectScopedVolumes(t *testing.T) {
+	const projectID = "8b3a9c4d-0e2f-4a6b-9c1d-3e5f7a9b1c2d"
+
+	t.Run("create", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		cs := cloudstack.NewMockClient(ctrl)
+		vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+
+		params := &cloudstack.CreateVolumeParams{}
+		vs.EXPECT().NewCreateVolumeParams().Return(params)
+		vs.EXPECT().CreateVolume(params).Return(&cloudstack.CreateVolumeResponse{Id: testVolumeID}, nil)
+
+		c := &client{CloudStackClient: cs, projectID: projectID}
+		if _, err := c.CreateVolume(context.Background(), "offering", "zone", "pvc-1", 1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, _ := params.GetProjectid(); got != projectID {
+			t.Errorf("expected project ID %q, got %q", projectID, got)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		cs := cloudstack.NewMockClient(ctrl)
+		vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+
+		params := &cloudstack.ListVolumesParams{}
+		vs.EXPECT().NewListVolumesParams().Return(params)
+		vs.EXPECT().ListVolumes(params).Return(&cloudstack.ListVolumesResponse{
+			Count:   1,
+			Volumes: []*cloudstack.Volume{{Id: testVolumeID, Name: "pvc-1"}},
+		}, nil)
+
+		c := &client{CloudStackClient: cs, projectID: projectID}
+		if _, err := c.GetVolumeByName(context.Background(), "pvc-1"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, _ := params.GetProjectid(); got != projectID {
+			t.Errorf("expected project ID %q, got %q", projectID, got)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		cs := cloudstack.NewMockClient(ctrl)
+		vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+
+		listParams := &cloudstack.ListVolumesParams{}
+		vs.EXPECT().NewListVolumesParams().Return(listParams)
+		vs.EXPECT().ListVolumes(listParams).Return(&cloudstack.ListVolumesResponse{
+			Count:   1,
+			Volumes: []*cloudstack.Volume{{Id: testVolumeID}},
+		}, nil)
+		deleteParams := &cloudstack.DeleteVolumeParams{}
+		vs.EXPECT().NewDeleteVolumeParams(testVolumeID).Return(deleteParams)
+		vs.EXPECT().DeleteVolume(deleteParams).Return(&cloudstack.DeleteVolumeResponse{Success: true}, nil)
+
+		c := &client{CloudStackClient: cs, projectID: projectID}
+		if err := c.DeleteVolume(context.Background(), testVolumeID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, _ := listParams.GetProjectid(); got != projectID {
+			t.Errorf("expected project ID %q, got %q", projectID, got)
+		}
+	})
+
+	t.Run("delete volume of another project", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		cs := cloudstack.NewMockClient(ctrl)
+		vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+
+		listParams := &cloudstack.ListVolumesParams{}
+		vs.EXPECT().NewListVolumesParams().Return(listParams)
+		vs.EXPECT().ListVolumes(listParams).Return(&cloudstack.ListVolumesResponse{}, nil)
+
+		c := &client{CloudStackClient: cs, projectID: projectID}
+		if err := c.DeleteVolume(context.Background(), testVolumeID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("expand", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		cs := cloudstack.NewMockClient(ctrl)
+		vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+
+		listParams := &cloudstack.ListVolumesParams{}
+		vs.EXPECT().NewListVolumesParams().Return(listParams)
+		vs.EXPECT().ListVolumes(listParams).Return(&cloudstack.ListVolumesResponse{
+			Count:   1,
+			Volumes: []*cloudstack.Volume{{Id: testVolumeID, State: "Ready", Size: 1 << 30}},
+		}, nil)
+		resizeParams := &cloudstack.ResizeVolumeParams{}
+		vs.EXPECT().NewResizeVolumeParams(testVolumeID).Return(resizeParams)
+		vs.EXPECT().ResizeVolume(resizeParams).Return(&cloudstack.ResizeVolumeResponse{}, nil)
+
+		c := &client{CloudStackClient: cs, projectID: projectID}
+		if err := c.ExpandVolume(context.Background(), testVolumeID, 2); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, _ := listParams.GetProjectid(); got != projectID {
+			t.Errorf("expected project ID %q, got %q", projectID, got)
+		}
+	})
+}
+
+func TestListVolumes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cs := cloudstack.NewMockClient(ctrl)
+	vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+
+	params := &cloudstack.ListVolumesParams{}
+	vs.EXPECT().NewListVolumesParams().Return(params)
+	vs.EXPECT().ListVolumes(params).Return(&cloudstack.ListVolumesResponse{
+		Count: 5,
+		Volumes: []*cloudstack.Volume{
+			{Id: "3f5c2a1b-7d4e-4b6a-9c8d-0e1f2a3b4c5d", State: "Ready", Virtualmachineid: "0d7107a3-94d2-44e7-89b8-8930881309a5", Deviceid: 1},
+			{Id: "5e6f7a8b-9c0d-4e1f-a2b3-c4d5e6f7a8b9", State: "Allocated"},
+		},
+	}, nil)
+
+	c := &client{CloudStackClient: cs}
+	volumes, total, err := c.ListVolumes(context.Background(), 2, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if total != 5 {
+		t.Errorf("expected 5 volumes in total, got %d", total)
+	}
+	if len(volumes) != 2 || volumes[0].VirtualMachineID != "0d7107a3-94d2-44e7-89b8-8930881309a5" || volumes[1].State != VolumeAllocated {
+		t.Errorf("unexpected volumes %+v", volumes)
+	}
+	if page, _ := params.GetPage(); page != 2 {
+		t.Errorf("expected page 2, got %d", page)
+	}
+	if pageSize, _ := params.GetPagesize(); pageSize != 2 {
+		t.Errorf("expected page size 2, got %d", pageSize)
+	}
+	if volumeType, _ := params.GetType(); volumeType != "DATADISK" {
+		t.Errorf("expected type DATADISK, got %q", volumeType)
+	}
+}
+
+func TestDomainScopedVolumes(t *testing.T) {
+	const (
+		domainID = "2e7a4c1b-9f3d-4b6a-8e5c-7d1f3a9b5c2e"
+		account  = "kubernetes"
+	)
+
+	t.Run("create", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		cs := cloudstack.NewMockClient(ctrl)
+		vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+
+		params := &cloudstack.CreateVolumeParams{}
+		vs.EXPECT().NewCreateVolumeParams().Return(params)
+		vs.EXPECT().CreateVolume(params).Return(&cloudstack.CreateVolumeResponse{Id: testVolumeID}, nil)
+
+		c := &client{CloudStackClient: cs, domainID: domainID, account: account}
+		if _, err := c.CreateVolume(context.Background(), "offering", "zone", "pvc-1", 1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, _ := params.GetDomainid(); got != domainID {
+			t.Errorf("expected domain ID %q, got %q", domainID, got)
+		}
+		if got, _ := params.GetAccount(); got != account {
+			t.Errorf("expected account %q, got %q", account, got)
+		}
+	})
+
+	t.Run("duplicate check", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		cs := cloudstack.NewMockClient(ctrl)
+		vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+
+		params := &cloudstack.ListVolumesParams{}
+		vs.EXPECT().NewListVolumesParams().Return(params)
+		vs.EXPECT().ListVolumes(params).Return(&cloudstack.ListVolumesResponse{}, nil)
+
+		c := &client{CloudStackClient: cs, domainID: domainID}
+		if _, err := c.GetVolumeByName(context.Background(), "pvc-1"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+		if got, _ := params.GetDomainid(); got != domainID {
+			t.Errorf("expected domain ID %q, got %q", domainID, got)
+		}
+		if got, ok := params.GetAccount(); ok {
+			t.Errorf("expected no account, got %q", got)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		cs := cloudstack.NewMockClient(ctrl)
+		vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+
+		params := &cloudstack.ListVolumesParams{}
+		vs.EXPECT().NewListVolumesParams().Return(params)
+		vs.EXPECT().ListVolumes(params).Return(&cloudstack.ListVolumesResponse{}, nil)
+
+		c := &client{CloudStackClient: cs, domainID: domainID, account: account}
+		if _, _, err := c.ListVolumes(context.Background(), 1, 10); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, _ := params.GetDomainid(); got != domainID {
+			t.Errorf("expected domain ID %q, got %q", domainID, got)
+		}
+		if got, _ := params.GetAccount(); got != account {
+			t.Errorf("expected account %q, got %q", account, got)
+		}
+	})
+
+	t.Run("delete volume of another domain", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		cs := cloudstack.NewMockClient(ctrl)
+		vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+
+		listParams := &cloudstack.ListVolumesParams{}
+		vs.EXPECT().NewListVolumesParams().Return(listParams)
+		vs.EXPECT().ListVolumes(listParams).Return(&cloudstack.ListVolumesResponse{}, nil)
+
+		c := &client{CloudStackClient: cs, domainID: domainID}
+		if err := c.DeleteVolume(context.Background(), testVolumeID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+		if got, _ := listParams.GetDomainid(); got != domainID {
+			t.Errorf("expected domain ID %q, got %q", domainID, got)
+		}
+	})
+}
+
+func TestAttachVolumeToDeviceInUse(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	cs := cloudstack.NewMockClient(ctrl)
+	vs := cs.Volume.(*cloudstack.MockVolumeServiceIface)
+	as := cs.Asyncjob.(*cloudstack.MockAsyncjobServiceIface)
+
+	params := &cloudstack.AttachVolumeParams{}
+	vs.EXPECT().NewAttachVolumeParams(testVolumeID, "vm").Return(params)
+	vs.EXPECT().AttachVolume(params).Return(&cloudstack.AttachVolumeResponse{JobID: testJobID}, nil)
+	jobParams := &cloudstack.QueryAsyncJobResultParams{}
+	as.EXPECT().NewQueryAsyncJobResultParams(testJobID).Return(jobParams)
+	as.EXPECT().QueryAsyncJobResult(jobParams).Return(&cloudstack.QueryAsyncJobResultResponse{
+		Jobstatus: jobFailed,
+		Jobresult: []byte(`{"errorcode":530,"errortext":"deviceId 2 is used by vm 12"}`),
+	}, nil)
+
+	c := &client{CloudStackClient: cs, jobPollInterval: time.Millisecond, jobTimeout: time.Minute}
+	_, err := c.AttachVolumeToDevice(context.Background(), testVolumeID, "vm", 2)
+	if !errors.Is(err, ErrDeviceIDInUse) {
+		t.Errorf("expected ErrDeviceIDInUse, got %v", err)
+	}
+	if deviceID, _ := params.GetDeviceid(); deviceID != 2 {
+		t.Errorf("expected device ID 2 to be requested, got %d", deviceID)
+	}
+}
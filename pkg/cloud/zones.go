@@ -2,11 +2,51 @@ package cloud
 
 import (
 	"context"
+	"slices"
+	"sync"
+	"time"
 
+	"github.com/apache/cloudstack-go/v2/cloudstack"
 	"k8s.io/klog/v2"
 )
 
+// DefaultZoneCacheTTL is the default time during which the list of zones
+// is reused by ListZonesID.
+const DefaultZoneCacheTTL = 5 * time.Minute
+
+// zoneCache holds the IDs of the available zones, which seldom change,
+// for ttl. Its lock is held while listing the zones, so that concurrent
+// callers share a single API call.
+type zoneCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	zoneIDs []string
+	expires time.Time
+}
+
+// ListZonesID returns the IDs of the available zones, from the cache if
+// enabled and not expired.
 func (c *client) ListZonesID(ctx context.Context) ([]string, error) {
+	if c.zones.ttl <= 0 {
+		return c.listZonesID(ctx)
+	}
+
+	c.zones.mu.Lock()
+	defer c.zones.mu.Unlock()
+	if time.Now().Before(c.zones.expires) {
+		return slices.Clone(c.zones.zoneIDs), nil
+	}
+	zoneIDs, err := c.listZonesID(ctx)
+	if err != nil {
+		return zoneIDs, err
+	}
+	c.zones.zoneIDs = zoneIDs
+	c.zones.expires = time.Now().Add(c.zones.ttl)
+
+	return slices.Clone(zoneIDs), nil
+}
+
+func (c *client) listZonesID(ctx context.Context) ([]string, error) {
 	logger := klog.FromContext(ctx)
 	result := make([]string, 0)
 	p := c.Zone.NewListZonesParams()
@@ -14,7 +54,12 @@ func (c *client) ListZonesID(ctx context.Context) ([]string, error) {
 	logger.V(2).Info("CloudStack API call", "command", "ListZones", "params", map[string]string{
 		"available": "true",
 	})
-	r, err := c.Zone.ListZones(p)
+	var r *cloudstack.ListZonesResponse
+	err := c.retry(ctx, "ListZones", isTransient, func() (err error) {
+		r, err = c.Zone.ListZones(p)
+
+		return err
+	})
 	if err != nil {
 		return result, err
 	}
@@ -24,3 +69,22 @@ func (c *client) ListZonesID(ctx context.Context) ([]string, error) {
 
 	return result, nil
 }
+
+// Ping checks that the management server answers API calls, with the
+// lightest call available. It is not retried.
+func (c *client) Ping(ctx context.Context) error {
+	logger := klog.FromContext(ctx)
+	p := c.Zone.NewListZonesParams()
+	p.SetPage(1)
+	p.SetPagesize(1)
+	logger.V(4).Info("CloudStack API call", "command", "ListZones", "params", map[string]string{
+		"page":     "1",
+		"pagesize": "1",
+	})
+
+	return c.call(ctx, "ListZones", func() error {
+		_, err := c.Zone.ListZones(p)
+
+		return err
+	})
+}
@@ -0,0 +1,59 @@
+package cloud
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestListZonesIDCache(t *testing.T) {
+	srv, hits := newAPIServer(t, http.StatusOK, listZonesBody)
+	c, ok := New(&Config{APIURL: srv.URL + "/client/api", ZoneCacheTTL: time.Hour}).(*client)
+	if !ok {
+		t.Fatal("New did not return a *client")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			zones, err := c.ListZonesID(context.Background())
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if len(zones) != 1 {
+				t.Errorf("expected 1 zone, got %v", zones)
+			}
+		}()
+	}
+	wg.Wait()
+	if hits.Load() != 1 {
+		t.Errorf("expected 1 request, got %d", hits.Load())
+	}
+
+	// The zones are listed again once the cache expired.
+	c.zones.expires = time.Now()
+	if _, err := c.ListZonesID(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hits.Load() != 2 {
+		t.Errorf("expected 2 requests, got %d", hits.Load())
+	}
+}
+
+func TestListZonesIDNoCache(t *testing.T) {
+	srv, hits := newAPIServer(t, http.StatusOK, listZonesBody)
+	c := New(&Config{APIURL: srv.URL + "/client/api"})
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.ListZonesID(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if hits.Load() != 2 {
+		t.Errorf("expected 2 requests, got %d", hits.Load())
+	}
+}
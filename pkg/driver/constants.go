@@ -1,5 +1,7 @@
 package driver
 
+import "time"
+
 // DriverName is the name of the CSI plugin.
 const DriverName = "csi.cloudstack.apache.org"
 
@@ -21,6 +23,39 @@ const (
 	// DefaultCSIEndpoint is the default CSI endpoint for the driver.
 	DefaultCSIEndpoint             = "unix://tmp/csi.sock"
 	DefaultMaxVolAttachLimit int64 = 256
+	// DefaultVolumeAttachSlots is the number of virtio disk slots of a KVM instance.
+	DefaultVolumeAttachSlots int64 = 24
+	// DefaultReservedVolumeAttachments is the number of slots used by
+	// the root disk and the CD-ROM drive.
+	DefaultReservedVolumeAttachments int64 = 2
+	// DefaultGRPCMaxMsgSize is the maximum size of the gRPC messages,
+	// 16 MiB, leaving room for large ListVolumes responses.
+	DefaultGRPCMaxMsgSize = 16 * 1024 * 1024
+	// DefaultGRPCKeepaliveMinTime is lower than the keepalive interval of
+	// the CSI sidecars, so that their connections are not dropped.
+	DefaultGRPCKeepaliveMinTime = 10 * time.Second
+	// DefaultMaxCustomVolumeSize is the default of the
+	// custom.diskoffering.size.max CloudStack setting, in GB.
+	DefaultMaxCustomVolumeSize int64 = 1024
+	// DefaultMaxVolumeNameLength is the length of the name column of the
+	// volumes in the CloudStack database.
+	DefaultMaxVolumeNameLength = 255
+)
+
+const (
+	// volumeNameHashLength is the length of the suffix of the shortened
+	// volume names: a dash and 16 hexadecimal digits of a hash.
+	volumeNameHashLength = 17
+	// minVolumeNameLength leaves room for some of the name before the
+	// hash of the shortened volume names.
+	minVolumeNameLength = 32
+)
+
+// Node annotations.
+const (
+	// VolumeAttachLimitAnnotation overrides the maximum number of volumes
+	// attachable to a node.
+	VolumeAttachLimitAnnotation = DriverName + "/volume-attach-limit"
 )
 
 // Filesystem types.
@@ -33,17 +68,99 @@ const (
 	FSTypeExt4 = "ext4"
 	// FSTypeXfs represents the xfs filesystem type.
 	FSTypeXfs = "xfs"
+	// FSTypeBtrfs represents the btrfs filesystem type.
+	FSTypeBtrfs = "btrfs"
 )
 
 // Topology keys.
 const (
 	ZoneKey = "topology." + DriverName + "/zone"
 	HostKey = "topology." + DriverName + "/host"
+	// PodKey and ClusterKey are the finer failure domains of the nodes
+	// running with --topology-segments.
+	PodKey     = "topology." + DriverName + "/pod"
+	ClusterKey = "topology." + DriverName + "/cluster"
+)
+
+// Optional topology segments, as named in --topology-segments and in
+// the TopologySegmentsKey volume parameter.
+const (
+	PodSegment     = "pod"
+	ClusterSegment = "cluster"
 )
 
 // Volume parameters keys.
 const (
 	DiskOfferingKey = DriverName + "/disk-offering-id"
+	// DiskOfferingNameKey may be used instead of DiskOfferingKey to
+	// select the disk offering by its name.
+	DiskOfferingNameKey = DriverName + "/disk-offering-name"
+	// MkfsOptionsKey holds extra options passed to mkfs when formatting a volume.
+	MkfsOptionsKey = DriverName + "/mkfs-options"
+	// Ext4LazyInitKey tells whether the inode tables and the journal of
+	// ext4 volumes are initialized lazily: true, the default, or false.
+	Ext4LazyInitKey = DriverName + "/ext4-lazy-init"
+	// HypervisorKey holds the hypervisor of the volume, as named by
+	// CloudStack, e.g. KVM or XenServer. It may be set in the parameters,
+	// for disk offerings targeting a single hypervisor, and is set by the
+	// controller in the volume context when the volume is on a storage.
+	// The node uses it to find the disk of the volume.
+	HypervisorKey = DriverName + "/hypervisor"
+	// ZoneIDKey holds the ID of the zone of the volume. It is set by the
+	// controller in the volume context, along with DiskOfferingKey.
+	ZoneIDKey = DriverName + "/zone-id"
+	// FilesystemUUIDKey holds the UUID the device of a volume must have to be staged.
+	FilesystemUUIDKey = DriverName + "/filesystem-uuid"
+	// SELinuxLabelKey holds the SELinux label given to all the files of a volume.
+	SELinuxLabelKey = DriverName + "/selinux-label"
+	// StorageTagsKey holds the comma-separated storage tags of the primary
+	// storages the volume must be placed on.
+	StorageTagsKey = DriverName + "/storage-tags"
+	// ProvisioningTypeKey holds the provisioning type of the volume: thin,
+	// sparse or fat. It must be the one of the disk offering.
+	ProvisioningTypeKey = DriverName + "/provisioning-type"
+	// TopologySegmentsKey holds the comma-separated optional topology
+	// segments, e.g. "pod,cluster", the volume is restricted to, in
+	// addition to its zone.
+	TopologySegmentsKey = DriverName + "/topology-segments"
+	// EphemeralSizeKey holds the size of an ephemeral inline volume, as a
+	// quantity, e.g. "10Gi".
+	EphemeralSizeKey = DriverName + "/size"
+)
+
+// Volume context keys set by the kubelet.
+const (
+	// EphemeralKey is "true" for the ephemeral inline volumes, defined in
+	// the pod spec rather than by a PVC.
+	EphemeralKey = "csi.storage.k8s.io/ephemeral"
+)
+
+// Volume parameters keys set by the external-provisioner, when run
+// with --extra-create-metadata.
+const (
+	PVCNameKey      = "csi.storage.k8s.io/pvc/name"
+	PVCNamespaceKey = "csi.storage.k8s.io/pvc/namespace"
+	PVNameKey       = "csi.storage.k8s.io/pv/name"
+)
+
+// Volume tags keys.
+const (
+	PVCNameTag      = "kubernetes.io/created-for/pvc/name"
+	PVCNamespaceTag = "kubernetes.io/created-for/pvc/namespace"
+	PVNameTag       = "kubernetes.io/created-for/pv/name"
+	ClusterIDTag    = DriverName + "/cluster-id"
+)
+
+// Secret keys.
+const (
+	// EncryptionPassphraseKey is the NodeStageVolume secret holding the
+	// passphrase used to encrypt the volume with LUKS.
+	EncryptionPassphraseKey = "encryption-passphrase"
 )
 
-const deviceIDContextKey = "deviceID"
+// Publish context keys.
+const (
+	deviceIDContextKey = "deviceID"
+	// readonlyContextKey is "true" for the volumes published read-only.
+	readonlyContextKey = "readonly"
+)
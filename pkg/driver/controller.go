@@ -2,26 +2,36 @@ package driver
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"k8s.io/klog/v2"
 
 	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud"
 	"github.com/leaseweb/cloudstack-csi-driver/pkg/util"
 )
 
-// onlyVolumeCapAccessMode is the only volume capability access
-// mode possible for CloudStack: SINGLE_NODE_WRITER, since a
+// volumeCapAccessModes are the volume capability access modes
+// possible for CloudStack: the single node writer ones, since a
 // CloudStack volume can only be attached to a single node at
-// any given time.
-var onlyVolumeCapAccessMode = csi.VolumeCapability_AccessMode{
-	Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+// any given time. With SINGLE_NODE_MULTI_WRITER, several pods of
+// the node may publish the volume.
+var volumeCapAccessModes = []csi.VolumeCapability_AccessMode_Mode{
+	csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+	csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+	csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
 }
 
 type controllerServer struct {
@@ -35,14 +45,89 @@ type controllerServer struct {
 
 	// A map storing all volumes/snapshots with ongoing operations.
 	operationLocks *util.OperationLock
+
+	// clusterID is added to the tags of the created volumes.
+	clusterID string
+
+	// requireTags makes volume creation fail when tagging fails.
+	requireTags bool
+
+	// maxCustomVolumeSize is the maximum size in GB of the volumes of
+	// customized disk offerings, or 0.
+	maxCustomVolumeSize int64
+
+	// minVolumeSize and maxVolumeSize bound the size in bytes of the
+	// volumes, or are 0.
+	minVolumeSize int64
+	maxVolumeSize int64
+
+	// volumeNamePrefix and maxVolumeNameLength derive the names of the
+	// CloudStack volumes from the names of the requests.
+	volumeNamePrefix    string
+	maxVolumeNameLength int
+
+	// readonlyVolumes remembers whether the volumes attached since the
+	// controller started were published read-only, to refuse publishing
+	// them again with another mode. It is keyed by volume ID. It is only
+	// kept in memory, as CloudStack has no read-only attachments: after a
+	// restart, the volumes already attached are published again in any
+	// mode.
+	readonlyVolumes sync.Map
+
+	// createVolumeTimeout, deleteVolumeTimeout, attachTimeout and
+	// snapshotTimeout bound the CloudStack jobs of the operations, or 0.
+	createVolumeTimeout time.Duration
+	deleteVolumeTimeout time.Duration
+	attachTimeout       time.Duration
+	snapshotTimeout     time.Duration
+
+	// detachTimeout bounds the wait for a detachment, or 0.
+	detachTimeout time.Duration
+	// forceDetach detaches the volumes of the VMs not running by their
+	// ID once detachTimeout expired.
+	forceDetach bool
+
+	// progress reports the slow attachments and detachments, when not nil.
+	progress *progressReporter
+
+	// firstDeviceID is the first device ID requested when attaching a
+	// volume, or 0 to let CloudStack choose it.
+	firstDeviceID int64
+
+	// defaultDiskOfferingID is the disk offering of the volumes whose
+	// parameters do not select one, e.g. without storage class.
+	defaultDiskOfferingID string
 }
 
 // NewControllerServer creates a new Controller gRPC server.
-func NewControllerServer(connector cloud.Interface) csi.ControllerServer {
+func NewControllerServer(connector cloud.Interface, options *Options) csi.ControllerServer {
+	maxVolumeNameLength := options.MaxVolumeNameLength
+	if maxVolumeNameLength == 0 {
+		maxVolumeNameLength = DefaultMaxVolumeNameLength
+	}
+
 	return &controllerServer{
 		connector:      connector,
 		volumeLocks:    util.NewVolumeLocks(),
 		operationLocks: util.NewOperationLock(),
+		clusterID:      options.ClusterID,
+		requireTags:    options.RequireTags,
+
+		maxCustomVolumeSize: options.MaxCustomVolumeSize,
+		minVolumeSize:       options.MinVolumeSize,
+		maxVolumeSize:       options.MaxVolumeSize,
+		volumeNamePrefix:    options.VolumeNamePrefix,
+		maxVolumeNameLength: maxVolumeNameLength,
+		createVolumeTimeout: options.CreateVolumeTimeout,
+		deleteVolumeTimeout: options.DeleteVolumeTimeout,
+		attachTimeout:       options.AttachTimeout,
+		snapshotTimeout:     options.SnapshotTimeout,
+		detachTimeout:       options.DetachTimeout,
+		forceDetach:         options.ForceDetach,
+		progress:            newProgressReporter(options.ProgressEventInterval),
+		firstDeviceID:       options.FirstDeviceID,
+
+		defaultDiskOfferingID: options.DefaultDiskOffering,
 	}
 }
 
@@ -55,22 +140,24 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 	if req.GetName() == "" {
 		return nil, status.Error(codes.InvalidArgument, "Volume name missing in request")
 	}
-	name := req.GetName()
+	name := volumeName(cs.volumeNamePrefix, req.GetName(), cs.maxVolumeNameLength)
 
 	volCaps := req.GetVolumeCapabilities()
 	if len(volCaps) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume capabilities missing in request")
 	}
 	if !isValidVolumeCapabilities(volCaps) {
-		return nil, status.Error(codes.InvalidArgument, "Volume capabilities not supported. Only SINGLE_NODE_WRITER supported.")
+		return nil, status.Error(codes.InvalidArgument, "Volume capabilities not supported. Only single node writer access modes supported.")
 	}
 
-	if req.GetParameters() == nil {
-		return nil, status.Error(codes.InvalidArgument, "Volume parameters missing in request")
+	// Without storage class, a volume has no parameters, and is created
+	// with the default disk offering.
+	if req.GetParameters() == nil && cs.defaultDiskOfferingID == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume parameters missing in request, and no default disk offering set")
 	}
-	diskOfferingID := req.GetParameters()[DiskOfferingKey]
-	if diskOfferingID == "" {
-		return nil, status.Errorf(codes.InvalidArgument, "Missing parameter %v", DiskOfferingKey)
+	diskOfferingID, err := resolveDiskOffering(ctx, cs.connector, req.GetParameters(), cs.defaultDiskOfferingID)
+	if err != nil {
+		return nil, err
 	}
 
 	if acquired := cs.volumeLocks.TryAcquire(name); !acquired {
@@ -80,25 +167,39 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 	}
 	defer cs.volumeLocks.Release(name)
 
-	// Check if a volume with that name already exists.
+	// Check if a volume with that name already exists. The name of the
+	// CloudStack volume is derived from the request name only: the
+	// external-provisioner derives it from the UID of the PVC, so a
+	// retried request finds the volume created by the previous attempt
+	// instead of creating another one.
 	vol, err := cs.connector.GetVolumeByName(ctx, name)
 	if err != nil {
-		if !errors.Is(err, cloud.ErrNotFound) {
+		switch {
+		case errors.Is(err, cloud.ErrTooManyResults):
+			return nil, status.Errorf(codes.AlreadyExists, "Several volumes named %v already exist", name)
+		case !errors.Is(err, cloud.ErrNotFound):
 			// Error with CloudStack
 			return nil, status.Errorf(codes.Internal, "CloudStack error: %v", err)
 		}
 	} else {
 		// The volume exists. Check if it suits the request.
+		// Restored volumes keep the disk offering of their source.
+		if req.GetVolumeContentSource() != nil {
+			diskOfferingID = ""
+		}
 		if ok, message := checkVolumeSuitable(vol, diskOfferingID, req.GetCapacityRange(), req.GetAccessibilityRequirements()); !ok {
 			return nil, status.Errorf(codes.AlreadyExists, "Volume %v already exists but does not satisfy request: %s", name, message)
 		}
+		if ok, message := checkVolumeSource(vol, req.GetVolumeContentSource()); !ok {
+			return nil, status.Errorf(codes.AlreadyExists, "Volume %v already exists but does not satisfy request: %s", name, message)
+		}
 		// Existing volume is ok.
 		resp := &csi.CreateVolumeResponse{
 			Volume: &csi.Volume{
 				VolumeId:      vol.ID,
 				CapacityBytes: vol.Size,
-				VolumeContext: req.GetParameters(),
-				// ContentSource: req.GetVolumeContentSource(), TODO: snapshot support.
+				VolumeContext: volumeContext(req.GetParameters(), vol.ZoneID, vol.DiskOfferingID, vol.Hypervisor),
+				ContentSource: req.GetVolumeContentSource(),
 				AccessibleTopology: []*csi.Topology{
 					Topology{ZoneID: vol.ZoneID}.ToCSI(),
 				},
@@ -110,36 +211,60 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 
 	// We have to create the volume.
 
+	if src := req.GetVolumeContentSource(); src != nil {
+		return cs.createVolumeFromSource(ctx, req, name, src)
+	}
+
 	// Determine volume size using requested capacity range.
-	sizeInGB, err := determineSize(req)
+	offering, err := cs.connector.GetDiskOfferingByID(ctx, diskOfferingID)
+	switch {
+	case errors.Is(err, cloud.ErrNotFound):
+		return nil, status.Errorf(codes.InvalidArgument, "Disk offering %v not found", diskOfferingID)
+	case err != nil:
+		return nil, status.Errorf(codes.Internal, "CloudStack error: %v", err)
+	}
+	sizeInGB, err := determineOfferingSize(req, offering)
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return nil, err
+	}
+	if err := cs.checkVolumeSize(sizeInGB); err != nil {
+		return nil, err
+	}
+	// The size of the volume is only set for customized offerings.
+	createSizeInGB := sizeInGB
+	if !offering.Customized {
+		createSizeInGB = 0
 	}
 
 	// Determine zone using topology constraints.
-	var zoneID string
-	topologyRequirement := req.GetAccessibilityRequirements()
-	if topologyRequirement == nil || topologyRequirement.GetRequisite() == nil { //nolint:nestif
-		// No topology requirement. Use random zone.
-		zones, err := cs.connector.ListZonesID(ctx)
-		if err != nil {
-			return nil, status.Error(codes.InvalidArgument, err.Error())
-		}
-		n := len(zones)
-		if n == 0 {
-			return nil, status.Error(codes.Internal, "No zone available")
-		}
-		zoneID = zones[rand.Intn(n)] //nolint:gosec
-	} else {
-		reqTopology := topologyRequirement.GetRequisite()
-		if len(reqTopology) > 1 {
-			return nil, status.Error(codes.InvalidArgument, "Too many topology requirements")
-		}
-		t, err := NewTopology(reqTopology[0])
-		if err != nil {
-			return nil, status.Error(codes.InvalidArgument, "Cannot parse topology requirements")
-		}
-		zoneID = t.ZoneID
+	zones, err := cs.connector.ListZonesID(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "CloudStack error: %v", err)
+	}
+	topology, err := pickTopology(req.GetAccessibilityRequirements(), zones)
+	switch {
+	case errors.Is(err, ErrNoZoneAvailable):
+		return nil, status.Error(codes.Internal, "No zone available")
+	case errors.Is(err, ErrNoMatchingZone):
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	zoneID := topology.ZoneID
+	// The volume is restricted to the optional segments of the chosen
+	// topology the storage class opts in to, e.g. to the cluster of the
+	// node it is first attached to, whose primary storages hold it.
+	segments, err := parseTopologySegments(req.GetParameters()[TopologySegmentsKey])
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid %s: %v", TopologySegmentsKey, err)
+	}
+	topology = topology.restrict(segments)
+
+	if err := cs.checkStorageTags(ctx, req.GetParameters()[StorageTagsKey], offering, zoneID, sizeInGB); err != nil {
+		return nil, err
+	}
+	if err := checkProvisioningType(req.GetParameters()[ProvisioningTypeKey], offering); err != nil {
+		return nil, err
 	}
 
 	logger.Info("Creating new volume",
@@ -147,21 +272,37 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 		"size", sizeInGB,
 		"offering", diskOfferingID,
 		"zone", zoneID,
+		"topology", topology.ToCSI().GetSegments(),
 	)
 
-	volID, err := cs.connector.CreateVolume(ctx, diskOfferingID, zoneID, name, sizeInGB)
+	createCtx, cancel := withOperationTimeout(ctx, cs.createVolumeTimeout)
+	defer cancel()
+	volID, err := cs.connector.CreateVolume(createCtx, diskOfferingID, zoneID, name, createSizeInGB)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Cannot create volume %s: %v", name, err.Error())
+		return nil, status.Errorf(cloudErrorCode(err), "Cannot create volume %s: %v", name, err.Error())
+	}
+
+	if err := cs.tagVolume(ctx, volID, name, req.GetParameters()); err != nil {
+		return nil, err
+	}
+
+	// The hypervisor is known once the volume is on a primary storage,
+	// e.g. with a disk offering whose storage tags select one.
+	var hypervisor string
+	if vol, err := cs.connector.GetVolumeByID(ctx, volID); err != nil {
+		logger.Info("Cannot get the hypervisor of the new volume", "volumeID", volID, "err", err)
+	} else {
+		hypervisor = vol.Hypervisor
 	}
 
 	resp := &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
 			VolumeId:      volID,
 			CapacityBytes: util.GigaBytesToBytes(sizeInGB),
-			VolumeContext: req.GetParameters(),
-			// ContentSource: req.GetVolumeContentSource(), TODO: snapshot support.
+			VolumeContext: volumeContext(req.GetParameters(), zoneID, diskOfferingID, hypervisor),
+			ContentSource: req.GetVolumeContentSource(),
 			AccessibleTopology: []*csi.Topology{
-				Topology{ZoneID: zoneID}.ToCSI(),
+				topology.ToCSI(),
 			},
 		},
 	}
@@ -169,10 +310,254 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 	return resp, nil
 }
 
+// createVolumeFromSource creates the volume requested by req, named name,
+// either by restoring a snapshot or by cloning a volume.
+func (cs *controllerServer) createVolumeFromSource(ctx context.Context, req *csi.CreateVolumeRequest, name string, src *csi.VolumeContentSource) (*csi.CreateVolumeResponse, error) {
+	logger := klog.FromContext(ctx)
+
+	var srcSize int64
+	var zoneID, diskOfferingID, hypervisor string
+	var create func() (string, error)
+	switch {
+	case src.GetSnapshot() != nil:
+		snapshotID := src.GetSnapshot().GetSnapshotId()
+		snap, err := cs.connector.GetSnapshotByID(ctx, snapshotID)
+		if err != nil {
+			if errors.Is(err, cloud.ErrNotFound) {
+				return nil, status.Errorf(codes.NotFound, "Snapshot %v not found", snapshotID)
+			}
+
+			return nil, status.Errorf(codes.Internal, "CloudStack error: %v", err)
+		}
+		srcSize, zoneID = snap.Size, snap.ZoneID
+		create = func() (string, error) {
+			logger.Info("Creating new volume from snapshot", "name", name, "snapshotID", snapshotID, "zone", zoneID)
+
+			createCtx, cancel := withOperationTimeout(ctx, cs.createVolumeTimeout)
+			defer cancel()
+
+			return cs.connector.CreateVolumeFromSnapshot(createCtx, zoneID, name, snapshotID)
+		}
+	case src.GetVolume() != nil:
+		srcVolumeID := src.GetVolume().GetVolumeId()
+		srcVol, err := cs.connector.GetVolumeByID(ctx, srcVolumeID)
+		if err != nil {
+			if errors.Is(err, cloud.ErrNotFound) {
+				return nil, status.Errorf(codes.NotFound, "Volume %v not found", srcVolumeID)
+			}
+
+			return nil, status.Errorf(codes.Internal, "CloudStack error: %v", err)
+		}
+		srcSize, zoneID, diskOfferingID, hypervisor = srcVol.Size, srcVol.ZoneID, srcVol.DiskOfferingID, srcVol.Hypervisor
+		create = func() (string, error) {
+			// lock out the source volume for delete and expand operations
+			if err := cs.operationLocks.GetCloneLock(srcVolumeID); err != nil {
+				return "", status.Error(codes.Aborted, err.Error())
+			}
+			defer cs.operationLocks.ReleaseCloneLock(srcVolumeID)
+			logger.Info("Cloning volume", "name", name, "sourceVolumeID", srcVolumeID, "zone", zoneID)
+
+			createCtx, cancel := withOperationTimeout(ctx, cs.createVolumeTimeout)
+			defer cancel()
+
+			return cs.connector.CloneVolume(createCtx, srcVolumeID, name)
+		}
+	default:
+		return nil, status.Error(codes.InvalidArgument, "Unsupported volume content source")
+	}
+
+	sizeInGB, err := determineSourceSize(req, srcSize, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	if sizeInGB > 0 {
+		err = cs.checkVolumeSize(sizeInGB)
+	} else {
+		err = cs.checkVolumeSize(util.RoundUpBytesToGB(srcSize))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	volID, err := create()
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+
+		return nil, status.Errorf(cloudErrorCode(err), "Cannot create volume %s: %v", name, err)
+	}
+
+	capacity := srcSize
+	if sizeInGB > 0 {
+		if err := cs.connector.ExpandVolume(ctx, volID, sizeInGB); err != nil {
+			return nil, status.Errorf(cloudErrorCode(err), "Could not resize volume %s to size %v: %v", name, sizeInGB, err)
+		}
+		capacity = util.GigaBytesToBytes(sizeInGB)
+	}
+
+	if err := cs.tagVolume(ctx, volID, name, req.GetParameters()); err != nil {
+		return nil, err
+	}
+
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      volID,
+			CapacityBytes: capacity,
+			VolumeContext: volumeContext(req.GetParameters(), zoneID, diskOfferingID, hypervisor),
+			ContentSource: src,
+			AccessibleTopology: []*csi.Topology{
+				Topology{ZoneID: zoneID}.ToCSI(),
+			},
+		},
+	}, nil
+}
+
+// determineSourceSize returns the size in GB a volume created from a
+// source of srcSize bytes in zone zoneID must be expanded to, or 0 if the
+// size of the source is enough. It checks that the source is compatible
+// with the request.
+func determineSourceSize(req *csi.CreateVolumeRequest, srcSize int64, zoneID string) (int64, error) {
+	requisite, err := requisiteZones(req.GetAccessibilityRequirements())
+	if err != nil {
+		return 0, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if len(requisite) > 0 && !slices.Contains(requisite, zoneID) {
+		return 0, status.Errorf(codes.InvalidArgument, "Source in zone %s, requested zones are %v", zoneID, requisite)
+	}
+
+	limit := req.GetCapacityRange().GetLimitBytes()
+	if limit > 0 && srcSize > limit {
+		return 0, status.Errorf(codes.OutOfRange, "Source size %v bytes > requested limit size %v bytes", srcSize, limit)
+	}
+
+	// The external-provisioner refuses such requests as well.
+	required := req.GetCapacityRange().GetRequiredBytes()
+	if required > 0 && required < srcSize {
+		return 0, status.Errorf(codes.OutOfRange, "Requested size %v bytes < source size %v bytes", required, srcSize)
+	}
+	if required <= srcSize {
+		return 0, nil
+	}
+	sizeInGB := util.RoundUpBytesToGB(required)
+	if limit > 0 && util.GigaBytesToBytes(sizeInGB) > limit {
+		return 0, status.Errorf(codes.OutOfRange, "After round-up, volume size %v GB exceeds the limit specified of %v bytes", sizeInGB, limit)
+	}
+
+	return sizeInGB, nil
+}
+
+// checkVolumeSource checks that an existing volume was created from the
+// requested content source. The source of cloned volumes cannot be told:
+// they are only known to be created from a snapshot.
+func checkVolumeSource(vol *cloud.Volume, src *csi.VolumeContentSource) (bool, string) {
+	switch {
+	case src == nil:
+		return true, ""
+	case src.GetSnapshot() != nil:
+		if vol.SnapshotID != src.GetSnapshot().GetSnapshotId() {
+			return false, fmt.Sprintf("Volume created from snapshot %q; requested snapshot %s", vol.SnapshotID, src.GetSnapshot().GetSnapshotId())
+		}
+	case src.GetVolume() != nil:
+		if vol.SnapshotID == "" {
+			return false, "Volume not created from another volume"
+		}
+	}
+
+	return true, ""
+}
+
+// tagVolume tags a newly created volume. Failures are only logged,
+// unless tags are required: the volume is then deleted.
+func (cs *controllerServer) tagVolume(ctx context.Context, volumeID, name string, params map[string]string) error {
+	logger := klog.FromContext(ctx)
+	tags := cs.volumeTags(params)
+	if len(tags) == 0 {
+		return nil
+	}
+	if err := cs.connector.CreateVolumeTags(ctx, volumeID, tags); err != nil {
+		if cs.requireTags {
+			// Do not leave an untagged volume behind, the next attempt would reuse it.
+			if delErr := cs.connector.DeleteVolume(ctx, volumeID); delErr != nil {
+				logger.Error(delErr, "Failed to delete untagged volume", "volumeID", volumeID)
+			}
+
+			return status.Errorf(codes.Internal, "Cannot tag volume %s: %v", name, err)
+		}
+		logger.Error(err, "Failed to tag volume", "volumeID", volumeID, "tags", tags)
+	}
+
+	return nil
+}
+
+// volumeTags returns the tags of a new volume, built from the PVC and PV
+// metadata of the volume parameters and the cluster ID.
+func (cs *controllerServer) volumeTags(params map[string]string) map[string]string {
+	tags := make(map[string]string)
+	for key, tag := range map[string]string{
+		PVCNameKey:      PVCNameTag,
+		PVCNamespaceKey: PVCNamespaceTag,
+		PVNameKey:       PVNameTag,
+	} {
+		if value := params[key]; value != "" {
+			tags[tag] = value
+		}
+	}
+	if cs.clusterID != "" {
+		tags[ClusterIDTag] = cs.clusterID
+	}
+
+	return tags
+}
+
+// resolveDiskOffering returns the ID of the disk offering selected by the
+// volume parameters, either directly by its ID or by its name, or else the
+// default disk offering ID, if any.
+func resolveDiskOffering(ctx context.Context, connector cloud.Interface, params map[string]string, defaultDiskOfferingID string) (string, error) {
+	diskOfferingID := params[DiskOfferingKey]
+	diskOfferingName := params[DiskOfferingNameKey]
+	switch {
+	case diskOfferingID != "" && diskOfferingName != "":
+		return "", status.Errorf(codes.InvalidArgument, "Parameters %v and %v are mutually exclusive", DiskOfferingKey, DiskOfferingNameKey)
+	case diskOfferingID != "":
+		return diskOfferingID, nil
+	case diskOfferingName == "" && defaultDiskOfferingID != "":
+		return defaultDiskOfferingID, nil
+	case diskOfferingName == "":
+		return "", status.Errorf(codes.InvalidArgument, "Missing parameter %v or %v, and no default disk offering set", DiskOfferingKey, DiskOfferingNameKey)
+	}
+
+	diskOfferingID, err := connector.GetDiskOfferingByName(ctx, diskOfferingName)
+	switch {
+	case errors.Is(err, cloud.ErrNotFound):
+		return "", status.Errorf(codes.InvalidArgument, "No disk offering named %q", diskOfferingName)
+	case errors.Is(err, cloud.ErrTooManyResults):
+		return "", status.Errorf(codes.InvalidArgument, "Several disk offerings named %q, use %v instead", diskOfferingName, DiskOfferingKey)
+	case err != nil:
+		return "", status.Errorf(codes.Internal, "CloudStack error: %v", err)
+	}
+
+	return diskOfferingID, nil
+}
+
+// volumeName returns the name of the CloudStack volume created for the
+// request name: the name with prefix, shortened to maxLength. Shortened
+// names end with a hash of the full name, so that they stay unique.
+func volumeName(prefix, name string, maxLength int) string {
+	fullName := prefix + name
+	if len(fullName) <= maxLength {
+		return fullName
+	}
+	sum := sha256.Sum256([]byte(fullName))
+	hash := hex.EncodeToString(sum[:])[:volumeNameHashLength-1]
+
+	return fullName[:maxLength-volumeNameHashLength] + "-" + hash
+}
+
 func checkVolumeSuitable(vol *cloud.Volume,
 	diskOfferingID string, capRange *csi.CapacityRange, topologyRequirement *csi.TopologyRequirement,
 ) (bool, string) {
-	if vol.DiskOfferingID != diskOfferingID {
+	if diskOfferingID != "" && vol.DiskOfferingID != diskOfferingID {
 		return false, fmt.Sprintf("Disk offering %s; requested disk offering %s", vol.DiskOfferingID, diskOfferingID)
 	}
 
@@ -185,21 +570,139 @@ func checkVolumeSuitable(vol *cloud.Volume,
 		}
 	}
 
-	if topologyRequirement != nil && topologyRequirement.GetRequisite() != nil {
-		reqTopology := topologyRequirement.GetRequisite()
-		if len(reqTopology) > 1 {
-			return false, "Too many topology requirements"
+	requisite, err := requisiteZones(topologyRequirement)
+	if err != nil {
+		return false, "Cannot parse topology requirements"
+	}
+	if len(requisite) > 0 && !slices.Contains(requisite, vol.ZoneID) {
+		return false, fmt.Sprintf("Volume in zone %s, requested zones are %v", vol.ZoneID, requisite)
+	}
+
+	return true, ""
+}
+
+// checkProvisioningType makes sure that the volume is provisioned as
+// requested. CloudStack has no provisioning type per volume: it is the one
+// of the disk offering, which must then match, and report it.
+func checkProvisioningType(provisioningType string, offering *cloud.DiskOffering) error {
+	if provisioningType == "" {
+		return nil
+	}
+	provisioningType = strings.ToLower(provisioningType)
+	if !slices.Contains([]string{"thin", "sparse", "fat"}, provisioningType) {
+		return status.Errorf(codes.InvalidArgument, "Invalid %s %q: must be thin, sparse or fat", ProvisioningTypeKey, provisioningType)
+	}
+	switch {
+	case offering.ProvisioningType == "":
+		return status.Errorf(codes.InvalidArgument, "Disk offering %s does not report its provisioning type, %s %q cannot be honored", offering.Name, ProvisioningTypeKey, provisioningType)
+	case !strings.EqualFold(offering.ProvisioningType, provisioningType):
+		return status.Errorf(codes.InvalidArgument, "Disk offering %s provisions volumes %s, not %s: use a disk offering with the requested provisioning type", offering.Name, offering.ProvisioningType, provisioningType)
+	}
+
+	return nil
+}
+
+// checkStorageTags makes sure that the volume is placed on a primary
+// storage with the requested storage tags. CloudStack places volumes
+// according to the storage tags of their disk offering, which must then
+// have them, and only when they are first attached: a zone with no room
+// left on such primary storages is refused beforehand.
+func (cs *controllerServer) checkStorageTags(ctx context.Context, tags string, offering *cloud.DiskOffering, zoneID string, sizeInGB int64) error {
+	wanted := splitStorageTags(tags)
+	if len(wanted) == 0 {
+		return nil
+	}
+	offeringTags := splitStorageTags(offering.Tags)
+	for _, tag := range wanted {
+		if !slices.Contains(offeringTags, tag) {
+			return status.Errorf(codes.InvalidArgument, "Disk offering %s does not have storage tag %q, its storage tags are %v", offering.Name, tag, offeringTags)
 		}
-		t, err := NewTopology(reqTopology[0])
-		if err != nil {
-			return false, "Cannot parse topology requirements"
+	}
+
+	available, err := cs.connector.GetAvailableCapacity(ctx, zoneID, offering.ID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "CloudStack error: %v", err)
+	}
+	if available < util.GigaBytesToBytes(sizeInGB) {
+		return status.Errorf(codes.ResourceExhausted, "No primary storage with storage tags %v has %v GB available in zone %s", wanted, sizeInGB, zoneID)
+	}
+
+	return nil
+}
+
+func splitStorageTags(tags string) []string {
+	var result []string
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			result = append(result, tag)
 		}
-		if t.ZoneID != vol.ZoneID {
-			return false, fmt.Sprintf("Volume in zone %s, requested zone is %s", vol.ZoneID, t.ZoneID)
+	}
+
+	return result
+}
+
+// determineOfferingSize returns the size in GB of the volume created with
+// the disk offering: the requested size for customized offerings, the size
+// of the offering otherwise, provided it is in the requested capacity range.
+func determineOfferingSize(req *csi.CreateVolumeRequest, offering *cloud.DiskOffering) (int64, error) {
+	if offering.Customized {
+		sizeInGB, err := determineSize(req)
+		if err != nil {
+			return 0, status.Error(codes.InvalidArgument, err.Error())
 		}
+
+		return sizeInGB, nil
 	}
 
-	return true, ""
+	size := util.GigaBytesToBytes(offering.SizeInGB)
+	capRange := req.GetCapacityRange()
+	if required := capRange.GetRequiredBytes(); required > size {
+		return 0, status.Errorf(codes.OutOfRange, "Disk offering %s has a fixed size of %v GB, less than the required %v bytes", offering.Name, offering.SizeInGB, required)
+	}
+	if limit := capRange.GetLimitBytes(); limit > 0 && size > limit {
+		return 0, status.Errorf(codes.OutOfRange, "Disk offering %s has a fixed size of %v GB, more than the limit of %v bytes", offering.Name, offering.SizeInGB, limit)
+	}
+
+	return offering.SizeInGB, nil
+}
+
+// checkVolumeSize makes sure that a volume of sizeInGB is within the
+// minimum and maximum volume sizes. The sizes are compared once rounded up
+// to GB, as CloudStack sizes volumes in GB.
+func (cs *controllerServer) checkVolumeSize(sizeInGB int64) error {
+	size := util.GigaBytesToBytes(sizeInGB)
+	if cs.minVolumeSize > 0 && size < cs.minVolumeSize {
+		return status.Errorf(codes.OutOfRange, "Volume size %v GB is below the minimum of %v bytes", sizeInGB, cs.minVolumeSize)
+	}
+	if cs.maxVolumeSize > 0 && size > cs.maxVolumeSize {
+		return status.Errorf(codes.OutOfRange, "Volume size %v GB exceeds the maximum of %v bytes", sizeInGB, cs.maxVolumeSize)
+	}
+
+	return nil
+}
+
+// checkExpandable makes sure that the disk offering of vol lets it be
+// expanded to sizeInGB.
+func (cs *controllerServer) checkExpandable(ctx context.Context, vol *cloud.Volume, sizeInGB int64) error {
+	offering, err := cs.connector.GetDiskOfferingByID(ctx, vol.DiskOfferingID)
+	switch {
+	case errors.Is(err, cloud.ErrNotFound):
+		// e.g. an offering of another domain: CloudStack has the last word.
+		klog.FromContext(ctx).Info("Disk offering of volume not found, expanding it anyway", "volumeID", vol.ID, "diskOfferingID", vol.DiskOfferingID)
+
+		return nil
+	case err != nil:
+		return status.Errorf(codes.Internal, "CloudStack error: %v", err)
+	}
+
+	if !offering.Customized || offering.SizeStrict {
+		return status.Errorf(codes.OutOfRange, "Volume %s has disk offering %s of fixed size, it cannot be expanded to %v GB", vol.ID, offering.Name, sizeInGB)
+	}
+	if cs.maxCustomVolumeSize > 0 && sizeInGB > cs.maxCustomVolumeSize {
+		return status.Errorf(codes.OutOfRange, "Volume size %v GB exceeds the maximum of %v GB of disk offering %s", sizeInGB, cs.maxCustomVolumeSize, offering.Name)
+	}
+
+	return nil
 }
 
 func determineSize(req *csi.CreateVolumeRequest) (int64, error) {
@@ -253,13 +756,32 @@ func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 	}
 	defer cs.operationLocks.ReleaseDeleteLock(volumeID)
 
+	vol, err := cs.connector.GetVolumeByID(ctx, volumeID)
+	switch {
+	case errors.Is(err, cloud.ErrNotFound):
+		logger.V(4).Info("Volume already deleted", "volumeID", volumeID)
+
+		return &csi.DeleteVolumeResponse{}, nil
+	case err != nil:
+		return nil, status.Errorf(cloudErrorCode(err), "Cannot get volume %s: %v", volumeID, err)
+	case vol.State == cloud.VolumeExpunging:
+		logger.V(4).Info("Volume already being deleted", "volumeID", volumeID)
+
+		return &csi.DeleteVolumeResponse{}, nil
+	case vol.VirtualMachineID != "":
+		// The external-provisioner retries once the volume is detached.
+		return nil, status.Errorf(codes.FailedPrecondition, "Volume %s is still attached to VM %s", volumeID, vol.VirtualMachineID)
+	}
+
 	logger.Info("Deleting volume",
 		"volumeID", volumeID,
 	)
 
-	err := cs.connector.DeleteVolume(ctx, volumeID)
+	deleteCtx, cancel := withOperationTimeout(ctx, cs.deleteVolumeTimeout)
+	defer cancel()
+	err = cs.connector.DeleteVolume(deleteCtx, volumeID)
 	if err != nil && !errors.Is(err, cloud.ErrNotFound) {
-		return nil, status.Errorf(codes.Internal, "Cannot delete volume %s: %s", volumeID, err.Error())
+		return nil, status.Errorf(cloudErrorCode(err), "Cannot delete volume %s: %s", volumeID, err.Error())
 	}
 
 	return &csi.DeleteVolumeResponse{}, nil
@@ -281,20 +803,21 @@ func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *cs
 	}
 	nodeID := req.GetNodeId()
 
-	if req.GetReadonly() {
-		return nil, status.Error(codes.InvalidArgument, "Readonly not possible")
-	}
+	// CloudStack cannot attach volumes read-only: the node mounts them
+	// read-only instead.
+	readonly := req.GetReadonly()
 
 	if req.GetVolumeCapability() == nil {
 		return nil, status.Error(codes.InvalidArgument, "Volume capability missing in request")
 	}
-	if req.GetVolumeCapability().GetAccessMode().GetMode() != onlyVolumeCapAccessMode.GetMode() {
+	if !slices.Contains(volumeCapAccessModes, req.GetVolumeCapability().GetAccessMode().GetMode()) {
 		return nil, status.Error(codes.InvalidArgument, "Access mode not accepted")
 	}
 
 	logger.Info("Initiating attaching volume",
 		"volumeID", volumeID,
 		"nodeID", nodeID,
+		"readonly", readonly,
 	)
 
 	// Check volume.
@@ -313,7 +836,7 @@ func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *cs
 			"attachedNodeID", vol.VirtualMachineID,
 		)
 
-		return nil, status.Error(codes.AlreadyExists, "Volume already assigned to another node")
+		return nil, status.Errorf(codes.FailedPrecondition, "Volume %v already attached to another node", volumeID)
 	}
 
 	if _, err := cs.connector.GetVMByID(ctx, nodeID); errors.Is(err, cloud.ErrNotFound) {
@@ -324,17 +847,17 @@ func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *cs
 	}
 
 	if vol.VirtualMachineID == nodeID {
+		if published, ok := cs.readonlyVolumes.Load(volumeID); ok && published.(bool) != readonly {
+			return nil, status.Errorf(codes.AlreadyExists, "Volume %v already published to node %v with readonly %v", volumeID, nodeID, published)
+		}
 		// volume already attached.
 		logger.Info("Volume already attached to node",
 			"volumeID", volumeID,
 			"nodeID", nodeID,
 			"deviceID", vol.DeviceID,
 		)
-		publishContext := map[string]string{
-			deviceIDContextKey: vol.DeviceID,
-		}
 
-		return &csi.ControllerPublishVolumeResponse{PublishContext: publishContext}, nil
+		return &csi.ControllerPublishVolumeResponse{PublishContext: publishContext(vol.DeviceID, readonly)}, nil
 	}
 
 	logger.Info("Attaching volume to node",
@@ -342,21 +865,78 @@ func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *cs
 		"nodeID", nodeID,
 	)
 
-	deviceID, err := cs.connector.AttachVolume(ctx, volumeID, nodeID)
+	attachCtx, cancel := withOperationTimeout(ctx, cs.attachTimeout)
+	defer cancel()
+	attachCtx, stopProgress := cs.progress.start(attachCtx, volumeID, attachInProgressReason,
+		fmt.Sprintf("Attaching volume %s to node %s", volumeID, nodeID))
+	deviceID, err := cs.attachVolume(attachCtx, volumeID, nodeID)
+	stopProgress()
+	if err != nil && !errors.Is(err, cloud.ErrMaxVolumesReached) {
+		// A previous, timed out, attempt may have attached the volume
+		// in the meantime, making CloudStack refuse to attach it again.
+		if vol, getErr := cs.connector.GetVolumeByID(ctx, volumeID); getErr == nil && vol.VirtualMachineID == nodeID {
+			logger.Info("Volume attached to node by a previous attempt",
+				"volumeID", volumeID,
+				"nodeID", nodeID,
+				"deviceID", vol.DeviceID,
+			)
+			deviceID, err = vol.DeviceID, nil
+		}
+	}
+	if errors.Is(err, cloud.ErrMaxVolumesReached) {
+		return nil, status.Errorf(codes.ResourceExhausted, "Cannot attach volume %s: %s", volumeID, err.Error())
+	}
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Cannot attach volume %s: %s", volumeID, err.Error())
+		return nil, status.Errorf(cloudErrorCode(err), "Cannot attach volume %s: %s", volumeID, err.Error())
 	}
 
 	logger.Info("Attached volume to node successfully",
 		"volumeID", volumeID,
 		"nodeID", nodeID,
 	)
+	cs.readonlyVolumes.Store(volumeID, readonly)
 
+	return &csi.ControllerPublishVolumeResponse{PublishContext: publishContext(deviceID, readonly)}, nil
+}
+
+// attachVolume attaches the volume to the node. With firstDeviceID, the
+// device IDs are requested from it, trying the next one while CloudStack
+// reports them in use, e.g. by the disks of the template, so that the
+// volume does not take an unexpected slot. The device ID is returned.
+func (cs *controllerServer) attachVolume(ctx context.Context, volumeID, nodeID string) (string, error) {
+	if cs.firstDeviceID == 0 {
+		return cs.connector.AttachVolume(ctx, volumeID, nodeID)
+	}
+
+	logger := klog.FromContext(ctx)
+	var err error
+	for id := cs.firstDeviceID; id < cs.firstDeviceID+DefaultVolumeAttachSlots; id++ {
+		var deviceID string
+		deviceID, err = cs.connector.AttachVolumeToDevice(ctx, volumeID, nodeID, id)
+		if !errors.Is(err, cloud.ErrDeviceIDInUse) {
+			return deviceID, err
+		}
+		logger.Info("Device ID in use, trying the next one",
+			"volumeID", volumeID,
+			"nodeID", nodeID,
+			"deviceID", id,
+		)
+	}
+
+	return "", fmt.Errorf("%w: no free device ID from %d: %w", cloud.ErrMaxVolumesReached, cs.firstDeviceID, err)
+}
+
+// publishContext returns the publish context of a volume attached with
+// the given device ID, telling the node to mount it read-only if needed.
+func publishContext(deviceID string, readonly bool) map[string]string {
 	publishContext := map[string]string{
 		deviceIDContextKey: deviceID,
 	}
+	if readonly {
+		publishContext[readonlyContextKey] = "true"
+	}
 
-	return &csi.ControllerPublishVolumeResponse{PublishContext: publishContext}, nil
+	return publishContext
 }
 
 func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
@@ -372,20 +952,31 @@ func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *
 	nodeID := req.GetNodeId()
 
 	// Check volume.
-	if vol, err := cs.connector.GetVolumeByID(ctx, volumeID); errors.Is(err, cloud.ErrNotFound) {
+	vol, err := cs.connector.GetVolumeByID(ctx, volumeID)
+	if errors.Is(err, cloud.ErrNotFound) {
 		// Volume does not exist in CloudStack. We can safely assume this volume is no longer attached
 		// The spec requires us to return OK here.
 		return &csi.ControllerUnpublishVolumeResponse{}, nil
 	} else if err != nil {
 		// Error with CloudStack
 		return nil, status.Errorf(codes.Internal, "Error %v", err)
-	} else if nodeID != "" && vol.VirtualMachineID != nodeID {
+	}
+	if vol.VirtualMachineID == "" {
+		// Volume is already detached.
+		logger.Info("Volume already detached",
+			"volumeID", volumeID,
+			"nodeID", nodeID,
+		)
+
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	}
+	if nodeID != "" && vol.VirtualMachineID != nodeID {
 		// Volume is present but not attached to this particular nodeID
 		return &csi.ControllerUnpublishVolumeResponse{}, nil
 	}
 
 	// Check VM existence.
-	if _, err := cs.connector.GetVMByID(ctx, nodeID); errors.Is(err, cloud.ErrNotFound) {
+	if _, err := cs.connector.GetVMByID(ctx, vol.VirtualMachineID); errors.Is(err, cloud.ErrNotFound) {
 		// volumes cannot be attached to deleted VMs.
 		logger.Error(nil, "VM not found, marking ControllerUnpublishVolume successful",
 			"volumeID", volumeID,
@@ -403,11 +994,36 @@ func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *
 		"nodeID", nodeID,
 	)
 
-	err := cs.connector.DetachVolume(ctx, volumeID)
+	detachCtx := ctx
+	if cs.detachTimeout > 0 {
+		var cancel context.CancelFunc
+		detachCtx, cancel = context.WithTimeout(ctx, cs.detachTimeout)
+		defer cancel()
+	}
+	detachCtx, stopProgress := cs.progress.start(detachCtx, volumeID, detachInProgressReason,
+		fmt.Sprintf("Detaching volume %s from node %s", volumeID, vol.VirtualMachineID))
+	err = cs.connector.DetachVolume(detachCtx, volumeID)
+	stopProgress()
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Cannot detach volume %s: %s", volumeID, err.Error())
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil && detachCtx.Err() != nil {
+			return nil, cs.detachTimedOut(ctx, vol)
+		}
+
+		// A previous, timed out, attempt may have detached the volume
+		// in the meantime.
+		if vol, getErr := cs.connector.GetVolumeByID(ctx, volumeID); errors.Is(getErr, cloud.ErrNotFound) || (getErr == nil && vol.VirtualMachineID == "") {
+			logger.Info("Volume detached by a previous attempt",
+				"volumeID", volumeID,
+				"nodeID", nodeID,
+			)
+
+			return &csi.ControllerUnpublishVolumeResponse{}, nil
+		}
+
+		return nil, status.Errorf(cloudErrorCode(err), "Cannot detach volume %s: %s", volumeID, err.Error())
 	}
 
+	cs.readonlyVolumes.Delete(volumeID)
 	logger.Info("Detached volume from node successfully",
 		"volumeID", volumeID,
 		"nodeID", nodeID,
@@ -437,8 +1053,12 @@ func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req
 		return nil, status.Errorf(codes.Internal, "Error %v", err)
 	}
 
-	if !isValidVolumeCapabilities(volCaps) {
-		return &csi.ValidateVolumeCapabilitiesResponse{Message: "Requested VolumeCapabilities are invalid"}, nil
+	for _, c := range volCaps {
+		if msg := unsupportedVolumeCapability(c); msg != "" {
+			logger.Info("Volume capability not supported", "volumeID", volumeID, "reason", msg)
+
+			return &csi.ValidateVolumeCapabilitiesResponse{Message: msg}, nil
+		}
 	}
 
 	return &csi.ValidateVolumeCapabilitiesResponse{
@@ -450,9 +1070,62 @@ func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req
 	}, nil
 }
 
+// unsupportedVolumeCapability returns why a CloudStack volume cannot be
+// used with the capability, or an empty string if it can.
+func unsupportedVolumeCapability(c *csi.VolumeCapability) string {
+	switch mode := c.GetAccessMode().GetMode(); {
+	case slices.Contains(volumeCapAccessModes, mode):
+	case mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		mode == csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+		mode == csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+		return fmt.Sprintf("Access mode %s not supported: CloudStack volumes cannot be attached to several nodes", mode)
+	default:
+		return fmt.Sprintf("Access mode %s not supported, only %v are", mode, volumeCapAccessModes)
+	}
+
+	switch {
+	case c.GetBlock() != nil:
+	case c.GetMount() != nil:
+		if fsType := c.GetMount().GetFsType(); fsType != "" {
+			if _, ok := ValidFSTypes[strings.ToLower(fsType)]; !ok {
+				return fmt.Sprintf("Filesystem type %s not supported", fsType)
+			}
+		}
+	default:
+		return "Access type missing: either block or mount is required"
+	}
+
+	return ""
+}
+
+// detachTimedOut handles a detachment not completed within the detach
+// timeout, e.g. because the VM is stopped or its host is unreachable. With
+// forceDetach, the detachment of the volumes of the VMs not running is
+// issued again by volume ID. The external-attacher retries on the Aborted
+// error returned.
+func (cs *controllerServer) detachTimedOut(ctx context.Context, vol *cloud.Volume) error {
+	logger := klog.FromContext(ctx)
+	if cs.forceDetach {
+		vm, err := cs.connector.GetVMByID(ctx, vol.VirtualMachineID)
+		switch {
+		case err != nil:
+			logger.Error(err, "Cannot get VM, not forcing detachment", "volumeID", vol.ID, "vmID", vol.VirtualMachineID)
+		case vm.State == cloud.VMRunning:
+			logger.Info("VM running, not forcing detachment", "volumeID", vol.ID, "vmID", vm.ID)
+		default:
+			logger.Info("Forcing detachment of volume", "volumeID", vol.ID, "vmID", vm.ID, "vmState", vm.State)
+			if err := cs.connector.ForceDetachVolume(ctx, vol.ID); err != nil {
+				logger.Error(err, "Cannot force detachment of volume", "volumeID", vol.ID, "vmID", vm.ID)
+			}
+		}
+	}
+
+	return status.Errorf(codes.Aborted, "Detachment of volume %s from VM %s did not complete within %v", vol.ID, vol.VirtualMachineID, cs.detachTimeout)
+}
+
 func isValidVolumeCapabilities(volCaps []*csi.VolumeCapability) bool {
 	for _, c := range volCaps {
-		if c.GetAccessMode() != nil && c.GetAccessMode().GetMode() != onlyVolumeCapAccessMode.GetMode() {
+		if c.GetAccessMode() != nil && !slices.Contains(volumeCapAccessModes, c.GetAccessMode().GetMode()) {
 			return false
 		}
 	}
@@ -489,8 +1162,11 @@ func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi
 	if maxVolSize > 0 && maxVolSize < util.GigaBytesToBytes(volSizeGB) {
 		return nil, status.Error(codes.OutOfRange, "Volume size exceeds the limit specified")
 	}
+	if err := cs.checkVolumeSize(volSizeGB); err != nil {
+		return nil, err
+	}
 
-	_, err := cs.connector.GetVolumeByID(ctx, volumeID)
+	vol, err := cs.connector.GetVolumeByID(ctx, volumeID)
 	if err != nil {
 		if errors.Is(err, cloud.ErrNotFound) {
 			return nil, status.Errorf(codes.NotFound, "Volume %v not found", volumeID)
@@ -499,6 +1175,36 @@ func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi
 		return nil, status.Error(codes.Internal, fmt.Sprintf("GetVolume failed with error %v", err))
 	}
 
+	nodeExpansionRequired := true
+	// Node expansion is not required for raw block volumes.
+	volCap := req.GetVolumeCapability()
+	if volCap != nil && volCap.GetBlock() != nil {
+		nodeExpansionRequired = false
+	}
+
+	// CloudStack refuses to shrink volumes. The requested size may already
+	// be reached, e.g. by a previous attempt or when it was rounded up to
+	// a whole number of GB: the expansion is then done.
+	if vol.Size > util.GigaBytesToBytes(volSizeGB) {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume %s has a size of %v bytes, it cannot be shrunk to %v GB", volumeID, vol.Size, volSizeGB)
+	}
+	if vol.Size == util.GigaBytesToBytes(volSizeGB) {
+		logger.Info("Volume already has the requested size",
+			"volumeID", volumeID,
+			"volumeSize", vol.Size,
+			"requestedSize", volSizeBytes,
+		)
+
+		return &csi.ControllerExpandVolumeResponse{
+			CapacityBytes:         vol.Size,
+			NodeExpansionRequired: nodeExpansionRequired,
+		}, nil
+	}
+
+	if err := cs.checkExpandable(ctx, vol, volSizeGB); err != nil {
+		return nil, err
+	}
+
 	// lock out volumeID for clone and delete operation
 	if err := cs.operationLocks.GetExpandLock(volumeID); err != nil {
 		logger.Error(err, "failed acquiring expand lock", "volumeID", volumeID)
@@ -509,7 +1215,7 @@ func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi
 
 	err = cs.connector.ExpandVolume(ctx, volumeID, volSizeGB)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Could not resize volume %q to size %v: %v", volumeID, volSizeGB, err)
+		return nil, status.Errorf(cloudErrorCode(err), "Could not resize volume %q to size %v: %v", volumeID, volSizeGB, err)
 	}
 
 	logger.Info("Volume successfully expanded",
@@ -517,19 +1223,366 @@ func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi
 		"volumeSize", volSizeGB,
 	)
 
-	nodeExpansionRequired := true
-	// Node expansion is not required for raw block volumes.
-	volCap := req.GetVolumeCapability()
-	if volCap != nil && volCap.GetBlock() != nil {
-		nodeExpansionRequired = false
-	}
-
 	return &csi.ControllerExpandVolumeResponse{
 		CapacityBytes:         util.GigaBytesToBytes(volSizeGB),
 		NodeExpansionRequired: nodeExpansionRequired,
 	}, nil
 }
 
+func (cs *controllerServer) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+	logger := klog.FromContext(ctx)
+	logger.V(6).Info("ListVolumes: called", "args", *req)
+
+	maxEntries := int(req.GetMaxEntries())
+	if maxEntries < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Max entries must not be negative")
+	}
+
+	// The token is the number of volumes already listed. CloudStack
+	// pages have a fixed size, so it is a multiple of maxEntries.
+	start := 0
+	if token := req.GetStartingToken(); token != "" {
+		var err error
+		start, err = strconv.Atoi(token)
+		if err != nil || start < 0 || (maxEntries > 0 && start%maxEntries != 0) {
+			return nil, status.Errorf(codes.Aborted, "Invalid starting token %q", token)
+		}
+	}
+
+	page := 0
+	if maxEntries > 0 {
+		page = start/maxEntries + 1
+	}
+	volumes, total, err := cs.connector.ListVolumes(ctx, page, maxEntries)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "CloudStack error: %v", err)
+	}
+	if maxEntries == 0 {
+		volumes = volumes[min(start, len(volumes)):]
+	}
+	nextToken := ""
+	if maxEntries > 0 && start+maxEntries < total {
+		nextToken = strconv.Itoa(start + maxEntries)
+	}
+
+	entries := make([]*csi.ListVolumesResponse_Entry, 0, len(volumes))
+	for _, vol := range volumes {
+		entries = append(entries, &csi.ListVolumesResponse_Entry{
+			Volume: csiVolume(vol),
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				PublishedNodeIds: publishedNodeIDs(vol),
+				VolumeCondition:  volumeCondition(vol),
+			},
+		})
+	}
+
+	return &csi.ListVolumesResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
+}
+
+func (cs *controllerServer) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	logger := klog.FromContext(ctx)
+	logger.V(6).Info("ControllerGetVolume: called", "args", *req)
+
+	volumeID := req.GetVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
+	}
+
+	vol, err := cs.connector.GetVolumeByID(ctx, volumeID)
+	if errors.Is(err, cloud.ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "Volume %v not found", volumeID)
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "Error %v", err)
+	}
+
+	return &csi.ControllerGetVolumeResponse{
+		Volume: csiVolume(vol),
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: publishedNodeIDs(vol),
+			VolumeCondition:  volumeCondition(vol),
+		},
+	}, nil
+}
+
+// csiVolume returns the CSI volume of vol, as listed.
+func csiVolume(vol *cloud.Volume) *csi.Volume {
+	return &csi.Volume{
+		VolumeId:      vol.ID,
+		CapacityBytes: vol.Size,
+		ContentSource: volumeContentSource(vol),
+		AccessibleTopology: []*csi.Topology{
+			Topology{ZoneID: vol.ZoneID}.ToCSI(),
+		},
+	}
+}
+
+// volumeContext returns the volume context of a volume created with the
+// parameters params: the parameters, except the csi.storage.k8s.io/ ones
+// such as the references to secrets, with the ID of the zone, the ID of
+// the disk offering and the hypervisor of the volume, when known. Secrets
+// are never added.
+func volumeContext(params map[string]string, zoneID, diskOfferingID, hypervisor string) map[string]string {
+	volCtx := make(map[string]string, len(params)+3)
+	for k, v := range params {
+		if !strings.HasPrefix(k, "csi.storage.k8s.io/") {
+			volCtx[k] = v
+		}
+	}
+	if zoneID != "" {
+		volCtx[ZoneIDKey] = zoneID
+	}
+	if diskOfferingID != "" {
+		volCtx[DiskOfferingKey] = diskOfferingID
+	}
+	if hypervisor != "" {
+		volCtx[HypervisorKey] = hypervisor
+	}
+
+	return volCtx
+}
+
+// publishedNodeIDs returns the ID of the VM vol is attached to, if any.
+func publishedNodeIDs(vol *cloud.Volume) []string {
+	if vol.VirtualMachineID == "" {
+		return nil
+	}
+
+	return []string{vol.VirtualMachineID}
+}
+
+// volumeContentSource returns the snapshot vol was restored from, if any.
+func volumeContentSource(vol *cloud.Volume) *csi.VolumeContentSource {
+	if vol.SnapshotID == "" {
+		return nil
+	}
+
+	return &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: vol.SnapshotID},
+		},
+	}
+}
+
+// volumeCondition reports the volumes in a state that prevents their
+// use as abnormal.
+func volumeCondition(vol *cloud.Volume) *csi.VolumeCondition {
+	if vol.State == cloud.VolumeReady || vol.State == cloud.VolumeAllocated {
+		return &csi.VolumeCondition{Message: "Volume is " + vol.State}
+	}
+
+	return &csi.VolumeCondition{
+		Abnormal: true,
+		Message:  fmt.Sprintf("Volume is in state %q", vol.State),
+	}
+}
+
+func (cs *controllerServer) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
+	logger := klog.FromContext(ctx)
+	logger.V(6).Info("GetCapacity: called", "args", *req)
+
+	// Without disk offering, the capacity of all the primary storages is returned.
+	diskOfferingID := ""
+	if params := req.GetParameters(); params[DiskOfferingKey] != "" || params[DiskOfferingNameKey] != "" {
+		var err error
+		if diskOfferingID, err = resolveDiskOffering(ctx, cs.connector, params, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	zoneID := ""
+	if req.GetAccessibleTopology() != nil {
+		t, err := NewTopology(req.GetAccessibleTopology())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid accessible topology: %v", err)
+		}
+		zoneIDs, err := cs.connector.ListZonesID(ctx)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "CloudStack error: %v", err)
+		}
+		if !slices.Contains(zoneIDs, t.ZoneID) {
+			return &csi.GetCapacityResponse{}, nil
+		}
+		zoneID = t.ZoneID
+	}
+
+	available, err := cs.connector.GetAvailableCapacity(ctx, zoneID, diskOfferingID)
+	if errors.Is(err, cloud.ErrNotFound) {
+		return nil, status.Errorf(codes.InvalidArgument, "Disk offering %s not found", diskOfferingID)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "CloudStack error: %v", err)
+	}
+
+	return &csi.GetCapacityResponse{AvailableCapacity: available}, nil
+}
+
+func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	logger := klog.FromContext(ctx)
+	logger.V(6).Info("CreateSnapshot: called", "args", protosanitizer.StripSecrets(*req))
+
+	name := req.GetName()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "Snapshot name missing in request")
+	}
+	volumeID := req.GetSourceVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "Source volume ID missing in request")
+	}
+
+	if acquired := cs.volumeLocks.TryAcquire(name); !acquired {
+		logger.Error(errors.New(util.ErrVolumeOperationAlreadyExistsVolumeName), "failed to acquire snapshot lock", "snapshotName", name)
+
+		return nil, status.Errorf(codes.Aborted, util.SnapshotOperationAlreadyExistsFmt, name)
+	}
+	defer cs.volumeLocks.Release(name)
+
+	// Check if a snapshot with that name already exists.
+	snap, err := cs.connector.GetSnapshotByName(ctx, name)
+	if err == nil {
+		if snap.VolumeID != volumeID {
+			return nil, status.Errorf(codes.AlreadyExists, "Snapshot %v already exists for volume %v", name, snap.VolumeID)
+		}
+
+		return &csi.CreateSnapshotResponse{Snapshot: toCSISnapshot(snap)}, nil
+	}
+	if !errors.Is(err, cloud.ErrNotFound) {
+		return nil, status.Errorf(codes.Internal, "CloudStack error: %v", err)
+	}
+
+	if _, err := cs.connector.GetVolumeByID(ctx, volumeID); err != nil {
+		if errors.Is(err, cloud.ErrNotFound) {
+			return nil, status.Errorf(codes.NotFound, "Volume %v not found", volumeID)
+		}
+
+		return nil, status.Errorf(codes.Internal, "CloudStack error: %v", err)
+	}
+
+	// lock out volumeID for delete and restore operation
+	if err := cs.operationLocks.GetSnapshotCreateLock(volumeID); err != nil {
+		logger.Error(err, "failed acquiring snapshot create lock", "volumeID", volumeID)
+
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	defer cs.operationLocks.ReleaseSnapshotCreateLock(volumeID)
+
+	logger.Info("Creating snapshot",
+		"name", name,
+		"volumeID", volumeID,
+	)
+
+	snapshotCtx, cancel := withOperationTimeout(ctx, cs.snapshotTimeout)
+	defer cancel()
+	snap, err = cs.connector.CreateSnapshot(snapshotCtx, volumeID, name)
+	if err != nil {
+		return nil, status.Errorf(cloudErrorCode(err), "Cannot create snapshot %s: %v", name, err)
+	}
+
+	return &csi.CreateSnapshotResponse{Snapshot: toCSISnapshot(snap)}, nil
+}
+
+func (cs *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	logger := klog.FromContext(ctx)
+	logger.V(6).Info("DeleteSnapshot: called", "args", protosanitizer.StripSecrets(*req))
+
+	snapshotID := req.GetSnapshotId()
+	if snapshotID == "" {
+		return nil, status.Error(codes.InvalidArgument, "Snapshot ID missing in request")
+	}
+
+	if acquired := cs.volumeLocks.TryAcquire(snapshotID); !acquired {
+		logger.Error(errors.New(util.ErrVolumeOperationAlreadyExistsVolumeID), "failed to acquire snapshot lock", "snapshotID", snapshotID)
+
+		return nil, status.Errorf(codes.Aborted, util.SnapshotOperationAlreadyExistsFmt, snapshotID)
+	}
+	defer cs.volumeLocks.Release(snapshotID)
+
+	if _, err := cs.connector.GetSnapshotByID(ctx, snapshotID); err != nil {
+		if errors.Is(err, cloud.ErrNotFound) {
+			return &csi.DeleteSnapshotResponse{}, nil
+		}
+
+		return nil, status.Errorf(codes.Internal, "CloudStack error: %v", err)
+	}
+
+	logger.Info("Deleting snapshot",
+		"snapshotID", snapshotID,
+	)
+
+	snapshotCtx, cancel := withOperationTimeout(ctx, cs.snapshotTimeout)
+	defer cancel()
+	if err := cs.connector.DeleteSnapshot(snapshotCtx, snapshotID); err != nil && !errors.Is(err, cloud.ErrNotFound) {
+		return nil, status.Errorf(cloudErrorCode(err), "Cannot delete snapshot %s: %v", snapshotID, err)
+	}
+
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+func (cs *controllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	logger := klog.FromContext(ctx)
+	logger.V(6).Info("ListSnapshots: called", "args", protosanitizer.StripSecrets(*req))
+
+	if req.GetMaxEntries() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Max entries must not be negative")
+	}
+
+	var snapshots []*cloud.Snapshot
+	if snapshotID := req.GetSnapshotId(); snapshotID != "" {
+		snap, err := cs.connector.GetSnapshotByID(ctx, snapshotID)
+		if err != nil && !errors.Is(err, cloud.ErrNotFound) {
+			return nil, status.Errorf(codes.Internal, "CloudStack error: %v", err)
+		}
+		if err == nil && (req.GetSourceVolumeId() == "" || snap.VolumeID == req.GetSourceVolumeId()) {
+			snapshots = append(snapshots, snap)
+		}
+	} else {
+		var err error
+		snapshots, err = cs.connector.ListSnapshots(ctx, req.GetSourceVolumeId())
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "CloudStack error: %v", err)
+		}
+	}
+
+	// The token is the index of the first snapshot to return.
+	start := 0
+	if token := req.GetStartingToken(); token != "" {
+		var err error
+		start, err = strconv.Atoi(token)
+		if err != nil || start < 0 || start > len(snapshots) {
+			return nil, status.Errorf(codes.Aborted, "Invalid starting token %q", token)
+		}
+	}
+	end := len(snapshots)
+	nextToken := ""
+	if maxEntries := int(req.GetMaxEntries()); maxEntries > 0 && start+maxEntries < end {
+		end = start + maxEntries
+		nextToken = strconv.Itoa(end)
+	}
+
+	entries := make([]*csi.ListSnapshotsResponse_Entry, 0, end-start)
+	for _, snap := range snapshots[start:end] {
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{Snapshot: toCSISnapshot(snap)})
+	}
+
+	return &csi.ListSnapshotsResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
+}
+
+func toCSISnapshot(snap *cloud.Snapshot) *csi.Snapshot {
+	return &csi.Snapshot{
+		SnapshotId:     snap.ID,
+		SourceVolumeId: snap.VolumeID,
+		SizeBytes:      snap.Size,
+		CreationTime:   timestamppb.New(snap.CreatedAt),
+		// Snapshots are only usable once backed up to the secondary storage.
+		ReadyToUse: snap.State == cloud.SnapshotBackedUp,
+	}
+}
+
 func (cs *controllerServer) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
 	logger := klog.FromContext(ctx)
 	logger.V(6).Info("ControllerGetCapabilities: called", "args", protosanitizer.StripSecrets(*req))
@@ -550,6 +1603,13 @@ func (cs *controllerServer) ControllerGetCapabilities(ctx context.Context, req *
 					},
 				},
 			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_PUBLISH_READONLY,
+					},
+				},
+			},
 			{
 				Type: &csi.ControllerServiceCapability_Rpc{
 					Rpc: &csi.ControllerServiceCapability_RPC{
@@ -557,8 +1617,91 @@ func (cs *controllerServer) ControllerGetCapabilities(ctx context.Context, req *
 					},
 				},
 			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_GET_VOLUME,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+					},
+				},
+			},
 		},
 	}
 
 	return resp, nil
 }
+
+// withOperationTimeout bounds ctx by the timeout of an operation, if not 0.
+func withOperationTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// cloudErrorCode returns the gRPC code of an error of the CloudStack
+// connector: DeadlineExceeded when an async job did not complete in time,
+// so that the caller retries later, or Internal.
+func cloudErrorCode(err error) codes.Code {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return codes.DeadlineExceeded
+	}
+
+	return codes.Internal
+}
@@ -1,9 +1,23 @@
 package driver
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud"
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud/fake"
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/util"
 )
 
 func TestDetermineSize(t *testing.T) {
@@ -40,3 +54,1841 @@ func TestDetermineSize(t *testing.T) {
 		})
 	}
 }
+
+func TestResolveDiskOffering(t *testing.T) {
+	cases := []struct {
+		name       string
+		params     map[string]string
+		expectedID string
+		expectCode codes.Code
+	}{
+		{"by ID", map[string]string{DiskOfferingKey: "9743fd77-0f5d-4ef9-b2f8-f194235c769c"}, "9743fd77-0f5d-4ef9-b2f8-f194235c769c", codes.OK},
+		{"by name", map[string]string{DiskOfferingNameKey: "custom"}, "9743fd77-0f5d-4ef9-b2f8-f194235c769c", codes.OK},
+		{"unknown name", map[string]string{DiskOfferingNameKey: "unknown"}, "", codes.InvalidArgument},
+		{"both", map[string]string{DiskOfferingKey: "9743fd77-0f5d-4ef9-b2f8-f194235c769c", DiskOfferingNameKey: "custom"}, "", codes.InvalidArgument},
+		{"none", map[string]string{}, "", codes.InvalidArgument},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			id, err := resolveDiskOffering(context.Background(), fake.New(), c.params, "")
+			if code := status.Code(err); code != c.expectCode {
+				t.Fatalf("expected code %v, got %v (%v)", c.expectCode, code, err)
+			}
+			if id != c.expectedID {
+				t.Errorf("expected ID %q, got %q", c.expectedID, id)
+			}
+		})
+	}
+}
+
+// taggingConnector records the tags of the volumes, and fails to tag
+// them when tagErr is set.
+type taggingConnector struct {
+	cloud.Interface
+	tags   map[string]map[string]string
+	tagErr error
+}
+
+func (c *taggingConnector) CreateVolumeTags(_ context.Context, volumeID string, tags map[string]string) error {
+	if c.tagErr != nil {
+		return c.tagErr
+	}
+	c.tags[volumeID] = tags
+
+	return nil
+}
+
+// duplicateConnector reports several volumes for any name.
+type duplicateConnector struct {
+	cloud.Interface
+}
+
+func (c *duplicateConnector) GetVolumeByName(_ context.Context, _ string) (*cloud.Volume, error) {
+	return nil, cloud.ErrTooManyResults
+}
+
+func TestCreateVolumeIdempotency(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+	newRequest := func(sizeInGB int64, params map[string]string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:          "pvc-6b6f3a0e-4a3c-4b7e-9f6a-0c1d2e3f4a5b",
+			CapacityRange: &csi.CapacityRange{RequiredBytes: sizeInGB * gib},
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+			Parameters: params,
+		}
+	}
+	params := map[string]string{DiskOfferingKey: "9743fd77-0f5d-4ef9-b2f8-f194235c769c"}
+
+	cs := NewControllerServer(fake.New(), &Options{})
+	first, err := cs.CreateVolume(context.Background(), newRequest(5, params))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("already exists, same params", func(t *testing.T) {
+		resp, err := cs.CreateVolume(context.Background(), newRequest(5, params))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.GetVolume().GetVolumeId() != first.GetVolume().GetVolumeId() {
+			t.Errorf("expected existing volume %s, got %s", first.GetVolume().GetVolumeId(), resp.GetVolume().GetVolumeId())
+		}
+		if resp.GetVolume().GetCapacityBytes() != 5*gib {
+			t.Errorf("expected capacity %d, got %d", 5*gib, resp.GetVolume().GetCapacityBytes())
+		}
+	})
+
+	t.Run("exists, conflicting size", func(t *testing.T) {
+		_, err := cs.CreateVolume(context.Background(), newRequest(10, params))
+		if status.Code(err) != codes.AlreadyExists {
+			t.Errorf("expected AlreadyExists error, got %v", err)
+		}
+	})
+
+	t.Run("exists, conflicting disk offering", func(t *testing.T) {
+		_, err := cs.CreateVolume(context.Background(), newRequest(5, map[string]string{DiskOfferingKey: "e5e4d7c3-1c3f-4e4b-8b1c-2d3e4f5a6b7c"}))
+		if status.Code(err) != codes.AlreadyExists {
+			t.Errorf("expected AlreadyExists error, got %v", err)
+		}
+	})
+
+	t.Run("duplicates", func(t *testing.T) {
+		cs := NewControllerServer(&duplicateConnector{Interface: fake.New()}, &Options{})
+		_, err := cs.CreateVolume(context.Background(), newRequest(5, params))
+		if status.Code(err) != codes.AlreadyExists {
+			t.Errorf("expected AlreadyExists error, got %v", err)
+		}
+	})
+}
+
+func TestVolumeName(t *testing.T) {
+	long := strings.Repeat("a", 40)
+	cases := []struct {
+		name      string
+		prefix    string
+		reqName   string
+		maxLength int
+		expected  string
+	}{
+		{"short", "", "pvc-1", 32, "pvc-1"},
+		{"prefix", "k8s-", "pvc-1", 32, "k8s-pvc-1"},
+		{"max length", "", long[:32], 32, long[:32]},
+		{"too long", "k8s-", long, 32, "k8s-aaaaaaaaaaa-d3a41f7438f1dce7"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if name := volumeName(c.prefix, c.reqName, c.maxLength); name != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, name)
+			}
+		})
+	}
+
+	// Names differing after the truncation do not collide.
+	if volumeName("", long+"1", 32) == volumeName("", long+"2", 32) {
+		t.Error("expected different names")
+	}
+}
+
+func TestCreateVolumeLongName(t *testing.T) {
+	ctx := context.Background()
+	connector := fake.New()
+	cs := NewControllerServer(connector, &Options{VolumeNamePrefix: "k8s-", MaxVolumeNameLength: 32})
+	create := func(name string) string {
+		t.Helper()
+		resp, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name: name,
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+			Parameters: map[string]string{DiskOfferingKey: "9743fd77-0f5d-4ef9-b2f8-f194235c769c"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return resp.GetVolume().GetVolumeId()
+	}
+
+	first := create("pvc-6b6f3a0e-4a3c-4b7e-9f6a-0c1d2e3f4a5b")
+	vol, err := connector.GetVolumeByID(ctx, first)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vol.Name) != 32 || !strings.HasPrefix(vol.Name, "k8s-pvc-6b6f3a0-") {
+		t.Errorf("expected a shortened name with the prefix, got %q", vol.Name)
+	}
+	if id := create("pvc-6b6f3a0e-4a3c-4b7e-9f6a-0c1d2e3f4a5b"); id != first {
+		t.Errorf("expected existing volume %s, got %s", first, id)
+	}
+	if id := create("pvc-6b6f3a0e-4a3c-4b7e-9f6a-0c1d2e3f4a5c"); id == first {
+		t.Errorf("expected a new volume, got existing volume %s", id)
+	}
+}
+
+func TestCreateVolumeSizeBounds(t *testing.T) {
+	cases := []struct {
+		name     string
+		required int64
+		code     codes.Code
+		size     int64
+	}{
+		{"under min", util.GigaBytesToBytes(1), codes.OutOfRange, 0},
+		{"over max", util.GigaBytesToBytes(8) + 1, codes.OutOfRange, 0},
+		{"rounded up to min", util.GigaBytesToBytes(1) + 1, codes.OK, util.GigaBytesToBytes(2)},
+		{"max", util.GigaBytesToBytes(8), codes.OK, util.GigaBytesToBytes(8)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cs := NewControllerServer(fake.New(), &Options{
+				MinVolumeSize: util.GigaBytesToBytes(2),
+				MaxVolumeSize: util.GigaBytesToBytes(8),
+			})
+			resp, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "pvc-" + strings.ReplaceAll(c.name, " ", "-"),
+				VolumeCapabilities: []*csi.VolumeCapability{{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				}},
+				CapacityRange: &csi.CapacityRange{RequiredBytes: c.required},
+				Parameters:    map[string]string{DiskOfferingKey: "9743fd77-0f5d-4ef9-b2f8-f194235c769c"},
+			})
+			if status.Code(err) != c.code {
+				t.Fatalf("expected code %v, got %v", c.code, err)
+			}
+			if got := resp.GetVolume().GetCapacityBytes(); got != c.size {
+				t.Errorf("expected size %v, got %v", c.size, got)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeContext(t *testing.T) {
+	const (
+		diskOfferingID = "9743fd77-0f5d-4ef9-b2f8-f194235c769c"
+		zoneID         = "a1887604-237c-4212-a9cd-94620b7880fa"
+	)
+	cs := NewControllerServer(fake.New(), &Options{DefaultDiskOffering: diskOfferingID})
+	req := &csi.CreateVolumeRequest{
+		Name: "pvc-6b6f3a0e-4a3c-4b7e-9f6a-0c1d2e3f4a5b",
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}},
+		Parameters: map[string]string{
+			MkfsOptionsKey: "-I 512",
+			"csi.storage.k8s.io/provisioner-secret-name": "cloudstack-secret",
+		},
+		Secrets: map[string]string{EncryptionPassphraseKey: "s3cr3t"},
+	}
+	expected := map[string]string{
+		MkfsOptionsKey:  "-I 512",
+		ZoneIDKey:       zoneID,
+		DiskOfferingKey: diskOfferingID,
+	}
+
+	// The volume is created, then found by the retried request.
+	for _, attempt := range []string{"created", "existing"} {
+		resp, err := cs.CreateVolume(context.Background(), req)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", attempt, err)
+		}
+		if volCtx := resp.GetVolume().GetVolumeContext(); !maps.Equal(volCtx, expected) {
+			t.Errorf("%s: expected volume context %v, got %v", attempt, expected, volCtx)
+		}
+	}
+}
+
+// hypervisorConnector puts its volumes on the primary storage of a
+// hypervisor as soon as they are created.
+type hypervisorConnector struct {
+	cloud.Interface
+	hypervisor string
+}
+
+func (c *hypervisorConnector) GetVolumeByID(ctx context.Context, volumeID string) (*cloud.Volume, error) {
+	vol, err := c.Interface.GetVolumeByID(ctx, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	vol.Hypervisor = c.hypervisor
+
+	return vol, nil
+}
+
+func TestCreateVolumeHypervisor(t *testing.T) {
+	const (
+		sourceVolumeID = "ace9f28b-3081-40c1-8353-4cc3e3014072"
+		nodeID         = "0d7107a3-94d2-44e7-89b8-8930881309a5"
+	)
+	newRequest := func(name string, params map[string]string, src *csi.VolumeContentSource) *csi.CreateVolumeRequest {
+		params[DiskOfferingKey] = "9743fd77-0f5d-4ef9-b2f8-f194235c769c"
+
+		return &csi.CreateVolumeRequest{
+			Name: name,
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+			Parameters:          params,
+			VolumeContentSource: src,
+		}
+	}
+
+	for _, hypervisor := range []string{"KVM", "VMware", "XenServer"} {
+		t.Run(hypervisor, func(t *testing.T) {
+			cs := NewControllerServer(fake.New(), &Options{})
+			resp, err := cs.CreateVolume(context.Background(), newRequest("pvc-"+hypervisor, map[string]string{HypervisorKey: hypervisor}, nil))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if h := resp.GetVolume().GetVolumeContext()[HypervisorKey]; h != hypervisor {
+				t.Errorf("expected hypervisor %q in the volume context, got %q", hypervisor, h)
+			}
+		})
+	}
+
+	t.Run("from the new volume", func(t *testing.T) {
+		cs := NewControllerServer(&hypervisorConnector{Interface: fake.New(), hypervisor: "XenServer"}, &Options{})
+		resp, err := cs.CreateVolume(context.Background(), newRequest("pvc-new", map[string]string{}, nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if h := resp.GetVolume().GetVolumeContext()[HypervisorKey]; h != "XenServer" {
+			t.Errorf("expected hypervisor XenServer in the volume context, got %q", h)
+		}
+	})
+
+	t.Run("from the source volume", func(t *testing.T) {
+		connector := fake.New()
+		// The source volume is on the storage of the KVM node.
+		if _, err := connector.AttachVolume(context.Background(), sourceVolumeID, nodeID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		cs := NewControllerServer(connector, &Options{})
+		src := &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Volume{
+			Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: sourceVolumeID},
+		}}
+		resp, err := cs.CreateVolume(context.Background(), newRequest("clone", map[string]string{}, src))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if h := resp.GetVolume().GetVolumeContext()[HypervisorKey]; h != "KVM" {
+			t.Errorf("expected hypervisor KVM in the volume context, got %q", h)
+		}
+	})
+}
+
+func TestCreateVolumeDefaultDiskOffering(t *testing.T) {
+	const diskOfferingID = "9743fd77-0f5d-4ef9-b2f8-f194235c769c"
+	cases := []struct {
+		name         string
+		defaultID    string
+		params       map[string]string
+		expectedCode codes.Code
+	}{
+		{"default applied", diskOfferingID, nil, codes.OK},
+		{"default applied to empty parameters", diskOfferingID, map[string]string{}, codes.OK},
+		{"no default", "", nil, codes.InvalidArgument},
+		{"no default nor parameter", "", map[string]string{}, codes.InvalidArgument},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			connector := fake.New()
+			cs := NewControllerServer(connector, &Options{DefaultDiskOffering: c.defaultID})
+
+			resp, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+				Name: "pvc-6b6f3a0e-4a3c-4b7e-9f6a-0c1d2e3f4a5b",
+				VolumeCapabilities: []*csi.VolumeCapability{{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				}},
+				Parameters: c.params,
+			})
+			if code := status.Code(err); code != c.expectedCode {
+				t.Fatalf("expected code %v, got %v (%v)", c.expectedCode, code, err)
+			}
+			if err != nil {
+				if !strings.Contains(err.Error(), "no default disk offering") {
+					t.Errorf("expected the error to tell no default disk offering is set, got %v", err)
+				}
+
+				return
+			}
+			vol, err := connector.GetVolumeByID(ctx, resp.GetVolume().GetVolumeId())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if vol.DiskOfferingID != diskOfferingID {
+				t.Errorf("expected disk offering %s, got %s", diskOfferingID, vol.DiskOfferingID)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeProvisioningType(t *testing.T) {
+	cases := []struct {
+		name             string
+		diskOfferingName string
+		provisioningType string
+		expectedCode     codes.Code
+	}{
+		{"not requested", "custom", "", codes.OK},
+		{"thin", "custom", "thin", codes.OK},
+		{"case insensitive", "custom", "Thin", codes.OK},
+		{"fat", "small", "fat", codes.OK},
+		{"mismatch", "small", "thin", codes.InvalidArgument},
+		{"invalid", "custom", "lazy", codes.InvalidArgument},
+		{"not reported", "unreported", "thin", codes.InvalidArgument},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			connector := fake.New(fake.WithDiskOfferings(
+				cloud.DiskOffering{ID: "9743fd77-0f5d-4ef9-b2f8-f194235c769c", Name: "custom", Customized: true, ProvisioningType: "thin"},
+				cloud.DiskOffering{ID: "c2d1f3a4-5b6c-4d7e-8f90-a1b2c3d4e5f6", Name: "small", SizeInGB: 10, ProvisioningType: "fat"},
+				cloud.DiskOffering{ID: "e5f6a7b8-c9d0-4e1f-a2b3-c4d5e6f7a8b9", Name: "unreported", Customized: true},
+			))
+			cs := NewControllerServer(connector, &Options{})
+			params := map[string]string{DiskOfferingNameKey: c.diskOfferingName}
+			if c.provisioningType != "" {
+				params[ProvisioningTypeKey] = c.provisioningType
+			}
+
+			_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "pvc-6b6f3a0e-4a3c-4b7e-9f6a-0c1d2e3f4a5b",
+				VolumeCapabilities: []*csi.VolumeCapability{{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				}},
+				Parameters: params,
+			})
+			if code := status.Code(err); code != c.expectedCode {
+				t.Errorf("expected code %v, got %v (%v)", c.expectedCode, code, err)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeDiskOfferingSize(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+	cases := []struct {
+		name             string
+		diskOfferingName string
+		capRange         *csi.CapacityRange
+		expectedCapacity int64
+		expectedCode     codes.Code
+	}{
+		{"custom offering", "custom", &csi.CapacityRange{RequiredBytes: 5 * gib}, 5 * gib, codes.OK},
+		{"custom offering, size rounded up", "custom", &csi.CapacityRange{RequiredBytes: 5*gib + 1}, 6 * gib, codes.OK},
+		{"fixed offering", "small", &csi.CapacityRange{RequiredBytes: 5 * gib}, 10 * gib, codes.OK},
+		{"fixed offering, no capacity range", "small", nil, 10 * gib, codes.OK},
+		{"fixed offering too small", "small", &csi.CapacityRange{RequiredBytes: 20 * gib}, 0, codes.OutOfRange},
+		{"fixed offering too large", "small", &csi.CapacityRange{LimitBytes: 5 * gib}, 0, codes.OutOfRange},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			connector := fake.New()
+			cs := NewControllerServer(connector, &Options{})
+			resp, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:          "pvc-6b6f3a0e-4a3c-4b7e-9f6a-0c1d2e3f4a5b",
+				CapacityRange: c.capRange,
+				VolumeCapabilities: []*csi.VolumeCapability{{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				}},
+				Parameters: map[string]string{DiskOfferingNameKey: c.diskOfferingName},
+			})
+			if status.Code(err) != c.expectedCode {
+				t.Fatalf("expected %v error, got %v", c.expectedCode, err)
+			}
+			if err != nil {
+				return
+			}
+			if resp.GetVolume().GetCapacityBytes() != c.expectedCapacity {
+				t.Errorf("expected capacity %d, got %d", c.expectedCapacity, resp.GetVolume().GetCapacityBytes())
+			}
+			vol, err := connector.GetVolumeByID(context.Background(), resp.GetVolume().GetVolumeId())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if vol.Size != c.expectedCapacity {
+				t.Errorf("expected volume of %d bytes, got %d", c.expectedCapacity, vol.Size)
+			}
+		})
+	}
+}
+
+// storageConnector records the disk offerings used to create volumes,
+// and reports the given capacity left on the primary storages.
+type storageConnector struct {
+	cloud.Interface
+	available      int64
+	diskOfferingID string
+}
+
+func (c *storageConnector) GetAvailableCapacity(_ context.Context, _, _ string) (int64, error) {
+	return c.available, nil
+}
+
+func (c *storageConnector) CreateVolume(ctx context.Context, diskOfferingID, zoneID, name string, sizeInGB int64) (string, error) {
+	c.diskOfferingID = diskOfferingID
+
+	return c.Interface.CreateVolume(ctx, diskOfferingID, zoneID, name, sizeInGB)
+}
+
+func TestCreateVolumeStorageTags(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+	newRequest := func(storageTags string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:          "pvc-6b6f3a0e-4a3c-4b7e-9f6a-0c1d2e3f4a5b",
+			CapacityRange: &csi.CapacityRange{RequiredBytes: 5 * gib},
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+			Parameters: map[string]string{
+				DiskOfferingKey: "9743fd77-0f5d-4ef9-b2f8-f194235c769c",
+				StorageTagsKey:  storageTags,
+			},
+		}
+	}
+
+	t.Run("matching tags", func(t *testing.T) {
+		connector := &storageConnector{Interface: fake.New(), available: 10 * gib}
+		cs := NewControllerServer(connector, &Options{})
+		resp, err := cs.CreateVolume(context.Background(), newRequest("ssd"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if connector.diskOfferingID != "9743fd77-0f5d-4ef9-b2f8-f194235c769c" {
+			t.Errorf("expected volume created with the tagged disk offering, got %q", connector.diskOfferingID)
+		}
+		if tags := resp.GetVolume().GetVolumeContext()[StorageTagsKey]; tags != "ssd" {
+			t.Errorf("expected storage tags in volume context, got %q", tags)
+		}
+	})
+
+	t.Run("tags not in disk offering", func(t *testing.T) {
+		connector := &storageConnector{Interface: fake.New(), available: 10 * gib}
+		cs := NewControllerServer(connector, &Options{})
+		_, err := cs.CreateVolume(context.Background(), newRequest("ssd, nvme"))
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected InvalidArgument error, got %v", err)
+		}
+		if connector.diskOfferingID != "" {
+			t.Error("expected no volume to be created")
+		}
+	})
+
+	t.Run("no primary storage available", func(t *testing.T) {
+		connector := &storageConnector{Interface: fake.New(), available: 0}
+		cs := NewControllerServer(connector, &Options{})
+		_, err := cs.CreateVolume(context.Background(), newRequest("ssd"))
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("expected ResourceExhausted error, got %v", err)
+		}
+		if connector.diskOfferingID != "" {
+			t.Error("expected no volume to be created")
+		}
+	})
+}
+
+func TestCreateVolumeCloudErrors(t *testing.T) {
+	errAPI := errors.New("CloudStack API error")
+	cases := []struct {
+		name       string
+		opts       []fake.Option
+		expectCode codes.Code
+	}{
+		{"list zones", []fake.Option{fake.WithError("ListZonesID", errAPI)}, codes.Internal},
+		{"disk offering", []fake.Option{fake.WithError("GetDiskOfferingByID", errAPI)}, codes.Internal},
+		{"create", []fake.Option{fake.WithError("CreateVolume", errAPI)}, codes.Internal},
+		{"job timeout", []fake.Option{fake.WithJobDuration(time.Minute)}, codes.DeadlineExceeded},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			cs := NewControllerServer(fake.New(c.opts...), &Options{})
+			_, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+				Name: "pvc-cloud-error",
+				VolumeCapabilities: []*csi.VolumeCapability{{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				}},
+				Parameters: map[string]string{DiskOfferingKey: "9743fd77-0f5d-4ef9-b2f8-f194235c769c"},
+			})
+			if code := status.Code(err); code != c.expectCode {
+				t.Errorf("expected code %v, got %v (%v)", c.expectCode, code, err)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeTopologySegments(t *testing.T) {
+	const zoneID = "a1887604-237c-4212-a9cd-94620b7880fa"
+	nodeTopology := func(zoneID, podID, clusterID string) *csi.Topology {
+		return Topology{ZoneID: zoneID, PodID: podID, ClusterID: clusterID}.ToCSI()
+	}
+	requirement := &csi.TopologyRequirement{
+		Requisite: []*csi.Topology{
+			nodeTopology(zoneID, "pod-1", "cluster-1"),
+			nodeTopology(zoneID, "pod-1", "cluster-2"),
+			nodeTopology(zoneID, "pod-2", "cluster-3"),
+		},
+		Preferred: []*csi.Topology{
+			nodeTopology("zone-x", "pod-9", "cluster-9"),
+			nodeTopology(zoneID, "pod-1", "cluster-2"),
+		},
+	}
+
+	cases := []struct {
+		name        string
+		segments    string
+		requirement *csi.TopologyRequirement
+		expected    map[string]string
+	}{
+		{
+			name:        "zone only",
+			requirement: requirement,
+			expected:    map[string]string{ZoneKey: zoneID},
+		},
+		{
+			name:        "pod and cluster",
+			segments:    "pod,cluster",
+			requirement: requirement,
+			expected:    map[string]string{ZoneKey: zoneID, PodKey: "pod-1", ClusterKey: "cluster-2"},
+		},
+		{
+			name:        "cluster",
+			segments:    "cluster",
+			requirement: requirement,
+			expected:    map[string]string{ZoneKey: zoneID, ClusterKey: "cluster-2"},
+		},
+		{
+			name:     "requisite",
+			segments: "pod, cluster",
+			requirement: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{nodeTopology("zone-x", "pod-9", "cluster-9"), nodeTopology(zoneID, "pod-2", "cluster-3")},
+			},
+			expected: map[string]string{ZoneKey: zoneID, PodKey: "pod-2", ClusterKey: "cluster-3"},
+		},
+		{
+			name:     "nodes without segments",
+			segments: "pod,cluster",
+			requirement: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{zoneTopology(zoneID)},
+			},
+			expected: map[string]string{ZoneKey: zoneID},
+		},
+		{
+			name:     "no requirement",
+			segments: "pod,cluster",
+			expected: map[string]string{ZoneKey: zoneID},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cs := NewControllerServer(fake.New(), &Options{})
+			resp, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name: "pvc-" + strings.ReplaceAll(c.name, " ", "-"),
+				VolumeCapabilities: []*csi.VolumeCapability{{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				}},
+				Parameters: map[string]string{
+					DiskOfferingKey:     "9743fd77-0f5d-4ef9-b2f8-f194235c769c",
+					TopologySegmentsKey: c.segments,
+				},
+				AccessibilityRequirements: c.requirement,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			topologies := resp.GetVolume().GetAccessibleTopology()
+			if len(topologies) != 1 {
+				t.Fatalf("expected 1 accessible topology, got %v", topologies)
+			}
+			if !reflect.DeepEqual(topologies[0].GetSegments(), c.expected) {
+				t.Errorf("expected topology %v, got %v", c.expected, topologies[0].GetSegments())
+			}
+		})
+	}
+
+	t.Run("invalid segment", func(t *testing.T) {
+		cs := NewControllerServer(fake.New(), &Options{})
+		_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name: "pvc-invalid-segment",
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+			Parameters: map[string]string{
+				DiskOfferingKey:     "9743fd77-0f5d-4ef9-b2f8-f194235c769c",
+				TopologySegmentsKey: "rack",
+			},
+		})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected InvalidArgument error, got %v", err)
+		}
+	})
+}
+
+func TestCreateVolumeTags(t *testing.T) {
+	params := map[string]string{
+		DiskOfferingKey: "9743fd77-0f5d-4ef9-b2f8-f194235c769c",
+		PVCNameKey:      "data",
+		PVCNamespaceKey: "default",
+		PVNameKey:       "pvc-6c4f7a1e",
+	}
+	newRequest := func(name string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:       name,
+			Parameters: params,
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+		}
+	}
+
+	t.Run("tagged", func(t *testing.T) {
+		connector := &taggingConnector{Interface: fake.New(), tags: map[string]map[string]string{}}
+		cs := NewControllerServer(connector, &Options{ClusterID: "prod-1"})
+		resp, err := cs.CreateVolume(context.Background(), newRequest("vol-tagged"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := map[string]string{
+			PVCNameTag:      "data",
+			PVCNamespaceTag: "default",
+			PVNameTag:       "pvc-6c4f7a1e",
+			ClusterIDTag:    "prod-1",
+		}
+		if tags := connector.tags[resp.GetVolume().GetVolumeId()]; !reflect.DeepEqual(tags, expected) {
+			t.Errorf("expected tags %v, got %v", expected, tags)
+		}
+	})
+
+	t.Run("best effort", func(t *testing.T) {
+		connector := &taggingConnector{Interface: fake.New(), tagErr: errors.New("tagging failed")}
+		cs := NewControllerServer(connector, &Options{})
+		if _, err := cs.CreateVolume(context.Background(), newRequest("vol-best-effort")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("required", func(t *testing.T) {
+		connector := &taggingConnector{Interface: fake.New(), tagErr: errors.New("tagging failed")}
+		cs := NewControllerServer(connector, &Options{RequireTags: true})
+		_, err := cs.CreateVolume(context.Background(), newRequest("vol-required"))
+		if status.Code(err) != codes.Internal {
+			t.Fatalf("expected Internal error, got %v", err)
+		}
+		if _, err := connector.GetVolumeByName(context.Background(), "vol-required"); !errors.Is(err, cloud.ErrNotFound) {
+			t.Errorf("expected untagged volume to be deleted, got %v", err)
+		}
+	})
+}
+
+func TestControllerExpandVolume(t *testing.T) {
+	const gb = 1024 * 1024 * 1024
+	const (
+		customDiskOfferingID = "9743fd77-0f5d-4ef9-b2f8-f194235c769c"
+		fixedDiskOfferingID  = "3b7c1d52-6a2e-4f0b-9d8e-5c4a1f2b7e90"
+	)
+	cases := []struct {
+		name                  string
+		volumeID              string
+		diskOfferingID        string
+		capacityRange         *csi.CapacityRange
+		block                 bool
+		expectCode            codes.Code
+		expectedCapacity      int64
+		expectedNodeExpansion bool
+	}{
+		{"grow", "", customDiskOfferingID, &csi.CapacityRange{RequiredBytes: 8 * gb}, false, codes.OK, 8 * gb, true},
+		{"rounded up to GB", "", customDiskOfferingID, &csi.CapacityRange{RequiredBytes: 7*gb + gb/2}, false, codes.OK, 8 * gb, true},
+		{"equal", "", customDiskOfferingID, &csi.CapacityRange{RequiredBytes: 5 * gb}, false, codes.OK, 5 * gb, true},
+		{"rounded up to current size", "", customDiskOfferingID, &csi.CapacityRange{RequiredBytes: 4*gb + gb/2}, false, codes.OK, 5 * gb, true},
+		{"shrink", "", customDiskOfferingID, &csi.CapacityRange{RequiredBytes: 3 * gb}, false, codes.InvalidArgument, 0, false},
+		{"block", "", customDiskOfferingID, &csi.CapacityRange{RequiredBytes: 8 * gb}, true, codes.OK, 8 * gb, false},
+		{"rounded size above limit", "", customDiskOfferingID, &csi.CapacityRange{RequiredBytes: 7*gb + gb/2, LimitBytes: 7*gb + gb/2}, false, codes.OutOfRange, 0, false},
+		{"above offering maximum", "", customDiskOfferingID, &csi.CapacityRange{RequiredBytes: 20 * gb}, false, codes.OutOfRange, 0, false},
+		{"fixed size offering", "", fixedDiskOfferingID, &csi.CapacityRange{RequiredBytes: 8 * gb}, false, codes.OutOfRange, 0, false},
+		{"unknown volume", "0f1d6c5e-5a4b-4e3c-9d2a-1b0c9e8f7a6d", customDiskOfferingID, &csi.CapacityRange{RequiredBytes: 8 * gb}, false, codes.NotFound, 0, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			connector := fake.New()
+			volumeID, err := connector.CreateVolume(context.Background(), c.diskOfferingID, "a1887604-237c-4212-a9cd-94620b7880fa", "vol-expand", 5)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if c.volumeID != "" {
+				volumeID = c.volumeID
+			}
+			volCap := &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			}
+			if c.block {
+				volCap.AccessType = &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}
+			}
+
+			cs := NewControllerServer(connector, &Options{MaxCustomVolumeSize: 16})
+			resp, err := cs.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+				VolumeId:         volumeID,
+				CapacityRange:    c.capacityRange,
+				VolumeCapability: volCap,
+			})
+			if code := status.Code(err); code != c.expectCode {
+				t.Fatalf("expected code %v, got %v (%v)", c.expectCode, code, err)
+			}
+			if err != nil {
+				return
+			}
+			if resp.GetCapacityBytes() != c.expectedCapacity {
+				t.Errorf("expected capacity %d, got %d", c.expectedCapacity, resp.GetCapacityBytes())
+			}
+			if resp.GetNodeExpansionRequired() != c.expectedNodeExpansion {
+				t.Errorf("expected node expansion required %v, got %v", c.expectedNodeExpansion, resp.GetNodeExpansionRequired())
+			}
+			vol, err := connector.GetVolumeByID(context.Background(), volumeID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if vol.Size != c.expectedCapacity {
+				t.Errorf("expected volume size %d, got %d", c.expectedCapacity, vol.Size)
+			}
+		})
+	}
+}
+
+// inProgressConnector creates snapshots whose backup is still running.
+type inProgressConnector struct {
+	cloud.Interface
+}
+
+func (c *inProgressConnector) CreateSnapshot(ctx context.Context, volumeID, name string) (*cloud.Snapshot, error) {
+	snap, err := c.Interface.CreateSnapshot(ctx, volumeID, name)
+	if err != nil {
+		return nil, err
+	}
+	snap.State = "BackingUp"
+
+	return snap, nil
+}
+
+func TestCreateSnapshot(t *testing.T) {
+	const volumeID = "ace9f28b-3081-40c1-8353-4cc3e3014072"
+
+	t.Run("ready", func(t *testing.T) {
+		cs := NewControllerServer(fake.New(), &Options{})
+		req := &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: volumeID}
+		resp, err := cs.CreateSnapshot(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !resp.GetSnapshot().GetReadyToUse() || resp.GetSnapshot().GetSourceVolumeId() != volumeID {
+			t.Errorf("unexpected snapshot %v", resp.GetSnapshot())
+		}
+
+		// Retries return the same snapshot.
+		retry, err := cs.CreateSnapshot(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if retry.GetSnapshot().GetSnapshotId() != resp.GetSnapshot().GetSnapshotId() {
+			t.Errorf("expected snapshot %s, got %s", resp.GetSnapshot().GetSnapshotId(), retry.GetSnapshot().GetSnapshotId())
+		}
+	})
+
+	t.Run("in progress", func(t *testing.T) {
+		cs := NewControllerServer(&inProgressConnector{Interface: fake.New()}, &Options{})
+		resp, err := cs.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: volumeID})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.GetSnapshot().GetReadyToUse() {
+			t.Error("expected snapshot not to be ready")
+		}
+	})
+
+	t.Run("unknown volume", func(t *testing.T) {
+		cs := NewControllerServer(fake.New(), &Options{})
+		_, err := cs.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: "0f1d6c5e-5a4b-4e3c-9d2a-1b0c9e8f7a6d"})
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("expected NotFound error, got %v", err)
+		}
+	})
+}
+
+func TestListSnapshots(t *testing.T) {
+	connector := fake.New()
+	cs := NewControllerServer(connector, &Options{})
+	for _, name := range []string{"snap-1", "snap-2", "snap-3"} {
+		if _, err := cs.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: name, SourceVolumeId: "ace9f28b-3081-40c1-8353-4cc3e3014072"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	seen := map[string]bool{}
+	token := ""
+	pages := 0
+	for {
+		resp, err := cs.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{MaxEntries: 2, StartingToken: token})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		pages++
+		if len(resp.GetEntries()) > 2 {
+			t.Fatalf("expected at most 2 entries, got %d", len(resp.GetEntries()))
+		}
+		for _, e := range resp.GetEntries() {
+			seen[e.GetSnapshot().GetSnapshotId()] = true
+		}
+		token = resp.GetNextToken()
+		if token == "" {
+			break
+		}
+	}
+	if pages != 2 || len(seen) != 3 {
+		t.Errorf("expected 3 snapshots in 2 pages, got %d in %d pages", len(seen), pages)
+	}
+
+	if _, err := cs.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{StartingToken: "invalid"}); status.Code(err) != codes.Aborted {
+		t.Errorf("expected Aborted error for an invalid token, got %v", err)
+	}
+
+	resp, err := cs.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{SnapshotId: "0f1d6c5e-5a4b-4e3c-9d2a-1b0c9e8f7a6d"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.GetEntries()) != 0 {
+		t.Errorf("expected no entries for an unknown snapshot, got %v", resp.GetEntries())
+	}
+
+	// Only the snapshots of the source volume are listed.
+	otherVolumeID, err := connector.CreateVolume(context.Background(), "offering", "zone", "vol-2", 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	otherSnap, err := cs.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snap-4", SourceVolumeId: otherVolumeID})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err = cs.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{SourceVolumeId: "ace9f28b-3081-40c1-8353-4cc3e3014072"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.GetEntries()) != 3 {
+		t.Errorf("expected the 3 snapshots of the source volume, got %v", resp.GetEntries())
+	}
+	for _, e := range resp.GetEntries() {
+		if e.GetSnapshot().GetSourceVolumeId() != "ace9f28b-3081-40c1-8353-4cc3e3014072" {
+			t.Errorf("expected snapshots of the source volume, got %v", e.GetSnapshot())
+		}
+	}
+	resp, err = cs.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{
+		SnapshotId:     otherSnap.GetSnapshot().GetSnapshotId(),
+		SourceVolumeId: "ace9f28b-3081-40c1-8353-4cc3e3014072",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.GetEntries()) != 0 {
+		t.Errorf("expected no entries for a snapshot of another volume, got %v", resp.GetEntries())
+	}
+}
+
+func TestListVolumes(t *testing.T) {
+	const nodeID = "0d7107a3-94d2-44e7-89b8-8930881309a5"
+	connector := fake.New()
+	cs := NewControllerServer(connector, &Options{})
+	for _, name := range []string{"vol-2", "vol-3"} {
+		if _, err := connector.CreateVolume(context.Background(), "offering", "zone", name, 1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := connector.AttachVolume(context.Background(), "ace9f28b-3081-40c1-8353-4cc3e3014072", nodeID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	seen := map[string]bool{}
+	token := ""
+	pages := 0
+	for {
+		resp, err := cs.ListVolumes(context.Background(), &csi.ListVolumesRequest{MaxEntries: 2, StartingToken: token})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		pages++
+		if len(resp.GetEntries()) > 2 {
+			t.Fatalf("expected at most 2 entries, got %d", len(resp.GetEntries()))
+		}
+		for _, e := range resp.GetEntries() {
+			id := e.GetVolume().GetVolumeId()
+			seen[id] = true
+			nodeIDs := e.GetStatus().GetPublishedNodeIds()
+			if id == "ace9f28b-3081-40c1-8353-4cc3e3014072" && !reflect.DeepEqual(nodeIDs, []string{nodeID}) {
+				t.Errorf("expected volume %s published to %s, got %v", id, nodeID, nodeIDs)
+			}
+			if e.GetStatus().GetVolumeCondition().GetAbnormal() {
+				t.Errorf("expected volume %s to be normal", id)
+			}
+		}
+		token = resp.GetNextToken()
+		if token == "" {
+			break
+		}
+	}
+	if pages != 2 || len(seen) != 3 {
+		t.Errorf("expected 3 volumes in 2 pages, got %d in %d pages", len(seen), pages)
+	}
+
+	for _, token := range []string{"invalid", "-2", "1"} {
+		if _, err := cs.ListVolumes(context.Background(), &csi.ListVolumesRequest{MaxEntries: 2, StartingToken: token}); status.Code(err) != codes.Aborted {
+			t.Errorf("expected Aborted error for token %q, got %v", token, err)
+		}
+	}
+
+	// The volumes of an expired token were deleted.
+	resp, err := cs.ListVolumes(context.Background(), &csi.ListVolumesRequest{MaxEntries: 2, StartingToken: "10"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.GetEntries()) != 0 || resp.GetNextToken() != "" {
+		t.Errorf("expected an empty last page, got %v", resp)
+	}
+}
+
+func TestVolumeCondition(t *testing.T) {
+	cases := []struct {
+		state    string
+		abnormal bool
+	}{
+		{cloud.VolumeAllocated, false},
+		{cloud.VolumeReady, false},
+		{"Migrating", true},
+		{"Destroy", true},
+		{"Expunging", true},
+	}
+	for _, c := range cases {
+		t.Run(c.state, func(t *testing.T) {
+			condition := volumeCondition(&cloud.Volume{State: c.state})
+			if condition.GetAbnormal() != c.abnormal {
+				t.Errorf("expected abnormal %t, got %t", c.abnormal, condition.GetAbnormal())
+			}
+		})
+	}
+}
+
+// stateConnector returns its volumes in the given state.
+type stateConnector struct {
+	cloud.Interface
+	state string
+}
+
+func (c *stateConnector) GetVolumeByID(ctx context.Context, volumeID string) (*cloud.Volume, error) {
+	vol, err := c.Interface.GetVolumeByID(ctx, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	vol.State = c.state
+
+	return vol, nil
+}
+
+func TestDeleteVolumeStates(t *testing.T) {
+	const (
+		volumeID = "ace9f28b-3081-40c1-8353-4cc3e3014072"
+		nodeID   = "0d7107a3-94d2-44e7-89b8-8930881309a5"
+	)
+	cases := []struct {
+		name         string
+		volumeID     string
+		attach       bool
+		state        string
+		expectedCode codes.Code
+		// deleted tells whether the volume is deleted in CloudStack.
+		deleted bool
+	}{
+		{"ready", volumeID, false, cloud.VolumeReady, codes.OK, true},
+		{"missing", "0b0ab8b0-1e3e-4c1a-9a5e-2f1d3c4b5a69", false, cloud.VolumeReady, codes.OK, false},
+		{"attached", volumeID, true, cloud.VolumeReady, codes.FailedPrecondition, false},
+		{"expunging", volumeID, false, cloud.VolumeExpunging, codes.OK, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			connector := fake.New()
+			if c.attach {
+				if _, err := connector.AttachVolume(ctx, volumeID, nodeID); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			cs := NewControllerServer(&stateConnector{Interface: connector, state: c.state}, &Options{})
+
+			_, err := cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: c.volumeID})
+			if code := status.Code(err); code != c.expectedCode {
+				t.Fatalf("expected code %v, got %v (%v)", c.expectedCode, code, err)
+			}
+			_, err = connector.GetVolumeByID(ctx, volumeID)
+			if deleted := errors.Is(err, cloud.ErrNotFound); deleted != c.deleted {
+				t.Errorf("expected the volume to be deleted: %v, got %v (%v)", c.deleted, deleted, err)
+			}
+		})
+	}
+}
+
+func TestControllerGetVolume(t *testing.T) {
+	const (
+		volumeID = "ace9f28b-3081-40c1-8353-4cc3e3014072"
+		nodeID   = "0d7107a3-94d2-44e7-89b8-8930881309a5"
+	)
+
+	t.Run("attached and healthy", func(t *testing.T) {
+		connector := fake.New()
+		if _, err := connector.AttachVolume(context.Background(), volumeID, nodeID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		cs := NewControllerServer(&stateConnector{Interface: connector, state: cloud.VolumeReady}, &Options{})
+
+		resp, err := cs.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: volumeID})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.GetVolume().GetVolumeId() != volumeID || resp.GetVolume().GetCapacityBytes() != 10 {
+			t.Errorf("unexpected volume %v", resp.GetVolume())
+		}
+		if nodeIDs := resp.GetStatus().GetPublishedNodeIds(); !reflect.DeepEqual(nodeIDs, []string{nodeID}) {
+			t.Errorf("expected volume published to %s, got %v", nodeID, nodeIDs)
+		}
+		if resp.GetStatus().GetVolumeCondition().GetAbnormal() {
+			t.Errorf("expected a normal volume, got %v", resp.GetStatus().GetVolumeCondition())
+		}
+	})
+
+	t.Run("destroyed", func(t *testing.T) {
+		cs := NewControllerServer(&stateConnector{Interface: fake.New(), state: "Destroy"}, &Options{})
+
+		resp, err := cs.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: volumeID})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(resp.GetStatus().GetPublishedNodeIds()) != 0 {
+			t.Errorf("expected no published node, got %v", resp.GetStatus().GetPublishedNodeIds())
+		}
+		condition := resp.GetStatus().GetVolumeCondition()
+		if !condition.GetAbnormal() || !strings.Contains(condition.GetMessage(), "Destroy") {
+			t.Errorf("expected an abnormal volume in state Destroy, got %v", condition)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		cs := NewControllerServer(fake.New(), &Options{})
+
+		_, err := cs.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: "5e0d3c1a-9b7f-4e2d-8c6a-1f2e3d4c5b6a"})
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("expected NotFound error, got %v", err)
+		}
+	})
+}
+
+func TestGetCapacity(t *testing.T) {
+	cs := NewControllerServer(fake.New(), &Options{})
+
+	cases := []struct {
+		name     string
+		req      *csi.GetCapacityRequest
+		expected int64
+	}{
+		{"no topology", &csi.GetCapacityRequest{}, 100 * 1024 * 1024 * 1024},
+		{"known zone", &csi.GetCapacityRequest{
+			AccessibleTopology: Topology{ZoneID: "a1887604-237c-4212-a9cd-94620b7880fa"}.ToCSI(),
+			Parameters:         map[string]string{DiskOfferingNameKey: "custom"},
+		}, 100 * 1024 * 1024 * 1024},
+		{"unknown zone", &csi.GetCapacityRequest{
+			AccessibleTopology: Topology{ZoneID: "a9d9a6ba-9ba1-4dd5-8b48-6a9a4a5a5a37"}.ToCSI(),
+		}, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resp, err := cs.GetCapacity(context.Background(), c.req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.GetAvailableCapacity() != c.expected {
+				t.Errorf("expected %d bytes available, got %d", c.expected, resp.GetAvailableCapacity())
+			}
+		})
+	}
+
+	_, err := cs.GetCapacity(context.Background(), &csi.GetCapacityRequest{
+		Parameters: map[string]string{DiskOfferingNameKey: "unknown"},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument error for an unknown disk offering, got %v", err)
+	}
+}
+
+// timeoutConnector fails to attach volumes as if the async job did not
+// complete in time.
+type timeoutConnector struct {
+	cloud.Interface
+}
+
+func (c *timeoutConnector) AttachVolume(_ context.Context, volumeID, _ string) (string, error) {
+	return "", fmt.Errorf("AttachVolume job for volume %s did not complete in time: %w", volumeID, context.DeadlineExceeded)
+}
+
+func TestControllerPublishVolumeTimeout(t *testing.T) {
+	cs := NewControllerServer(&timeoutConnector{Interface: fake.New()}, &Options{})
+	_, err := cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId: "ace9f28b-3081-40c1-8353-4cc3e3014072",
+		NodeId:   "0d7107a3-94d2-44e7-89b8-8930881309a5",
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded error, got %v", err)
+	}
+}
+
+// raceConnector attaches and detaches volumes, then fails as if they had
+// already been attached or detached by a concurrent attempt.
+type raceConnector struct {
+	cloud.Interface
+}
+
+func (c *raceConnector) AttachVolume(ctx context.Context, volumeID, vmID string) (string, error) {
+	if _, err := c.Interface.AttachVolume(ctx, volumeID, vmID); err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("CloudStack API error 431: volume %s is already attached", volumeID)
+}
+
+func (c *raceConnector) DetachVolume(ctx context.Context, volumeID string) error {
+	if err := c.Interface.DetachVolume(ctx, volumeID); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("CloudStack API error 431: volume %s is not attached", volumeID)
+}
+
+func TestControllerPublishVolumeIdempotency(t *testing.T) {
+	const (
+		volumeID = "ace9f28b-3081-40c1-8353-4cc3e3014072"
+		nodeID   = "0d7107a3-94d2-44e7-89b8-8930881309a5"
+	)
+	req := &csi.ControllerPublishVolumeRequest{
+		VolumeId: volumeID,
+		NodeId:   nodeID,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	}
+
+	t.Run("already attached", func(t *testing.T) {
+		cs := NewControllerServer(fake.New(), &Options{})
+		first, err := cs.ControllerPublishVolume(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		second, err := cs.ControllerPublishVolume(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if second.GetPublishContext()[deviceIDContextKey] != first.GetPublishContext()[deviceIDContextKey] {
+			t.Errorf("expected device ID %q, got %q", first.GetPublishContext()[deviceIDContextKey], second.GetPublishContext()[deviceIDContextKey])
+		}
+	})
+
+	t.Run("attached by a concurrent attempt", func(t *testing.T) {
+		cs := NewControllerServer(&raceConnector{Interface: fake.New()}, &Options{})
+		resp, err := cs.ControllerPublishVolume(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.GetPublishContext()[deviceIDContextKey] == "" {
+			t.Error("expected a device ID")
+		}
+	})
+
+	t.Run("attached elsewhere", func(t *testing.T) {
+		connector := fake.New()
+		if _, err := connector.AttachVolume(context.Background(), volumeID, "93a0b4fe-3a4f-4a5c-8e2d-2b1e0a7c6d5f"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		cs := NewControllerServer(connector, &Options{})
+		if _, err := cs.ControllerPublishVolume(context.Background(), req); status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("expected FailedPrecondition error, got %v", err)
+		}
+	})
+
+	t.Run("single node multi writer attached elsewhere", func(t *testing.T) {
+		connector := fake.New()
+		if _, err := connector.AttachVolume(context.Background(), volumeID, "93a0b4fe-3a4f-4a5c-8e2d-2b1e0a7c6d5f"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		cs := NewControllerServer(connector, &Options{})
+		_, err := cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+			VolumeId: volumeID,
+			NodeId:   nodeID,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER},
+			},
+		})
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("expected FailedPrecondition error, got %v", err)
+		}
+	})
+}
+
+func TestControllerPublishVolumeReadonly(t *testing.T) {
+	cs := NewControllerServer(fake.New(), &Options{})
+	publish := func(readonly bool) (*csi.ControllerPublishVolumeResponse, error) {
+		return cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+			VolumeId: "ace9f28b-3081-40c1-8353-4cc3e3014072",
+			NodeId:   "0d7107a3-94d2-44e7-89b8-8930881309a5",
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			},
+			Readonly: readonly,
+		})
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := publish(true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.GetPublishContext()[readonlyContextKey] != "true" {
+			t.Errorf("expected the publish context to be read-only, got %v", resp.GetPublishContext())
+		}
+	}
+	if _, err := publish(false); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected AlreadyExists error, got %v", err)
+	}
+}
+
+// slotConnector attaches volumes with the device IDs requested, reporting
+// the ones of inUse as taken.
+type slotConnector struct {
+	cloud.Interface
+	inUse     []int64
+	requested []int64
+}
+
+func (c *slotConnector) AttachVolumeToDevice(ctx context.Context, volumeID, vmID string, deviceID int64) (string, error) {
+	c.requested = append(c.requested, deviceID)
+	if slices.Contains(c.inUse, deviceID) {
+		return "", cloud.ErrDeviceIDInUse
+	}
+
+	return c.Interface.AttachVolumeToDevice(ctx, volumeID, vmID, deviceID)
+}
+
+func TestControllerPublishVolumeDeviceID(t *testing.T) {
+	cases := []struct {
+		name      string
+		inUse     []int64
+		expected  string
+		requested []int64
+		code      codes.Code
+	}{
+		{"free", nil, "2", []int64{2}, codes.OK},
+		{"conflict", []int64{2, 3}, "4", []int64{2, 3, 4}, codes.OK},
+		{"all in use", []int64{2, 3}, "", nil, codes.ResourceExhausted},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			connector := &slotConnector{Interface: fake.New(), inUse: c.inUse}
+			if c.code != codes.OK {
+				// Every device ID is in use.
+				for id := int64(2); id < 2+DefaultVolumeAttachSlots; id++ {
+					connector.inUse = append(connector.inUse, id)
+				}
+			}
+			cs := NewControllerServer(connector, &Options{FirstDeviceID: 2})
+
+			resp, err := cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+				VolumeId: "ace9f28b-3081-40c1-8353-4cc3e3014072",
+				NodeId:   "0d7107a3-94d2-44e7-89b8-8930881309a5",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				},
+			})
+			if status.Code(err) != c.code {
+				t.Fatalf("expected %v error, got %v", c.code, err)
+			}
+			if err != nil {
+				if len(connector.requested) != int(DefaultVolumeAttachSlots) {
+					t.Errorf("expected %d device IDs to be requested, got %v", DefaultVolumeAttachSlots, connector.requested)
+				}
+
+				return
+			}
+			if deviceID := resp.GetPublishContext()[deviceIDContextKey]; deviceID != c.expected {
+				t.Errorf("expected device ID %s in the publish context, got %q", c.expected, deviceID)
+			}
+			if !slices.Equal(connector.requested, c.requested) {
+				t.Errorf("expected device IDs %v to be requested, got %v", c.requested, connector.requested)
+			}
+		})
+	}
+}
+
+func TestControllerUnpublishVolumeIdempotency(t *testing.T) {
+	const (
+		volumeID = "ace9f28b-3081-40c1-8353-4cc3e3014072"
+		nodeID   = "0d7107a3-94d2-44e7-89b8-8930881309a5"
+	)
+
+	t.Run("already detached", func(t *testing.T) {
+		connector := fake.New()
+		cs := NewControllerServer(connector, &Options{})
+		for _, node := range []string{nodeID, ""} {
+			if _, err := cs.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{
+				VolumeId: volumeID,
+				NodeId:   node,
+			}); err != nil {
+				t.Errorf("unexpected error for node %q: %v", node, err)
+			}
+		}
+	})
+
+	t.Run("detached by a concurrent attempt", func(t *testing.T) {
+		connector := fake.New()
+		if _, err := connector.AttachVolume(context.Background(), volumeID, nodeID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		cs := NewControllerServer(&raceConnector{Interface: connector}, &Options{})
+		if _, err := cs.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{
+			VolumeId: volumeID,
+			NodeId:   nodeID,
+		}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("attached elsewhere", func(t *testing.T) {
+		connector := fake.New()
+		if _, err := connector.AttachVolume(context.Background(), volumeID, nodeID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		cs := NewControllerServer(connector, &Options{})
+		if _, err := cs.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{
+			VolumeId: volumeID,
+			NodeId:   "93a0b4fe-3a4f-4a5c-8e2d-2b1e0a7c6d5f",
+		}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if vol, _ := connector.GetVolumeByID(context.Background(), volumeID); vol.VirtualMachineID != nodeID {
+			t.Errorf("expected volume to stay attached to %s, got %q", nodeID, vol.VirtualMachineID)
+		}
+	})
+}
+
+// stuckDetachConnector never completes the detachments, as for a VM
+// whose host is unreachable.
+type stuckDetachConnector struct {
+	cloud.Interface
+	vmState string
+	forced  bool
+}
+
+func (c *stuckDetachConnector) GetVMByID(ctx context.Context, vmID string) (*cloud.VM, error) {
+	vm, err := c.Interface.GetVMByID(ctx, vmID)
+	if err != nil {
+		return nil, err
+	}
+	vm.State = c.vmState
+
+	return vm, nil
+}
+
+func (c *stuckDetachConnector) DetachVolume(ctx context.Context, volumeID string) error {
+	<-ctx.Done()
+
+	return fmt.Errorf("DetachVolume job for volume %s did not complete in time: %w", volumeID, ctx.Err())
+}
+
+func (c *stuckDetachConnector) ForceDetachVolume(ctx context.Context, volumeID string) error {
+	c.forced = true
+
+	return c.Interface.ForceDetachVolume(ctx, volumeID)
+}
+
+func TestControllerUnpublishVolumeDetachTimeout(t *testing.T) {
+	const (
+		volumeID = "ace9f28b-3081-40c1-8353-4cc3e3014072"
+		nodeID   = "0d7107a3-94d2-44e7-89b8-8930881309a5"
+	)
+	cases := []struct {
+		name           string
+		forceDetach    bool
+		vmState        string
+		expectDetached bool
+	}{
+		{"no force", false, "Stopped", false},
+		{"force, VM stopped", true, "Stopped", true},
+		{"force, VM running", true, cloud.VMRunning, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			connector := &stuckDetachConnector{Interface: fake.New(), vmState: c.vmState}
+			if _, err := connector.AttachVolume(ctx, volumeID, nodeID); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			cs := NewControllerServer(connector, &Options{DetachTimeout: 10 * time.Millisecond, ForceDetach: c.forceDetach})
+			req := &csi.ControllerUnpublishVolumeRequest{VolumeId: volumeID, NodeId: nodeID}
+
+			_, err := cs.ControllerUnpublishVolume(ctx, req)
+			if status.Code(err) != codes.Aborted {
+				t.Fatalf("expected Aborted error, got %v", err)
+			}
+			if connector.forced != c.expectDetached {
+				t.Errorf("expected forced detachment %v, got %v", c.expectDetached, connector.forced)
+			}
+
+			// The retry of the external-attacher succeeds once detached.
+			_, err = cs.ControllerUnpublishVolume(ctx, req)
+			if c.expectDetached && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !c.expectDetached && status.Code(err) != codes.Aborted {
+				t.Errorf("expected Aborted error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateVolumeCapabilities(t *testing.T) {
+	mount := func(mode csi.VolumeCapability_AccessMode_Mode, fsType string) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: fsType}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+		}
+	}
+	block := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+		}
+	}
+
+	cases := []struct {
+		name      string
+		caps      []*csi.VolumeCapability
+		confirmed bool
+	}{
+		{"mount", []*csi.VolumeCapability{mount(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, "")}, true},
+		{"mount xfs", []*csi.VolumeCapability{mount(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, "xfs")}, true},
+		{"block", []*csi.VolumeCapability{block(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)}, true},
+		{"block and mount", []*csi.VolumeCapability{
+			block(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+			mount(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, "ext4"),
+		}, true},
+		{"block single node multi writer", []*csi.VolumeCapability{block(csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER)}, true},
+		{"mount single node single writer", []*csi.VolumeCapability{mount(csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER, "")}, true},
+		{"block multi writer", []*csi.VolumeCapability{block(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)}, false},
+		{"mount multi reader", []*csi.VolumeCapability{mount(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, "")}, false},
+		{"unknown filesystem", []*csi.VolumeCapability{mount(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, "ntfs")}, false},
+		{"no access type", []*csi.VolumeCapability{{
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}}, false},
+		{"one unsupported", []*csi.VolumeCapability{
+			mount(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, ""),
+			block(csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER),
+		}, false},
+	}
+
+	cs := NewControllerServer(fake.New(), &Options{})
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resp, err := cs.ValidateVolumeCapabilities(context.Background(), &csi.ValidateVolumeCapabilitiesRequest{
+				VolumeId:           "ace9f28b-3081-40c1-8353-4cc3e3014072",
+				VolumeCapabilities: c.caps,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if confirmed := resp.GetConfirmed() != nil; confirmed != c.confirmed {
+				t.Errorf("expected confirmed %v, got %v (message %q)", c.confirmed, confirmed, resp.GetMessage())
+			}
+			if !c.confirmed && resp.GetMessage() == "" {
+				t.Error("expected a message explaining why the capabilities are not supported")
+			}
+		})
+	}
+
+	t.Run("volume not found", func(t *testing.T) {
+		_, err := cs.ValidateVolumeCapabilities(context.Background(), &csi.ValidateVolumeCapabilitiesRequest{
+			VolumeId:           "00000000-0000-0000-0000-000000000000",
+			VolumeCapabilities: []*csi.VolumeCapability{mount(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, "")},
+		})
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("expected NotFound error, got %v", err)
+		}
+	})
+}
+
+func TestDeleteSnapshot(t *testing.T) {
+	cs := NewControllerServer(fake.New(), &Options{})
+	resp, err := cs.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: "ace9f28b-3081-40c1-8353-4cc3e3014072"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Deleting twice succeeds.
+	for i := 0; i < 2; i++ {
+		if _, err := cs.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: resp.GetSnapshot().GetSnapshotId()}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestOperationTimeouts(t *testing.T) {
+	const (
+		volumeID = "ace9f28b-3081-40c1-8353-4cc3e3014072"
+		nodeID   = "0d7107a3-94d2-44e7-89b8-8930881309a5"
+		timeout  = 10 * time.Millisecond
+	)
+	// The CloudStack jobs take longer than the timeouts.
+	const jobDuration = 100 * time.Millisecond
+
+	cases := []struct {
+		name    string
+		timeout func(o *Options) *time.Duration
+		call    func(cs csi.ControllerServer) error
+	}{
+		{
+			name:    "create volume",
+			timeout: func(o *Options) *time.Duration { return &o.CreateVolumeTimeout },
+			call: func(cs csi.ControllerServer) error {
+				_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+					Name: "pvc-6b6f3a0e-4a3c-4b7e-9f6a-0c1d2e3f4a5b",
+					VolumeCapabilities: []*csi.VolumeCapability{{
+						AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+						AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+					}},
+					Parameters: map[string]string{DiskOfferingKey: "9743fd77-0f5d-4ef9-b2f8-f194235c769c"},
+				})
+
+				return err
+			},
+		},
+		{
+			name:    "delete volume",
+			timeout: func(o *Options) *time.Duration { return &o.DeleteVolumeTimeout },
+			call: func(cs csi.ControllerServer) error {
+				_, err := cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeID})
+
+				return err
+			},
+		},
+		{
+			name:    "attach",
+			timeout: func(o *Options) *time.Duration { return &o.AttachTimeout },
+			call: func(cs csi.ControllerServer) error {
+				_, err := cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+					VolumeId: volumeID,
+					NodeId:   nodeID,
+					VolumeCapability: &csi.VolumeCapability{
+						AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+						AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+					},
+				})
+
+				return err
+			},
+		},
+		{
+			name:    "create snapshot",
+			timeout: func(o *Options) *time.Duration { return &o.SnapshotTimeout },
+			call: func(cs csi.ControllerServer) error {
+				_, err := cs.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: "snap-1", SourceVolumeId: volumeID})
+
+				return err
+			},
+		},
+	}
+	allTimeouts := func(o *Options) []*time.Duration {
+		return []*time.Duration{&o.CreateVolumeTimeout, &o.DeleteVolumeTimeout, &o.AttachTimeout, &o.SnapshotTimeout}
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// Only the timeout of the operation applies to it.
+			options := &Options{}
+			*c.timeout(options) = timeout
+			cs := NewControllerServer(fake.New(fake.WithJobDuration(jobDuration)), options)
+			if err := c.call(cs); status.Code(err) != codes.DeadlineExceeded {
+				t.Errorf("expected DeadlineExceeded error, got %v", err)
+			}
+
+			options = &Options{}
+			for _, d := range allTimeouts(options) {
+				*d = timeout
+			}
+			*c.timeout(options) = 0
+			cs = NewControllerServer(fake.New(fake.WithJobDuration(jobDuration)), options)
+			if err := c.call(cs); err != nil {
+				t.Errorf("unexpected error with the timeouts of the other operations: %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeFromSource(t *testing.T) {
+	const (
+		gb             = 1024 * 1024 * 1024
+		sourceVolumeID = "ace9f28b-3081-40c1-8353-4cc3e3014072"
+		zoneID         = "a1887604-237c-4212-a9cd-94620b7880fa"
+	)
+	newRequest := func(name string, src *csi.VolumeContentSource, capRange *csi.CapacityRange) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:          name,
+			CapacityRange: capRange,
+			Parameters:    map[string]string{DiskOfferingKey: "9743fd77-0f5d-4ef9-b2f8-f194235c769c"},
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+			VolumeContentSource: src,
+		}
+	}
+	snapshotSource := func(id string) *csi.VolumeContentSource {
+		return &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: id},
+		}}
+	}
+	volumeSource := func(id string) *csi.VolumeContentSource {
+		return &csi.VolumeContentSource{Type: &csi.VolumeContentSource_Volume{
+			Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: id},
+		}}
+	}
+
+	// setup returns a controller server whose source volume has 5 GB,
+	// and the ID of a snapshot of this volume.
+	setup := func(t *testing.T) (csi.ControllerServer, string) {
+		t.Helper()
+		connector := fake.New()
+		if err := connector.ExpandVolume(context.Background(), sourceVolumeID, 5); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		snap, err := connector.CreateSnapshot(context.Background(), sourceVolumeID, "snap-1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return NewControllerServer(connector, &Options{}), snap.ID
+	}
+
+	t.Run("from snapshot", func(t *testing.T) {
+		cs, snapshotID := setup(t)
+		req := newRequest("restored", snapshotSource(snapshotID), nil)
+		resp, err := cs.CreateVolume(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.GetVolume().GetCapacityBytes() != 5*gb {
+			t.Errorf("expected capacity %d, got %d", 5*gb, resp.GetVolume().GetCapacityBytes())
+		}
+		if resp.GetVolume().GetContentSource().GetSnapshot().GetSnapshotId() != snapshotID {
+			t.Errorf("expected content source %s, got %v", snapshotID, resp.GetVolume().GetContentSource())
+		}
+
+		if _, err := cs.CreateVolume(context.Background(), req); err != nil {
+			t.Fatalf("unexpected error on retry: %v", err)
+		}
+	})
+
+	t.Run("from snapshot with another source", func(t *testing.T) {
+		cs, snapshotID := setup(t)
+		if _, err := cs.CreateVolume(context.Background(), newRequest("restored", snapshotSource(snapshotID), nil)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err := cs.CreateVolume(context.Background(), newRequest("restored", snapshotSource("0f1d6c5e-5a4b-4e3c-9d2a-1b0c9e8f7a6d"), nil))
+		if status.Code(err) != codes.AlreadyExists {
+			t.Errorf("expected AlreadyExists error, got %v", err)
+		}
+	})
+
+	t.Run("clone", func(t *testing.T) {
+		cs, _ := setup(t)
+		req := newRequest("clone", volumeSource(sourceVolumeID), &csi.CapacityRange{RequiredBytes: 8 * gb})
+		resp, err := cs.CreateVolume(context.Background(), req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.GetVolume().GetCapacityBytes() != 8*gb {
+			t.Errorf("expected capacity %d, got %d", 8*gb, resp.GetVolume().GetCapacityBytes())
+		}
+		if _, err := cs.CreateVolume(context.Background(), req); err != nil {
+			t.Fatalf("unexpected error on retry: %v", err)
+		}
+	})
+
+	t.Run("blank volume with the name of a clone", func(t *testing.T) {
+		cs, _ := setup(t)
+		if _, err := cs.CreateVolume(context.Background(), newRequest("blank", nil, nil)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err := cs.CreateVolume(context.Background(), newRequest("blank", volumeSource(sourceVolumeID), nil))
+		if status.Code(err) != codes.AlreadyExists {
+			t.Errorf("expected AlreadyExists error, got %v", err)
+		}
+	})
+
+	t.Run("size too small", func(t *testing.T) {
+		cs, snapshotID := setup(t)
+		for _, capRange := range []*csi.CapacityRange{{RequiredBytes: 2 * gb, LimitBytes: 2 * gb}, {RequiredBytes: 2 * gb}} {
+			for _, src := range []*csi.VolumeContentSource{snapshotSource(snapshotID), volumeSource(sourceVolumeID)} {
+				_, err := cs.CreateVolume(context.Background(), newRequest("too-small", src, capRange))
+				if status.Code(err) != codes.OutOfRange {
+					t.Errorf("expected OutOfRange error for %v, got %v", capRange, err)
+				}
+			}
+		}
+	})
+
+	t.Run("other zone", func(t *testing.T) {
+		cs, snapshotID := setup(t)
+		req := newRequest("other-zone", snapshotSource(snapshotID), nil)
+		req.AccessibilityRequirements = &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{{Segments: map[string]string{ZoneKey: "6f2b8f0e-4c1a-4d9e-8b7a-5c3d2e1f0a9b"}}},
+		}
+		if _, err := cs.CreateVolume(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected InvalidArgument error, got %v", err)
+		}
+		req.AccessibilityRequirements.Requisite[0].Segments[ZoneKey] = zoneID
+		if _, err := cs.CreateVolume(context.Background(), req); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("missing sources", func(t *testing.T) {
+		cs, _ := setup(t)
+		for _, src := range []*csi.VolumeContentSource{snapshotSource("0f1d6c5e-5a4b-4e3c-9d2a-1b0c9e8f7a6d"), volumeSource("0f1d6c5e-5a4b-4e3c-9d2a-1b0c9e8f7a6d")} {
+			if _, err := cs.CreateVolume(context.Background(), newRequest("missing", src, nil)); status.Code(err) != codes.NotFound {
+				t.Errorf("expected NotFound error, got %v", err)
+			}
+		}
+	})
+}
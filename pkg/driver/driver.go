@@ -9,7 +9,10 @@ import (
 	"net"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 	"k8s.io/klog/v2"
 
 	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud"
@@ -19,7 +22,8 @@ import (
 
 // Interface is the CloudStack CSI driver interface.
 type Interface interface {
-	// Run the CSI driver gRPC server
+	// Run the CSI driver gRPC server, until ctx is done. The calls in
+	// progress are then given some time to complete.
 	Run(ctx context.Context) error
 }
 
@@ -27,33 +31,55 @@ type cloudstackDriver struct {
 	controller csi.ControllerServer
 	node       csi.NodeServer
 	options    *Options
+
+	// prober checks the CloudStack API on Probe calls, in the modes
+	// running the controller service.
+	prober *apiProber
+
+	drainer *drainer
+
+	// registry holds the metrics served over HTTP.
+	registry *prometheus.Registry
 }
 
 // New instantiates a new CloudStack CSI driver.
 func New(ctx context.Context, csConnector cloud.Interface, options *Options, mounter mount.Interface) (Interface, error) {
 	logger := klog.FromContext(ctx)
-	logger.Info("Driver starting", "Driver", DriverName, "Version", driverVersion)
+	logger.Info("Driver starting", "Driver", DriverName, "Version", vendorVersion(), "BuildDate", buildDate)
 
 	if err := validateMode(options.Mode); err != nil {
 		return nil, fmt.Errorf("invalid driver options: %w", err)
 	}
 
 	driver := &cloudstackDriver{
-		options: options,
+		options:  options,
+		drainer:  &drainer{},
+		registry: newRegistry(),
+	}
+	if mounter == nil && options.Mode != ControllerMode {
+		mounter = newMounter(options, driver.registry)
 	}
 
 	switch options.Mode {
 	case ControllerMode:
-		driver.controller = NewControllerServer(csConnector)
+		driver.controller = NewControllerServer(csConnector, options)
+		driver.prober = newAPIProber(csConnector, options)
 	case NodeMode:
 		driver.node = NewNodeServer(csConnector, mounter, options)
 	case AllMode:
-		driver.controller = NewControllerServer(csConnector)
+		driver.controller = NewControllerServer(csConnector, options)
 		driver.node = NewNodeServer(csConnector, mounter, options)
+		driver.prober = newAPIProber(csConnector, options)
 	default:
 		return nil, fmt.Errorf("unknown mode: %s", options.Mode)
 	}
 
+	if ns, ok := driver.node.(*nodeServer); ok {
+		if err := NodePreflight(ctx, ns.mounter, options.PreflightFailFast); err != nil {
+			return nil, fmt.Errorf("node preflight failed: %w", err)
+		}
+	}
+
 	return driver, nil
 }
 
@@ -69,17 +95,39 @@ func (cs *cloudstackDriver) Run(ctx context.Context) error {
 		return fmt.Errorf("failed to listen: %w", err)
 	}
 
-	// Log every request and payloads (request + response)
+	// Log every request, its duration and result, and track the requests
+	// in progress for the shutdown.
 	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-			resp, err := handler(klog.NewContext(ctx, logger), req)
-			if err != nil {
-				logger.Error(err, "GRPC method failed", "method", info.FullMethod)
-			}
-
-			return resp, err
+		grpc.ChainUnaryInterceptor(
+			logGRPC(logger, cs.options.GRPCLogVerbosity),
+			instrumentGRPC(cs.registry),
+			cs.drainer.unaryInterceptor(),
+		),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             cs.options.GRPCKeepaliveMinTime,
+			PermitWithoutStream: cs.options.GRPCKeepalivePermitWithoutStream,
 		}),
 	}
+	if cs.options.OTLPEndpoint != "" {
+		shutdownTracing, err := setupTracing(ctx, cs.options.OTLPEndpoint)
+		if err != nil {
+			listener.Close()
+
+			return err
+		}
+		defer func() {
+			if err := shutdownTracing(context.Background()); err != nil {
+				logger.Error(err, "Failed to flush the traces")
+			}
+		}()
+		opts = append(opts, grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	}
+	if cs.options.GRPCMaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(cs.options.GRPCMaxRecvMsgSize))
+	}
+	if cs.options.GRPCMaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(cs.options.GRPCMaxSendMsgSize))
+	}
 	grpcServer := grpc.NewServer(opts...)
 
 	csi.RegisterIdentityServer(grpcServer, cs)
@@ -95,9 +143,46 @@ func (cs *cloudstackDriver) Run(ctx context.Context) error {
 		return fmt.Errorf("unknown mode: %s", cs.options.Mode)
 	}
 
+	httpServer, httpServed, err := cs.serveHTTP(logger)
+	if err != nil {
+		listener.Close()
+
+		return err
+	}
+
 	logger.Info("Listening for connections", "address", listener.Addr())
 
-	return grpcServer.Serve(listener)
+	served := make(chan error, 1)
+	go func() {
+		served <- grpcServer.Serve(listener)
+	}()
+	select {
+	case err := <-served:
+		return err
+	case err := <-httpServed:
+		grpcServer.Stop()
+
+		return fmt.Errorf("HTTP server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	logger.Info("Shutting down, waiting for the calls in progress", "timeout", cs.options.ShutdownTimeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), cs.options.ShutdownTimeout)
+	defer cancel()
+	if err := cs.drainer.drain(drainCtx); err != nil {
+		logger.Error(err, "Calls still in progress, stopping anyway")
+		grpcServer.Stop()
+	} else {
+		grpcServer.GracefulStop()
+	}
+	if httpServer != nil {
+		if err := httpServer.Shutdown(drainCtx); err != nil {
+			logger.Error(err, "Failed to shut down the HTTP server")
+		}
+	}
+	logger.Info("Driver stopped")
+
+	return nil
 }
 
 func validateMode(mode Mode) error {
@@ -0,0 +1,108 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud/fake"
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/mount"
+)
+
+func TestRunMaxMsgSize(t *testing.T) {
+	endpoint := "unix://" + filepath.Join(t.TempDir(), "csi.sock")
+	d, err := New(context.Background(), fake.New(), &Options{
+		Mode:               ControllerMode,
+		Endpoint:           endpoint,
+		GRPCMaxRecvMsgSize: 1024,
+		ShutdownTimeout:    time.Second,
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- d.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		if err := <-stopped; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+
+	conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	client := csi.NewControllerClient(conn)
+
+	validate := func(contextSize int) error {
+		_, err := client.ValidateVolumeCapabilities(context.Background(), &csi.ValidateVolumeCapabilitiesRequest{
+			VolumeId: "ace9f28b-3081-40c1-8353-4cc3e3014072",
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+			VolumeContext: map[string]string{"padding": strings.Repeat("x", contextSize)},
+		}, grpc.WaitForReady(true))
+
+		return err
+	}
+
+	if err := validate(100); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := validate(2048); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("expected ResourceExhausted error, got %v", err)
+	}
+}
+
+type preflightMounter struct {
+	mount.Interface
+	errs []error
+}
+
+func (m *preflightMounter) Preflight(_ []string) []error {
+	return m.errs
+}
+
+func TestNodePreflight(t *testing.T) {
+	mounter := &preflightMounter{
+		Interface: mount.NewFake(),
+		errs: []error{
+			errors.New("blkid not found in $PATH"),
+			errors.New("mkfs.xfs not found in $PATH"),
+		},
+	}
+
+	if err := NodePreflight(context.Background(), mounter, false); err != nil {
+		t.Errorf("unexpected error without fail fast: %v", err)
+	}
+
+	err := NodePreflight(context.Background(), mounter, true)
+	if err == nil {
+		t.Fatal("expected an error with fail fast")
+	}
+	for _, e := range mounter.errs {
+		if !errors.Is(err, e) {
+			t.Errorf("expected the error to wrap %q, got %v", e, err)
+		}
+	}
+
+	if err := NodePreflight(context.Background(), &preflightMounter{Interface: mount.NewFake()}, true); err != nil {
+		t.Errorf("unexpected error when all checks pass: %v", err)
+	}
+}
@@ -0,0 +1,236 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud"
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/mount"
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/util"
+)
+
+const (
+	// ephemeralVolumeIDPrefix is the prefix of the IDs the kubelet gives
+	// to the ephemeral inline volumes. CloudStack volume IDs are UUIDs.
+	ephemeralVolumeIDPrefix = "csi-"
+	// defaultEphemeralSizeInGB is the size of the ephemeral inline
+	// volumes without EphemeralSizeKey.
+	defaultEphemeralSizeInGB int64 = 1
+)
+
+func isEphemeral(volumeContext map[string]string) bool {
+	return volumeContext[EphemeralKey] == "true"
+}
+
+// publishEphemeralVolume creates a CloudStack volume named after the
+// ephemeral inline volume, attaches it to the node, and formats and mounts
+// it at the target path. The kubelet neither stages ephemeral inline
+// volumes nor calls the controller service for them.
+func (ns *nodeServer) publishEphemeralVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	logger := klog.FromContext(ctx)
+	volumeID := req.GetVolumeId()
+	target := req.GetTargetPath()
+	volCap := req.GetVolumeCapability()
+
+	switch volCap.GetAccessMode().GetMode() {
+	case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+		return nil, status.Error(codes.InvalidArgument, "Ephemeral volumes cannot be shared by several nodes")
+	}
+	if !isValidVolumeCapabilities([]*csi.VolumeCapability{volCap}) {
+		return nil, status.Error(codes.InvalidArgument, "Volume capability not supported")
+	}
+	mnt := volCap.GetMount()
+	if mnt == nil {
+		return nil, status.Error(codes.InvalidArgument, "Ephemeral volumes must be mounted")
+	}
+
+	fsType := mnt.GetFsType()
+	if fsType == "" {
+		fsType = ns.defaultFsType
+		logger.V(4).Info("NodePublishVolume: fstype not set, using the default", "volumeID", volumeID, "fstype", fsType)
+	}
+	if _, ok := ValidFSTypes[strings.ToLower(fsType)]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "NodePublishVolume: invalid fstype %s", fsType)
+	}
+
+	var mountOptions []string
+	if req.GetReadonly() {
+		mountOptions = append(mountOptions, "ro")
+	}
+	for _, f := range mnt.GetMountFlags() {
+		if !hasMountOption(mountOptions, f) {
+			mountOptions = append(mountOptions, f)
+		}
+	}
+	mountOptions, err := mount.ValidateMountOptions(mountOptions)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	formatOptions, err := volumeFormatOptions(fsType, req.GetVolumeContext())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if acquired := ns.volumeLocks.TryAcquire(volumeID); !acquired {
+		logger.Error(errors.New(util.ErrVolumeOperationAlreadyExistsVolumeID), "failed to acquire volume lock", "volumeID", volumeID)
+
+		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer ns.volumeLocks.Release(volumeID)
+
+	mounted, err := ns.isMounted(ctx, target)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, status.Errorf(codes.Internal, "Could not check if %q is mounted: %v", target, err)
+		}
+		if err := ns.mounter.MakeDir(target); err != nil {
+			return nil, status.Errorf(codes.Internal, "Could not create dir %q: %v", target, err)
+		}
+	}
+	if mounted {
+		logger.Info("NodePublishVolume: ephemeral volume is already mounted", "volumeID", volumeID, "target", target)
+
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	vol, err := ns.ensureEphemeralVolume(ctx, volumeID, req.GetVolumeContext())
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := ns.findDevicePath(ctx, vol.ID, attachedDeviceID(vol), ns.volumeHypervisor(ctx, vol.ID, vol.Hypervisor))
+	if err != nil {
+		return nil, err
+	}
+
+	logger.V(4).Info("NodePublishVolume: mounting ephemeral volume", "source", source, "volumeID", volumeID, "target", target, "fstype", fsType, "options", mountOptions, "formatOptions", formatOptions)
+	if err := ns.mounter.FormatAndMountWithFormatOptions(source, target, fsType, mountOptions, formatOptions); err != nil {
+		return nil, status.Errorf(codes.Internal, "could not format %q and mount it at %q: %v", source, target, err)
+	}
+
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// ensureEphemeralVolume returns the CloudStack volume of an ephemeral
+// inline volume, attached to the node, creating it if needed.
+func (ns *nodeServer) ensureEphemeralVolume(ctx context.Context, volumeID string, volumeContext map[string]string) (*cloud.Volume, error) {
+	logger := klog.FromContext(ctx)
+
+	vm, err := ns.connector.GetNodeInfo(ctx, ns.nodeName)
+	if err != nil {
+		return nil, status.Errorf(cloudErrorCode(err), "Cannot get node %s: %v", ns.nodeName, err)
+	}
+
+	vol, err := ns.connector.GetVolumeByName(ctx, volumeID)
+	switch {
+	case errors.Is(err, cloud.ErrNotFound):
+		if vol, err = ns.createEphemeralVolume(ctx, volumeID, vm.ZoneID, volumeContext); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, status.Errorf(cloudErrorCode(err), "Cannot get volume %s: %v", volumeID, err)
+	}
+
+	switch vol.VirtualMachineID {
+	case vm.ID:
+		return vol, nil
+	case "":
+	default:
+		return nil, status.Errorf(codes.FailedPrecondition, "Ephemeral volume %s is attached to another VM %s", volumeID, vol.VirtualMachineID)
+	}
+
+	logger.V(4).Info("NodePublishVolume: attaching ephemeral volume", "volumeID", volumeID, "cloudstackVolumeID", vol.ID, "vmID", vm.ID)
+	deviceID, err := ns.connector.AttachVolume(ctx, vol.ID, vm.ID)
+	if err != nil {
+		if errors.Is(err, cloud.ErrMaxVolumesReached) {
+			return nil, status.Errorf(codes.ResourceExhausted, "Cannot attach ephemeral volume %s to node %s: %v", volumeID, ns.nodeName, err)
+		}
+
+		return nil, status.Errorf(cloudErrorCode(err), "Cannot attach ephemeral volume %s: %v", volumeID, err)
+	}
+	vol.VirtualMachineID = vm.ID
+	vol.DeviceID = deviceID
+
+	return vol, nil
+}
+
+// createEphemeralVolume creates the CloudStack volume of an ephemeral
+// inline volume, in the zone of the node.
+func (ns *nodeServer) createEphemeralVolume(ctx context.Context, volumeID, zoneID string, volumeContext map[string]string) (*cloud.Volume, error) {
+	logger := klog.FromContext(ctx)
+
+	diskOfferingID, err := resolveDiskOffering(ctx, ns.connector, volumeContext, "")
+	if err != nil {
+		return nil, err
+	}
+	offering, err := ns.connector.GetDiskOfferingByID(ctx, diskOfferingID)
+	if err != nil {
+		if errors.Is(err, cloud.ErrNotFound) {
+			return nil, status.Errorf(codes.InvalidArgument, "Disk offering %s not found", diskOfferingID)
+		}
+
+		return nil, status.Errorf(cloudErrorCode(err), "Cannot get disk offering %s: %v", diskOfferingID, err)
+	}
+
+	var sizeInGB int64
+	if offering.Customized {
+		sizeInGB = defaultEphemeralSizeInGB
+		if size := volumeContext[EphemeralSizeKey]; size != "" {
+			quantity, err := resource.ParseQuantity(size)
+			if err != nil || quantity.Sign() <= 0 {
+				return nil, status.Errorf(codes.InvalidArgument, "Invalid %v %q", EphemeralSizeKey, size)
+			}
+			sizeInGB = util.RoundUpBytesToGB(quantity.Value())
+		}
+	}
+
+	logger.Info("Creating ephemeral volume", "volumeID", volumeID, "diskOfferingID", diskOfferingID, "zoneID", zoneID, "size", sizeInGB)
+	id, err := ns.connector.CreateVolume(ctx, diskOfferingID, zoneID, volumeID, sizeInGB)
+	if err != nil {
+		return nil, status.Errorf(cloudErrorCode(err), "Cannot create ephemeral volume %s: %v", volumeID, err)
+	}
+	vol, err := ns.connector.GetVolumeByID(ctx, id)
+	if err != nil {
+		return nil, status.Errorf(cloudErrorCode(err), "Cannot get volume %s: %v", id, err)
+	}
+
+	return vol, nil
+}
+
+// deleteEphemeralVolume detaches and deletes the CloudStack volume of an
+// ephemeral inline volume, once unmounted.
+func (ns *nodeServer) deleteEphemeralVolume(ctx context.Context, volumeID string) error {
+	logger := klog.FromContext(ctx)
+
+	vol, err := ns.connector.GetVolumeByName(ctx, volumeID)
+	switch {
+	case errors.Is(err, cloud.ErrNotFound):
+		return nil
+	case err != nil:
+		return status.Errorf(cloudErrorCode(err), "Cannot get volume %s: %v", volumeID, err)
+	}
+
+	if vol.VirtualMachineID != "" {
+		logger.V(4).Info("NodeUnpublishVolume: detaching ephemeral volume", "volumeID", volumeID, "cloudstackVolumeID", vol.ID, "vmID", vol.VirtualMachineID)
+		if err := ns.connector.DetachVolume(ctx, vol.ID); err != nil && !errors.Is(err, cloud.ErrNotFound) {
+			return status.Errorf(cloudErrorCode(err), "Cannot detach ephemeral volume %s: %v", volumeID, err)
+		}
+	}
+
+	logger.Info("Deleting ephemeral volume", "volumeID", volumeID, "cloudstackVolumeID", vol.ID)
+	if err := ns.connector.DeleteVolume(ctx, vol.ID); err != nil && !errors.Is(err, cloud.ErrNotFound) {
+		return status.Errorf(cloudErrorCode(err), "Cannot delete ephemeral volume %s: %v", volumeID, err)
+	}
+
+	return nil
+}
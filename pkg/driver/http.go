@@ -0,0 +1,98 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+const metricsNamespace = "cloudstack_csi"
+
+// newRegistry returns the registry of the metrics served on /metrics,
+// with the metrics of the Go runtime and of the process.
+func newRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return reg
+}
+
+// instrumentGRPC returns a unary server interceptor recording the duration
+// of the CSI calls, by method and result code, in reg.
+func instrumentGRPC(reg prometheus.Registerer) grpc.UnaryServerInterceptor {
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of the CSI calls, in seconds.",
+		// Creating, attaching or formatting a volume takes minutes.
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 15, 30, 60, 120, 300},
+	}, []string{"method", "code"})
+	reg.MustRegister(duration)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		duration.WithLabelValues(info.FullMethod, status.Code(err).String()).Observe(time.Since(start).Seconds())
+
+		return resp, err
+	}
+}
+
+// httpHandler serves /healthz, reporting the result of the Probe call,
+// and the metrics on /metrics.
+func (cs *cloudstackDriver) httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		resp, err := cs.Probe(r.Context(), &csi.ProbeRequest{})
+		if err != nil || !resp.GetReady().GetValue() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.Handle("/metrics", promhttp.HandlerFor(cs.registry, promhttp.HandlerOpts{}))
+
+	return mux
+}
+
+// serveHTTP starts the HTTP server on the configured address. It returns
+// the server, nil if disabled, and a channel receiving the error ending it.
+func (cs *cloudstackDriver) serveHTTP(logger klog.Logger) (*http.Server, <-chan error, error) {
+	if cs.options.HTTPEndpoint == "" {
+		return nil, nil, nil
+	}
+
+	listener, err := net.Listen("tcp", cs.options.HTTPEndpoint)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to listen for HTTP: %w", err)
+	}
+	server := &http.Server{
+		Handler:           cs.httpHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	logger.Info("Serving health checks and metrics", "address", listener.Addr())
+
+	served := make(chan error, 1)
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			served <- err
+		}
+	}()
+
+	return server, served, nil
+}
@@ -0,0 +1,125 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud/fake"
+)
+
+func httpGet(t *testing.T, url string) (int, string) {
+	t.Helper()
+
+	resp, err := http.Get(url) //nolint:noctx
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return resp.StatusCode, string(body)
+}
+
+func TestHTTPHealthz(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"ready", nil, http.StatusOK},
+		{"not ready", errors.New("connection refused"), http.StatusServiceUnavailable},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			connector := &pingConnector{Interface: fake.New(), err: c.err}
+			d := &cloudstackDriver{
+				prober:   newAPIProber(connector, &Options{ProbeFailureThreshold: 1}),
+				registry: newRegistry(),
+			}
+			server := httptest.NewServer(d.httpHandler())
+			defer server.Close()
+
+			if code, body := httpGet(t, server.URL+"/healthz"); code != c.expected {
+				t.Errorf("expected status %d, got %d: %s", c.expected, code, body)
+			}
+		})
+	}
+}
+
+func TestHTTPServer(t *testing.T) {
+	// Find a free port for the HTTP server.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	httpEndpoint := l.Addr().String()
+	l.Close()
+
+	endpoint := "unix://" + filepath.Join(t.TempDir(), "csi.sock")
+	d, err := New(context.Background(), fake.New(), &Options{
+		Mode:               ControllerMode,
+		Endpoint:           endpoint,
+		HTTPEndpoint:       httpEndpoint,
+		GRPCMaxRecvMsgSize: DefaultGRPCMaxMsgSize,
+		ShutdownTimeout:    time.Second,
+		ProbeTimeout:       time.Second,
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- d.Run(ctx)
+	}()
+
+	// Make a CSI call, recorded in the metrics.
+	conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	if _, err := csi.NewIdentityClient(conn).GetPluginInfo(ctx, &csi.GetPluginInfoRequest{}, grpc.WaitForReady(true)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if code, body := httpGet(t, "http://"+httpEndpoint+"/healthz"); code != http.StatusOK {
+		t.Errorf("expected status 200 on /healthz, got %d: %s", code, body)
+	}
+	code, body := httpGet(t, "http://"+httpEndpoint+"/metrics")
+	if code != http.StatusOK {
+		t.Errorf("expected status 200 on /metrics, got %d", code)
+	}
+	for _, metric := range []string{
+		`cloudstack_csi_operation_duration_seconds_count{code="OK",method="/csi.v1.Identity/GetPluginInfo"} 1`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, metric) {
+			t.Errorf("expected metric %s in:\n%s", metric, body)
+		}
+	}
+
+	cancel()
+	if err := <-stopped; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := http.Get("http://" + httpEndpoint + "/healthz"); err == nil { //nolint:noctx
+		t.Error("expected the HTTP server to be shut down")
+	}
+}
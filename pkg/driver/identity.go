@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 	"k8s.io/klog/v2"
 )
 
@@ -12,7 +13,7 @@ func (cs *cloudstackDriver) GetPluginInfo(ctx context.Context, req *csi.GetPlugi
 	logger.V(6).Info("GetPluginInfo: called", "args", *req)
 	resp := &csi.GetPluginInfoResponse{
 		Name:          DriverName,
-		VendorVersion: driverVersion,
+		VendorVersion: vendorVersion(),
 	}
 
 	return resp, nil
@@ -22,7 +23,15 @@ func (cs *cloudstackDriver) Probe(ctx context.Context, req *csi.ProbeRequest) (*
 	logger := klog.FromContext(ctx)
 	logger.V(6).Info("Probe: called", "args", *req)
 
-	return &csi.ProbeResponse{}, nil
+	if cs.prober != nil {
+		if err := cs.prober.check(ctx); err != nil {
+			logger.Error(err, "Driver not ready")
+
+			return &csi.ProbeResponse{Ready: wrapperspb.Bool(false)}, nil
+		}
+	}
+
+	return &csi.ProbeResponse{Ready: wrapperspb.Bool(true)}, nil
 }
 
 func (cs *cloudstackDriver) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
@@ -45,6 +54,13 @@ func (cs *cloudstackDriver) GetPluginCapabilities(ctx context.Context, req *csi.
 					},
 				},
 			},
+			{
+				Type: &csi.PluginCapability_VolumeExpansion_{
+					VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
+						Type: csi.PluginCapability_VolumeExpansion_ONLINE,
+					},
+				},
+			},
 		},
 	}
 
@@ -0,0 +1,68 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// DefaultGRPCLogVerbosity is the verbosity of the logs of the CSI calls.
+const DefaultGRPCLogVerbosity = 4
+
+// logGRPC returns a unary server interceptor logging every CSI call, with
+// its volume, duration and result, at the given verbosity. Failed calls
+// are always logged. Secrets are removed from the logged requests. A
+// panicking call fails with an Internal error instead of crashing the
+// driver.
+func logGRPC(logger klog.Logger, verbosity int) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		keysAndValues := append([]interface{}{"method", info.FullMethod}, requestKeysAndValues(req)...)
+		logger.V(verbosity).Info("GRPC call", append(keysAndValues, "request", protosanitizer.StripSecrets(req))...)
+		start := time.Now()
+
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error(fmt.Errorf("%v", r), "GRPC method panicked", append(keysAndValues, "stack", string(debug.Stack()))...)
+				resp, err = nil, status.Errorf(codes.Internal, "Unexpected error in %s: %v", info.FullMethod, r)
+			}
+			keysAndValues = append(keysAndValues, "duration", time.Since(start), "code", status.Code(err))
+			if err != nil {
+				logger.Error(err, "GRPC method failed", keysAndValues...)
+
+				return
+			}
+			logger.V(verbosity).Info("GRPC call completed", keysAndValues...)
+		}()
+
+		return handler(klog.NewContext(ctx, logger), req)
+	}
+}
+
+// requestKeysAndValues returns the IDs of the volume or snapshot of the
+// request, to be logged.
+func requestKeysAndValues(req interface{}) []interface{} {
+	var keysAndValues []interface{}
+	switch r := req.(type) {
+	case *csi.CreateVolumeRequest:
+		keysAndValues = append(keysAndValues, "volumeName", r.GetName())
+	case *csi.CreateSnapshotRequest:
+		keysAndValues = append(keysAndValues, "volumeID", r.GetSourceVolumeId(), "snapshotName", r.GetName())
+	case interface{ GetVolumeId() string }:
+		if id := r.GetVolumeId(); id != "" {
+			keysAndValues = append(keysAndValues, "volumeID", id)
+		}
+	}
+	if r, ok := req.(interface{ GetSnapshotId() string }); ok && r.GetSnapshotId() != "" {
+		keysAndValues = append(keysAndValues, "snapshotID", r.GetSnapshotId())
+	}
+
+	return keysAndValues
+}
@@ -0,0 +1,144 @@
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"k8s.io/klog/v2/ktesting"
+)
+
+func newBufferLogger(verbosity int) (klog.Logger, ktesting.Buffer) {
+	logger := ktesting.NewLogger(ktesting.NopTL{}, ktesting.NewConfig(ktesting.Verbosity(verbosity), ktesting.BufferLogs(true)))
+
+	return logger, logger.GetSink().(ktesting.Underlier).GetBuffer()
+}
+
+// logValue returns the value logged for key, or nil.
+func logValue(entry ktesting.LogEntry, key string) interface{} {
+	for i := 0; i+1 < len(entry.ParameterKVList); i += 2 {
+		if entry.ParameterKVList[i] == key {
+			return entry.ParameterKVList[i+1]
+		}
+	}
+
+	return nil
+}
+
+func TestLogGRPC(t *testing.T) {
+	const method = "/csi.v1.Controller/ControllerPublishVolume"
+	info := &grpc.UnaryServerInfo{FullMethod: method}
+	req := &csi.ControllerPublishVolumeRequest{
+		VolumeId: "ace9f28b-3081-40c1-8353-4cc3e3014072",
+		NodeId:   "0d7107a3-94d2-44e7-89b8-8930881309a5",
+		Secrets:  map[string]string{"password": "s3cr3t"},
+	}
+
+	tests := []struct {
+		name         string
+		verbosity    int
+		handler      grpc.UnaryHandler
+		expectedCode codes.Code
+		expected     []string
+	}{
+		{
+			name:      "success",
+			verbosity: DefaultGRPCLogVerbosity,
+			handler: func(context.Context, interface{}) (interface{}, error) {
+				return &csi.ControllerPublishVolumeResponse{}, nil
+			},
+			expectedCode: codes.OK,
+			expected:     []string{"GRPC call", "GRPC call completed"},
+		},
+		{
+			name:      "success not logged",
+			verbosity: DefaultGRPCLogVerbosity - 1,
+			handler: func(context.Context, interface{}) (interface{}, error) {
+				return &csi.ControllerPublishVolumeResponse{}, nil
+			},
+			expectedCode: codes.OK,
+		},
+		{
+			name:      "error",
+			verbosity: 0,
+			handler: func(context.Context, interface{}) (interface{}, error) {
+				return nil, status.Error(codes.NotFound, "volume not found")
+			},
+			expectedCode: codes.NotFound,
+			expected:     []string{"GRPC method failed"},
+		},
+		{
+			name:         "panic",
+			verbosity:    0,
+			handler:      func(context.Context, interface{}) (interface{}, error) { panic("boom") },
+			expectedCode: codes.Internal,
+			expected:     []string{"GRPC method panicked", "GRPC method failed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, buffer := newBufferLogger(tt.verbosity)
+			_, err := logGRPC(logger, DefaultGRPCLogVerbosity)(context.Background(), req, info, tt.handler)
+			if code := status.Code(err); code != tt.expectedCode {
+				t.Fatalf("expected code %v, got %v (%v)", tt.expectedCode, code, err)
+			}
+
+			entries := buffer.Data()
+			if len(entries) != len(tt.expected) {
+				t.Fatalf("expected %d log entries, got %d:\n%s", len(tt.expected), len(entries), buffer.String())
+			}
+			for i, entry := range entries {
+				if entry.Message != tt.expected[i] {
+					t.Errorf("expected message %q, got %q", tt.expected[i], entry.Message)
+				}
+				if v := logValue(entry, "method"); v != method {
+					t.Errorf("expected method %q, got %v", method, v)
+				}
+				if v := logValue(entry, "volumeID"); v != req.GetVolumeId() {
+					t.Errorf("expected volume ID %q, got %v", req.GetVolumeId(), v)
+				}
+			}
+			if len(entries) > 0 {
+				if v := logValue(entries[len(entries)-1], "code"); v != tt.expectedCode {
+					t.Errorf("expected logged code %v, got %v", tt.expectedCode, v)
+				}
+			}
+			if strings.Contains(buffer.String(), "s3cr3t") {
+				t.Errorf("secret logged:\n%s", buffer.String())
+			}
+		})
+	}
+}
+
+func TestRequestKeysAndValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      interface{}
+		expected []interface{}
+	}{
+		{"create volume", &csi.CreateVolumeRequest{Name: "pvc-1"}, []interface{}{"volumeName", "pvc-1"}},
+		{"volume", &csi.NodeStageVolumeRequest{VolumeId: "vol-1"}, []interface{}{"volumeID", "vol-1"}},
+		{"create snapshot", &csi.CreateSnapshotRequest{SourceVolumeId: "vol-1", Name: "snap"}, []interface{}{"volumeID", "vol-1", "snapshotName", "snap"}},
+		{"snapshot", &csi.DeleteSnapshotRequest{SnapshotId: "snap-1"}, []interface{}{"snapshotID", "snap-1"}},
+		{"other", &csi.NodeGetInfoRequest{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := requestKeysAndValues(tt.req)
+			if len(got) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, got)
+			}
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Errorf("expected %v, got %v", tt.expected, got)
+				}
+			}
+		})
+	}
+}
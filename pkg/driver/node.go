@@ -5,12 +5,20 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/klog/v2"
 
 	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud"
@@ -24,10 +32,11 @@ const (
 )
 
 var ValidFSTypes = map[string]struct{}{
-	FSTypeExt2: {},
-	FSTypeExt3: {},
-	FSTypeExt4: {},
-	FSTypeXfs:  {},
+	FSTypeExt2:  {},
+	FSTypeExt3:  {},
+	FSTypeExt4:  {},
+	FSTypeXfs:   {},
+	FSTypeBtrfs: {},
 }
 
 type nodeServer struct {
@@ -36,27 +45,132 @@ type nodeServer struct {
 	mounter           mount.Interface
 	maxVolumesPerNode int64
 	nodeName          string
+	defaultFsType     string
+	topologySegments  []string
 	volumeLocks       *util.VolumeLocks
+
+	// deviceWaitTimeout bounds the wait for the device of a volume, or 0.
+	deviceWaitTimeout time.Duration
+
+	// reconcileVolumeSize grows the filesystems smaller than their device.
+	reconcileVolumeSize bool
+
+	// hypervisor is the configured hypervisor of the node. When empty,
+	// the one of the node VM is given to the mounter by NodeGetInfo.
+	hypervisor string
+
+	// zoneID is the zone of the node, once known from NodeGetInfo.
+	zoneID atomic.Pointer[string]
+
+	// kubeClient is used to read the annotations of the node. It is nil
+	// when the driver does not run in a Kubernetes cluster.
+	kubeClient kubernetes.Interface
 }
 
 // NewNodeServer creates a new Node gRPC server.
 func NewNodeServer(connector cloud.Interface, mounter mount.Interface, options *Options) csi.NodeServer {
 	if mounter == nil {
-		mounter = mount.New()
+		mounter = newMounter(options, nil)
+	}
+
+	fsType := options.DefaultFSType
+	if fsType == "" {
+		fsType = defaultFsType
+	}
+
+	var kubeClient kubernetes.Interface
+	if config, err := rest.InClusterConfig(); err == nil {
+		kubeClient, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			klog.ErrorS(err, "Failed to create Kubernetes client, node annotations are ignored")
+		}
 	}
 
 	return &nodeServer{
-		connector:         connector,
-		mounter:           mounter,
-		maxVolumesPerNode: options.VolumeAttachLimit,
-		nodeName:          options.NodeName,
-		volumeLocks:       util.NewVolumeLocks(),
+		connector:           connector,
+		mounter:             mounter,
+		maxVolumesPerNode:   maxVolumesPerNode(options.VolumeAttachLimit, options.ReservedVolumeAttachments),
+		nodeName:            options.NodeName,
+		defaultFsType:       fsType,
+		topologySegments:    options.TopologySegments,
+		volumeLocks:         util.NewVolumeLocks(),
+		deviceWaitTimeout:   options.DeviceWaitTimeout,
+		reconcileVolumeSize: options.ReconcileVolumeSize,
+		hypervisor:          options.Hypervisor,
+		kubeClient:          kubeClient,
+	}
+}
+
+// newMounter creates the mounter configured by the node options, its
+// metrics registered in reg if not nil.
+func newMounter(options *Options, reg prometheus.Registerer) mount.Interface {
+	return mount.New(mount.Options{
+		DiskIDPath:     options.DiskIDPath,
+		DiskIDPrefixes: options.DiskIDPrefixes,
+		DevicePathBackoff: wait.Backoff{
+			Duration: options.DevicePathBackoffDuration,
+			Factor:   options.DevicePathBackoffFactor,
+			Steps:    options.DevicePathBackoffSteps,
+		},
+		Multipath:            options.EnableMultipath,
+		RefuseFormatMismatch: options.RefuseFormatMismatch,
+		VerifyDevice:         options.VerifyDeviceAfterFormat,
+		CacheDevicePaths:     options.CacheDevicePaths,
+		UdevadmPath:          options.UdevadmPath,
+		DeviceSettleDelay:    options.DeviceSettleDelay,
+		ProcPath:             options.ProcPath,
+		SysPath:              options.SysPath,
+		DisableUdevadm:       options.DisableUdevadm,
+		DisableSCSIRescan:    options.DisableSCSIRescan,
+		DryRun:               options.DryRun,
+		Hypervisor:           options.Hypervisor,
+		MetricsRegisterer:    reg,
+	})
+}
+
+// maxVolumesPerNode returns the maximum number of volumes attachable to a
+// node: the configured limit if set, otherwise the number of disk slots
+// not reserved.
+func maxVolumesPerNode(limit, reserved int64) int64 {
+	if limit > 0 {
+		return limit
+	}
+
+	return max(DefaultVolumeAttachSlots-reserved, 1)
+}
+
+// nodeVolumeAttachLimit returns the maximum number of volumes attachable
+// to the node, which may be overridden by the VolumeAttachLimitAnnotation
+// annotation of the node.
+func (ns *nodeServer) nodeVolumeAttachLimit(ctx context.Context) int64 {
+	logger := klog.FromContext(ctx)
+	if ns.kubeClient == nil {
+		return ns.maxVolumesPerNode
+	}
+
+	node, err := ns.kubeClient.CoreV1().Nodes().Get(ctx, ns.nodeName, metav1.GetOptions{})
+	if err != nil {
+		logger.Error(err, "Failed to get node, ignoring its annotations", "node", ns.nodeName)
+
+		return ns.maxVolumesPerNode
+	}
+	value, ok := node.GetAnnotations()[VolumeAttachLimitAnnotation]
+	if !ok {
+		return ns.maxVolumesPerNode
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 1 || limit > DefaultMaxVolAttachLimit {
+		logger.Info("Ignoring invalid node annotation", "annotation", VolumeAttachLimitAnnotation, "value", value)
+
+		return ns.maxVolumesPerNode
 	}
+
+	return limit
 }
 
 func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
 	logger := klog.FromContext(ctx)
-	logger.V(6).Info("NodeStageVolume: called", "args", *req)
+	logger.V(6).Info("NodeStageVolume: called", "args", protosanitizer.StripSecrets(*req))
 
 	// Check parameters
 
@@ -90,7 +204,8 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 
 	fsType := mnt.GetFsType()
 	if fsType == "" {
-		fsType = defaultFsType
+		fsType = ns.defaultFsType
+		logger.V(4).Info("NodeStageVolume: fstype not set, using the default", "volumeID", volumeID, "fstype", fsType)
 	}
 
 	_, ok := ValidFSTypes[strings.ToLower(fsType)]
@@ -105,6 +220,20 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 		}
 	}
 
+	mountOptions, err := mount.WithSELinuxLabel(mountOptions, req.GetVolumeContext()[SELinuxLabelKey])
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	mountOptions, err = mount.ValidateMountOptions(mountOptions)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	formatOptions, err := volumeFormatOptions(fsType, req.GetVolumeContext())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	if acquired := ns.volumeLocks.TryAcquire(volumeID); !acquired {
 		logger.Error(errors.New(util.ErrVolumeOperationAlreadyExistsVolumeID), "failed to acquire volume lock", "volumeID", volumeID)
 
@@ -112,17 +241,42 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 	}
 	defer ns.volumeLocks.Release(volumeID)
 
-	// Now, find the device path
-	source, err := ns.mounter.GetDevicePath(ctx, volumeID)
+	// A volume of another zone cannot be attached to the node: do not
+	// wait for its device.
+	if zoneID, nodeZoneID := req.GetVolumeContext()[ZoneIDKey], ns.zoneID.Load(); zoneID != "" && nodeZoneID != nil && zoneID != *nodeZoneID {
+		return nil, status.Errorf(codes.FailedPrecondition, "Volume %s is in zone %s, node is in zone %s", volumeID, zoneID, *nodeZoneID)
+	}
+
+	// Now, find the device path, first from the device ID the volume
+	// was attached with.
+	source, err := ns.findDevicePath(ctx, volumeID, req.GetPublishContext()[deviceIDContextKey], ns.volumeHypervisor(ctx, volumeID, req.GetVolumeContext()[HypervisorKey]))
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Cannot find device path for volume %s: %s", volumeID, err.Error())
+		return nil, err
 	}
 
 	logger.V(4).Info("NodeStageVolume: device found",
 		"source", source,
+		"zoneID", req.GetVolumeContext()[ZoneIDKey],
+		"diskOfferingID", req.GetVolumeContext()[DiskOfferingKey],
 	)
 
+	// Encrypted volumes are mounted through their device-mapper device.
+	passphrase := req.GetSecrets()[EncryptionPassphraseKey]
+	stagedDevice := source
+	if passphrase != "" {
+		stagedDevice = ns.mounter.EncryptedDevicePath(volumeID)
+	}
+
 	exists, err := ns.mounter.PathExists(target)
+	if err != nil && ns.mounter.IsCorruptedMnt(err) {
+		// The target is still mounted, but its device is gone or broken:
+		// remove the mount and stage the volume again.
+		logger.Info("NodeStageVolume: target is a corrupted mount, unmounting it", "target", target, "err", err)
+		if err = ns.mounter.Unstage(target); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to unmount corrupted target %q: %v", target, err)
+		}
+		exists = false
+	}
 	if err != nil {
 		msg := fmt.Sprintf("failed to check if target %q exists: %v", target, err)
 
@@ -150,28 +304,42 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 	// If the volume corresponding to the volume_id is already staged to the staging_target_path,
 	// and is identical to the specified volume_capability the Plugin MUST reply 0 OK.
 	logger.V(4).Info("NodeStageVolume: checking if volume is already staged", "device", device, "source", source, "target", target)
-	if device == source {
+	if device == stagedDevice {
 		logger.V(4).Info("NodeStageVolume: volume already staged", "volumeID", volumeID)
+		if ns.reconcileVolumeSize {
+			if err := ns.reconcileSize(ctx, volumeID, target, passphrase); err != nil {
+				return nil, status.Errorf(codes.Internal, "could not reconcile the size of volume %q: %v", volumeID, err)
+			}
+		}
 
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
-	logger.V(4).Info("NodeStageVolume: staging volume", "source", source, "volumeID", volumeID, "target", target, "fstype", fsType, "options", mountOptions)
-	err = ns.mounter.FormatAndMount(source, target, fsType, mountOptions)
+	// Refuse to mount someone else's data if the wrong device is attached.
+	if err = ns.mounter.CheckDiskUUID(source, req.GetVolumeContext()[FilesystemUUIDKey]); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "could not stage volume %s: %v", volumeID, err)
+	}
+
+	logger.V(4).Info("NodeStageVolume: staging volume", "source", source, "volumeID", volumeID, "target", target, "fstype", fsType, "options", mountOptions, "formatOptions", formatOptions, "encrypted", passphrase != "")
+	if passphrase != "" {
+		err = ns.mounter.FormatAndMountWithEncryption(volumeID, source, target, fsType, mountOptions, formatOptions, passphrase)
+	} else {
+		err = ns.mounter.FormatAndMountWithFormatOptions(source, target, fsType, mountOptions, formatOptions)
+	}
 	if err != nil {
 		msg := fmt.Sprintf("could not format %q and mount it at %q: %v", source, target, err)
 
 		return nil, status.Error(codes.Internal, msg)
 	}
 
-	needResize, err := ns.mounter.NeedResize(source, target)
+	needResize, err := ns.mounter.NeedResize(stagedDevice, target)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Could not determine if volume %q (%q) needs to be resized:  %v", volumeID, source, err)
 	}
 
 	if needResize {
 		logger.V(2).Info("NodeStageVolume: volume needs resizing", "source", source)
-		if _, err := ns.mounter.Resize(source, target); err != nil {
+		if _, err := ns.mounter.Resize(stagedDevice, target); err != nil {
 			return nil, status.Errorf(codes.Internal, "could not resize volume %q (%q):  %v", volumeID, source, err)
 		}
 	}
@@ -180,6 +348,22 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
+// volumeFormatOptions returns the mkfs options of a volume: the ones of
+// MkfsOptionsKey, with the lazy initialization of ext4 set from
+// Ext4LazyInitKey.
+func volumeFormatOptions(fsType string, volumeContext map[string]string) ([]string, error) {
+	lazy := true
+	switch v := volumeContext[Ext4LazyInitKey]; v {
+	case "", "true":
+	case "false":
+		lazy = false
+	default:
+		return nil, fmt.Errorf("invalid %s %q: must be true or false", Ext4LazyInitKey, v)
+	}
+
+	return mount.WithExt4LazyInit(fsType, strings.Fields(volumeContext[MkfsOptionsKey]), lazy), nil
+}
+
 // hasMountOption returns a boolean indicating whether the given
 // slice already contains a mount option. This is used to prevent
 // passing duplicate option to the mount command.
@@ -215,6 +399,20 @@ func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 	}
 	defer ns.volumeLocks.Release(volumeID)
 
+	// From the spec: If the volume corresponding to the volume_id
+	// is not staged to the staging_target_path, the Plugin MUST
+	// reply 0 OK. After a crash, the target may be gone, or left
+	// unmounted, and the LUKS device of the volume left open.
+	exists, err := ns.mounter.PathExists(target)
+	if err != nil && !ns.mounter.IsCorruptedMnt(err) {
+		return nil, status.Errorf(codes.Internal, "failed to check if target %q exists: %v", target, err)
+	}
+	if !exists {
+		logger.V(4).Info("NodeUnstageVolume: target not found", "target", target)
+
+		return ns.closeEncryptedVolume(volumeID)
+	}
+
 	// Check if target directory is a mount point. GetDeviceNameFromMount
 	// given a mnt point, finds the device from /proc/mounts
 	// returns the device name, reference count, and error code
@@ -225,13 +423,15 @@ func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 		return nil, status.Error(codes.Internal, msg)
 	}
 
-	// From the spec: If the volume corresponding to the volume_id
-	// is not staged to the staging_target_path, the Plugin MUST
-	// reply 0 OK.
 	if refCount == 0 {
-		logger.V(4).Info("NodeUnstageVolume: target not mounted", "target", target)
+		logger.V(4).Info("NodeUnstageVolume: target not mounted, removing it", "target", target)
+		// Nothing is staged: a target that cannot be removed, e.g. not
+		// empty, is left to the kubelet.
+		if err := ns.mounter.Unstage(target); err != nil {
+			logger.Error(err, "NodeUnstageVolume: failed to remove target", "target", target)
+		}
 
-		return &csi.NodeUnstageVolumeResponse{}, nil
+		return ns.closeEncryptedVolume(volumeID)
 	}
 
 	if refCount > 1 {
@@ -253,6 +453,16 @@ func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
+// closeEncryptedVolume closes the LUKS device left open for a volume which
+// is not staged.
+func (ns *nodeServer) closeEncryptedVolume(volumeID string) (*csi.NodeUnstageVolumeResponse, error) {
+	if err := ns.mounter.CloseEncryptedVolume(volumeID); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to close the LUKS device of volume %s: %v", volumeID, err)
+	}
+
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
 func (ns *nodeServer) isMounted(ctx context.Context, target string) (bool, error) {
 	logger := klog.FromContext(ctx)
 
@@ -280,6 +490,68 @@ func (ns *nodeServer) isMounted(ctx context.Context, target string) (bool, error
 	return !notMnt, nil
 }
 
+// findDevicePath returns the device of an attached volume, from the device
+// ID it was attached with if known, and with the disk serials of its
+// hypervisor if not empty, waiting at most ns.deviceWaitTimeout for it to
+// appear.
+func (ns *nodeServer) findDevicePath(ctx context.Context, volumeID, deviceID, hypervisor string) (string, error) {
+	if ns.deviceWaitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ns.deviceWaitTimeout)
+		defer cancel()
+	}
+	source, err := ns.mounter.GetDevicePathByDeviceID(ctx, volumeID, deviceID, hypervisor)
+	if err != nil {
+		return "", devicePathError(volumeID, err)
+	}
+
+	return source, nil
+}
+
+// volumeHypervisor returns hypervisor, the one of the volume, whose disk
+// serials are those of its device, or an empty string to use the ones of
+// the node: when the hypervisor is configured, not known or not supported.
+func (ns *nodeServer) volumeHypervisor(ctx context.Context, volumeID, hypervisor string) string {
+	if hypervisor == "" || ns.hypervisor != "" {
+		return ""
+	}
+	if _, ok := mount.SerialFuncs[strings.ToLower(hypervisor)]; !ok {
+		klog.FromContext(ctx).Info("Cannot use the hypervisor of the volume to find its device, using the disk serials of the node", "volumeID", volumeID, "hypervisor", hypervisor)
+
+		return ""
+	}
+
+	return hypervisor
+}
+
+// attachedDeviceID returns the device ID vol is attached with, or an
+// empty string if not attached.
+func attachedDeviceID(vol *cloud.Volume) string {
+	if vol.VirtualMachineID == "" {
+		return ""
+	}
+
+	return vol.DeviceID
+}
+
+// devicePathError converts an error returned by GetDevicePath to a gRPC
+// error: volumes whose device did not show up, e.g. not attached yet, are
+// reported as NotFound, or DeadlineExceeded if the wait was cut short,
+// and failed lookups as Internal. The message tells the reason.
+func devicePathError(volumeID string, err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, mount.ErrDeviceNotFound):
+		code = codes.NotFound
+	}
+
+	return status.Errorf(code, "Cannot find device path for volume %s: %v", volumeID, err)
+}
+
 func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) { //nolint:gocyclo,gocognit
 	logger := klog.FromContext(ctx)
 	logger.V(6).Info("NodePublishVolume: called", "args", *req)
@@ -290,6 +562,17 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
 	}
 
+	if isEphemeral(req.GetVolumeContext()) {
+		if req.GetTargetPath() == "" {
+			return nil, status.Error(codes.InvalidArgument, "Target path missing in request")
+		}
+		if req.GetVolumeCapability() == nil {
+			return nil, status.Error(codes.InvalidArgument, "Volume capability missing in request")
+		}
+
+		return ns.publishEphemeralVolume(ctx, req)
+	}
+
 	source := req.GetStagingTargetPath()
 	if source == "" {
 		return nil, status.Error(codes.InvalidArgument, "Staging target path missing in request")
@@ -309,8 +592,11 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		return nil, status.Error(codes.InvalidArgument, "Volume capability not supported")
 	}
 
+	// CloudStack cannot attach volumes read-only: volumes published
+	// read-only by the controller are bind-mounted read-only, while the
+	// staging mount stays writable so that new volumes can be formatted.
 	mountOptions := []string{"bind"}
-	if req.GetReadonly() {
+	if req.GetReadonly() || req.GetPublishContext()[readonlyContextKey] == "true" {
 		mountOptions = append(mountOptions, "ro")
 	}
 
@@ -353,7 +639,7 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 
 		fsType := mnt.GetFsType()
 		if fsType == "" {
-			fsType = defaultFsType
+			fsType = ns.defaultFsType
 		}
 
 		_, ok := ValidFSTypes[strings.ToLower(fsType)]
@@ -373,38 +659,14 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 			return nil, status.Errorf(codes.Internal, "failed to mount %q at %q: %v", source, target, err)
 		}
 	case *csi.VolumeCapability_Block:
-		source, err := ns.mounter.GetDevicePath(ctx, volumeID)
+		source, err := ns.findDevicePath(ctx, volumeID, req.GetPublishContext()[deviceIDContextKey], ns.volumeHypervisor(ctx, volumeID, req.GetVolumeContext()[HypervisorKey]))
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, "Cannot find device path for volume %s: %v", volumeID, err)
-		}
-
-		globalMountPath := filepath.Dir(target)
-		exists, err := ns.mounter.PathExists(globalMountPath)
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "Could not check if path exists %q: %v", globalMountPath, err)
-		}
-		if !exists {
-			if err = ns.mounter.MakeDir(globalMountPath); err != nil {
-				return nil, status.Errorf(codes.Internal, "Could not create dir %q: %v", globalMountPath, err)
-			}
+			return nil, err
 		}
 
 		mounted, err := ns.isMounted(ctx, target)
-		if err != nil { //nolint:nestif
-			if os.IsNotExist(err) {
-				// Create the mount point as a file since bind mount device node requires it to be a file
-				logger.V(4).Info("NodePublishVolume: making target file", "target", target)
-				err = ns.mounter.MakeFile(target)
-				if err != nil {
-					if removeErr := os.Remove(target); removeErr != nil {
-						return nil, status.Errorf(codes.Internal, "Could not remove mount target %q: %v", target, removeErr)
-					}
-
-					return nil, status.Errorf(codes.Internal, "Could not create file %q: %v", target, err)
-				}
-			} else {
-				return nil, status.Errorf(codes.Internal, "Could not check if %q is mounted: %v", target, err)
-			}
+		if err != nil && !os.IsNotExist(err) {
+			return nil, status.Errorf(codes.Internal, "Could not check if %q is mounted: %v", target, err)
 		}
 
 		if mounted {
@@ -422,11 +684,7 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 			"volumeID", volumeID,
 		)
 
-		if err := ns.mounter.Mount(source, target, "", mountOptions); err != nil {
-			if removeErr := os.Remove(target); removeErr != nil {
-				return nil, status.Errorf(codes.Internal, "Could not remove mount target %q: %v", target, removeErr)
-			}
-
+		if err := ns.mounter.BindBlockDevice(source, target, mountOptions); err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to mount %q at %q: %v", source, target, err)
 		}
 	}
@@ -460,6 +718,18 @@ func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		return nil, status.Errorf(codes.Internal, "failed to unmount target %q: %v", target, err)
 	}
 
+	// The CloudStack volumes of the ephemeral inline volumes are deleted
+	// with them.
+	if strings.HasPrefix(volumeID, ephemeralVolumeIDPrefix) {
+		if acquired := ns.volumeLocks.TryAcquire(volumeID); !acquired {
+			return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volumeID)
+		}
+		defer ns.volumeLocks.Release(volumeID)
+		if err := ns.deleteEphemeralVolume(ctx, volumeID); err != nil {
+			return nil, err
+		}
+	}
+
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
@@ -467,10 +737,6 @@ func (ns *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoReque
 	logger := klog.FromContext(ctx)
 	logger.V(6).Info("NodeGetInfo: called", "args", *req)
 
-	if ns.nodeName == "" {
-		return nil, status.Error(codes.Internal, "Missing node name")
-	}
-
 	vm, err := ns.connector.GetNodeInfo(ctx, ns.nodeName)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
@@ -481,13 +747,35 @@ func (ns *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoReque
 	if vm.ZoneID == "" {
 		return nil, status.Error(codes.Internal, "Node zone ID not found")
 	}
+	ns.zoneID.Store(&vm.ZoneID)
+	if ns.hypervisor == "" && vm.Hypervisor != "" {
+		if err := ns.mounter.SetHypervisor(vm.Hypervisor); err != nil {
+			logger.Error(err, "Cannot use the hypervisor of the node to find volumes, using the KVM disk serials", "hypervisor", vm.Hypervisor)
+		} else {
+			logger.V(4).Info("Using the disk serials of the node hypervisor", "hypervisor", vm.Hypervisor)
+		}
+	}
 
 	topology := Topology{ZoneID: vm.ZoneID}
+	if len(ns.topologySegments) > 0 {
+		// All the nodes must report the same segments: fail rather than
+		// leave some out.
+		if vm.HostID == "" {
+			return nil, status.Error(codes.Internal, "Node host ID not found, topology segments require root administrator credentials")
+		}
+		host, err := ns.connector.GetHostByID(ctx, vm.HostID)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Cannot get host %s of node: %v", vm.HostID, err)
+		}
+		topology.PodID = host.PodID
+		topology.ClusterID = host.ClusterID
+		topology = topology.restrict(ns.topologySegments)
+	}
 
 	return &csi.NodeGetInfoResponse{
 		NodeId:             vm.ID,
 		AccessibleTopology: topology.ToCSI(),
-		MaxVolumesPerNode:  ns.maxVolumesPerNode,
+		MaxVolumesPerNode:  ns.nodeVolumeAttachLimit(ctx),
 	}, nil
 }
 
@@ -511,35 +799,20 @@ func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 		return nil, status.Error(codes.InvalidArgument, "Volume path not provided")
 	}
 
-	volCap := req.GetVolumeCapability()
-	if volCap != nil { //nolint:nestif
+	var isBlock bool
+	if volCap := req.GetVolumeCapability(); volCap != nil {
 		caps := []*csi.VolumeCapability{volCap}
 		if !isValidVolumeCapabilities(caps) {
 			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("VolumeCapability is invalid: %v", volCap))
 		}
-
-		switch volCap.GetAccessType().(type) { //nolint:gocritic
-		case *csi.VolumeCapability_Block:
-			logger.Info("Filesystem expansion is skipped for block volumes")
-
-			return &csi.NodeExpandVolumeResponse{}, nil
-		}
+		isBlock = volCap.GetBlock() != nil
 	} else {
 		// VolumeCapability is nil, check if volumePath point to a block device
-		isBlock, err := ns.mounter.IsBlockDevice(volumePath)
+		var err error
+		isBlock, err = ns.mounter.IsBlockDevice(volumePath)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to determine if volumePath [%v] is a block device: %v", volumePath, err)
 		}
-		if isBlock {
-			// Skip resizing for Block NodeExpandVolume
-			bcap, err := ns.mounter.GetBlockSizeBytes(volumePath)
-			if err != nil {
-				return nil, status.Errorf(codes.Internal, "failed to get block capacity on path %s: %v", req.GetVolumePath(), err)
-			}
-			logger.V(4).Info("NodeExpandVolume: called, since given volumePath is a block device, ignoring...", "volumeID", volumeID, "volumePath", volumePath)
-
-			return &csi.NodeExpandVolumeResponse{CapacityBytes: bcap}, nil
-		}
 	}
 
 	if acquired := ns.volumeLocks.TryAcquire(volumeID); !acquired {
@@ -549,7 +822,7 @@ func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 	}
 	defer ns.volumeLocks.Release(volumeID)
 
-	_, err := ns.connector.GetVolumeByID(ctx, volumeID)
+	vol, err := ns.connector.GetVolumeByID(ctx, volumeID)
 	if err != nil {
 		if errors.Is(err, cloud.ErrNotFound) {
 			return nil, status.Error(codes.NotFound, fmt.Sprintf("Volume with ID %s not found", volumeID))
@@ -558,9 +831,23 @@ func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 		return nil, status.Error(codes.Internal, fmt.Sprintf("NodeExpandVolume failed with error %v", err))
 	}
 
-	devicePath, err := ns.mounter.GetDevicePath(ctx, volumeID)
-	if devicePath == "" {
-		return nil, status.Error(codes.Internal, fmt.Sprintf("Unable to find Device path for volume %s: %v", volumeID, err))
+	devicePath, err := ns.findDevicePath(ctx, volumeID, attachedDeviceID(vol), ns.volumeHypervisor(ctx, volumeID, vol.Hypervisor))
+	if err != nil {
+		return nil, err
+	}
+
+	if isBlock {
+		return ns.expandBlockVolume(ctx, volumeID, devicePath, req.GetCapacityRange().GetRequiredBytes())
+	}
+
+	// The filesystem of an encrypted volume is on its LUKS device, which
+	// must be grown first.
+	mapperDevice, err := ns.mounter.ResizeEncryptedVolume(volumeID, req.GetSecrets()[EncryptionPassphraseKey])
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Could not resize the LUKS device of volume %q: %v", volumeID, err)
+	}
+	if mapperDevice != "" {
+		devicePath = mapperDevice
 	}
 
 	logger.Info("Expanding volume",
@@ -581,6 +868,64 @@ func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 	return &csi.NodeExpandVolumeResponse{CapacityBytes: bcap}, nil
 }
 
+// reconcileSize grows the filesystem mounted at volumePath when its device
+// is larger, e.g. after the volume was expanded in CloudStack without
+// Kubernetes knowing: the kubelet then never calls NodeExpandVolume, as
+// the PersistentVolume already has the requested size. The LUKS device of
+// an encrypted volume is grown first, with passphrase if not empty.
+func (ns *nodeServer) reconcileSize(ctx context.Context, volumeID, volumePath, passphrase string) error {
+	devicePath, _, err := ns.mounter.GetDeviceName(volumePath)
+	if err != nil {
+		return fmt.Errorf("failed to find the device mounted at %s: %w", volumePath, err)
+	}
+	if devicePath == "" {
+		return nil
+	}
+	if devicePath == ns.mounter.EncryptedDevicePath(volumeID) {
+		if _, err := ns.mounter.ResizeEncryptedVolume(volumeID, passphrase); err != nil {
+			return fmt.Errorf("failed to resize the LUKS device %s: %w", devicePath, err)
+		}
+	}
+	needResize, err := ns.mounter.NeedResize(devicePath, volumePath)
+	if err != nil {
+		return fmt.Errorf("failed to compare the sizes of %s and its filesystem: %w", devicePath, err)
+	}
+	if !needResize {
+		return nil
+	}
+
+	klog.FromContext(ctx).Info("Device larger than its filesystem, resizing it",
+		"volumeID", volumeID,
+		"devicePath", devicePath,
+		"volumePath", volumePath,
+	)
+	if _, err := ns.mounter.Resize(devicePath, volumePath); err != nil {
+		return fmt.Errorf("failed to resize the filesystem of %s: %w", devicePath, err)
+	}
+
+	return nil
+}
+
+// expandBlockVolume makes the new size of the device of a raw block
+// volume visible to the pods, as there is no filesystem to grow.
+func (ns *nodeServer) expandBlockVolume(ctx context.Context, volumeID, devicePath string, requiredBytes int64) (*csi.NodeExpandVolumeResponse, error) {
+	logger := klog.FromContext(ctx)
+	logger.Info("Rescanning block volume", "devicePath", devicePath, "volumeID", volumeID)
+
+	if err := ns.mounter.RescanDevice(devicePath); err != nil {
+		return nil, status.Errorf(codes.Internal, "Could not rescan volume %q (%q): %v", volumeID, devicePath, err)
+	}
+	bcap, err := ns.mounter.GetBlockSizeBytes(devicePath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get block capacity on path %s: %v", devicePath, err)
+	}
+	if bcap < requiredBytes {
+		return nil, status.Errorf(codes.Internal, "Volume %q (%q) is %d bytes after rescan, expected at least %d", volumeID, devicePath, bcap, requiredBytes)
+	}
+
+	return &csi.NodeExpandVolumeResponse{CapacityBytes: bcap}, nil
+}
+
 func (ns *nodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
 	logger := klog.FromContext(ctx)
 	logger.V(6).Info("NodeGetVolumeStats: called", "args", *req)
@@ -608,15 +953,26 @@ func (ns *nodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVo
 		return nil, status.Errorf(codes.NotFound, "path %s does not exist", volumePath)
 	}
 
+	condition, err := ns.volumeCondition(req.GetVolumePath(), req.GetStagingTargetPath())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to check the health of volume %s: %v", req.GetVolumeId(), err)
+	}
+	if condition.GetAbnormal() {
+		// The statistics of a broken mount point would fail or hang.
+		logger.Info("Volume is abnormal", "volumeID", req.GetVolumeId(), "message", condition.GetMessage())
+
+		return &csi.NodeGetVolumeStatsResponse{VolumeCondition: condition}, nil
+	}
+
 	isBlock, err := ns.mounter.IsBlockDevice(volumePath)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to determine if %q is block device: %s", volumePath, err)
 	}
 
 	if isBlock {
-		bcap, blockErr := ns.mounter.GetBlockSizeBytes(req.GetVolumePath())
+		bcap, blockErr := ns.mounter.GetBlockSizeBytes(volumePath)
 		if blockErr != nil {
-			return nil, status.Errorf(codes.Internal, "failed to get block capacity on path %s: %v", req.GetVolumePath(), blockErr)
+			return nil, status.Errorf(codes.Internal, "failed to get block capacity on path %s: %v", volumePath, blockErr)
 		}
 
 		return &csi.NodeGetVolumeStatsResponse{
@@ -626,6 +982,7 @@ func (ns *nodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVo
 					Total: bcap,
 				},
 			},
+			VolumeCondition: condition,
 		}, nil
 	}
 
@@ -649,9 +1006,45 @@ func (ns *nodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVo
 				Unit:      csi.VolumeUsage_INODES,
 			},
 		},
+		VolumeCondition: condition,
 	}, nil
 }
 
+// volumeCondition reports a volume as abnormal when its mount points are
+// corrupted, when its device disappeared, or when its staging mount point
+// is read-only: staging mount points are always mounted read-write, the
+// kernel remounts them read-only after I/O errors.
+func (ns *nodeServer) volumeCondition(volumePath, stagingPath string) (*csi.VolumeCondition, error) {
+	if volumePath != "" {
+		health, err := ns.mounter.CheckMountHealth(volumePath)
+		if err != nil {
+			return nil, err
+		}
+		switch health { //nolint:exhaustive
+		case mount.MountCorrupted, mount.MountDeviceMissing:
+			return &csi.VolumeCondition{
+				Abnormal: true,
+				Message:  fmt.Sprintf("Mount point %s is %s", volumePath, health),
+			}, nil
+		}
+	}
+	if stagingPath != "" {
+		health, err := ns.mounter.CheckMountHealth(stagingPath)
+		if err != nil {
+			return nil, err
+		}
+		switch health { //nolint:exhaustive
+		case mount.MountCorrupted, mount.MountDeviceMissing, mount.MountReadOnly:
+			return &csi.VolumeCondition{
+				Abnormal: true,
+				Message:  fmt.Sprintf("Staging mount point %s is %s", stagingPath, health),
+			}, nil
+		}
+	}
+
+	return &csi.VolumeCondition{Message: "Volume is healthy"}, nil
+}
+
 func (ns *nodeServer) NodeGetCapabilities(_ context.Context, _ *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
 	resp := &csi.NodeGetCapabilitiesResponse{
 		Capabilities: []*csi.NodeServiceCapability{
@@ -676,6 +1069,20 @@ func (ns *nodeServer) NodeGetCapabilities(_ context.Context, _ *csi.NodeGetCapab
 					},
 				},
 			},
+			{
+				Type: &csi.NodeServiceCapability_Rpc{
+					Rpc: &csi.NodeServiceCapability_RPC{
+						Type: csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
+					},
+				},
+			},
+			{
+				Type: &csi.NodeServiceCapability_Rpc{
+					Rpc: &csi.NodeServiceCapability_RPC{
+						Type: csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+					},
+				},
+			},
 		},
 	}
 
@@ -0,0 +1,1122 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud"
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud/fake"
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/mount"
+)
+
+func TestNodeGetVolumeStats(t *testing.T) {
+	ns := NewNodeServer(fake.New(), mount.NewFake(), &Options{})
+
+	resp, err := ns.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+		VolumeId:   "ace9f28b-3081-40c1-8353-4cc3e3014072",
+		VolumePath: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[csi.VolumeUsage_Unit]*csi.VolumeUsage{
+		csi.VolumeUsage_BYTES:  {Available: 3 << 30, Total: 10 << 30, Used: 7 << 30},
+		csi.VolumeUsage_INODES: {Available: 3000, Total: 10000, Used: 7000},
+	}
+	if len(resp.GetUsage()) != len(expected) {
+		t.Fatalf("expected %d usage entries, got %d", len(expected), len(resp.GetUsage()))
+	}
+	for _, u := range resp.GetUsage() {
+		e, ok := expected[u.GetUnit()]
+		if !ok {
+			t.Errorf("unexpected unit %v", u.GetUnit())
+
+			continue
+		}
+		if u.GetAvailable() != e.GetAvailable() || u.GetTotal() != e.GetTotal() || u.GetUsed() != e.GetUsed() {
+			t.Errorf("unit %v: expected %v, got %v", u.GetUnit(), e, u)
+		}
+	}
+}
+
+func TestReconcileVolumeSize(t *testing.T) {
+	const volumeID = "ace9f28b-3081-40c1-8353-4cc3e3014072"
+	cases := []struct {
+		name       string
+		reconcile  bool
+		needResize bool
+		encrypted  bool
+		// expected are the resizes, of the LUKS device first if
+		// encrypted, then of the filesystem.
+		expected func(stagingPath string) []string
+	}{
+		{"device larger than filesystem", true, true, false, func(stagingPath string) []string {
+			return []string{"/dev/sdb " + stagingPath}
+		}},
+		{"same size", true, false, false, func(string) []string { return nil }},
+		{"disabled", false, true, false, func(string) []string { return nil }},
+		{"encrypted", true, true, true, func(stagingPath string) []string {
+			return []string{"luks " + volumeID + " secret", "/dev/mapper/luks-" + volumeID + " " + stagingPath}
+		}},
+		{"encrypted same size", true, false, true, func(string) []string {
+			return []string{"luks " + volumeID + " secret"}
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mounter := &encryptedResizeMounter{resizeMounter{Interface: mount.NewFake(), needResize: c.needResize}}
+			ns := NewNodeServer(fake.New(), mounter, &Options{ReconcileVolumeSize: c.reconcile})
+			stagingPath := t.TempDir()
+			device := "/dev/sdb"
+			var secrets map[string]string
+			if c.encrypted {
+				device = mounter.EncryptedDevicePath(volumeID)
+				secrets = map[string]string{EncryptionPassphraseKey: "secret"}
+			}
+			if err := mounter.Mount(device, stagingPath, "ext4", nil); err != nil {
+				t.Fatal(err)
+			}
+			// Gathering the statistics never resizes: it may run with
+			// another operation on the volume.
+			_, err := ns.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+				VolumeId:          volumeID,
+				VolumePath:        t.TempDir(),
+				StagingTargetPath: stagingPath,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(mounter.resized) != 0 {
+				t.Errorf("expected no resize when gathering statistics, got %v", mounter.resized)
+			}
+
+			_, err = ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+				VolumeId:          volumeID,
+				StagingTargetPath: stagingPath,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				},
+				Secrets: secrets,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if expected := c.expected(stagingPath); !reflect.DeepEqual(mounter.resized, expected) {
+				t.Errorf("expected resizes %v when staging again, got %v", expected, mounter.resized)
+			}
+		})
+	}
+}
+
+// healthMounter reports the health of its mount points.
+type healthMounter struct {
+	mount.Interface
+	health map[string]mount.MountHealth
+}
+
+func (m *healthMounter) CheckMountHealth(path string) (mount.MountHealth, error) {
+	if health, ok := m.health[path]; ok {
+		return health, nil
+	}
+
+	return mount.MountHealthy, nil
+}
+
+func TestNodeGetVolumeStatsCondition(t *testing.T) {
+	cases := []struct {
+		name             string
+		volumeHealth     mount.MountHealth
+		stagingHealth    mount.MountHealth
+		expectedAbnormal bool
+	}{
+		{"healthy", mount.MountHealthy, mount.MountHealthy, false},
+		{"published read-only", mount.MountReadOnly, mount.MountHealthy, false},
+		{"block volume", mount.MountHealthy, mount.MountNotMounted, false},
+		{"corrupted", mount.MountCorrupted, mount.MountHealthy, true},
+		{"device missing", mount.MountHealthy, mount.MountDeviceMissing, true},
+		{"staged read-only", mount.MountHealthy, mount.MountReadOnly, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			volumePath, stagingPath := t.TempDir(), t.TempDir()
+			mounter := &healthMounter{
+				Interface: mount.NewFake(),
+				health:    map[string]mount.MountHealth{volumePath: c.volumeHealth, stagingPath: c.stagingHealth},
+			}
+			ns := NewNodeServer(fake.New(), mounter, &Options{})
+
+			resp, err := ns.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+				VolumeId:          "ace9f28b-3081-40c1-8353-4cc3e3014072",
+				VolumePath:        volumePath,
+				StagingTargetPath: stagingPath,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			condition := resp.GetVolumeCondition()
+			if condition == nil {
+				t.Fatal("expected a volume condition")
+			}
+			if condition.GetAbnormal() != c.expectedAbnormal {
+				t.Errorf("expected abnormal %t, got %v", c.expectedAbnormal, condition)
+			}
+			if c.expectedAbnormal && len(resp.GetUsage()) != 0 {
+				t.Errorf("expected no usage for an abnormal volume, got %v", resp.GetUsage())
+			}
+		})
+	}
+}
+
+// corruptedMounter reports its target as a corrupted mount,
+// until it is unstaged.
+type corruptedMounter struct {
+	mount.Interface
+	target   string
+	unstaged bool
+}
+
+func (m *corruptedMounter) PathExists(path string) (bool, error) {
+	if path == m.target && !m.unstaged {
+		return true, &os.PathError{Op: "stat", Path: path, Err: syscall.ESTALE}
+	}
+
+	return m.Interface.PathExists(path)
+}
+
+func (m *corruptedMounter) Unstage(path string) error {
+	m.unstaged = true
+
+	return m.Interface.Unstage(path)
+}
+
+// deviceIDMounter records the device ID used to find the device.
+type deviceIDMounter struct {
+	mount.Interface
+	deviceID string
+}
+
+func (m *deviceIDMounter) GetDevicePathByDeviceID(ctx context.Context, volumeID, deviceID, hypervisor string) (string, error) {
+	m.deviceID = deviceID
+
+	return m.Interface.GetDevicePathByDeviceID(ctx, volumeID, deviceID, hypervisor)
+}
+
+// slowDeviceMounter never finds the device of a volume before ctx is done.
+type slowDeviceMounter struct {
+	mount.Interface
+}
+
+func (m *slowDeviceMounter) GetDevicePathByDeviceID(ctx context.Context, volumeID, _, _ string) (string, error) {
+	<-ctx.Done()
+
+	return "", fmt.Errorf("%w for volumeID %q: %w", mount.ErrDeviceNotFound, volumeID, ctx.Err())
+}
+
+func TestDevicePathError(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		expected codes.Code
+	}{
+		{"not found", fmt.Errorf("%w: no entry", mount.ErrDeviceNotFound), codes.NotFound},
+		{"wait timed out", fmt.Errorf("%w: no entry: %w", mount.ErrDeviceNotFound, context.DeadlineExceeded), codes.DeadlineExceeded},
+		{"canceled", fmt.Errorf("%w: no entry: %w", mount.ErrDeviceNotFound, context.Canceled), codes.Canceled},
+		{"probe failed", fmt.Errorf("%w: %w", mount.ErrDeviceProbeFailed, syscall.ENOTDIR), codes.Internal},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := devicePathError("ace9f28b-3081-40c1-8353-4cc3e3014072", c.err)
+			if status.Code(err) != c.expected {
+				t.Errorf("expected %v error, got %v", c.expected, err)
+			}
+			if !strings.Contains(err.Error(), c.err.Error()) {
+				t.Errorf("expected the reason %q in %q", c.err, err)
+			}
+		})
+	}
+}
+
+func TestNodeStageVolumeDeviceWaitTimeout(t *testing.T) {
+	ns := NewNodeServer(fake.New(), &slowDeviceMounter{Interface: mount.NewFake()}, &Options{DeviceWaitTimeout: 10 * time.Millisecond})
+
+	_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "ace9f28b-3081-40c1-8353-4cc3e3014072",
+		StagingTargetPath: filepath.Join(t.TempDir(), "staging"),
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded error, got %v", err)
+	}
+}
+
+func TestNodeStageVolumeDeviceID(t *testing.T) {
+	const (
+		volumeID = "ace9f28b-3081-40c1-8353-4cc3e3014072"
+		nodeID   = "0d7107a3-94d2-44e7-89b8-8930881309a5"
+	)
+	ctx := context.Background()
+	connector := fake.New()
+	volCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	cs := NewControllerServer(connector, &Options{})
+	published, err := cs.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId:         volumeID,
+		NodeId:           nodeID,
+		VolumeCapability: volCap,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mounter := &deviceIDMounter{Interface: mount.NewFake()}
+	ns := NewNodeServer(connector, mounter, &Options{})
+	if _, err := ns.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          volumeID,
+		PublishContext:    published.GetPublishContext(),
+		StagingTargetPath: filepath.Join(t.TempDir(), "staging"),
+		VolumeCapability:  volCap,
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mounter.deviceID != "1" {
+		t.Errorf("expected the device to be looked up with device ID 1, got %q", mounter.deviceID)
+	}
+}
+
+func TestNodeStageVolumeCorruptedTarget(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "staging")
+	mounter := &corruptedMounter{Interface: mount.NewFake(), target: target}
+	ns := NewNodeServer(fake.New(), mounter, &Options{})
+
+	_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "ace9f28b-3081-40c1-8353-4cc3e3014072",
+		StagingTargetPath: target,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !mounter.unstaged {
+		t.Error("the corrupted target should have been unstaged")
+	}
+	if device, _, _ := mounter.GetDeviceName(target); device != "/dev/sdb" {
+		t.Errorf("expected /dev/sdb to be staged at %s, got %q", target, device)
+	}
+}
+
+// resizeMounter records the resize operations. With needResize, the
+// devices are larger than their filesystem.
+type resizeMounter struct {
+	mount.Interface
+	needResize bool
+	resized    []string
+}
+
+func (m *resizeMounter) NeedResize(_, _ string) (bool, error) {
+	return m.needResize, nil
+}
+
+func (m *resizeMounter) Resize(devicePath, deviceMountPath string) (bool, error) {
+	m.resized = append(m.resized, devicePath+" "+deviceMountPath)
+
+	return true, nil
+}
+
+func TestNodeExpandVolume(t *testing.T) {
+	cases := []struct {
+		name            string
+		volCap          *csi.VolumeCapability
+		expectedResized bool
+	}{
+		{"filesystem", &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}, true},
+		{"block", &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mounter := &resizeMounter{Interface: mount.NewFake()}
+			ns := NewNodeServer(fake.New(), mounter, &Options{})
+			stagingPath := t.TempDir()
+
+			resp, err := ns.NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{
+				VolumeId:          "ace9f28b-3081-40c1-8353-4cc3e3014072",
+				StagingTargetPath: stagingPath,
+				VolumeCapability:  c.volCap,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !c.expectedResized {
+				if len(mounter.resized) != 0 {
+					t.Errorf("expected no resize, got %v", mounter.resized)
+				}
+
+				return
+			}
+			expected := []string{"/dev/sdb " + stagingPath}
+			if !reflect.DeepEqual(mounter.resized, expected) {
+				t.Errorf("expected resize %v, got %v", expected, mounter.resized)
+			}
+			if resp.GetCapacityBytes() != 1<<30 {
+				t.Errorf("expected capacity %d, got %d", 1<<30, resp.GetCapacityBytes())
+			}
+		})
+	}
+}
+
+// encryptedResizeMounter is a resizeMounter whose volumes are encrypted:
+// the resize of their LUKS device is recorded with the passphrase.
+type encryptedResizeMounter struct {
+	resizeMounter
+}
+
+func (m *encryptedResizeMounter) ResizeEncryptedVolume(volumeID, passphrase string) (string, error) {
+	m.resized = append(m.resized, "luks "+volumeID+" "+passphrase)
+
+	return m.EncryptedDevicePath(volumeID), nil
+}
+
+func TestNodeExpandVolumeEncrypted(t *testing.T) {
+	const volumeID = "ace9f28b-3081-40c1-8353-4cc3e3014072"
+	mounter := &encryptedResizeMounter{resizeMounter{Interface: mount.NewFake()}}
+	ns := NewNodeServer(fake.New(), mounter, &Options{})
+	stagingPath := t.TempDir()
+
+	_, err := ns.NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+		Secrets: map[string]string{EncryptionPassphraseKey: "secret"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The LUKS device is grown first, then the filesystem it holds.
+	expected := []string{
+		"luks " + volumeID + " secret",
+		mounter.EncryptedDevicePath(volumeID) + " " + stagingPath,
+	}
+	if !reflect.DeepEqual(mounter.resized, expected) {
+		t.Errorf("expected resize %v, got %v", expected, mounter.resized)
+	}
+}
+
+// rescanMounter simulates a device whose size only changes once
+// rescanned.
+type rescanMounter struct {
+	mount.Interface
+	rescanned []string
+	newSize   int64
+}
+
+func (m *rescanMounter) RescanDevice(devicePath string) error {
+	m.rescanned = append(m.rescanned, devicePath)
+
+	return nil
+}
+
+func (m *rescanMounter) GetBlockSizeBytes(_ string) (int64, error) {
+	if len(m.rescanned) == 0 {
+		return 1 << 30, nil
+	}
+
+	return m.newSize, nil
+}
+
+func TestNodeExpandVolumeBlock(t *testing.T) {
+	cases := []struct {
+		name        string
+		newSize     int64
+		expectedErr codes.Code
+	}{
+		{"expanded", 2 << 30, codes.OK},
+		{"size not updated", 1 << 30, codes.Internal},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mounter := &rescanMounter{Interface: mount.NewFake(), newSize: c.newSize}
+			ns := NewNodeServer(fake.New(), mounter, &Options{})
+
+			resp, err := ns.NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{
+				VolumeId:   "ace9f28b-3081-40c1-8353-4cc3e3014072",
+				VolumePath: filepath.Join(t.TempDir(), "block"),
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				},
+				CapacityRange: &csi.CapacityRange{RequiredBytes: 2 << 30},
+			})
+			if status.Code(err) != c.expectedErr {
+				t.Fatalf("expected %v error, got %v", c.expectedErr, err)
+			}
+			if !reflect.DeepEqual(mounter.rescanned, []string{"/dev/sdb"}) {
+				t.Errorf("expected /dev/sdb to be rescanned, got %v", mounter.rescanned)
+			}
+			if err == nil && resp.GetCapacityBytes() != c.newSize {
+				t.Errorf("expected capacity %d, got %d", c.newSize, resp.GetCapacityBytes())
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeBlockMultiWriter(t *testing.T) {
+	mounter := mount.NewFake()
+	ns := NewNodeServer(fake.New(), mounter, &Options{})
+	dir := t.TempDir()
+	publish := func(target string) {
+		t.Helper()
+		_, err := ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:          "ace9f28b-3081-40c1-8353-4cc3e3014072",
+			StagingTargetPath: filepath.Join(dir, "staging"),
+			TargetPath:        target,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER},
+			},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Two pods of the node publish the volume, the first one twice.
+	first := filepath.Join(dir, "pod1", "block")
+	second := filepath.Join(dir, "pod2", "block")
+	publish(first)
+	publish(second)
+	publish(first)
+	for _, target := range []string{first, second} {
+		if device, _, _ := mounter.GetDeviceName(target); device != "/dev/sdb" {
+			t.Errorf("expected /dev/sdb to be bound at %s, got %q", target, device)
+		}
+	}
+}
+
+func TestMaxVolumesPerNode(t *testing.T) {
+	cases := []struct {
+		name     string
+		limit    int64
+		reserved int64
+		expected int64
+	}{
+		{"configured limit", 10, DefaultReservedVolumeAttachments, 10},
+		{"default", 0, DefaultReservedVolumeAttachments, 22},
+		{"no reserved slot", 0, 0, 24},
+		{"all slots reserved", 0, 30, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if limit := maxVolumesPerNode(c.limit, c.reserved); limit != c.expected {
+				t.Errorf("expected %d, got %d", c.expected, limit)
+			}
+		})
+	}
+}
+
+func TestNodeGetInfoVolumeAttachLimit(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		expected    int64
+	}{
+		{"no annotation", nil, 22},
+		{"annotation", map[string]string{VolumeAttachLimitAnnotation: "12"}, 12},
+		{"invalid annotation", map[string]string{VolumeAttachLimitAnnotation: "many"}, 22},
+		{"out of range annotation", map[string]string{VolumeAttachLimitAnnotation: "0"}, 22},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ns := NewNodeServer(fake.New(), mount.NewFake(), &Options{
+				NodeName:                  "node",
+				ReservedVolumeAttachments: DefaultReservedVolumeAttachments,
+			}).(*nodeServer)
+			ns.kubeClient = kubefake.NewSimpleClientset(&corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node", Annotations: c.annotations},
+			})
+
+			resp, err := ns.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.GetMaxVolumesPerNode() != c.expected {
+				t.Errorf("expected %d, got %d", c.expected, resp.GetMaxVolumesPerNode())
+			}
+		})
+	}
+}
+
+// luksMounter records the volumes whose LUKS devices are closed.
+type luksMounter struct {
+	mount.Interface
+	closed []string
+}
+
+func (m *luksMounter) CloseEncryptedVolume(volumeID string) error {
+	m.closed = append(m.closed, volumeID)
+
+	return nil
+}
+
+func TestNodeUnstageVolumeNotStaged(t *testing.T) {
+	const volumeID = "ace9f28b-3081-40c1-8353-4cc3e3014072"
+	cases := []struct {
+		name   string
+		create bool
+	}{
+		{"target not found", false},
+		{"stale target", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mounter := &luksMounter{Interface: mount.NewFake()}
+			ns := NewNodeServer(fake.New(), mounter, &Options{}).(*nodeServer)
+			target := filepath.Join(t.TempDir(), "staging")
+			if c.create {
+				if err := os.Mkdir(target, 0o750); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for i := 0; i < 2; i++ {
+				if _, err := ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{
+					VolumeId:          volumeID,
+					StagingTargetPath: target,
+				}); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if _, err := os.Stat(target); !os.IsNotExist(err) {
+				t.Errorf("expected target %s to be removed, got %v", target, err)
+			}
+			if !slices.Equal(mounter.closed, []string{volumeID, volumeID}) {
+				t.Errorf("expected the LUKS devices of the volume to be closed, got %v", mounter.closed)
+			}
+		})
+	}
+}
+
+// hypervisorMounter records the hypervisor it is given, either for the
+// node or to find the device of a volume.
+type hypervisorMounter struct {
+	mount.Interface
+	hypervisor string
+	// volumeHypervisors are the hypervisors devices were looked up with.
+	volumeHypervisors []string
+}
+
+func (m *hypervisorMounter) SetHypervisor(hypervisor string) error {
+	m.hypervisor = hypervisor
+
+	return nil
+}
+
+func (m *hypervisorMounter) GetDevicePathByDeviceID(ctx context.Context, volumeID, deviceID, hypervisor string) (string, error) {
+	m.volumeHypervisors = append(m.volumeHypervisors, hypervisor)
+
+	return m.Interface.GetDevicePathByDeviceID(ctx, volumeID, deviceID, hypervisor)
+}
+
+func TestNodeGetInfoHypervisor(t *testing.T) {
+	cases := []struct {
+		name       string
+		configured string
+		expected   string
+	}{
+		{"detected", "", "KVM"},
+		{"configured", "xenserver", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mounter := &hypervisorMounter{Interface: mount.NewFake()}
+			ns := NewNodeServer(fake.New(), mounter, &Options{Hypervisor: c.configured}).(*nodeServer)
+
+			if _, err := ns.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if mounter.hypervisor != c.expected {
+				t.Errorf("expected hypervisor %q given to the mounter, got %q", c.expected, mounter.hypervisor)
+			}
+		})
+	}
+}
+
+func TestNodeStageVolumeHypervisor(t *testing.T) {
+	cases := []struct {
+		name       string
+		hypervisor string
+		configured string
+		expected   string
+	}{
+		{"kvm", "KVM", "", "KVM"},
+		{"xenserver", "XenServer", "", "XenServer"},
+		{"vmware", "VMware", "", ""},
+		{"none", "", "", ""},
+		{"configured", "XenServer", "kvm", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mounter := &hypervisorMounter{Interface: mount.NewFake()}
+			ns := NewNodeServer(fake.New(), mounter, &Options{Hypervisor: c.configured})
+			_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+				VolumeId:          "ace9f28b-3081-40c1-8353-4cc3e3014072",
+				StagingTargetPath: filepath.Join(t.TempDir(), "staging"),
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				},
+				VolumeContext: map[string]string{HypervisorKey: c.hypervisor},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(mounter.volumeHypervisors, []string{c.expected}) {
+				t.Errorf("expected the device to be looked up with hypervisor %q, got %q", c.expected, mounter.volumeHypervisors)
+			}
+			// The serials of the node are left alone.
+			if mounter.hypervisor != "" {
+				t.Errorf("expected no hypervisor set for the node, got %q", mounter.hypervisor)
+			}
+		})
+	}
+}
+
+func TestNodeExpandVolumeHypervisor(t *testing.T) {
+	cases := []struct {
+		name       string
+		configured string
+		expected   string
+	}{
+		{"volume", "", "XenServer"},
+		{"configured", "kvm", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mounter := &hypervisorMounter{Interface: mount.NewFake()}
+			connector := &hypervisorConnector{Interface: fake.New(), hypervisor: "XenServer"}
+			ns := NewNodeServer(connector, mounter, &Options{Hypervisor: c.configured})
+			_, err := ns.NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{
+				VolumeId:          "ace9f28b-3081-40c1-8353-4cc3e3014072",
+				StagingTargetPath: t.TempDir(),
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(mounter.volumeHypervisors, []string{c.expected}) {
+				t.Errorf("expected the device to be looked up with hypervisor %q, got %q", c.expected, mounter.volumeHypervisors)
+			}
+		})
+	}
+}
+
+// adminlessConnector returns nodes without their host, as for accounts
+// that are not root administrators.
+type adminlessConnector struct {
+	cloud.Interface
+}
+
+func (c *adminlessConnector) GetNodeInfo(ctx context.Context, vmName string) (*cloud.VM, error) {
+	vm, err := c.Interface.GetNodeInfo(ctx, vmName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cloud.VM{ID: vm.ID, ZoneID: vm.ZoneID}, nil
+}
+
+func TestNodeStageVolumeOtherZone(t *testing.T) {
+	ns := NewNodeServer(fake.New(), mount.NewFake(), &Options{})
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          "ace9f28b-3081-40c1-8353-4cc3e3014072",
+		StagingTargetPath: filepath.Join(t.TempDir(), "staging"),
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+		VolumeContext: map[string]string{ZoneIDKey: "f1c6e0d6-4a4b-4b1e-9a9f-3d2b1c0e9f8a"},
+	}
+
+	// The zone of the node is not known yet.
+	if _, err := ns.NodeStageVolume(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := ns.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req.StagingTargetPath = filepath.Join(t.TempDir(), "staging")
+	if _, err := ns.NodeStageVolume(context.Background(), req); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition error, got %v", err)
+	}
+}
+
+func TestNodeGetInfoTopologySegments(t *testing.T) {
+	const zoneID = "a1887604-237c-4212-a9cd-94620b7880fa"
+	cases := []struct {
+		name     string
+		segments []string
+		expected map[string]string
+	}{
+		{"zone only", nil, map[string]string{ZoneKey: zoneID}},
+		{"pod", []string{PodSegment}, map[string]string{
+			ZoneKey: zoneID,
+			PodKey:  "f3b2c1d0-5e4f-4a3b-8c2d-1e0f9a8b7c6d",
+		}},
+		{"pod and cluster", []string{PodSegment, ClusterSegment}, map[string]string{
+			ZoneKey:    zoneID,
+			PodKey:     "f3b2c1d0-5e4f-4a3b-8c2d-1e0f9a8b7c6d",
+			ClusterKey: "7a6b5c4d-3e2f-4a1b-9c8d-7e6f5a4b3c2d",
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ns := NewNodeServer(fake.New(), mount.NewFake(), &Options{NodeName: "node", TopologySegments: c.segments})
+
+			resp, err := ns.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(resp.GetAccessibleTopology().GetSegments(), c.expected) {
+				t.Errorf("expected topology %v, got %v", c.expected, resp.GetAccessibleTopology().GetSegments())
+			}
+		})
+	}
+
+	t.Run("host not visible", func(t *testing.T) {
+		ns := NewNodeServer(&adminlessConnector{Interface: fake.New()}, mount.NewFake(), &Options{NodeName: "node", TopologySegments: []string{ClusterSegment}})
+		if _, err := ns.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{}); status.Code(err) != codes.Internal {
+			t.Errorf("expected Internal error, got %v", err)
+		}
+	})
+}
+
+func TestEphemeralVolume(t *testing.T) {
+	const volumeID = "csi-7f0e4b8a5d6c3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f"
+	connector := fake.New()
+	mounter := mount.NewFake()
+	ns := NewNodeServer(connector, mounter, &Options{NodeName: "node"})
+	target := filepath.Join(t.TempDir(), "target")
+	ctx := context.Background()
+
+	publish := func(mode csi.VolumeCapability_AccessMode_Mode) error {
+		_, err := ns.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId:   volumeID,
+			TargetPath: target,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+			},
+			VolumeContext: map[string]string{
+				EphemeralKey:        "true",
+				DiskOfferingNameKey: "custom",
+				EphemeralSizeKey:    "5Gi",
+			},
+		})
+
+		return err
+	}
+
+	if err := publish(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument error, got %v", err)
+	}
+	if _, err := connector.GetVolumeByName(ctx, volumeID); err == nil {
+		t.Fatal("no volume should have been created")
+	}
+
+	// Publishing is idempotent.
+	for i := 0; i < 2; i++ {
+		if err := publish(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	vol, err := connector.GetVolumeByName(ctx, volumeID)
+	if err != nil {
+		t.Fatalf("expected the volume to be created: %v", err)
+	}
+	if vol.VirtualMachineID != "0d7107a3-94d2-44e7-89b8-8930881309a5" {
+		t.Errorf("expected the volume to be attached to the node, got %q", vol.VirtualMachineID)
+	}
+	if vol.Size != 5*1024*1024*1024 {
+		t.Errorf("expected a 5 GiB volume, got %d bytes", vol.Size)
+	}
+	if device, _, _ := mounter.GetDeviceName(target); device != "/dev/sdb" {
+		t.Errorf("expected /dev/sdb to be mounted at %s, got %q", target, device)
+	}
+
+	if _, err := ns.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: volumeID, TargetPath: target}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := connector.GetVolumeByName(ctx, volumeID); !errors.Is(err, cloud.ErrNotFound) {
+		t.Errorf("expected the volume to be deleted, got %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("expected the target to be removed, got %v", err)
+	}
+
+	// Unpublishing is idempotent.
+	if _, err := ns.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: volumeID, TargetPath: target}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// blockingFormatMounter blocks formatting until released, counting the
+// formats.
+type blockingFormatMounter struct {
+	mount.Interface
+	formats atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (m *blockingFormatMounter) FormatAndMountWithFormatOptions(source, target, fstype string, options, formatOptions []string) error {
+	if m.formats.Add(1) == 1 {
+		close(m.started)
+	}
+	<-m.release
+
+	return m.Interface.FormatAndMountWithFormatOptions(source, target, fstype, options, formatOptions)
+}
+
+func TestNodeStageVolumeConcurrent(t *testing.T) {
+	mounter := &blockingFormatMounter{Interface: mount.NewFake(), started: make(chan struct{}), release: make(chan struct{})}
+	ns := NewNodeServer(fake.New(), mounter, &Options{})
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          "ace9f28b-3081-40c1-8353-4cc3e3014072",
+		StagingTargetPath: filepath.Join(t.TempDir(), "staging"),
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ns.NodeStageVolume(context.Background(), req)
+		done <- err
+	}()
+	<-mounter.started
+
+	// The volume is being formatted: staging it again is refused.
+	if _, err := ns.NodeStageVolume(context.Background(), req); status.Code(err) != codes.Aborted {
+		t.Errorf("expected Aborted error, got %v", err)
+	}
+	close(mounter.release)
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := mounter.formats.Load(); got != 1 {
+		t.Errorf("expected the volume to be formatted once, got %d", got)
+	}
+}
+
+func TestNodeStageVolumeDefaultFSType(t *testing.T) {
+	cases := []struct {
+		name         string
+		accessType   *csi.VolumeCapability_Mount
+		expectedType string
+	}{
+		{"unset", &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}, "xfs"},
+		{"set", &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext3"}}, "ext3"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "staging")
+			mounter := mount.NewFake()
+			ns := NewNodeServer(fake.New(), mounter, &Options{DefaultFSType: "xfs"})
+			_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+				VolumeId:          "ace9f28b-3081-40c1-8353-4cc3e3014072",
+				StagingTargetPath: target,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: c.accessType,
+					AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			mountPoints, _ := mounter.List()
+			if len(mountPoints) != 1 || mountPoints[0].Type != c.expectedType {
+				t.Errorf("expected a %s mount, got %v", c.expectedType, mountPoints)
+			}
+		})
+	}
+
+	t.Run("block", func(t *testing.T) {
+		mounter := mount.NewFake()
+		ns := NewNodeServer(fake.New(), mounter, &Options{DefaultFSType: "xfs"})
+		_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+			VolumeId:          "ace9f28b-3081-40c1-8353-4cc3e3014072",
+			StagingTargetPath: filepath.Join(t.TempDir(), "staging"),
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if mountPoints, _ := mounter.List(); len(mountPoints) != 0 {
+			t.Errorf("expected no mount, got %v", mountPoints)
+		}
+	})
+}
+
+func TestVolumeFormatOptions(t *testing.T) {
+	cases := []struct {
+		name          string
+		fsType        string
+		volumeContext map[string]string
+		expected      []string
+	}{
+		{"default", "ext4", nil, []string{"-E", "lazy_itable_init=1,lazy_journal_init=1"}},
+		{"not lazy", "ext4", map[string]string{Ext4LazyInitKey: "false", MkfsOptionsKey: "-I 512"}, []string{"-E", "lazy_itable_init=0,lazy_journal_init=0", "-I", "512"}},
+		{"xfs", "xfs", map[string]string{Ext4LazyInitKey: "false", MkfsOptionsKey: "-K"}, []string{"-K"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			formatOptions, err := volumeFormatOptions(c.fsType, c.volumeContext)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(formatOptions, c.expected) {
+				t.Errorf("expected format options %v, got %v", c.expected, formatOptions)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := volumeFormatOptions("ext4", map[string]string{Ext4LazyInitKey: "yes"}); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestNodeVolumeReadonly(t *testing.T) {
+	const volumeID = "ace9f28b-3081-40c1-8353-4cc3e3014072"
+	ctx := context.Background()
+	mounter := mount.NewFake()
+	ns := NewNodeServer(fake.New(), mounter, &Options{})
+	dir := t.TempDir()
+	staging := filepath.Join(dir, "staging")
+	target := filepath.Join(dir, "target")
+	volCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	publishContext := map[string]string{deviceIDContextKey: "1", readonlyContextKey: "true"}
+
+	if _, err := ns.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          volumeID,
+		PublishContext:    publishContext,
+		StagingTargetPath: staging,
+		VolumeCapability:  volCap,
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := ns.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:          volumeID,
+		PublishContext:    publishContext,
+		StagingTargetPath: staging,
+		TargetPath:        target,
+		VolumeCapability:  volCap,
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mountPoints, _ := mounter.List()
+	if len(mountPoints) != 2 {
+		t.Fatalf("expected 2 mounts, got %v", mountPoints)
+	}
+	for _, mp := range mountPoints {
+		if readonly := hasMountOption(mp.Opts, "ro"); readonly != (mp.Path == target) {
+			t.Errorf("expected only %s to be mounted read-only, got options %v for %s", target, mp.Opts, mp.Path)
+		}
+	}
+}
+
+func TestNodeVolumeMountOptionsOrder(t *testing.T) {
+	const volumeID = "ace9f28b-3081-40c1-8353-4cc3e3014072"
+	ctx := context.Background()
+	mounter := mount.NewFake()
+	ns := NewNodeServer(fake.New(), mounter, &Options{})
+	dir := t.TempDir()
+	staging := filepath.Join(dir, "staging")
+	target := filepath.Join(dir, "target")
+	flags := []string{"nconnect=4", "noatime", "x-systemd.requires=network-online.target", "noatime", "errors=remount-ro"}
+	volCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4", MountFlags: flags}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+
+	if _, err := ns.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: staging,
+		VolumeCapability:  volCap,
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := ns.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: staging,
+		TargetPath:        target,
+		VolumeCapability:  volCap,
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// SafeFormatAndMount adds defaults to the options of the staging mount.
+	expected := map[string][]string{
+		staging: {"nconnect=4", "noatime", "x-systemd.requires=network-online.target", "errors=remount-ro", "defaults"},
+		target:  {"bind", "nconnect=4", "noatime", "x-systemd.requires=network-online.target", "errors=remount-ro"},
+	}
+	mountPoints, _ := mounter.List()
+	for _, mp := range mountPoints {
+		if !reflect.DeepEqual(mp.Opts, expected[mp.Path]) {
+			t.Errorf("expected options %q for %s, got %q", expected[mp.Path], mp.Path, mp.Opts)
+		}
+	}
+	if len(mountPoints) != len(expected) {
+		t.Errorf("expected %d mounts, got %v", len(expected), mountPoints)
+	}
+}
@@ -2,8 +2,16 @@ package driver
 
 import (
 	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
 	flag "github.com/spf13/pflag"
+
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud"
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/mount"
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/util"
 )
 
 // Options contains options and configuration settings for the driver.
@@ -18,33 +26,415 @@ type Options struct {
 	// CloudStackConfig is the path to the CloudStack configuration file
 	CloudStackConfig string
 
+	// GRPCLogVerbosity is the verbosity of the logs of the CSI calls.
+	GRPCLogVerbosity int
+
+	// GRPCMaxRecvMsgSize and GRPCMaxSendMsgSize are the maximum sizes in
+	// bytes of the messages received and sent by the gRPC server.
+	GRPCMaxRecvMsgSize int
+	GRPCMaxSendMsgSize int
+
+	// GRPCKeepaliveMinTime is the minimum interval between the keepalive
+	// pings of the clients, which are disconnected when pinging more often.
+	GRPCKeepaliveMinTime time.Duration
+	// GRPCKeepalivePermitWithoutStream allows the clients to ping without
+	// calls in progress.
+	GRPCKeepalivePermitWithoutStream bool
+
+	// HTTPEndpoint is the address of the HTTP server serving the health
+	// checks and the metrics. Empty disables the server.
+	HTTPEndpoint string
+
+	// OTLPEndpoint is the URL of the OTLP gRPC endpoint the traces are
+	// exported to. Empty disables the tracing.
+	OTLPEndpoint string
+
+	// ShutdownTimeout is the maximum time given to the calls in progress
+	// to complete on shutdown.
+	ShutdownTimeout time.Duration
+
+	// CloudStackConfigReloadInterval is the interval between two reads
+	// of the CloudStack configuration file, to pick up rotated keys.
+	CloudStackConfigReloadInterval time.Duration
+
+	// APIRetry* tune the retries of the CloudStack API calls failing
+	// with a transient error.
+	APIRetryAttempts int
+	APIRetryDelay    time.Duration
+	APIRetryJitter   float64
+
+	// AsyncJob* tune the polling of the CloudStack async jobs, e.g.
+	// volume creations and attachments.
+	AsyncJobPollInterval time.Duration
+	AsyncJobTimeout      time.Duration
+
+	// API* limit the CloudStack API calls, queued when over the limits.
+	APIMaxInFlight int
+	APIQPS         float64
+	APIBurst       int
+
+	// #### Controller options #####
+
+	// ClusterID identifies the cluster in the tags of the volumes it creates.
+	ClusterID string
+
+	// RequireTags makes volume creation fail when the volume cannot be tagged.
+	RequireTags bool
+
+	// DefaultDiskOffering is the ID of the disk offering of the volumes
+	// whose parameters do not select one, e.g. without storage class.
+	DefaultDiskOffering string
+
+	// MaxCustomVolumeSize is the maximum size in GB of the volumes of
+	// customized disk offerings, as set in CloudStack.
+	MaxCustomVolumeSize int64
+
+	// MinVolumeSize and MaxVolumeSize bound the size in bytes of the
+	// created and expanded volumes, once rounded up to GB, or are 0.
+	MinVolumeSize int64
+	MaxVolumeSize int64
+
+	// VolumeNamePrefix is prepended to the names of the created volumes.
+	VolumeNamePrefix string
+
+	// MaxVolumeNameLength is the length above which the names of the
+	// created volumes are shortened.
+	MaxVolumeNameLength int
+
+	// ZoneCacheTTL is the time during which the list of zones is reused.
+	ZoneCacheTTL time.Duration
+
+	// *Timeout bound the CloudStack async jobs of the operations, which
+	// then fail with DeadlineExceeded. 0 waits until the request deadline.
+	CreateVolumeTimeout time.Duration
+	DeleteVolumeTimeout time.Duration
+	AttachTimeout       time.Duration
+	SnapshotTimeout     time.Duration
+
+	// DetachTimeout bounds the wait for the detachment of a volume, after
+	// which ControllerUnpublishVolume fails with Aborted. 0 disables it.
+	DetachTimeout time.Duration
+
+	// ForceDetach issues the detachment of the volumes of VMs that are
+	// not running again, by volume ID, once DetachTimeout expired.
+	ForceDetach bool
+
+	// ProgressEventInterval is how often an event is emitted on the
+	// PersistentVolume of a volume while it is attached or detached.
+	// 0 disables the events.
+	ProgressEventInterval time.Duration
+
+	// FirstDeviceID is the first device ID tried when attaching a volume,
+	// the next ones being tried when it is in use. 0 lets CloudStack
+	// choose the device ID.
+	FirstDeviceID int64
+
+	// Probe* tune the CloudStack API checks of the Probe calls.
+	ProbeTimeout          time.Duration
+	ProbeInterval         time.Duration
+	ProbeFailureThreshold int
+
 	// #### Node options #####
 
+	// NodeID is the CloudStack VM ID of the node, looked up in metadata when not set.
+	NodeID string
+
 	// NodeName is used to retrieve the node instance ID in case metadata lookup fails.
 	NodeName string
 
+	// DefaultFSType is the filesystem of the mount volumes whose
+	// capability does not set one.
+	DefaultFSType string
+
+	// TopologySegments are the optional topology segments, pod and
+	// cluster, reported by the node in addition to its zone.
+	TopologySegments []string
+
 	// VolumeAttachLimit specifies the value that shall be reported as "maximum number of attachable volumes"
 	// in CSINode objects. It is similar to https://kubernetes.io/docs/concepts/storage/storage-limits/#custom-limits
 	// which allowed administrators to specify custom volume limits by configuring the kube-scheduler.
 	VolumeAttachLimit int64
+
+	// ReservedVolumeAttachments is the number of disk slots not available to
+	// volumes, used when VolumeAttachLimit is not set.
+	ReservedVolumeAttachments int64
+
+	// DiskIDPath overrides the directory in which volumes are looked up,
+	// for when the host /dev is mounted elsewhere in the container.
+	DiskIDPath string
+
+	// Hypervisor selects how volume IDs are translated to disk serials.
+	// The hypervisor of the node is detected when empty.
+	Hypervisor string
+
+	// DiskIDPrefixes overrides the prefixes used to find volumes in /dev/disk/by-id.
+	// This is needed for hypervisors other than KVM, which name disks differently.
+	DiskIDPrefixes []string
+
+	// DevicePathBackoff* tune how long the node waits for the device of
+	// an attached volume to appear.
+	DevicePathBackoffDuration time.Duration
+	DevicePathBackoffFactor   float64
+	DevicePathBackoffSteps    int
+
+	// DeviceWaitTimeout bounds the wait for the device of a volume in
+	// NodeStageVolume, whatever the backoff. 0 disables it.
+	DeviceWaitTimeout time.Duration
+
+	// DeviceSettleDelay is how long the node waits before checking again
+	// that a missing device is gone.
+	DeviceSettleDelay time.Duration
+
+	// EnableMultipath enables the discovery of volumes exposed through multipath devices.
+	EnableMultipath bool
+
+	// RefuseFormatMismatch prevents mounting devices that hold a filesystem
+	// other than the one requested for the volume.
+	RefuseFormatMismatch bool
+
+	// VerifyDeviceAfterFormat checks that the device of a volume is
+	// readable once formatted and mounted.
+	VerifyDeviceAfterFormat bool
+
+	// ReconcileVolumeSize grows the filesystems of the volumes whose
+	// device is larger, e.g. after they were expanded out of band.
+	ReconcileVolumeSize bool
+
+	// CacheDevicePaths caches the device paths of the volumes.
+	CacheDevicePaths bool
+
+	// UdevadmPath overrides the udevadm command run when waiting for devices.
+	UdevadmPath string
+
+	// ProcPath and SysPath are where the procfs and the sysfs of the host are mounted.
+	ProcPath string
+	SysPath  string
+
+	// DisableUdevadm prevents running udevadm when waiting for devices.
+	DisableUdevadm bool
+
+	// DisableSCSIRescan prevents rescanning the SCSI hosts when waiting for devices.
+	DisableSCSIRescan bool
+
+	// DryRun only logs the changes the node would make to volumes, for diagnostics.
+	DryRun bool
+
+	// PreflightFailFast makes the node fail to start when the preflight
+	// checks of its commands and devices fail, instead of only logging.
+	PreflightFailFast bool
 }
 
 func (o *Options) AddFlags(f *flag.FlagSet) {
 	// Server options
 	f.StringVar(&o.Endpoint, "endpoint", DefaultCSIEndpoint, "Endpoint for the CSI driver server")
 	f.StringVar(&o.CloudStackConfig, "cloudstack-config", "./cloud-config", "Path to CloudStack configuration file")
+	f.IntVar(&o.GRPCLogVerbosity, "grpc-log-verbosity", DefaultGRPCLogVerbosity, "Verbosity (as in --v) of the logs of the CSI calls, with their duration and result. Failed calls are always logged.")
+	f.IntVar(&o.GRPCMaxRecvMsgSize, "grpc-max-recv-msg-size", DefaultGRPCMaxMsgSize, "Maximum size in bytes of the messages received by the gRPC server.")
+	f.IntVar(&o.GRPCMaxSendMsgSize, "grpc-max-send-msg-size", DefaultGRPCMaxMsgSize, "Maximum size in bytes of the messages sent by the gRPC server, e.g. ListVolumes responses.")
+	f.DurationVar(&o.GRPCKeepaliveMinTime, "grpc-keepalive-min-time", DefaultGRPCKeepaliveMinTime, "Minimum interval between the keepalive pings of the gRPC clients, which are disconnected when pinging more often.")
+	f.BoolVar(&o.GRPCKeepalivePermitWithoutStream, "grpc-keepalive-permit-without-stream", true, "Allow the gRPC clients to send keepalive pings without calls in progress, as idle sidecars do.")
+	f.StringVar(&o.HTTPEndpoint, "http-endpoint", "", "Address, e.g. :8080, of the HTTP server serving /healthz, reporting whether the driver is ready, and the Prometheus metrics on /metrics. Empty disables the server.")
+	f.StringVar(&o.OTLPEndpoint, "otlp-endpoint", "", "URL, e.g. http://otel-collector:4317, of the OpenTelemetry collector the traces of the CSI calls, CloudStack API calls and device lookups are exported to with OTLP over gRPC. Empty disables the tracing.")
+	f.DurationVar(&o.ShutdownTimeout, "shutdown-timeout", DefaultShutdownTimeout, "Maximum time given to the CSI calls in progress to complete on SIGTERM, new calls being refused.")
+	f.DurationVar(&o.CloudStackConfigReloadInterval, "cloudstack-config-reload-interval", time.Minute, "Interval between two reads of the CloudStack configuration file, to use its new API URLs and keys without a restart. 0 disables the reloads.")
+	f.IntVar(&o.APIRetryAttempts, "api-retry-attempts", cloud.DefaultRetryBackoff.Steps, "Maximum number of attempts of a CloudStack API call failing with a transient error. 1 disables the retries.")
+	f.DurationVar(&o.APIRetryDelay, "api-retry-delay", cloud.DefaultRetryBackoff.Duration, "Initial delay before retrying a CloudStack API call, doubled at each attempt.")
+	f.Float64Var(&o.APIRetryJitter, "api-retry-jitter", cloud.DefaultRetryBackoff.Jitter, "Maximum fraction of the delay randomly added to it, before retrying a CloudStack API call.")
+	f.DurationVar(&o.AsyncJobPollInterval, "async-job-poll-interval", cloud.DefaultAsyncJobPollInterval, "Delay between two polls of the result of a CloudStack async job.")
+	f.IntVar(&o.APIMaxInFlight, "api-max-in-flight", 0, "Maximum number of CloudStack API calls made at the same time. 0 disables the limit.")
+	f.Float64Var(&o.APIQPS, "api-qps", 0, "Maximum number of CloudStack API calls per second. 0 disables the limit.")
+	f.IntVar(&o.APIBurst, "api-burst", 10, "Number of CloudStack API calls allowed over --api-qps in bursts.")
+	f.DurationVar(&o.AsyncJobTimeout, "async-job-timeout", cloud.DefaultAsyncJobTimeout, "Maximum time to wait for a CloudStack async job to complete, if the request deadline is not earlier.")
+
+	// Controller options
+	if o.Mode == AllMode || o.Mode == ControllerMode {
+		f.StringVar(&o.ClusterID, "cluster-id", "", "Identifier of the cluster, added to the tags of the volumes it creates.")
+		f.StringVar(&o.DefaultDiskOffering, "default-disk-offering", "", "ID of the CloudStack disk offering of the volumes whose storage class does not set "+DiskOfferingKey+" or "+DiskOfferingNameKey+", e.g. PVCs without storage class.")
+		f.BoolVar(&o.RequireTags, "require-tags", false, "Fail to create a volume when it cannot be tagged, instead of only logging the error.")
+		f.Int64Var(&o.MaxCustomVolumeSize, "max-custom-volume-size", DefaultMaxCustomVolumeSize, "Maximum size in GB to which volumes of customized disk offerings may be expanded, the custom.diskoffering.size.max CloudStack setting. 0 disables the check.")
+		f.Int64Var(&o.MinVolumeSize, "min-volume-size", 0, "Minimum size in bytes of the created volumes, once rounded up to GB. 0 disables the check.")
+		f.Int64Var(&o.MaxVolumeSize, "max-volume-size", 0, "Maximum size in bytes of the created and expanded volumes, once rounded up to GB. 0 disables the check.")
+		f.StringVar(&o.VolumeNamePrefix, "volume-name-prefix", "", "Prefix of the names of the CloudStack volumes created for PersistentVolumes.")
+		f.IntVar(&o.MaxVolumeNameLength, "max-volume-name-length", DefaultMaxVolumeNameLength, "Maximum length of the names of the CloudStack volumes. Longer names are truncated and suffixed with a hash of the full name.")
+		f.DurationVar(&o.ZoneCacheTTL, "zone-cache-ttl", cloud.DefaultZoneCacheTTL, "Time during which the list of CloudStack zones is reused for the topology of the new volumes. 0 disables the cache.")
+		f.DurationVar(&o.CreateVolumeTimeout, "create-volume-timeout", 0, "Maximum time to wait for the creation of a volume, after which CreateVolume fails with DeadlineExceeded and is retried. 0 waits until the request deadline.")
+		f.DurationVar(&o.DeleteVolumeTimeout, "delete-volume-timeout", 0, "Maximum time to wait for the deletion of a volume, after which DeleteVolume fails with DeadlineExceeded and is retried. 0 waits until the request deadline.")
+		f.DurationVar(&o.AttachTimeout, "attach-timeout", 0, "Maximum time to wait for the attachment of a volume, after which ControllerPublishVolume fails with DeadlineExceeded and is retried. 0 waits until the request deadline.")
+		f.DurationVar(&o.SnapshotTimeout, "snapshot-timeout", 0, "Maximum time to wait for the creation or deletion of a snapshot, after which CreateSnapshot or DeleteSnapshot fails with DeadlineExceeded and is retried. 0 waits until the request deadline.")
+		f.DurationVar(&o.DetachTimeout, "detach-timeout", 0, "Maximum time to wait for the detachment of a volume, after which ControllerUnpublishVolume fails with Aborted and is retried. 0 waits until the request deadline.")
+		f.Int64Var(&o.FirstDeviceID, "first-device-id", 0, "First device ID, the slot of the disk in the VM, requested when attaching a volume. The next ones are requested while CloudStack reports them in use. 0 lets CloudStack choose the device ID.")
+		f.BoolVar(&o.ForceDetach, "force-detach", false, "Once --detach-timeout expired, issue the detachment again by volume ID if the VM is not running, e.g. stopped or on an unreachable host.")
+		f.DurationVar(&o.ProgressEventInterval, "progress-event-interval", 30*time.Second, "Interval at which an event telling the CloudStack job and the elapsed time is emitted on the PersistentVolume of a volume being attached or detached. 0 disables the events.")
+		f.DurationVar(&o.ProbeTimeout, "probe-timeout", DefaultProbeTimeout, "Maximum time given to the CloudStack API call checking the API on Probe calls.")
+		f.DurationVar(&o.ProbeInterval, "probe-interval", DefaultProbeInterval, "Time during which the result of a CloudStack API check is reused by the Probe calls.")
+		f.IntVar(&o.ProbeFailureThreshold, "probe-failure-threshold", DefaultProbeFailureThreshold, "Number of failed CloudStack API checks in a row after which the driver is reported not ready.")
+	}
 
 	// Node options
 	if o.Mode == AllMode || o.Mode == NodeMode {
+		f.StringVar(&o.NodeID, "node-id", "", "CloudStack VM ID of the node. Defaults to the ID found in the metadata server, cloud-init or ignition metadata, or the DMI system UUID.")
 		f.StringVar(&o.NodeName, "node-name", "", "Node name used to look up instance ID in case metadata lookup fails")
-		f.Int64Var(&o.VolumeAttachLimit, "volume-attach-limit", DefaultMaxVolAttachLimit, "Value for the maximum number of volumes attachable per node.")
+		f.StringVar(&o.DefaultFSType, "default-fstype", defaultFsType, "Filesystem of the volumes whose storage class does not set csi.storage.k8s.io/fstype: ext2, ext3, ext4, xfs or btrfs.")
+		f.StringSliceVar(&o.TopologySegments, "topology-segments", nil, "Comma-separated list of the topology segments reported by the node in addition to its zone: pod, cluster. Requires root administrator credentials.")
+		f.Int64Var(&o.VolumeAttachLimit, "volume-attach-limit", 0, "Value for the maximum number of volumes attachable per node. Defaults to the number of KVM disk slots, minus the reserved ones. May be overridden by the "+VolumeAttachLimitAnnotation+" node annotation.")
+		f.Int64Var(&o.ReservedVolumeAttachments, "reserved-volume-attachments", DefaultReservedVolumeAttachments, "Number of disk slots not available to volumes, when --volume-attach-limit is not set.")
+		f.StringVar(&o.DiskIDPath, "disk-id-path", "", "Directory holding the disk symlinks by id, used to find attached volumes. Defaults to /dev/disk/by-id.")
+		f.StringVar(&o.Hypervisor, "hypervisor", "", "Hypervisor of the node, selecting how volume IDs are translated to disk serials: kvm or xenserver. Defaults to the hypervisor of the node VM in CloudStack, or kvm.")
+		f.StringSliceVar(&o.DiskIDPrefixes, "disk-id-prefixes", nil, "Comma-separated list of /dev/disk/by-id prefixes used to find attached volumes. Defaults to the KVM prefixes.")
+		f.DurationVar(&o.DevicePathBackoffDuration, "device-path-backoff-duration", mount.DefaultDevicePathBackoff.Duration, "Initial delay between two lookups of the device of an attached volume.")
+		f.Float64Var(&o.DevicePathBackoffFactor, "device-path-backoff-factor", mount.DefaultDevicePathBackoff.Factor, "Factor by which the delay between two device lookups is multiplied.")
+		f.IntVar(&o.DevicePathBackoffSteps, "device-path-backoff-steps", mount.DefaultDevicePathBackoff.Steps, "Maximum number of lookups of the device of an attached volume.")
+		f.DurationVar(&o.DeviceSettleDelay, "device-settle-delay", time.Second, "Time to wait before checking again that the device of a mounted volume is missing, so that devices briefly removed by udev are neither reported as gone nor forcibly unmounted. 0 disables the second check.")
+		f.DurationVar(&o.DeviceWaitTimeout, "device-wait-timeout", 0, "Maximum time NodeStageVolume waits for the device of an attached volume, after which it fails with DeadlineExceeded and is retried by the kubelet. 0 only stops after the --device-path-backoff-steps lookups.")
+		f.BoolVar(&o.EnableMultipath, "enable-multipath", false, "Look up attached volumes among the device-mapper multipath devices.")
+		f.BoolVar(&o.CacheDevicePaths, "cache-device-paths", false, "Cache the /dev/disk/by-id paths of the volumes, to avoid scanning /dev again for volumes already found, until they are unstaged.")
+		f.StringVar(&o.UdevadmPath, "udevadm-path", "udevadm", "Path of the udevadm command run after a SCSI rescan.")
+		f.StringVar(&o.ProcPath, "proc-path", mount.DefaultProcPath, "Path where the procfs of the host is mounted, to read its mount table.")
+		f.StringVar(&o.SysPath, "sys-path", mount.DefaultSysPath, "Path where the sysfs of the host is mounted, to find and rescan devices.")
+		f.BoolVar(&o.DisableUdevadm, "disable-udevadm", false, "Do not run udevadm after a SCSI rescan, for node images without udev.")
+		f.BoolVar(&o.DisableSCSIRescan, "disable-scsi-rescan", false, "Do not rescan every SCSI host when waiting for devices, relying on udevadm only, for hosts where the rescan disturbs other disks.")
+		f.BoolVar(&o.DryRun, "dry-run", false, "Only log the format, mount and unmount operations the node would do, without doing them. Meant for diagnostics.")
+		f.BoolVar(&o.RefuseFormatMismatch, "refuse-format-mismatch", false, "Fail to stage a volume whose device already holds a filesystem other than the requested one.")
+		f.BoolVar(&o.VerifyDeviceAfterFormat, "verify-device-after-format", false, "Read the superblock of the device of a volume and flush it once formatted and mounted, failing to stage the volume when the device is unreadable.")
+		f.BoolVar(&o.ReconcileVolumeSize, "reconcile-volume-size", false, "Grow the filesystem of a staged volume whose device is larger, e.g. after the volume was expanded in CloudStack without Kubernetes, when the volume is staged again.")
+		f.BoolVar(&o.PreflightFailFast, "preflight-fail-fast", false, "Fail to start when the node lacks a command or directory needed to stage volumes, e.g. blkid, udevadm, mkfs.<fstype> or /dev/disk/by-id, instead of only logging it.")
 	}
 }
 
 func (o *Options) Validate() error {
+	if o.GRPCLogVerbosity < 0 {
+		return errors.New("invalid --grpc-log-verbosity specified, must not be negative")
+	}
+	if o.GRPCMaxRecvMsgSize < 1 {
+		return errors.New("invalid --grpc-max-recv-msg-size specified, must be positive")
+	}
+	if o.GRPCMaxSendMsgSize < 1 {
+		return errors.New("invalid --grpc-max-send-msg-size specified, must be positive")
+	}
+	if o.GRPCKeepaliveMinTime < 0 {
+		return errors.New("invalid --grpc-keepalive-min-time specified, must not be negative")
+	}
+	if o.OTLPEndpoint != "" {
+		if u, err := url.Parse(o.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid --otlp-endpoint specified: %q is not an http or https URL", o.OTLPEndpoint)
+		}
+	}
+	if o.ShutdownTimeout < 0 {
+		return errors.New("invalid --shutdown-timeout specified, must not be negative")
+	}
+	if o.CloudStackConfigReloadInterval < 0 {
+		return errors.New("invalid --cloudstack-config-reload-interval specified, must not be negative")
+	}
+	if o.APIRetryAttempts < 1 {
+		return errors.New("invalid --api-retry-attempts specified, must be at least 1")
+	}
+	if o.APIRetryDelay <= 0 {
+		return errors.New("invalid --api-retry-delay specified, must be positive")
+	}
+	if o.APIRetryJitter < 0 {
+		return errors.New("invalid --api-retry-jitter specified, must not be negative")
+	}
+	if o.AsyncJobPollInterval <= 0 {
+		return errors.New("invalid --async-job-poll-interval specified, must be positive")
+	}
+	if o.AsyncJobTimeout <= 0 {
+		return errors.New("invalid --async-job-timeout specified, must be positive")
+	}
+	if o.APIMaxInFlight < 0 {
+		return errors.New("invalid --api-max-in-flight specified, must not be negative")
+	}
+	if o.APIQPS < 0 {
+		return errors.New("invalid --api-qps specified, must not be negative")
+	}
+	if o.APIBurst < 1 {
+		return errors.New("invalid --api-burst specified, must be at least 1")
+	}
+	if o.Mode == AllMode || o.Mode == ControllerMode {
+		if o.MaxCustomVolumeSize < 0 {
+			return errors.New("invalid --max-custom-volume-size specified, must not be negative")
+		}
+		if o.MinVolumeSize < 0 {
+			return errors.New("invalid --min-volume-size specified, must not be negative")
+		}
+		if o.MaxVolumeSize < 0 || (o.MaxVolumeSize > 0 && o.MaxVolumeSize < util.GigaBytesToBytes(1)) {
+			return errors.New("invalid --max-volume-size specified, must be 0 or at least 1 GB")
+		}
+		if o.MaxVolumeSize > 0 && util.GigaBytesToBytes(util.RoundUpBytesToGB(o.MinVolumeSize)) > o.MaxVolumeSize {
+			return errors.New("invalid --min-volume-size specified, once rounded up to GB it must not exceed --max-volume-size")
+		}
+		if o.MaxVolumeNameLength < minVolumeNameLength {
+			return fmt.Errorf("invalid --max-volume-name-length specified, must be at least %d", minVolumeNameLength)
+		}
+		if len(o.VolumeNamePrefix) >= o.MaxVolumeNameLength-volumeNameHashLength {
+			return errors.New("invalid --volume-name-prefix specified, too long for --max-volume-name-length")
+		}
+		if o.ZoneCacheTTL < 0 {
+			return errors.New("invalid --zone-cache-ttl specified, must not be negative")
+		}
+		if o.FirstDeviceID < 0 {
+			return errors.New("invalid --first-device-id specified, must not be negative")
+		}
+		if o.CreateVolumeTimeout < 0 {
+			return errors.New("invalid --create-volume-timeout specified, must not be negative")
+		}
+		if o.DeleteVolumeTimeout < 0 {
+			return errors.New("invalid --delete-volume-timeout specified, must not be negative")
+		}
+		if o.AttachTimeout < 0 {
+			return errors.New("invalid --attach-timeout specified, must not be negative")
+		}
+		if o.SnapshotTimeout < 0 {
+			return errors.New("invalid --snapshot-timeout specified, must not be negative")
+		}
+		if o.DetachTimeout < 0 {
+			return errors.New("invalid --detach-timeout specified, must not be negative")
+		}
+		if o.ForceDetach && o.DetachTimeout == 0 {
+			return errors.New("--force-detach requires --detach-timeout")
+		}
+		if o.ProgressEventInterval < 0 {
+			return errors.New("invalid --progress-event-interval specified, must not be negative")
+		}
+		if o.ProbeTimeout < 0 {
+			return errors.New("invalid --probe-timeout specified, must not be negative")
+		}
+		if o.ProbeInterval < 0 {
+			return errors.New("invalid --probe-interval specified, must not be negative")
+		}
+		if o.ProbeFailureThreshold < 1 {
+			return errors.New("invalid --probe-failure-threshold specified, must be at least 1")
+		}
+	}
 	if o.Mode == AllMode || o.Mode == NodeMode {
-		if o.VolumeAttachLimit < 1 || o.VolumeAttachLimit > 256 {
-			return errors.New("invalid --volume-attach-limit specified, allowed range is 1 to 256")
+		if o.VolumeAttachLimit < 0 || o.VolumeAttachLimit > DefaultMaxVolAttachLimit {
+			return errors.New("invalid --volume-attach-limit specified, allowed range is 0 (the default limit) to 256")
+		}
+		if o.ReservedVolumeAttachments < 0 || o.ReservedVolumeAttachments >= DefaultVolumeAttachSlots {
+			return errors.New("invalid --reserved-volume-attachments specified, allowed range is 0 to 23")
+		}
+		if o.DevicePathBackoffSteps < 1 {
+			return errors.New("invalid --device-path-backoff-steps specified, must be at least 1")
+		}
+		if o.DevicePathBackoffDuration <= 0 {
+			return errors.New("invalid --device-path-backoff-duration specified, must be positive")
+		}
+		if o.DevicePathBackoffFactor < 1 {
+			return errors.New("invalid --device-path-backoff-factor specified, must be at least 1")
+		}
+		if o.DeviceSettleDelay < 0 {
+			return errors.New("invalid --device-settle-delay specified, must not be negative")
+		}
+		if o.DeviceWaitTimeout < 0 {
+			return errors.New("invalid --device-wait-timeout specified, must not be negative")
+		}
+		if _, ok := mount.SerialFuncs[strings.ToLower(o.Hypervisor)]; !ok && o.Hypervisor != "" {
+			return fmt.Errorf("invalid --hypervisor specified: %q", o.Hypervisor)
+		}
+		if _, ok := ValidFSTypes[o.DefaultFSType]; !ok && o.DefaultFSType != "" {
+			return fmt.Errorf("invalid --default-fstype specified: %q", o.DefaultFSType)
+		}
+		if _, err := parseTopologySegments(strings.Join(o.TopologySegments, ",")); err != nil {
+			return fmt.Errorf("invalid --topology-segments specified: %w", err)
 		}
 	}
 
@@ -0,0 +1,40 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"k8s.io/klog/v2"
+
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/mount"
+)
+
+// NodePreflight checks that the node provides the commands and devices
+// needed to stage volumes, logging what is missing and how to fix it.
+// The problems are returned only when failFast is set, the driver
+// otherwise starting anyway, as volumes may not need what is missing.
+func NodePreflight(ctx context.Context, mounter mount.Interface, failFast bool) error {
+	logger := klog.FromContext(ctx)
+
+	fsTypes := make([]string, 0, len(ValidFSTypes))
+	for fsType := range ValidFSTypes {
+		fsTypes = append(fsTypes, fsType)
+	}
+	slices.Sort(fsTypes)
+
+	errs := mounter.Preflight(fsTypes)
+	for _, err := range errs {
+		logger.Error(err, "Node preflight check failed")
+	}
+	if len(errs) == 0 {
+		logger.V(4).Info("Node preflight checks passed")
+
+		return nil
+	}
+	if !failFast {
+		return nil
+	}
+
+	return errors.Join(errs...)
+}
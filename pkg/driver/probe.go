@@ -0,0 +1,88 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud"
+)
+
+// Defaults of the CloudStack API checks of the Probe calls.
+const (
+	DefaultProbeTimeout          = 5 * time.Second
+	DefaultProbeInterval         = 30 * time.Second
+	DefaultProbeFailureThreshold = 3
+)
+
+// apiProber checks that the CloudStack API can be reached, for the Probe
+// calls. The result of a check is reused for interval, so that frequent
+// probes do not load the management server, and the API is only reported
+// unreachable after failureThreshold failed checks in a row.
+type apiProber struct {
+	connector        cloud.Interface
+	timeout          time.Duration
+	interval         time.Duration
+	failureThreshold int
+
+	mu        sync.Mutex
+	lastCheck time.Time
+	failures  int
+	lastErr   error
+}
+
+func newAPIProber(connector cloud.Interface, options *Options) *apiProber {
+	return &apiProber{
+		connector:        connector,
+		timeout:          options.ProbeTimeout,
+		interval:         options.ProbeInterval,
+		failureThreshold: max(options.ProbeFailureThreshold, 1),
+	}
+}
+
+// check returns an error when the API could not be reached by the last
+// failureThreshold checks.
+func (p *apiProber) check(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Since(p.lastCheck) >= p.interval {
+		p.lastCheck = time.Now()
+		if err := p.ping(ctx); err != nil {
+			p.failures++
+			p.lastErr = err
+			klog.FromContext(ctx).Error(err, "CloudStack API check failed", "failures", p.failures)
+		} else {
+			p.failures = 0
+			p.lastErr = nil
+		}
+	}
+	if p.failures >= p.failureThreshold {
+		return fmt.Errorf("CloudStack API unreachable for %d checks: %w", p.failures, p.lastErr)
+	}
+
+	return nil
+}
+
+// ping calls the API, giving up after timeout. The API calls cannot be
+// canceled, so a call that takes longer keeps running in the background.
+func (p *apiProber) ping(ctx context.Context) error {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- p.connector.Ping(ctx)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,103 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud"
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud/fake"
+)
+
+// pingConnector fails the API checks while err is set, and counts them.
+type pingConnector struct {
+	cloud.Interface
+	err   error
+	delay time.Duration
+	calls int
+}
+
+func (c *pingConnector) Ping(ctx context.Context) error {
+	c.calls++
+	if c.delay > 0 {
+		select {
+		case <-time.After(c.delay):
+		case <-ctx.Done():
+		}
+	}
+
+	return c.err
+}
+
+func probeReady(t *testing.T, d *cloudstackDriver) bool {
+	t.Helper()
+	resp, err := d.Probe(context.Background(), &csi.ProbeRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return resp.GetReady().GetValue()
+}
+
+func TestProbe(t *testing.T) {
+	t.Run("reachable", func(t *testing.T) {
+		connector := &pingConnector{Interface: fake.New()}
+		d := &cloudstackDriver{prober: newAPIProber(connector, &Options{ProbeFailureThreshold: 1})}
+		if !probeReady(t, d) {
+			t.Error("expected driver to be ready")
+		}
+		if connector.calls != 1 {
+			t.Errorf("expected 1 API check, got %d", connector.calls)
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		connector := &pingConnector{Interface: fake.New(), err: errors.New("connection refused")}
+		d := &cloudstackDriver{prober: newAPIProber(connector, &Options{ProbeFailureThreshold: 3})}
+		for i := 1; i < 3; i++ {
+			if !probeReady(t, d) {
+				t.Errorf("expected driver to be ready after %d failures", i)
+			}
+		}
+		if probeReady(t, d) {
+			t.Error("expected driver not to be ready after 3 failures")
+		}
+
+		// Recovered.
+		connector.err = nil
+		if !probeReady(t, d) {
+			t.Error("expected driver to be ready")
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		connector := &pingConnector{Interface: fake.New(), delay: time.Minute}
+		d := &cloudstackDriver{prober: newAPIProber(connector, &Options{ProbeTimeout: 10 * time.Millisecond, ProbeFailureThreshold: 1})}
+		if probeReady(t, d) {
+			t.Error("expected driver not to be ready")
+		}
+	})
+
+	t.Run("cached", func(t *testing.T) {
+		connector := &pingConnector{Interface: fake.New(), err: errors.New("connection refused")}
+		d := &cloudstackDriver{prober: newAPIProber(connector, &Options{ProbeInterval: time.Hour, ProbeFailureThreshold: 1})}
+		for i := 0; i < 3; i++ {
+			if probeReady(t, d) {
+				t.Error("expected driver not to be ready")
+			}
+		}
+		if connector.calls != 1 {
+			t.Errorf("expected 1 API check, got %d", connector.calls)
+		}
+	})
+
+	t.Run("node", func(t *testing.T) {
+		d := &cloudstackDriver{}
+		if !probeReady(t, d) {
+			t.Error("expected driver to be ready")
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud"
+)
+
+// Reasons of the events reporting the progress of slow operations.
+const (
+	attachInProgressReason = "AttachInProgress"
+	detachInProgressReason = "DetachInProgress"
+)
+
+// progressReporter emits events on the PersistentVolumes of the volumes
+// whose attachment or detachment takes long, telling the CloudStack job
+// and how long it has been running, as the PVCs otherwise only show
+// that they are pending.
+type progressReporter struct {
+	kubeClient kubernetes.Interface
+	recorder   record.EventRecorder
+	interval   time.Duration
+}
+
+// newProgressReporter creates a progressReporter emitting events every
+// interval. It returns nil, reporting nothing, when interval is 0 or the
+// driver does not run in a Kubernetes cluster.
+func newProgressReporter(interval time.Duration) *progressReporter {
+	if interval == 0 {
+		return nil
+	}
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil
+	}
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		klog.ErrorS(err, "Failed to create Kubernetes client, the progress of attachments is not reported")
+
+		return nil
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+
+	return &progressReporter{
+		kubeClient: kubeClient,
+		recorder:   broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: DriverName}),
+		interval:   interval,
+	}
+}
+
+// start reports every interval that the operation on the volume, told by
+// message, is still running, until the returned function is called. The
+// CloudStack job of the operation is the one waited for with the returned
+// context.
+func (r *progressReporter) start(ctx context.Context, volumeID, reason, message string) (context.Context, func()) {
+	if r == nil {
+		return ctx, func() {}
+	}
+
+	var jobID atomic.Pointer[string]
+	ctx = cloud.WithJobReporter(ctx, func(id string) { jobID.Store(&id) })
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		logger := klog.FromContext(ctx)
+		start := time.Now()
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		var pv *corev1.PersistentVolume
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			if pv == nil {
+				var err error
+				if pv, err = r.findPersistentVolume(ctx, volumeID); err != nil {
+					logger.V(4).Info("Cannot find the PersistentVolume of the volume, not reporting progress", "volumeID", volumeID, "err", err)
+
+					return
+				}
+			}
+			job := "not started yet"
+			if id := jobID.Load(); id != nil {
+				job = *id
+			}
+			r.recorder.Eventf(pv, corev1.EventTypeNormal, reason, "%s: CloudStack job %s, running for %v",
+				message, job, time.Since(start).Round(time.Second))
+		}
+	}()
+
+	return ctx, func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// findPersistentVolume returns the PersistentVolume of the volume.
+func (r *progressReporter) findPersistentVolume(ctx context.Context, volumeID string) (*corev1.PersistentVolume, error) {
+	pvs, err := r.kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range pvs.Items {
+		if csi := pvs.Items[i].Spec.CSI; csi != nil && csi.Driver == DriverName && csi.VolumeHandle == volumeID {
+			return &pvs.Items[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("no PersistentVolume of driver %s has volume handle %s", DriverName, volumeID)
+}
@@ -0,0 +1,111 @@
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud"
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud/fake"
+)
+
+const slowJobID = "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+
+// slowConnector attaches and detaches volumes through a slow CloudStack job.
+type slowConnector struct {
+	cloud.Interface
+	delay time.Duration
+}
+
+func (c *slowConnector) AttachVolume(ctx context.Context, volumeID, vmID string) (string, error) {
+	cloud.ReportJob(ctx, slowJobID)
+	time.Sleep(c.delay)
+
+	return c.Interface.AttachVolume(ctx, volumeID, vmID)
+}
+
+func (c *slowConnector) DetachVolume(ctx context.Context, volumeID string) error {
+	cloud.ReportJob(ctx, slowJobID)
+	time.Sleep(c.delay)
+
+	return c.Interface.DetachVolume(ctx, volumeID)
+}
+
+func TestProgressEvents(t *testing.T) {
+	const (
+		volumeID = "ace9f28b-3081-40c1-8353-4cc3e3014072"
+		nodeID   = "0d7107a3-94d2-44e7-89b8-8930881309a5"
+	)
+	ctx := context.Background()
+	recorder := record.NewFakeRecorder(100)
+	cs := NewControllerServer(&slowConnector{Interface: fake.New(), delay: 100 * time.Millisecond}, &Options{}).(*controllerServer)
+	cs.progress = &progressReporter{
+		kubeClient: kubefake.NewSimpleClientset(&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pvc-6b6f3a0e-4a3c-4b7e-9f6a-0c1d2e3f4a5b"},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeSource: corev1.PersistentVolumeSource{
+					CSI: &corev1.CSIPersistentVolumeSource{Driver: DriverName, VolumeHandle: volumeID},
+				},
+			},
+		}),
+		recorder: recorder,
+		interval: 20 * time.Millisecond,
+	}
+
+	// assertEvents checks that the slow job was reported at least once,
+	// and nothing more once the operation completed.
+	assertEvents := func(reason string) {
+		t.Helper()
+		var events []string
+		for len(recorder.Events) > 0 {
+			events = append(events, <-recorder.Events)
+		}
+		if len(events) == 0 {
+			t.Fatalf("expected %s events", reason)
+		}
+		for _, e := range events {
+			if !strings.HasPrefix(e, "Normal "+reason+" ") || !strings.Contains(e, "CloudStack job "+slowJobID+", running for") {
+				t.Errorf("unexpected event %q", e)
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		if len(recorder.Events) > 0 {
+			t.Errorf("unexpected event %q once the operation completed", <-recorder.Events)
+		}
+	}
+
+	_, err := cs.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{
+		VolumeId: volumeID,
+		NodeId:   nodeID,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEvents(attachInProgressReason)
+
+	if _, err := cs.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{VolumeId: volumeID, NodeId: nodeID}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEvents(detachInProgressReason)
+}
+
+func TestProgressEventsFastJob(t *testing.T) {
+	recorder := record.NewFakeRecorder(100)
+	r := &progressReporter{kubeClient: kubefake.NewSimpleClientset(), recorder: recorder, interval: time.Hour}
+	_, stop := r.start(context.Background(), "ace9f28b-3081-40c1-8353-4cc3e3014072", attachInProgressReason, "Attaching volume")
+	stop()
+	if len(recorder.Events) > 0 {
+		t.Errorf("unexpected event %q", <-recorder.Events)
+	}
+}
@@ -0,0 +1,64 @@
+package driver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultShutdownTimeout is the maximum time given to the calls in
+// progress to complete on shutdown, within the default termination grace
+// period of the pods.
+const DefaultShutdownTimeout = 25 * time.Second
+
+// drainer tracks the CSI calls in progress, so that they can complete
+// on shutdown instead of being interrupted in the middle of CloudStack
+// async jobs. Once draining, new calls are refused.
+type drainer struct {
+	mu       sync.Mutex
+	draining bool
+	active   sync.WaitGroup
+}
+
+// unaryInterceptor returns a unary server interceptor tracking the calls,
+// and refusing them with an Unavailable error, retried by the COs, once
+// draining.
+func (d *drainer) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		d.mu.Lock()
+		if d.draining {
+			d.mu.Unlock()
+
+			return nil, status.Error(codes.Unavailable, "Driver shutting down")
+		}
+		d.active.Add(1)
+		d.mu.Unlock()
+		defer d.active.Done()
+
+		return handler(ctx, req)
+	}
+}
+
+// drain refuses new calls, and waits until the calls in progress
+// complete or ctx is done.
+func (d *drainer) drain(ctx context.Context) error {
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.active.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
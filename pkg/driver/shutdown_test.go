@@ -0,0 +1,175 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud"
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud/fake"
+)
+
+// blockingConnector blocks volume creations until release is closed.
+type blockingConnector struct {
+	cloud.Interface
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingConnector) CreateVolume(ctx context.Context, diskOfferingID, zoneID, name string, sizeInGB int64) (string, error) {
+	close(c.started)
+	<-c.release
+
+	return c.Interface.CreateVolume(ctx, diskOfferingID, zoneID, name, sizeInGB)
+}
+
+func TestDrainer(t *testing.T) {
+	d := &drainer{}
+	interceptor := d.unaryInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	inFlight := make(chan error, 1)
+	go func() {
+		_, err := interceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+			close(started)
+			<-release
+
+			return nil, nil
+		})
+		inFlight <- err
+	}()
+	<-started
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- d.drain(context.Background())
+	}()
+	// Wait for the drain to start.
+	for {
+		d.mu.Lock()
+		draining := d.draining
+		d.mu.Unlock()
+		if draining {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err := interceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		t.Error("unexpected call while draining")
+
+		return nil, nil
+	})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable error, got %v", err)
+	}
+	select {
+	case <-drained:
+		t.Fatal("expected drain to wait for the call in progress")
+	default:
+	}
+
+	close(release)
+	if err := <-inFlight; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := <-drained; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDrainerTimeout(t *testing.T) {
+	d := &drainer{}
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	go func() {
+		_, _ = d.unaryInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{}, func(context.Context, interface{}) (interface{}, error) {
+			close(started)
+			<-release
+
+			return nil, nil
+		})
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := d.drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded error, got %v", err)
+	}
+}
+
+func TestRunShutdown(t *testing.T) {
+	connector := &blockingConnector{Interface: fake.New(), started: make(chan struct{}), release: make(chan struct{})}
+	endpoint := "unix://" + filepath.Join(t.TempDir(), "csi.sock")
+	d, err := New(context.Background(), connector, &Options{
+		Mode:            ControllerMode,
+		Endpoint:        endpoint,
+		ShutdownTimeout: time.Minute,
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- d.Run(ctx)
+	}()
+
+	conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	client := csi.NewControllerClient(conn)
+
+	created := make(chan error, 1)
+	go func() {
+		_, err := client.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name: "pvc-shutdown",
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+			Parameters: map[string]string{DiskOfferingKey: "9743fd77-0f5d-4ef9-b2f8-f194235c769c"},
+		}, grpc.WaitForReady(true))
+		created <- err
+	}()
+	<-connector.started
+
+	cancel()
+	// New calls are refused while the volume creation completes.
+	for {
+		_, err := client.ControllerGetCapabilities(context.Background(), &csi.ControllerGetCapabilitiesRequest{})
+		if status.Code(err) == codes.Unavailable {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-stopped:
+		t.Fatal("expected the driver to wait for the call in progress")
+	default:
+	}
+
+	close(connector.release)
+	if err := <-created; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -2,14 +2,20 @@ package driver
 
 import (
 	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 )
 
 // Topology represents CloudStack storage topology.
 type Topology struct {
-	ZoneID string
-	HostID string
+	ZoneID    string
+	HostID    string
+	PodID     string
+	ClusterID string
 }
 
 // NewTopology converts a *csi.Topology to Topology.
@@ -23,9 +29,13 @@ func NewTopology(t *csi.Topology) (Topology, error) {
 	if !ok {
 		return Topology{}, errors.New("no zone in topology")
 	}
-	hostID := segments[HostKey]
 
-	return Topology{zoneID, hostID}, nil
+	return Topology{
+		ZoneID:    zoneID,
+		HostID:    segments[HostKey],
+		PodID:     segments[PodKey],
+		ClusterID: segments[ClusterKey],
+	}, nil
 }
 
 // ToCSI converts a Topology to a *csi.Topology.
@@ -35,8 +45,116 @@ func (t Topology) ToCSI() *csi.Topology {
 	if t.HostID != "" {
 		segments[HostKey] = t.HostID
 	}
+	if t.PodID != "" {
+		segments[PodKey] = t.PodID
+	}
+	if t.ClusterID != "" {
+		segments[ClusterKey] = t.ClusterID
+	}
 
 	return &csi.Topology{
 		Segments: segments,
 	}
 }
+
+// Specific errors.
+var (
+	ErrNoZoneAvailable = errors.New("no zone available")
+	ErrNoMatchingZone  = errors.New("no available zone matches the topology requirement")
+)
+
+// requisiteZones returns the zones of the requisite topologies of
+// requirement, or nil if there are none.
+func requisiteZones(requirement *csi.TopologyRequirement) ([]string, error) {
+	var zones []string
+	for _, t := range requirement.GetRequisite() {
+		topology, err := NewTopology(t)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse topology requirements: %w", err)
+		}
+		zones = append(zones, topology.ZoneID)
+	}
+
+	return zones, nil
+}
+
+// pickZone chooses the zone of a new volume among the available zones,
+// honoring the requirement: the first preferred topology that is also
+// requisite wins, then the first requisite topology. Without
+// requirement, a random zone is chosen.
+func pickZone(requirement *csi.TopologyRequirement, available []string) (string, error) {
+	topology, err := pickTopology(requirement, available)
+
+	return topology.ZoneID, err
+}
+
+// pickTopology chooses the topology of a new volume like pickZone, and
+// returns all the segments of the chosen topology.
+func pickTopology(requirement *csi.TopologyRequirement, available []string) (Topology, error) {
+	if len(available) == 0 {
+		return Topology{}, ErrNoZoneAvailable
+	}
+
+	requisite, err := requisiteZones(requirement)
+	if err != nil {
+		return Topology{}, err
+	}
+	accepts := func(zoneID string) bool {
+		return slices.Contains(available, zoneID) && (len(requisite) == 0 || slices.Contains(requisite, zoneID))
+	}
+
+	for _, t := range requirement.GetPreferred() {
+		topology, err := NewTopology(t)
+		if err != nil {
+			return Topology{}, fmt.Errorf("cannot parse topology preferences: %w", err)
+		}
+		if accepts(topology.ZoneID) {
+			return topology, nil
+		}
+	}
+	for _, t := range requirement.GetRequisite() {
+		// Already parsed by requisiteZones.
+		topology, _ := NewTopology(t)
+		if accepts(topology.ZoneID) {
+			return topology, nil
+		}
+	}
+	if len(requisite) > 0 || len(requirement.GetPreferred()) > 0 {
+		return Topology{}, ErrNoMatchingZone
+	}
+
+	return Topology{ZoneID: available[rand.Intn(len(available))]}, nil //nolint:gosec
+}
+
+// parseTopologySegments parses a comma-separated list of optional
+// topology segments.
+func parseTopologySegments(s string) ([]string, error) {
+	var segments []string
+	for _, segment := range strings.Split(s, ",") {
+		segment = strings.TrimSpace(segment)
+		switch segment {
+		case "":
+			continue
+		case PodSegment, ClusterSegment:
+			segments = append(segments, segment)
+		default:
+			return nil, fmt.Errorf("unknown topology segment %q, must be %s or %s", segment, PodSegment, ClusterSegment)
+		}
+	}
+
+	return segments, nil
+}
+
+// restrict returns the topology with its zone, and only those of its
+// optional segments that are listed.
+func (t Topology) restrict(segments []string) Topology {
+	restricted := Topology{ZoneID: t.ZoneID}
+	if slices.Contains(segments, PodSegment) {
+		restricted.PodID = t.PodID
+	}
+	if slices.Contains(segments, ClusterSegment) {
+		restricted.ClusterID = t.ClusterID
+	}
+
+	return restricted
+}
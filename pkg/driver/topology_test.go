@@ -0,0 +1,99 @@
+package driver
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func zoneTopology(zoneID string) *csi.Topology {
+	return Topology{ZoneID: zoneID}.ToCSI()
+}
+
+func TestPickZone(t *testing.T) {
+	available := []string{"zone-a", "zone-b", "zone-c"}
+	cases := []struct {
+		name        string
+		requirement *csi.TopologyRequirement
+		available   []string
+		expected    string
+		expectedErr error
+	}{
+		{
+			name:        "requisite",
+			requirement: &csi.TopologyRequirement{Requisite: []*csi.Topology{zoneTopology("zone-b")}},
+			expected:    "zone-b",
+		},
+		{
+			name: "preferred among requisite",
+			requirement: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{zoneTopology("zone-a"), zoneTopology("zone-c")},
+				Preferred: []*csi.Topology{zoneTopology("zone-c"), zoneTopology("zone-a")},
+			},
+			expected: "zone-c",
+		},
+		{
+			name: "preferred not requisite",
+			requirement: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{zoneTopology("zone-a")},
+				Preferred: []*csi.Topology{zoneTopology("zone-b")},
+			},
+			expected: "zone-a",
+		},
+		{
+			name:        "only preferred",
+			requirement: &csi.TopologyRequirement{Preferred: []*csi.Topology{zoneTopology("zone-x"), zoneTopology("zone-b")}},
+			expected:    "zone-b",
+		},
+		{
+			name:        "first available requisite",
+			requirement: &csi.TopologyRequirement{Requisite: []*csi.Topology{zoneTopology("zone-x"), zoneTopology("zone-c")}},
+			expected:    "zone-c",
+		},
+		{
+			name:        "no matching zone",
+			requirement: &csi.TopologyRequirement{Requisite: []*csi.Topology{zoneTopology("zone-x")}},
+			expectedErr: ErrNoMatchingZone,
+		},
+		{
+			name:        "no zone available",
+			requirement: &csi.TopologyRequirement{Requisite: []*csi.Topology{zoneTopology("zone-a")}},
+			available:   []string{},
+			expectedErr: ErrNoZoneAvailable,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			zones := available
+			if c.available != nil {
+				zones = c.available
+			}
+			zoneID, err := pickZone(c.requirement, zones)
+			if !errors.Is(err, c.expectedErr) {
+				t.Fatalf("expected error %v, got %v", c.expectedErr, err)
+			}
+			if zoneID != c.expected {
+				t.Errorf("expected zone %q, got %q", c.expected, zoneID)
+			}
+		})
+	}
+
+	t.Run("no requirement", func(t *testing.T) {
+		zoneID, err := pickZone(nil, available)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !slices.Contains(available, zoneID) {
+			t.Errorf("expected one of %v, got %q", available, zoneID)
+		}
+	})
+
+	t.Run("invalid topology", func(t *testing.T) {
+		requirement := &csi.TopologyRequirement{Requisite: []*csi.Topology{{Segments: map[string]string{HostKey: "host"}}}}
+		if _, err := pickZone(requirement, available); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
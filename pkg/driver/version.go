@@ -29,6 +29,9 @@ var (
 	buildDate     string
 )
 
+// unknownVersion is the version of the builds without version.
+const unknownVersion = "unknown"
+
 type VersionInfo struct {
 	DriverVersion string `json:"driverVersion"`
 	GitCommit     string `json:"gitCommit"`
@@ -49,6 +52,21 @@ func GetVersion() VersionInfo {
 	}
 }
 
+// vendorVersion returns the version of the driver reported to the COs,
+// with the commit it was built from as build metadata, e.g.
+// v0.6.0+0123abc.
+func vendorVersion() string {
+	version := driverVersion
+	if version == "" {
+		version = unknownVersion
+	}
+	if gitCommit != "" {
+		version += "+" + gitCommit
+	}
+
+	return version
+}
+
 func GetVersionJSON() (string, error) {
 	info := GetVersion()
 	marshaled, err := json.MarshalIndent(&info, "", "  ")
@@ -17,10 +17,13 @@ limitations under the License.
 package driver
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"runtime"
 	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
 )
 
 func TestGetVersion(t *testing.T) {
@@ -59,3 +62,38 @@ func TestGetVersionJSON(t *testing.T) {
 		t.Fatalf("json not equal\ngot:\n%s\nexpected:\n%s", version, expected)
 	}
 }
+
+func TestGetPluginInfo(t *testing.T) {
+	defer func(version, commit string) {
+		driverVersion, gitCommit = version, commit
+	}(driverVersion, gitCommit)
+
+	tests := []struct {
+		name     string
+		version  string
+		commit   string
+		expected string
+	}{
+		{"no build metadata", "", "", "unknown"},
+		{"version", "v0.6.0", "", "v0.6.0"},
+		{"version and commit", "v0.6.0", "0123abc", "v0.6.0+0123abc"},
+		{"commit", "", "0123abc", "unknown+0123abc"},
+	}
+
+	d := &cloudstackDriver{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driverVersion, gitCommit = tt.version, tt.commit
+			resp, err := d.GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.GetName() != DriverName {
+				t.Errorf("expected name %q, got %q", DriverName, resp.GetName())
+			}
+			if resp.GetVendorVersion() != tt.expected {
+				t.Errorf("expected version %q, got %q", tt.expected, resp.GetVendorVersion())
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// devicePathCache remembers the device paths of the volumes, so
+// that looking them up again does not scan /dev nor rescan the SCSI
+// hosts. Only paths naming the serial of the volume may be cached: the
+// names such as vdb are reused by the next volume attached in the same
+// slot. It is safe for concurrent use. A nil *devicePathCache is valid,
+// and caches nothing.
+type devicePathCache struct {
+	mu    sync.Mutex
+	paths map[string]string
+}
+
+func newDevicePathCache() *devicePathCache {
+	return &devicePathCache{paths: make(map[string]string)}
+}
+
+// get returns the cached device path of volumeID, if it still exists.
+// Paths which disappeared, because the volume was detached, are evicted.
+func (c *devicePathCache) get(volumeID string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	path, ok := c.paths[volumeID]
+	if !ok {
+		return "", false
+	}
+	if _, err := os.Lstat(path); err != nil {
+		delete(c.paths, volumeID)
+
+		return "", false
+	}
+
+	return path, true
+}
+
+func (c *devicePathCache) set(volumeID, path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.paths[volumeID] = path
+}
+
+// delete forgets the device path of volumeID.
+func (c *devicePathCache) delete(volumeID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.paths, volumeID)
+}
+
+// evict forgets the device paths leading to device, and the ones leading
+// nowhere anymore.
+func (c *devicePathCache) evict(device string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for volumeID, path := range c.paths {
+		if _, err := filepath.EvalSymlinks(path); err != nil || sameDevice(path, device) {
+			delete(c.paths, volumeID)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+	kexec "k8s.io/utils/exec"
+)
+
+const (
+	mapperPath = "/dev/mapper"
+
+	// luksMapperPrefix is prepended to the name of the device-mapper
+	// devices opened by the driver, so they can be told apart from
+	// other mappings on the node.
+	luksMapperPrefix = "luks-"
+)
+
+// EncryptedDevicePath returns the path of the device-mapper device
+// used to access the LUKS container of the volume.
+func (m *mounter) EncryptedDevicePath(volumeID string) string {
+	return filepath.Join(m.mapperPath, luksMapperName(volumeID))
+}
+
+// luksMapperName names the device-mapper device of a volume after its
+// ID, as device names such as vdb are reused by other volumes.
+func luksMapperName(volumeID string) string {
+	return luksMapperPrefix + volumeID
+}
+
+// FormatAndMountWithEncryption opens (and if needed creates) a LUKS
+// container on source, the device of the volume, using passphrase, then
+// formats and mounts the resulting device-mapper device at target.
+func (m *mounter) FormatAndMountWithEncryption(volumeID, source, target, fstype string, options, formatOptions []string, passphrase string) error {
+	if passphrase == "" {
+		return errors.New("empty encryption passphrase")
+	}
+	if m.skipDryRun("encrypt, format and mount", "source", source, "target", target, "fstype", fstype, "options", options, "formatOptions", formatOptions) {
+		return nil
+	}
+
+	mapperDevice, err := m.openEncryptedDevice(volumeID, source, passphrase)
+	if err != nil {
+		return err
+	}
+
+	return m.FormatAndMountWithFormatOptions(mapperDevice, target, fstype, options, formatOptions)
+}
+
+// openEncryptedDevice opens the LUKS container of the volume on
+// devicePath and returns the path of the device-mapper device. The
+// container is created first if devicePath is blank. Devices that are
+// already opened on devicePath are reused as is; the ones opened on
+// another device, e.g. before the volume was attached again, are closed
+// first, unless mounted.
+func (m *mounter) openEncryptedDevice(volumeID, devicePath, passphrase string) (string, error) {
+	name := luksMapperName(volumeID)
+	mapperDevice := m.EncryptedDevicePath(volumeID)
+
+	if _, err := os.Stat(mapperDevice); err == nil {
+		backingDevice, err := m.encryptedBackingDevice(name)
+		if err != nil {
+			return "", err
+		}
+		if sameDevice(backingDevice, devicePath) {
+			klog.V(4).InfoS("LUKS device already opened", "device", devicePath, "mapperDevice", mapperDevice)
+
+			return mapperDevice, nil
+		}
+		mounted, err := m.isDeviceMounted(mapperDevice)
+		if err != nil {
+			return "", err
+		}
+		if mounted {
+			return "", fmt.Errorf("LUKS device %s is opened on %s instead of %s, and mounted", mapperDevice, backingDevice, devicePath)
+		}
+		klog.InfoS("LUKS device opened on another device, closing it", "mapperDevice", mapperDevice, "backingDevice", backingDevice, "device", devicePath)
+		if err := m.closeEncryptedDevice(mapperDevice); err != nil {
+			return "", err
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to check if %s exists: %w", mapperDevice, err)
+	}
+
+	isLuks, err := m.isLuks(devicePath)
+	if err != nil {
+		return "", err
+	}
+	if !isLuks {
+		// Never encrypt a device holding anything, it would destroy its data.
+		existingFormat, err := m.GetDiskFormat(devicePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to get disk format of %s: %w", devicePath, err)
+		}
+		if existingFormat != "" {
+			return "", fmt.Errorf("device %s is not a LUKS device and already contains %q", devicePath, existingFormat)
+		}
+
+		klog.InfoS("Formatting device as LUKS", "device", devicePath)
+		if output, err := m.cryptsetup(passphrase, "-q", "luksFormat", "--type", "luks2", "--key-file", "-", devicePath); err != nil {
+			return "", fmt.Errorf("luksFormat of %s failed: %w, output: %s", devicePath, err, output)
+		}
+	}
+
+	klog.V(4).InfoS("Opening LUKS device", "device", devicePath, "name", name)
+	if output, err := m.cryptsetup(passphrase, "luksOpen", "--key-file", "-", devicePath, name); err != nil {
+		return "", fmt.Errorf("luksOpen of %s failed: %w, output: %s", devicePath, err, output)
+	}
+
+	return mapperDevice, nil
+}
+
+// encryptedVolumeID returns the ID of the volume whose LUKS device is
+// mapperDevice, if opened by the driver.
+func (m *mounter) encryptedVolumeID(mapperDevice string) (string, bool) {
+	if filepath.Dir(mapperDevice) != m.mapperPath {
+		return "", false
+	}
+
+	return strings.CutPrefix(filepath.Base(mapperDevice), luksMapperPrefix)
+}
+
+// closeEncryptedDevice closes the given device-mapper device, if it
+// was opened by the driver.
+func (m *mounter) closeEncryptedDevice(mapperDevice string) error {
+	if _, ok := m.encryptedVolumeID(mapperDevice); !ok {
+		return nil
+	}
+
+	klog.V(4).InfoS("Closing LUKS device", "mapperDevice", mapperDevice)
+	if output, err := m.cryptsetup("", "luksClose", filepath.Base(mapperDevice)); err != nil {
+		return fmt.Errorf("luksClose of %s failed: %w, output: %s", mapperDevice, err, output)
+	}
+
+	return nil
+}
+
+// CloseEncryptedVolume closes the LUKS device left open for the volume,
+// e.g. by a crash between the unmount and the close, unless mounted.
+func (m *mounter) CloseEncryptedVolume(volumeID string) error {
+	mapperDevice := m.EncryptedDevicePath(volumeID)
+	if _, err := os.Stat(mapperDevice); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to check if %s exists: %w", mapperDevice, err)
+	}
+
+	mounted, err := m.isDeviceMounted(mapperDevice)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		klog.V(4).InfoS("LUKS device still mounted, leaving it open", "volumeID", volumeID, "mapperDevice", mapperDevice)
+
+		return nil
+	}
+	if m.skipDryRun("close LUKS device", "mapperDevice", mapperDevice) {
+		return nil
+	}
+
+	return m.closeEncryptedDevice(mapperDevice)
+}
+
+// ResizeEncryptedVolume grows the LUKS device of the volume to the size of
+// the device it was opened on, once expanded, and returns its path, or an
+// empty string if the volume has no LUKS device. The passphrase, if not
+// empty, is given to cryptsetup, which needs it for the LUKS2 containers
+// whose key is kept in the kernel keyring.
+func (m *mounter) ResizeEncryptedVolume(volumeID, passphrase string) (string, error) {
+	mapperDevice := m.EncryptedDevicePath(volumeID)
+	if _, err := os.Stat(mapperDevice); os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to check if %s exists: %w", mapperDevice, err)
+	}
+	if m.skipDryRun("resize LUKS device", "mapperDevice", mapperDevice) {
+		return mapperDevice, nil
+	}
+
+	args := []string{"resize"}
+	if passphrase != "" {
+		args = append(args, "--key-file", "-")
+	}
+	klog.V(4).InfoS("Resizing LUKS device", "mapperDevice", mapperDevice)
+	if output, err := m.cryptsetup(passphrase, append(args, luksMapperName(volumeID))...); err != nil {
+		return "", fmt.Errorf("resize of %s failed: %w, output: %s", mapperDevice, err, output)
+	}
+
+	return mapperDevice, nil
+}
+
+// encryptedBackingDevice returns the device the LUKS device named name
+// was opened on.
+func (m *mounter) encryptedBackingDevice(name string) (string, error) {
+	output, err := m.cryptsetup("", "status", name)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the status of LUKS device %s: %w, output: %s", name, err, output)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if device, ok := strings.CutPrefix(strings.TrimSpace(line), "device:"); ok {
+			return strings.TrimSpace(device), nil
+		}
+	}
+
+	return "", fmt.Errorf("no device in the status of LUKS device %s: %s", name, output)
+}
+
+// sameDevice tells whether the paths a and b lead to the same device.
+func sameDevice(a, b string) bool {
+	if resolved, err := filepath.EvalSymlinks(a); err == nil {
+		a = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(b); err == nil {
+		b = resolved
+	}
+
+	return a == b
+}
+
+func (m *mounter) isLuks(devicePath string) (bool, error) {
+	_, err := m.cryptsetup("", "isLuks", devicePath)
+	if err == nil {
+		return true, nil
+	}
+	var exitErr kexec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitStatus() == 1 {
+		return false, nil
+	}
+
+	return false, fmt.Errorf("failed to check if %s is a LUKS device: %w", devicePath, err)
+}
+
+// cryptsetup runs cryptsetup with the given arguments. If not empty, the
+// passphrase is written to its standard input, so it never appears in
+// the process list.
+func (m *mounter) cryptsetup(passphrase string, args ...string) ([]byte, error) {
+	cmd := m.Exec.Command("cryptsetup", args...)
+	if passphrase != "" {
+		cmd.SetStdin(strings.NewReader(passphrase))
+	}
+
+	return cmd.CombinedOutput()
+}
@@ -3,6 +3,8 @@ package mount
 import (
 	"context"
 	"os"
+	"path/filepath"
+	"slices"
 
 	"k8s.io/mount-utils"
 	exec "k8s.io/utils/exec/testing"
@@ -27,6 +29,53 @@ func NewFake() Interface {
 	}
 }
 
+func (m *fakeMounter) ApplyFSGroup(_ string, _ int64, _ FSGroupChangePolicy) error {
+	return nil
+}
+
+func (m *fakeMounter) BindBlockDevice(source, target string, options []string) error {
+	return bindBlockDevice(m, source, target, options)
+}
+
+func (m *fakeMounter) CheckMountHealth(path string) (MountHealth, error) {
+	mountPoints, err := m.List()
+	if err != nil {
+		return "", err
+	}
+	for _, mp := range mountPoints {
+		if mp.Path != path {
+			continue
+		}
+		if slices.Contains(mp.Opts, "ro") {
+			return MountReadOnly, nil
+		}
+
+		return MountHealthy, nil
+	}
+
+	return MountNotMounted, nil
+}
+
+func (m *fakeMounter) CheckDiskUUID(_, _ string) error {
+	return nil
+}
+
+func (m *fakeMounter) EncryptedDevicePath(volumeID string) string {
+	return filepath.Join(mapperPath, luksMapperName(volumeID))
+}
+
+func (m *fakeMounter) ForceCleanupMountPoint(path string) error {
+	return mount.CleanupMountPoint(path, m, false)
+}
+
+func (m *fakeMounter) FormatAndMountWithEncryption(volumeID, _, target, fstype string, options, formatOptions []string, _ string) error {
+	return m.FormatAndMountWithFormatOptions(m.EncryptedDevicePath(volumeID), target, fstype, options, formatOptions)
+}
+
+func (m *fakeMounter) FormatAndMountWithFormatOptions(source, target, fstype string, options, formatOptions []string) error {
+	return m.FormatAndMountSensitiveWithFormatOptions(source, target, fstype, options, nil, formatOptions)
+}
+
 func (m *fakeMounter) GetBlockSizeBytes(_ string) (int64, error) {
 	return 1073741824, nil
 }
@@ -35,6 +84,10 @@ func (m *fakeMounter) GetDevicePath(_ context.Context, _ string) (string, error)
 	return "/dev/sdb", nil
 }
 
+func (m *fakeMounter) GetDevicePathByDeviceID(ctx context.Context, volumeID, _, _ string) (string, error) {
+	return m.GetDevicePath(ctx, volumeID)
+}
+
 func (m *fakeMounter) GetDeviceName(mountPath string) (string, int, error) {
 	return mount.GetDeviceNameFromMount(m, mountPath)
 }
@@ -74,6 +127,10 @@ func (*fakeMounter) MakeFile(pathname string) error {
 	return nil
 }
 
+func (m *fakeMounter) GetDiskUUID(_ string) (string, error) {
+	return "", nil
+}
+
 func (m *fakeMounter) GetStatistics(_ string) (volumeStatistics, error) {
 	return volumeStatistics{
 		AvailableBytes: 3 * giB,
@@ -90,14 +147,38 @@ func (m *fakeMounter) IsBlockDevice(_ string) (bool, error) {
 	return false, nil
 }
 
-func (m *fakeMounter) IsCorruptedMnt(_ error) bool {
-	return false
+func (m *fakeMounter) IsCorruptedMnt(err error) bool {
+	return mount.IsCorruptedMnt(err)
+}
+
+func (m *fakeMounter) MountWithProjectQuota(source, target, fstype string, options []string, _ uint32, _ int64) error {
+	if fstype == "xfs" {
+		options = projectQuotaOptions(options)
+	}
+
+	return m.Mount(source, target, fstype, options)
 }
 
 func (m *fakeMounter) NeedResize(_ string, _ string) (bool, error) {
 	return false, nil
 }
 
+func (m *fakeMounter) ReleaseOrphanedDevices(_ []string, _ bool) ([]string, error) {
+	return nil, nil
+}
+
+func (m *fakeMounter) Preflight(_ []string) []error {
+	return nil
+}
+
+func (m *fakeMounter) SetHypervisor(_ string) error {
+	return nil
+}
+
+func (m *fakeMounter) RescanDevice(_ string) error {
+	return nil
+}
+
 func (m *fakeMounter) Resize(_ string, _ string) (bool, error) {
 	return true, nil
 }
@@ -106,6 +187,14 @@ func (m *fakeMounter) Unpublish(path string) error {
 	return m.Unstage(path)
 }
 
+func (*fakeMounter) ResizeEncryptedVolume(_, _ string) (string, error) {
+	return "", nil
+}
+
+func (*fakeMounter) CloseEncryptedVolume(_ string) error {
+	return nil
+}
+
 func (m *fakeMounter) Unstage(path string) error {
 	return mount.CleanupMountPoint(path, m, true)
 }
@@ -0,0 +1,119 @@
+package mount
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"k8s.io/klog/v2"
+)
+
+// FSGroupChangePolicy defines when the ownership of a volume
+// is changed by ApplyFSGroup. The values are the ones of the
+// fsGroupChangePolicy field of the pod security context.
+type FSGroupChangePolicy string
+
+const (
+	// FSGroupChangeAlways always changes the ownership of every file.
+	FSGroupChangeAlways FSGroupChangePolicy = "Always"
+	// FSGroupChangeOnRootMismatch only changes the ownership of the files
+	// when the root directory of the volume does not match the expected
+	// ownership and permissions.
+	FSGroupChangeOnRootMismatch FSGroupChangePolicy = "OnRootMismatch"
+
+	// rwMask and roMask are the permissions given to the group on files.
+	rwMask = os.FileMode(0o660)
+	roMask = os.FileMode(0o440)
+	// execMask is the permission given to the group on directories
+	// and on files already executable by their owner.
+	execMask = os.FileMode(0o110)
+)
+
+// ApplyFSGroup gives the group gid ownership of the volume mounted at
+// path, the way the kubelet does it for pods with a fsGroup: files become
+// readable and writable by the group, and directories get the setgid bit
+// so new files inherit the group. Block volumes are left untouched.
+func (m *mounter) ApplyFSGroup(path string, gid int64, policy FSGroupChangePolicy) error {
+	isBlock, err := m.IsBlockDevice(path)
+	if err != nil {
+		return fmt.Errorf("failed to determine if %s is a block device: %w", path, err)
+	}
+	if isBlock {
+		return nil
+	}
+
+	if policy == FSGroupChangeOnRootMismatch {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !fsGroupMismatch(info, gid) {
+			klog.V(4).InfoS("Skipping fsGroup change, the volume root already matches", "path", path, "gid", gid)
+
+			return nil
+		}
+	}
+
+	if m.skipDryRun("apply fsGroup", "path", path, "gid", gid, "policy", policy) {
+		return nil
+	}
+
+	klog.V(4).InfoS("Applying fsGroup", "path", path, "gid", gid, "policy", policy)
+
+	return filepath.WalkDir(path, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Following symlinks could change files outside the volume.
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		return changeFSGroup(name, info, gid)
+	})
+}
+
+// fsGroupMismatch reports whether the ownership and permissions of the
+// volume root info do not match what ApplyFSGroup sets.
+func fsGroupMismatch(info fs.FileInfo, gid int64) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || int64(stat.Gid) != gid {
+		return true
+	}
+	mode := info.Mode()
+	expected := rwMask | execMask
+
+	return mode&os.ModeSetgid == 0 || mode.Perm()&expected != expected
+}
+
+func changeFSGroup(name string, info fs.FileInfo, gid int64) error {
+	if err := os.Lchown(name, -1, int(gid)); err != nil {
+		return fmt.Errorf("failed to change group of %s: %w", name, err)
+	}
+
+	mode := info.Mode()
+	mask := rwMask
+	if mode.Perm()&0o200 == 0 {
+		// Read-only files stay read-only.
+		mask = roMask
+	}
+	if info.IsDir() || mode.Perm()&0o100 != 0 {
+		mask |= execMask
+	}
+	newMode := mode.Perm() | mask
+	if info.IsDir() {
+		newMode |= os.ModeSetgid
+	}
+
+	if err := os.Chmod(name, newMode|mode&(os.ModeSetuid|os.ModeSticky)); err != nil {
+		return fmt.Errorf("failed to change permissions of %s: %w", name, err)
+	}
+
+	return nil
+}
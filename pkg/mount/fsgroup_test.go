@@ -0,0 +1,93 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// createFSGroupTree creates a volume holding a directory, a file and
+// a symlink to a file outside of the volume.
+func createFSGroupTree(t *testing.T, rootMode os.FileMode) (root, outside string) {
+	t.Helper()
+
+	root = t.TempDir()
+	outside = filepath.Join(t.TempDir(), "outside")
+	for _, f := range []struct {
+		path string
+		mode os.FileMode
+	}{
+		{outside, 0o600},
+		{filepath.Join(root, "file"), 0o600},
+		{filepath.Join(root, "dir", "file"), 0o400},
+	} {
+		if err := os.MkdirAll(filepath.Dir(f.path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f.path, nil, f.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(root, rootMode); err != nil {
+		t.Fatal(err)
+	}
+
+	return root, outside
+}
+
+func assertMode(t *testing.T, path string, expected os.FileMode) {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Mode() & (os.ModePerm | os.ModeSetgid); got != expected {
+		t.Errorf("%s: expected mode %v, got %v", path, expected, got)
+	}
+}
+
+func TestApplyFSGroup(t *testing.T) {
+	gid := int64(os.Getgid())
+
+	for _, policy := range []FSGroupChangePolicy{FSGroupChangeAlways, FSGroupChangeOnRootMismatch} {
+		t.Run(string(policy), func(t *testing.T) {
+			m := newTestMounter(t, Options{})
+			root, outside := createFSGroupTree(t, 0o755)
+
+			if err := m.ApplyFSGroup(root, gid, policy); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			assertMode(t, root, os.ModeSetgid|0o775)
+			assertMode(t, filepath.Join(root, "file"), 0o660)
+			assertMode(t, filepath.Join(root, "dir"), os.ModeSetgid|0o770)
+			assertMode(t, filepath.Join(root, "dir", "file"), 0o440)
+			assertMode(t, outside, 0o600)
+		})
+	}
+}
+
+func TestApplyFSGroupOnRootMatch(t *testing.T) {
+	gid := int64(os.Getgid())
+
+	for policy, expected := range map[FSGroupChangePolicy]os.FileMode{
+		FSGroupChangeAlways:         0o660,
+		FSGroupChangeOnRootMismatch: 0o600,
+	} {
+		t.Run(string(policy), func(t *testing.T) {
+			m := newTestMounter(t, Options{})
+			// The root already has the expected group and permissions.
+			root, _ := createFSGroupTree(t, os.ModeSetgid|0o770)
+
+			if err := m.ApplyFSGroup(root, gid, policy); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			assertMode(t, filepath.Join(root, "file"), expected)
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"k8s.io/mount-utils"
+)
+
+// MountHealth is the state of a mount point, as seen by CheckMountHealth.
+type MountHealth string
+
+const (
+	// MountHealthy means the mount point is mounted and responsive.
+	MountHealthy MountHealth = "healthy"
+	// MountNotMounted means nothing is mounted at the mount point.
+	MountNotMounted MountHealth = "not mounted"
+	// MountCorrupted means the mount point is mounted, but returns
+	// errors such as EIO or ESTALE, or does not answer at all.
+	MountCorrupted MountHealth = "corrupted"
+	// MountReadOnly means the mount point is healthy, but mounted
+	// read-only, e.g. remounted by the kernel after I/O errors.
+	MountReadOnly MountHealth = "read-only"
+	// MountDeviceMissing means the device of the mount point
+	// disappeared, e.g. because the volume was detached.
+	MountDeviceMissing MountHealth = "device missing"
+
+	// defaultHealthCheckTimeout is how long CheckMountHealth waits for
+	// a mount point to answer before considering it as hung.
+	defaultHealthCheckTimeout = 5 * time.Second
+)
+
+// CheckMountHealth tells whether path is mounted and responsive, and
+// whether its device still exists. The mount point is accessed in the
+// background, so that a hung mount makes CheckMountHealth return
+// MountCorrupted instead of blocking.
+func (m *mounter) CheckMountHealth(path string) (MountHealth, error) {
+	mountPoints, err := m.List()
+	if err != nil {
+		return "", fmt.Errorf("failed to list mount points: %w", err)
+	}
+	i := slices.IndexFunc(mountPoints, func(mp mount.MountPoint) bool { return mp.Path == path })
+	if i < 0 {
+		return MountNotMounted, nil
+	}
+	mp := mountPoints[i]
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.stat(path)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		switch {
+		case err == nil:
+		case m.IsCorruptedMnt(err):
+			return MountCorrupted, nil
+		default:
+			return "", fmt.Errorf("failed to check mount point %s: %w", path, err)
+		}
+	case <-time.After(m.healthCheckTimeout):
+		return MountCorrupted, nil
+	}
+
+	device := mp.Device
+	if mp.Type == devtmpfsType {
+		// Raw block volumes are bind mounts of their device file.
+		if device, _, err = m.getBoundDeviceName(path); err != nil {
+			return "", err
+		}
+	}
+	if strings.HasPrefix(device, devPath+"/") && m.deviceGone(device) {
+		m.devicePaths.evict(device)
+
+		return MountDeviceMissing, nil
+	}
+	if slices.Contains(mp.Opts, "ro") {
+		return MountReadOnly, nil
+	}
+
+	return MountHealthy, nil
+}
+
+// deviceGone tells whether device does not exist. A missing device is
+// looked up again after the settle delay, so that a device recreated by
+// udev in the meantime is not reported as gone.
+func (m *mounter) deviceGone(device string) bool {
+	if _, err := m.stat(device); !errors.Is(err, os.ErrNotExist) {
+		return false
+	}
+	if m.deviceSettleDelay <= 0 {
+		return true
+	}
+	time.Sleep(m.deviceSettleDelay)
+	_, err := m.stat(device)
+
+	return errors.Is(err, os.ErrNotExist)
+}
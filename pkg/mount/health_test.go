@@ -0,0 +1,138 @@
+package mount
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"k8s.io/mount-utils"
+)
+
+func TestCheckMountHealth(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	for name, c := range map[string]struct {
+		mounted  bool
+		opts     []string
+		stat     func(string) (os.FileInfo, error)
+		expected MountHealth
+	}{
+		"healthy": {
+			mounted:  true,
+			stat:     func(string) (os.FileInfo, error) { return nil, nil },
+			expected: MountHealthy,
+		},
+		"not mounted": {
+			stat: func(string) (os.FileInfo, error) {
+				t.Error("a path which is not mounted should not be accessed")
+
+				return nil, nil
+			},
+			expected: MountNotMounted,
+		},
+		"corrupted": {
+			mounted: true,
+			stat: func(path string) (os.FileInfo, error) {
+				return nil, &os.PathError{Op: "stat", Path: path, Err: syscall.ESTALE}
+			},
+			expected: MountCorrupted,
+		},
+		"read-only": {
+			mounted:  true,
+			opts:     []string{"ro", "relatime"},
+			stat:     func(string) (os.FileInfo, error) { return nil, nil },
+			expected: MountReadOnly,
+		},
+		"device missing": {
+			mounted: true,
+			stat: func(path string) (os.FileInfo, error) {
+				if path == "/dev/sdb" {
+					return nil, &os.PathError{Op: "stat", Path: path, Err: syscall.ENOENT}
+				}
+
+				return nil, nil
+			},
+			expected: MountDeviceMissing,
+		},
+		"hung": {
+			mounted: true,
+			stat: func(string) (os.FileInfo, error) {
+				<-release
+
+				return nil, nil
+			},
+			expected: MountCorrupted,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := newTestMounter(t, Options{})
+			var mountPoints []mount.MountPoint
+			if c.mounted {
+				mountPoints = append(mountPoints, mount.MountPoint{Device: "/dev/sdb", Path: "/staging", Opts: c.opts})
+			}
+			m.Interface = mount.NewFakeMounter(mountPoints)
+			m.stat = c.stat
+			m.healthCheckTimeout = 50 * time.Millisecond
+
+			health, err := m.CheckMountHealth("/staging")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if health != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, health)
+			}
+		})
+	}
+}
+
+func TestCheckMountHealthError(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	m.Interface = mount.NewFakeMounter([]mount.MountPoint{{Device: "/dev/sdb", Path: "/staging"}})
+	m.stat = func(path string) (os.FileInfo, error) {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: syscall.ENOENT}
+	}
+
+	if _, err := m.CheckMountHealth("/staging"); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestCheckMountHealthDeviceSettle(t *testing.T) {
+	for name, c := range map[string]struct {
+		// lookups is the number of lookups of the device after which
+		// it shows up again.
+		lookups  int
+		delay    time.Duration
+		expected MountHealth
+	}{
+		"reappeared":   {lookups: 1, delay: 10 * time.Millisecond, expected: MountHealthy},
+		"gone":         {lookups: 3, delay: 10 * time.Millisecond, expected: MountDeviceMissing},
+		"no delay set": {lookups: 1, expected: MountDeviceMissing},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := newTestMounter(t, Options{DeviceSettleDelay: c.delay})
+			m.Interface = mount.NewFakeMounter([]mount.MountPoint{{Device: "/dev/sdb", Path: "/staging"}})
+			lookups := 0
+			m.stat = func(path string) (os.FileInfo, error) {
+				if path != "/dev/sdb" {
+					return nil, nil
+				}
+				if lookups++; lookups <= c.lookups {
+					return nil, &os.PathError{Op: "stat", Path: path, Err: syscall.ENOENT}
+				}
+
+				return nil, nil
+			}
+
+			health, err := m.CheckMountHealth("/staging")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if health != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, health)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package mount
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "cloudstack_csi"
+	metricsSubsystem = "mounter"
+
+	operationFormatAndMount = "format_and_mount"
+	operationGetDevicePath  = "get_device_path"
+	operationResize         = "resize"
+)
+
+// metrics instruments the mounter. A nil *metrics is valid,
+// and records nothing.
+type metrics struct {
+	operationDuration *prometheus.HistogramVec
+	scsiRescans       prometheus.Counter
+}
+
+// newMetrics creates the mounter metrics and registers them in reg.
+// It returns nil if reg is nil.
+func newMetrics(reg prometheus.Registerer) *metrics {
+	if reg == nil {
+		return nil
+	}
+
+	m := &metrics{
+		operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of the mounter operations, in seconds.",
+			// Formatting a large volume, or waiting for a device, takes minutes.
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 15, 30, 60, 120, 300},
+		}, []string{"operation"}),
+		scsiRescans: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "scsi_rescans_total",
+			Help:      "Number of SCSI host rescans triggered while waiting for a device.",
+		}),
+	}
+	reg.MustRegister(m.operationDuration, m.scsiRescans)
+
+	return m
+}
+
+// observeDuration records the time elapsed since start for operation.
+// It is meant to be deferred.
+func (m *metrics) observeDuration(operation string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.operationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+func (m *metrics) incSCSIRescans() {
+	if m == nil {
+		return
+	}
+	m.scsiRescans.Inc()
+}
@@ -0,0 +1,75 @@
+package mount
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	m := newTestMounter(t, Options{
+		MetricsRegisterer: reg,
+		DevicePathBackoff: wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 3},
+	})
+
+	// The device is never found: three lookups, and as many rescans.
+	if _, err := m.GetDevicePath(context.Background(), testVolumeID); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := m.FormatAndMount("/dev/sdb", "/target", "ext4", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := testutil.ToFloat64(m.metrics.scsiRescans); got != 3 {
+		t.Errorf("expected 3 SCSI rescans, got %v", got)
+	}
+	for operation, expected := range map[string]int{
+		operationGetDevicePath:  1,
+		operationFormatAndMount: 1,
+		operationResize:         0,
+	} {
+		if got := histogramCount(t, reg, operation); got != expected {
+			t.Errorf("expected %d %s observations, got %d", expected, operation, got)
+		}
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	if m.metrics != nil {
+		t.Fatal("metrics should be disabled without registerer")
+	}
+
+	// Must not panic.
+	if err := m.FormatAndMount("/dev/sdb", "/target", "ext4", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func histogramCount(t *testing.T, reg prometheus.Gatherer, operation string) int {
+	t.Helper()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	for _, f := range families {
+		if f.GetName() != "cloudstack_csi_mounter_operation_duration_seconds" {
+			continue
+		}
+		for _, metric := range f.GetMetric() {
+			for _, l := range metric.GetLabel() {
+				if l.GetName() == "operation" && l.GetValue() == operation {
+					return int(metric.GetHistogram().GetSampleCount())
+				}
+			}
+		}
+	}
+
+	return 0
+}
@@ -4,48 +4,234 @@ package mount
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sys/unix"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 	"k8s.io/mount-utils"
 	kexec "k8s.io/utils/exec"
+
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/util"
 )
 
 const (
-	diskIDPath = "/dev/disk/by-id"
+	diskIDPath   = "/dev/disk/by-id"
+	diskPathPath = "/dev/disk/by-path"
+	devPath      = "/dev"
+
+	// DefaultProcPath and DefaultSysPath are where procfs and sysfs are
+	// mounted by default.
+	DefaultProcPath = "/proc"
+	DefaultSysPath  = "/sys"
+
+	devtmpfsType = "devtmpfs"
+	// deletedSuffix ends the root of the bind mounts in mountinfo once
+	// their source file is removed.
+	deletedSuffix = "//deleted"
+
+	nvmeIDPrefix      = "nvme-"
+	multipathIDPrefix = "dm-uuid-mpath-"
+
+	// udevSettleTimeout is the maximum number of seconds
+	// to wait for udev events to be processed.
+	udevSettleTimeout = 10
+
+	// serialMaxLength is the maximum length of a virtio disk serial.
+	serialMaxLength = 20
+
+	defaultUdevadmPath = "udevadm"
+
+	// defaultUnmountTimeout is how long ForceCleanupMountPoint waits for
+	// a regular unmount before forcing it.
+	defaultUnmountTimeout = 30 * time.Second
 )
 
+// DefaultDiskIDPrefixes are the prefixes of the /dev/disk/by-id entries
+// created for CloudStack volumes attached to a KVM hypervisor.
+var DefaultDiskIDPrefixes = []string{"virtio-", "scsi-", "scsi-0QEMU_QEMU_HARDDISK_"}
+
+// DefaultDevicePathBackoff is the backoff used by GetDevicePath
+// while waiting for the device of a volume to appear.
+var DefaultDevicePathBackoff = wait.Backoff{
+	Duration: 1 * time.Second,
+	Factor:   1.1,
+	Steps:    15,
+}
+
 // Interface defines the set of methods to allow for
 // mount operations on a system.
 type Interface interface { //nolint:interfacebloat
 	mount.Interface
 
+	ApplyFSGroup(path string, gid int64, policy FSGroupChangePolicy) error
+	BindBlockDevice(source, target string, options []string) error
+	CheckMountHealth(path string) (MountHealth, error)
+	CheckDiskUUID(devicePath, expectedUUID string) error
+	CloseEncryptedVolume(volumeID string) error
+	EncryptedDevicePath(volumeID string) string
+	ForceCleanupMountPoint(path string) error
 	FormatAndMount(source string, target string, fstype string, options []string) error
+	FormatAndMountWithEncryption(volumeID, source, target, fstype string, options, formatOptions []string, passphrase string) error
+	FormatAndMountWithFormatOptions(source, target, fstype string, options, formatOptions []string) error
 	GetBlockSizeBytes(devicePath string) (int64, error)
 	GetDevicePath(ctx context.Context, volumeID string) (string, error)
+	GetDevicePathByDeviceID(ctx context.Context, volumeID, deviceID, hypervisor string) (string, error)
 	GetDeviceName(mountPath string) (string, int, error)
+	GetDiskFormat(disk string) (string, error)
+	GetDiskUUID(devicePath string) (string, error)
 	GetStatistics(volumePath string) (volumeStatistics, error)
 	IsBlockDevice(devicePath string) (bool, error)
 	IsCorruptedMnt(err error) bool
 	MakeDir(pathname string) error
 	MakeFile(pathname string) error
+	MountWithProjectQuota(source, target, fstype string, options []string, projectID uint32, quotaBytes int64) error
 	NeedResize(devicePath string, deviceMountPath string) (bool, error)
 	PathExists(path string) (bool, error)
+	Preflight(fsTypes []string) []error
+	ReleaseOrphanedDevices(activeVolumeIDs []string, clean bool) ([]string, error)
+	RescanDevice(devicePath string) error
+	ResizeEncryptedVolume(volumeID, passphrase string) (string, error)
+	SetHypervisor(hypervisor string) error
 	Resize(devicePath, deviceMountPath string) (bool, error)
 	Unpublish(path string) error
 	Unstage(path string) error
 }
 
+// Specific errors.
+var (
+	// ErrDeviceNotFound is returned when the device of a volume
+	// did not show up in time: the volume may not be attached yet.
+	ErrDeviceNotFound = errors.New("device not found")
+	// ErrDeviceProbeFailed is returned when the device of a volume
+	// could not be looked up.
+	ErrDeviceProbeFailed = errors.New("device probe failed")
+
+	// errUnmountTimeout is returned when an unmount did not complete in
+	// time, e.g. as the device of the mount is gone.
+	errUnmountTimeout = errors.New("unmount timed out")
+)
+
+// Options contains the configuration settings of the mounter.
+type Options struct {
+	// DiskIDPath is the directory holding the disk symlinks by id.
+	// Defaults to /dev/disk/by-id when empty. It must be changed when
+	// the host /dev is mounted elsewhere in the node plugin container.
+	DiskIDPath string
+
+	// DiskIDPrefixes is the list of prefixes prepended to the disk serial
+	// when looking up a volume in /dev/disk/by-id.
+	// Defaults to DefaultDiskIDPrefixes when empty.
+	DiskIDPrefixes []string
+
+	// DevicePathBackoff is the backoff used by GetDevicePath while
+	// waiting for the device of a volume to appear.
+	// Defaults to DefaultDevicePathBackoff when Steps is zero.
+	DevicePathBackoff wait.Backoff
+
+	// Multipath enables the lookup of volumes exposed
+	// through device-mapper multipath devices.
+	Multipath bool
+
+	// RefuseFormatMismatch makes FormatAndMount return an error, instead
+	// of trying to mount it, when a device already holds a filesystem
+	// other than the requested one. It guards against attaching the
+	// wrong device to a volume.
+	RefuseFormatMismatch bool
+
+	// VerifyDevice makes FormatAndMount read the superblock area of the
+	// device and flush it once mounted, and unmount it and return an
+	// error if the device turns out to be unreadable, so that staging
+	// is retried instead of failing later in the pods.
+	VerifyDevice bool
+
+	// DryRun makes the mounter only log the changes it would make to the
+	// node (formatting, mounting, unmounting, creating files...), while
+	// still looking up devices for real. Meant for diagnostics.
+	DryRun bool
+
+	// CacheDevicePaths enables caching the device paths found by
+	// GetDevicePath in /dev/disk/by-id, named after the serial of the
+	// volume, until the device is unstaged or disappears.
+	CacheDevicePaths bool
+
+	// UdevadmPath is the udevadm command run after a SCSI rescan.
+	// Defaults to "udevadm", looked up in $PATH, when empty.
+	UdevadmPath string
+
+	// DisableUdevadm skips running udevadm after a SCSI rescan,
+	// for node images not shipping it.
+	DisableUdevadm bool
+
+	// DisableSCSIRescan skips writing to the scan file of every SCSI
+	// host when waiting for devices, relying on udevadm only.
+	DisableSCSIRescan bool
+
+	// DeviceSettleDelay is how long CheckMountHealth and Unstage wait
+	// before looking up a missing device again, as udev may briefly
+	// remove and recreate it, e.g. during detach races. No wait is done
+	// when zero.
+	DeviceSettleDelay time.Duration
+
+	// ProcPath is where the procfs of the host is mounted, to read its
+	// mount table. Defaults to DefaultProcPath when empty.
+	ProcPath string
+
+	// SysPath is where the sysfs of the host is mounted, to find and
+	// rescan devices. Defaults to DefaultSysPath when empty.
+	SysPath string
+
+	// Hypervisor selects how volume IDs are translated to disk serials,
+	// among SerialFuncs. Defaults to KVM when empty; it may be changed
+	// later with SetHypervisor.
+	Hypervisor string
+
+	// MetricsRegisterer, if set, is used to register metrics
+	// about the duration of the mounter operations.
+	MetricsRegisterer prometheus.Registerer
+}
+
 type mounter struct {
 	*mount.SafeFormatAndMount
+	diskIDPath           string
+	diskPathPath         string
+	diskIDPrefixes       []string
+	nvmeSysPath          string
+	mapperPath           string
+	scsiHostPath         string
+	sysBlockPath         string
+	devicePathBackoff    wait.Backoff
+	multipath            bool
+	refuseFormatMismatch bool
+	verifyDevice         bool
+	dryRun               bool
+	udevadmPath          string
+	scsiRescan           bool
+	// serial is the SerialFunc of the hypervisor, KVM when nil.
+	serial             atomic.Pointer[SerialFunc]
+	unmountTimeout     time.Duration
+	devicePaths        *devicePathCache
+	healthCheckTimeout time.Duration
+	deviceSettleDelay  time.Duration
+	mountInfoPath      string
+	// procMountsPath is the mounts file List reads, or empty to use
+	// the one of the mount.Interface.
+	procMountsPath string
+	// stat is os.Stat, replaced in tests.
+	stat    func(name string) (os.FileInfo, error)
+	metrics *metrics
 }
 
 type volumeStatistics struct {
@@ -54,19 +240,83 @@ type volumeStatistics struct {
 }
 
 // New creates an implementation of the mount.Interface.
-func New() Interface {
-	return &mounter{
-		&mount.SafeFormatAndMount{
+func New(opts Options) Interface {
+	idPath := opts.DiskIDPath
+	if idPath == "" {
+		idPath = diskIDPath
+	}
+	prefixes := opts.DiskIDPrefixes
+	if len(prefixes) == 0 {
+		prefixes = DefaultDiskIDPrefixes
+	}
+	udevadmPath := opts.UdevadmPath
+	if opts.DisableUdevadm {
+		udevadmPath = ""
+	} else if udevadmPath == "" {
+		udevadmPath = defaultUdevadmPath
+	}
+	var devicePaths *devicePathCache
+	if opts.CacheDevicePaths {
+		devicePaths = newDevicePathCache()
+	}
+	backoff := opts.DevicePathBackoff
+	if backoff.Steps == 0 {
+		backoff = DefaultDevicePathBackoff
+	}
+	procPath := opts.ProcPath
+	if procPath == "" {
+		procPath = DefaultProcPath
+	}
+	var procMountsPath string
+	if procPath != DefaultProcPath {
+		procMountsPath = filepath.Join(procPath, "mounts")
+	}
+	sysPath := opts.SysPath
+	if sysPath == "" {
+		sysPath = DefaultSysPath
+	}
+
+	m := &mounter{
+		SafeFormatAndMount: &mount.SafeFormatAndMount{
 			Interface: mount.New(""),
 			Exec:      kexec.New(),
 		},
+		diskIDPath:           idPath,
+		diskPathPath:         diskPathPath,
+		diskIDPrefixes:       prefixes,
+		nvmeSysPath:          filepath.Join(sysPath, "class", "nvme"),
+		mapperPath:           mapperPath,
+		scsiHostPath:         filepath.Join(sysPath, "class", "scsi_host"),
+		sysBlockPath:         filepath.Join(sysPath, "block"),
+		devicePathBackoff:    backoff,
+		multipath:            opts.Multipath,
+		refuseFormatMismatch: opts.RefuseFormatMismatch,
+		verifyDevice:         opts.VerifyDevice,
+		dryRun:               opts.DryRun,
+		udevadmPath:          udevadmPath,
+		scsiRescan:           !opts.DisableSCSIRescan,
+		unmountTimeout:       defaultUnmountTimeout,
+		devicePaths:          devicePaths,
+		healthCheckTimeout:   defaultHealthCheckTimeout,
+		deviceSettleDelay:    opts.DeviceSettleDelay,
+		mountInfoPath:        filepath.Join(procPath, "self", "mountinfo"),
+		procMountsPath:       procMountsPath,
+		stat:                 os.Stat,
+		metrics:              newMetrics(opts.MetricsRegisterer),
 	}
+	if err := m.SetHypervisor(opts.Hypervisor); err != nil {
+		klog.ErrorS(err, "Using the disk serials of KVM")
+	}
+
+	return m
 }
 
 // GetBlockSizeBytes gets the size of the disk in bytes.
 func (m *mounter) GetBlockSizeBytes(devicePath string) (int64, error) {
 	output, err := m.Exec.Command("blockdev", "--getsize64", devicePath).Output()
-	if err != nil {
+	if errors.Is(err, kexec.ErrExecutableNotFound) {
+		return -1, fmt.Errorf("blockdev is required to get the size of block volume at path %s: %w", devicePath, err)
+	} else if err != nil {
 		return -1, fmt.Errorf("error when getting size of block volume at path %s: output: %s, err: %w", devicePath, string(output), err)
 	}
 	strOut := strings.TrimSpace(string(output))
@@ -79,18 +329,38 @@ func (m *mounter) GetBlockSizeBytes(devicePath string) (int64, error) {
 }
 
 func (m *mounter) GetDevicePath(ctx context.Context, volumeID string) (string, error) {
-	backoff := wait.Backoff{
-		Duration: 1 * time.Second,
-		Factor:   1.1,
-		Steps:    15,
+	return m.getDevicePath(ctx, volumeID, "", m.serialFunc(""))
+}
+
+// getDevicePath waits for the device of the volume to appear, looking up
+// the serial toSerial translates its ID to. When that serial is not found,
+// the device is looked up in /dev/disk/by-path from deviceID, if not empty.
+func (m *mounter) getDevicePath(ctx context.Context, volumeID, deviceID string, toSerial SerialFunc) (devicePath string, err error) {
+	defer m.metrics.observeDuration(operationGetDevicePath, time.Now())
+	ctx, span := tracer.Start(ctx, "GetDevicePath", trace.WithAttributes(
+		attribute.String("volume.id", volumeID),
+		attribute.String("device.id", deviceID),
+	))
+	defer func() { util.EndSpan(span, err) }()
+
+	if path, ok := m.devicePaths.get(volumeID); ok {
+		return path, nil
 	}
 
-	var devicePath string
-	err := wait.ExponentialBackoffWithContext(ctx, backoff, func(context.Context) (bool, error) {
-		path, err := m.getDevicePathBySerialID(volumeID)
+	err = wait.ExponentialBackoffWithContext(ctx, m.devicePathBackoff, func(context.Context) (bool, error) {
+		path, err := m.getDevicePathBySerialID(volumeID, toSerial)
 		if err != nil {
 			return false, err
 		}
+		if path == "" {
+			path, err = m.getDevicePathByPath(deviceID)
+			if err != nil {
+				return false, err
+			}
+			if path != "" {
+				klog.FromContext(ctx).V(4).Info("Found device in by-path from its device ID", "volumeID", volumeID, "deviceID", deviceID, "devicePath", path)
+			}
+		}
 		if path != "" {
 			devicePath = path
 
@@ -102,19 +372,102 @@ func (m *mounter) GetDevicePath(ctx context.Context, volumeID string) (string, e
 	})
 
 	if wait.Interrupted(err) {
-		return "", fmt.Errorf("failed to find device for the volumeID: %q within the alloted time", volumeID)
+		reason := fmt.Sprintf("no entry in %s for serial %q with prefixes %v, and no NVMe device with that serial", m.diskIDPath, toSerial(volumeID), m.diskIDPrefixes)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", fmt.Errorf("%w for volumeID %q: %s when the wait ended: %w", ErrDeviceNotFound, volumeID, reason, ctxErr)
+		}
+
+		return "", fmt.Errorf("%w for volumeID %q: %s after %d lookups", ErrDeviceNotFound, volumeID, reason, m.devicePathBackoff.Steps)
+	} else if err != nil {
+		return "", fmt.Errorf("%w for volumeID %q: %w", ErrDeviceProbeFailed, volumeID, err)
 	} else if devicePath == "" {
-		return "", fmt.Errorf("device path was empty for volumeID: %q", volumeID)
+		return "", fmt.Errorf("%w: device path was empty for volumeID: %q", ErrDeviceNotFound, volumeID)
 	}
+	m.cacheDevicePath(volumeID, devicePath)
 
 	return devicePath, nil
 }
 
-func (m *mounter) getDevicePathBySerialID(volumeID string) (string, error) {
-	sourcePathPrefixes := []string{"virtio-", "scsi-", "scsi-0QEMU_QEMU_HARDDISK_"}
-	serial := diskUUIDToSerial(volumeID)
-	for _, prefix := range sourcePathPrefixes {
-		source := filepath.Join(diskIDPath, prefix+serial)
+// cacheDevicePath caches the device path of the volume, if named after
+// its serial, as the links of /dev/disk/by-id are.
+func (m *mounter) cacheDevicePath(volumeID, devicePath string) {
+	if strings.HasPrefix(devicePath, m.diskIDPath+"/") {
+		m.devicePaths.set(volumeID, devicePath)
+	}
+}
+
+// GetDevicePathByDeviceID returns the device of the volume from the
+// device ID CloudStack attached it with, when the serial of that device is
+// the one of the volume. Otherwise, e.g. when deviceID is empty, it falls
+// back to GetDevicePath, which also looks for the device ID in
+// /dev/disk/by-path when the serial of the volume is not found. The
+// serials are the ones of hypervisor, the hypervisor of the volume, if not
+// empty and supported, and otherwise the ones of the node.
+func (m *mounter) GetDevicePathByDeviceID(ctx context.Context, volumeID, deviceID, hypervisor string) (string, error) {
+	toSerial := m.serialFunc(hypervisor)
+	if path := m.getDevicePathByDeviceID(toSerial(volumeID), deviceID); path != "" {
+		klog.FromContext(ctx).V(4).Info("Found device from its device ID", "volumeID", volumeID, "deviceID", deviceID, "devicePath", path)
+
+		return path, nil
+	}
+
+	return m.getDevicePath(ctx, volumeID, deviceID, toSerial)
+}
+
+// getDevicePathByDeviceID returns the device named after the device ID, as
+// done by CloudStack with KVM: device ID 1 is vdb with virtio, or sdb with
+// virtio-scsi. It is only returned if its serial is the one of the volume,
+// and never with multipath.
+func (m *mounter) getDevicePathByDeviceID(serial, deviceID string) string {
+	id, err := strconv.Atoi(deviceID)
+	if err != nil || id < 0 || id >= 26 || m.multipath {
+		return ""
+	}
+	letter := string(rune('a' + id))
+	for name, serialFile := range map[string]string{
+		"vd" + letter: "serial",
+		"sd" + letter: filepath.Join("device", "vpd_pg80"),
+	} {
+		data, err := os.ReadFile(filepath.Join(m.sysBlockPath, name, serialFile))
+		if err == nil && strings.Contains(strings.ToLower(string(data)), serial) {
+			return filepath.Join(devPath, name)
+		}
+	}
+
+	return ""
+}
+
+// getDevicePathByPath returns the /dev/disk/by-path entry of the SCSI
+// disk whose unit is the device ID, as done by CloudStack with KVM and
+// virtio-scsi: device ID 1 is the entry ending with -scsi-0:0:1:0. It is
+// used for hosts whose udev rules do not create the by-id entries. It is
+// only returned if a single entry matches, and never with multipath.
+func (m *mounter) getDevicePathByPath(deviceID string) (string, error) {
+	id, err := strconv.Atoi(deviceID)
+	if err != nil || id < 0 || m.multipath {
+		return "", nil
+	}
+	matches, err := filepath.Glob(filepath.Join(m.diskPathPath, fmt.Sprintf("*-scsi-0:0:%d:0", id)))
+	if err != nil || len(matches) != 1 {
+		return "", err
+	}
+
+	return matches[0], nil
+}
+
+func (m *mounter) getDevicePathBySerialID(volumeID string, toSerial SerialFunc) (string, error) {
+	serial := toSerial(volumeID)
+
+	// With multipath, the by-id symlinks of the single paths must not be used.
+	if m.multipath {
+		path, err := m.getMultipathDevicePathBySerial(serial)
+		if err != nil || path != "" {
+			return path, err
+		}
+	}
+
+	for _, prefix := range m.diskIDPrefixes {
+		source := filepath.Join(m.diskIDPath, prefix+serial)
 		_, err := os.Stat(source)
 		if err == nil {
 			return source, nil
@@ -124,58 +477,247 @@ func (m *mounter) getDevicePathBySerialID(volumeID string) (string, error) {
 		}
 	}
 
+	return m.getNVMeDevicePathBySerial(serial, toSerial)
+}
+
+// getNVMeDevicePathBySerial looks for a NVMe namespace whose controller
+// serial matches the given disk serial. It first looks for a
+// /dev/disk/by-id/nvme-<model>_<serial> symlink, then falls back to
+// reading the controller serials from sysfs, translated with toSerial.
+func (m *mounter) getNVMeDevicePathBySerial(serial string, toSerial SerialFunc) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(m.diskIDPath, nvmeIDPrefix+"*"+serial))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) > 0 {
+		return matches[0], nil
+	}
+
+	controllers, err := os.ReadDir(m.nvmeSysPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+
+		return "", err
+	}
+	for _, c := range controllers {
+		data, err := os.ReadFile(filepath.Join(m.nvmeSysPath, c.Name(), "serial"))
+		if err != nil {
+			continue
+		}
+		// The controller serial may be padded with spaces, and may not be truncated.
+		if toSerial(strings.TrimSpace(string(data))) != serial {
+			continue
+		}
+		namespaces, err := filepath.Glob(filepath.Join(m.nvmeSysPath, c.Name(), c.Name()+"n*"))
+		if err != nil || len(namespaces) == 0 {
+			continue
+		}
+
+		return filepath.Join(devPath, filepath.Base(namespaces[0])), nil
+	}
+
 	return "", nil
 }
 
+// getMultipathDevicePathBySerial looks for a multipath device whose WWID
+// contains the given disk serial, and returns its /dev/mapper path.
+func (m *mounter) getMultipathDevicePathBySerial(serial string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(m.diskIDPath, multipathIDPrefix+"*"+serial+"*"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", nil
+	}
+
+	// Resolve /dev/disk/by-id/dm-uuid-mpath-<wwid> to /dev/dm-<n>, then
+	// find the name of the map, which is the name of its /dev/mapper entry.
+	dmDevice, err := filepath.EvalSymlinks(matches[0])
+	if err != nil {
+		return "", err
+	}
+	name, err := os.ReadFile(filepath.Join(m.sysBlockPath, filepath.Base(dmDevice), "dm", "name"))
+	if err != nil {
+		return "", fmt.Errorf("failed to get name of multipath device %s: %w", dmDevice, err)
+	}
+
+	return filepath.Join(m.mapperPath, strings.TrimSpace(string(name))), nil
+}
+
 func (m *mounter) probeVolume(ctx context.Context) {
 	logger := klog.FromContext(ctx)
+
+	if m.scsiRescan {
+		if err := m.rescanSCSIHosts(ctx); err != nil {
+			logger.Info("SCSI host rescan interrupted", "err", err)
+
+			return
+		}
+	}
+
+	if m.udevadmPath == "" {
+		return
+	}
+
+	args := []string{"trigger"}
+	cmd := m.Exec.Command(m.udevadmPath, args...)
+	_, err := cmd.CombinedOutput()
+	if err != nil {
+		logger.Error(err, "Error running udevadm trigger")
+
+		return
+	}
+
+	// Wait for the triggered events to be processed,
+	// so the by-id symlinks exist when we look them up again.
+	args = []string{"settle", "--timeout=" + strconv.Itoa(udevSettleTimeout)}
+	cmd = m.Exec.Command(m.udevadmPath, args...)
+	if _, err = cmd.CombinedOutput(); err != nil {
+		logger.Error(err, "Error running udevadm settle")
+	}
+}
+
+// rescanSCSIHosts asks every SCSI host to rescan its bus. Writing to
+// sysfs can hang when the kernel is stuck, so the writes are done in
+// the background, and ctx.Err() is returned if ctx is done first.
+func (m *mounter) rescanSCSIHosts(ctx context.Context) error {
+	logger := klog.FromContext(ctx)
 	logger.V(2).Info("Scanning SCSI host")
+	m.metrics.incSCSIRescans()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
 
-	scsiPath := "/sys/class/scsi_host/"
-	if dirs, err := os.ReadDir(scsiPath); err == nil {
+		scsiPath := m.scsiHostPath
+		dirs, err := os.ReadDir(scsiPath)
+		if err != nil {
+			logger.Error(err, "Failed to read dir ", "dirName", scsiPath)
+
+			return
+		}
 		for _, f := range dirs {
-			name := scsiPath + f.Name() + "/scan"
+			name := filepath.Join(scsiPath, f.Name(), "scan")
 			data := []byte("- - -")
 			logger.V(2).Info("Triggering SCSI host rescan")
 			if err = os.WriteFile(name, data, 0o666); err != nil { //nolint:gosec
 				logger.Error(err, "Failed to rescan scsi host ", "dirName", name)
 			}
 		}
-	} else {
-		logger.Error(err, "Failed to read dir ", "dirName", scsiPath)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	args := []string{"trigger"}
-	cmd := m.Exec.Command("udevadm", args...)
-	_, err := cmd.CombinedOutput()
-	if err != nil {
-		logger.Error(err, "Error running udevadm trigger")
+// List returns the mount points of the host, from the mounts file of
+// Options.ProcPath when it is not the default one.
+func (m *mounter) List() ([]mount.MountPoint, error) {
+	if m.procMountsPath == "" {
+		return m.Interface.List()
 	}
+
+	return mount.ListProcMounts(m.procMountsPath)
 }
 
+// GetDeviceName returns the device mounted at mountPath, and the number
+// of mount points using it. Symlinks in mountPath are resolved first.
+// Raw block volumes, published with bind mounts of their device file,
+// appear in the mount table as mounts of devtmpfs: they are resolved
+// to the device they bind.
 func (m *mounter) GetDeviceName(mountPath string) (string, int, error) {
-	return mount.GetDeviceNameFromMount(m, mountPath)
+	if resolved, err := filepath.EvalSymlinks(mountPath); err == nil {
+		mountPath = resolved
+	}
+
+	mountPoints, err := m.List()
+	if err != nil {
+		return "", 0, err
+	}
+	i := slices.IndexFunc(mountPoints, func(mp mount.MountPoint) bool { return mp.Path == mountPath })
+	if i < 0 {
+		return "", 0, nil
+	}
+	if mountPoints[i].Type == devtmpfsType {
+		return m.getBoundDeviceName(mountPath)
+	}
+
+	device := mountPoints[i].Device
+	refCount := 0
+	for _, mp := range mountPoints {
+		if mp.Device == device {
+			refCount++
+		}
+	}
+
+	return device, refCount, nil
+}
+
+// getBoundDeviceName returns the device file bound at mountPath, and the
+// number of mount points it is bound to. The mount table only shows
+// devtmpfs for such bind mounts, so the bound file is read from mountinfo.
+func (m *mounter) getBoundDeviceName(mountPath string) (string, int, error) {
+	infos, err := mount.ParseMountInfo(m.mountInfoPath)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to parse %s: %w", m.mountInfoPath, err)
+	}
+	i := slices.IndexFunc(infos, func(info mount.MountInfo) bool { return info.MountPoint == mountPath })
+	if i < 0 {
+		return "", 0, fmt.Errorf("%s not found in %s", mountPath, m.mountInfoPath)
+	}
+
+	root := boundRoot(infos[i].Root)
+	refCount := 0
+	for _, info := range infos {
+		if info.FsType == devtmpfsType && boundRoot(info.Root) == root {
+			refCount++
+		}
+	}
+
+	return filepath.Join(devPath, root), refCount, nil
+}
+
+// boundRoot returns the root of a bind mount without the //deleted suffix
+// the kernel appends once the bound file is removed, e.g. when udev
+// recreates the device file, which is still the same device.
+func boundRoot(root string) string {
+	return strings.TrimSuffix(root, deletedSuffix)
 }
 
 // diskUUIDToSerial reproduces CloudStack function diskUuidToSerial
 // from https://github.com/apache/cloudstack/blob/0f3f2a0937/plugins/hypervisors/kvm/src/main/java/com/cloud/hypervisor/kvm/resource/LibvirtComputingResource.java#L3000
 //
 // This is what CloudStack do *with KVM hypervisor* to translate
-// a CloudStack volume UUID to libvirt disk serial.
+// a CloudStack volume UUID to libvirt disk serial:
+//   - surrounding spaces and hyphens are removed;
+//   - the result is lowercased, as CloudStack generates lowercase UUIDs,
+//     while volume IDs may be given to the driver in uppercase;
+//   - it is truncated to 20 characters, the maximum length of a
+//     virtio disk serial. Shorter values are returned as is.
 func diskUUIDToSerial(uuid string) string {
-	uuidWithoutHyphen := strings.ReplaceAll(uuid, "-", "")
-	if len(uuidWithoutHyphen) < 20 {
+	uuidWithoutHyphen := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(uuid), "-", ""))
+	if len(uuidWithoutHyphen) < serialMaxLength {
 		return uuidWithoutHyphen
 	}
 
-	return uuidWithoutHyphen[:20]
+	return uuidWithoutHyphen[:serialMaxLength]
 }
 
 func (*mounter) PathExists(path string) (bool, error) {
 	return mount.PathExists(path)
 }
 
-func (*mounter) MakeDir(pathname string) error {
+func (m *mounter) MakeDir(pathname string) error {
+	if m.skipDryRun("create directory", "path", pathname) {
+		return nil
+	}
+
 	err := os.MkdirAll(pathname, os.FileMode(0o755))
 	if err != nil {
 		if !os.IsExist(err) {
@@ -186,7 +728,11 @@ func (*mounter) MakeDir(pathname string) error {
 	return nil
 }
 
-func (*mounter) MakeFile(pathname string) error {
+func (m *mounter) MakeFile(pathname string) error {
+	if m.skipDryRun("create file", "path", pathname) {
+		return nil
+	}
+
 	f, err := os.OpenFile(pathname, os.O_CREATE, os.FileMode(0o644))
 	if err != nil {
 		if !os.IsExist(err) {
@@ -200,9 +746,270 @@ func (*mounter) MakeFile(pathname string) error {
 	return nil
 }
 
-// Resize resizes the filesystem of the given devicePath.
+// FormatAndMount formats source with the given filesystem if it is not
+// formatted yet, then mounts it at target.
+func (m *mounter) FormatAndMount(source, target, fstype string, options []string) error {
+	return m.FormatAndMountWithFormatOptions(source, target, fstype, options, nil)
+}
+
+// FormatAndMountWithFormatOptions behaves like FormatAndMount, and passes
+// the extra formatOptions to mkfs when source needs to be formatted.
+func (m *mounter) FormatAndMountWithFormatOptions(source, target, fstype string, options, formatOptions []string) error {
+	defer m.metrics.observeDuration(operationFormatAndMount, time.Now())
+
+	options, err := ValidateMountOptions(options)
+	if err != nil {
+		return err
+	}
+	if err := validateFormatOptions(source, target, formatOptions); err != nil {
+		return err
+	}
+	if m.skipDryRun("format and mount", "source", source, "target", target, "fstype", fstype, "options", options, "formatOptions", formatOptions) {
+		return nil
+	}
+	if m.refuseFormatMismatch {
+		if err := m.checkDiskFormat(source, fstype); err != nil {
+			return err
+		}
+	}
+	formatOptions = append(defaultFormatOptions(fstype), formatOptions...)
+
+	if err := m.FormatAndMountSensitiveWithFormatOptions(source, target, fstype, options, nil, formatOptions); err != nil {
+		return err
+	}
+	if m.verifyDevice {
+		if err := verifyDevice(source); err != nil {
+			if unmountErr := m.Unmount(target); unmountErr != nil {
+				return fmt.Errorf("%w, and could not unmount %s: %w", err, target, unmountErr)
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifySize is how much of a device verifyDevice reads: enough to cover
+// the superblocks of the supported filesystems, btrfs having its own at
+// 64 KiB.
+const verifySize = 68 * 1024
+
+// verifyDevice opens devicePath, reads its start where the superblock of
+// the filesystem lies, and flushes it, to make sure the device is usable.
+func verifyDevice(devicePath string) error {
+	f, err := os.Open(devicePath)
+	if err != nil {
+		return fmt.Errorf("could not open device %s: %w", devicePath, err)
+	}
+	defer f.Close()
+
+	if _, err := io.ReadFull(f, make([]byte, verifySize)); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("could not read the superblock of device %s: %w", devicePath, err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("could not flush device %s: %w", devicePath, err)
+	}
+
+	return nil
+}
+
+// checkDiskFormat returns an error if source already holds
+// something other than a fstype filesystem.
+func (m *mounter) checkDiskFormat(source, fstype string) error {
+	existingFormat, err := m.GetDiskFormat(source)
+	if err != nil {
+		return fmt.Errorf("failed to get disk format of %s: %w", source, err)
+	}
+	if existingFormat != "" && existingFormat != fstype {
+		return fmt.Errorf("device %s already contains %q, refusing to use it as %s", source, existingFormat, fstype)
+	}
+
+	return nil
+}
+
+// GetDiskUUID returns the UUID of the filesystem (or LUKS container)
+// on devicePath, or an empty string if it has none.
+func (m *mounter) GetDiskUUID(devicePath string) (string, error) {
+	output, err := m.Exec.Command("blkid", "-p", "-s", "UUID", "-o", "value", devicePath).CombinedOutput()
+	if err != nil {
+		// blkid exits with 2 when it finds nothing on the device.
+		var exitErr kexec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitStatus() == 2 {
+			return "", nil
+		}
+
+		return "", fmt.Errorf("failed to get UUID of %s: %w, output: %s", devicePath, err, output)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// CheckDiskUUID returns an error if the UUID of devicePath is not
+// expectedUUID, which means the wrong device is attached. Nothing is
+// checked when expectedUUID is empty.
+func (m *mounter) CheckDiskUUID(devicePath, expectedUUID string) error {
+	if expectedUUID == "" {
+		return nil
+	}
+
+	uuid, err := m.GetDiskUUID(devicePath)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(uuid, expectedUUID) {
+		return fmt.Errorf("device %s has UUID %q, expected %q", devicePath, uuid, expectedUUID)
+	}
+
+	return nil
+}
+
+// validateFormatOptions makes sure user provided mkfs options
+// cannot be used to format anything else than source.
+func validateFormatOptions(source, target string, formatOptions []string) error {
+	for _, o := range formatOptions {
+		if o == source || o == target || strings.HasPrefix(o, "/dev/") {
+			return fmt.Errorf("invalid format option %q: devices and paths are not allowed", o)
+		}
+	}
+
+	return nil
+}
+
+// defaultFormatOptions returns the mkfs options needed for the given filesystem,
+// on top of the ones set by mount.SafeFormatAndMount.
+func defaultFormatOptions(fstype string) []string {
+	switch fstype { //nolint:gocritic
+	case "btrfs":
+		// Like for xfs, force the creation: mkfs.btrfs otherwise refuses
+		// to run on a device where blkid found no filesystem but leftovers
+		// of a previous one remain.
+		return []string{"-f"}
+	}
+
+	return nil
+}
+
+// WithExt4LazyInit returns the formatOptions of an ext4 filesystem with
+// the lazy_itable_init and lazy_journal_init extended options set to lazy.
+// They are added to the first -E option, unless it already sets them, as
+// mkfs.ext4 only uses the last one. The options of other filesystems are
+// returned unchanged.
+func WithExt4LazyInit(fstype string, formatOptions []string, lazy bool) []string {
+	if fstype != "ext4" {
+		return formatOptions
+	}
+	value := "0"
+	if lazy {
+		value = "1"
+	}
+	extended := "lazy_itable_init=" + value + ",lazy_journal_init=" + value
+
+	formatOptions = slices.Clone(formatOptions)
+	for i, o := range formatOptions {
+		if o != "-E" || i+1 == len(formatOptions) {
+			continue
+		}
+		if !strings.Contains(formatOptions[i+1], "lazy_") {
+			formatOptions[i+1] = extended + "," + formatOptions[i+1]
+		}
+
+		return formatOptions
+	}
+
+	return append([]string{"-E", extended}, formatOptions...)
+}
+
+// BindBlockDevice bind mounts the raw block device source at target,
+// creating target as a file since a device node can only be bind
+// mounted on a file. The device is never formatted.
+func (m *mounter) BindBlockDevice(source, target string, options []string) error {
+	return bindBlockDevice(m, source, target, options)
+}
+
+func bindBlockDevice(m Interface, source, target string, options []string) error {
+	if err := m.MakeDir(filepath.Dir(target)); err != nil {
+		return fmt.Errorf("could not create dir %q: %w", filepath.Dir(target), err)
+	}
+	if err := m.MakeFile(target); err != nil {
+		return fmt.Errorf("could not create file %q: %w", target, err)
+	}
+
+	if !slices.Contains(options, "bind") {
+		options = append([]string{"bind"}, options...)
+	}
+	if err := m.Mount(source, target, "", options); err != nil {
+		if removeErr := os.Remove(target); removeErr != nil {
+			return fmt.Errorf("could not remove mount target %q: %w", target, removeErr)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// RescanDevice makes the kernel read the size of the SCSI device
+// devicePath again, e.g. after its volume was expanded. Virtio block
+// devices, without a rescan file, are updated by the hypervisor itself.
+func (m *mounter) RescanDevice(devicePath string) error {
+	if m.skipDryRun("device rescan", "devicePath", devicePath) {
+		return nil
+	}
+
+	if resolved, err := filepath.EvalSymlinks(devicePath); err == nil {
+		devicePath = resolved
+	}
+	name := filepath.Join(m.sysBlockPath, filepath.Base(devicePath), "device", "rescan")
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("cannot rescan device %s: %w", devicePath, err)
+	}
+	defer f.Close()
+	klog.V(4).InfoS("Rescanning device", "devicePath", devicePath)
+	if _, err := f.WriteString("1"); err != nil {
+		return fmt.Errorf("cannot rescan device %s: %w", devicePath, err)
+	}
+
+	return nil
+}
+
+// Resize grows the filesystem of devicePath, mounted at deviceMountPath,
+// to the size of the device.
 func (m *mounter) Resize(devicePath, deviceMountPath string) (bool, error) {
-	return mount.NewResizeFs(m.Exec).Resize(devicePath, deviceMountPath)
+	defer m.metrics.observeDuration(operationResize, time.Now())
+
+	if m.skipDryRun("resize", "devicePath", devicePath, "deviceMountPath", deviceMountPath) {
+		return true, nil
+	}
+
+	format, err := m.GetDiskFormat(devicePath)
+	if err != nil {
+		return false, fmt.Errorf("failed to get disk format of %s: %w", devicePath, err)
+	}
+
+	var cmd string
+	var args []string
+	switch format {
+	case "ext2", "ext3", "ext4":
+		cmd, args = "resize2fs", []string{devicePath}
+	case "xfs":
+		// xfs_growfs only works on mounted filesystems, given their mount point.
+		cmd, args = "xfs_growfs", []string{"-d", deviceMountPath}
+	case "btrfs":
+		cmd, args = "btrfs", []string{"filesystem", "resize", "max", deviceMountPath}
+	default:
+		return false, fmt.Errorf("resize of format %q is not supported for device %s mounted at %s", format, devicePath, deviceMountPath)
+	}
+
+	klog.V(4).InfoS("Resizing filesystem", "devicePath", devicePath, "deviceMountPath", deviceMountPath, "format", format)
+	if output, err := m.Exec.Command(cmd, args...).CombinedOutput(); err != nil {
+		return false, fmt.Errorf("resize of device %s failed: %w, %s output: %s", devicePath, err, cmd, output)
+	}
+
+	return true, nil
 }
 
 // NeedResize checks if the filesystem of the given devicePath needs to be resized.
@@ -218,15 +1025,10 @@ func (m *mounter) GetStatistics(volumePath string) (volumeStatistics, error) {
 	}
 
 	if isBlock {
-		// See http://man7.org/linux/man-pages/man8/blockdev.8.html for details
-		output, err := exec.Command("blockdev", "getsize64", volumePath).CombinedOutput()
+		// Block volumes only report their total size.
+		gotSizeBytes, err := m.GetBlockSizeBytes(volumePath)
 		if err != nil {
-			return volumeStatistics{}, fmt.Errorf("error when getting size of block volume at path %s: output: %s, err: %w", volumePath, string(output), err)
-		}
-		strOut := strings.TrimSpace(string(output))
-		gotSizeBytes, err := strconv.ParseInt(strOut, 10, 64)
-		if err != nil {
-			return volumeStatistics{}, fmt.Errorf("failed to parse size %s into int", strOut)
+			return volumeStatistics{}, err
 		}
 
 		return volumeStatistics{
@@ -272,10 +1074,154 @@ func (m *mounter) IsCorruptedMnt(err error) bool {
 
 // Unpublish unmounts the given path.
 func (m *mounter) Unpublish(path string) error {
-	return m.Unstage(path)
+	if m.skipDryRun("unmount and remove", "path", path) {
+		return nil
+	}
+
+	return mount.CleanupMountPoint(path, m, true)
 }
 
-// Unstage unmounts the given path.
+// Unstage unmounts the given path, and closes the underlying
+// LUKS device if the volume is encrypted. When the device is gone, still
+// missing after the settle delay, path is lazily and forcibly unmounted
+// right away, as a regular unmount could hang.
 func (m *mounter) Unstage(path string) error {
-	return mount.CleanupMountPoint(path, m, true)
+	dev, _, err := m.GetDeviceName(path)
+	if err != nil {
+		return err
+	}
+
+	if m.skipDryRun("unmount and remove", "path", path, "device", dev) {
+		return nil
+	}
+
+	// The device may be attached again with another name, or its name
+	// be given to another volume.
+	m.devicePaths.evict(dev)
+	if volumeID, ok := m.encryptedVolumeID(dev); ok {
+		m.devicePaths.delete(volumeID)
+	}
+	gone := strings.HasPrefix(dev, devPath+"/") && m.deviceGone(dev)
+	if gone {
+		klog.InfoS("Device of the staged volume is gone", "path", path, "device", dev)
+	}
+	if err := m.cleanupMountPoint(path, gone); err != nil {
+		return err
+	}
+
+	return m.closeEncryptedDevice(dev)
+}
+
+// ForceCleanupMountPoint unmounts path and removes it. Unlike
+// mount.CleanupMountPoint, it never hangs when the device backing the
+// mount is gone: if a regular unmount does not return in time, or fails
+// on a corrupted mount, path is lazily and forcibly unmounted. Other
+// errors, such as EBUSY while the volume is still in use, are returned.
+func (m *mounter) ForceCleanupMountPoint(path string) error {
+	if m.skipDryRun("force unmount and remove", "path", path) {
+		return nil
+	}
+
+	return m.cleanupMountPoint(path, false)
+}
+
+// cleanupMountPoint unmounts path and removes it, as done by
+// ForceCleanupMountPoint. With force, no regular unmount is attempted.
+func (m *mounter) cleanupMountPoint(path string, force bool) error {
+	mounted, err := m.isInMountTable(path)
+	if err != nil {
+		return err
+	}
+	if mounted && !force {
+		if err := m.unmountWithTimeout(path); err != nil {
+			if !m.unmountStuck(path, err) {
+				return fmt.Errorf("failed to unmount %s: %w", path, err)
+			}
+			klog.InfoS("Unmount failed, forcing a lazy unmount", "path", path, "err", err)
+			force = true
+		}
+	}
+	if mounted && force {
+		if output, err := m.Exec.Command("umount", "-l", "-f", path).CombinedOutput(); err != nil {
+			return fmt.Errorf("forced unmount of %s failed: %w, output: %s", path, err, output)
+		}
+		klog.InfoS("Forced unmount succeeded", "path", path)
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// isInMountTable tells whether path is a mount point. Unlike
+// IsLikelyNotMountPoint, it only reads the mount table, and never
+// accesses path, which could hang.
+func (m *mounter) isInMountTable(path string) (bool, error) {
+	mountPoints, err := m.List()
+	if err != nil {
+		return false, fmt.Errorf("failed to list mount points: %w", err)
+	}
+
+	return slices.ContainsFunc(mountPoints, func(mp mount.MountPoint) bool { return mp.Path == path }), nil
+}
+
+// unmountStuck tells whether the unmount of path failed with err because
+// the mount cannot be unmounted normally: the unmount timed out, or the
+// mount is corrupted, e.g. as its device is gone.
+func (m *mounter) unmountStuck(path string, err error) bool {
+	if errors.Is(err, errUnmountTimeout) || m.IsCorruptedMnt(err) {
+		return true
+	}
+	// The errors of the unmount command do not keep their errno.
+	_, err = m.stat(path)
+
+	return err != nil && m.IsCorruptedMnt(err)
+}
+
+// unmountWithTimeout unmounts path, giving up after m.unmountTimeout with
+// errUnmountTimeout. The unmount keeps running in the background after a
+// timeout.
+func (m *mounter) unmountWithTimeout(path string) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Unmount(path)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(m.unmountTimeout):
+		return fmt.Errorf("%w: unmount of %s did not complete within %s", errUnmountTimeout, path, m.unmountTimeout)
+	}
+}
+
+// Mount mounts source at target, unless in dry-run mode.
+func (m *mounter) Mount(source, target, fstype string, options []string) error {
+	if m.skipDryRun("mount", "source", source, "target", target, "fstype", fstype, "options", options) {
+		return nil
+	}
+
+	return m.SafeFormatAndMount.Mount(source, target, fstype, options)
+}
+
+// Unmount unmounts target, unless in dry-run mode.
+func (m *mounter) Unmount(target string) error {
+	if m.skipDryRun("unmount", "target", target) {
+		return nil
+	}
+
+	return m.SafeFormatAndMount.Unmount(target)
+}
+
+// skipDryRun reports whether the given action must be skipped because
+// the mounter is in dry-run mode, and logs it if so.
+func (m *mounter) skipDryRun(action string, keysAndValues ...any) bool {
+	if !m.dryRun {
+		return false
+	}
+	klog.InfoS("Dry run: skipping "+action, keysAndValues...)
+
+	return true
 }
@@ -0,0 +1,1630 @@
+package mount
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/mount-utils"
+	kexec "k8s.io/utils/exec"
+	exec "k8s.io/utils/exec/testing"
+)
+
+const testVolumeID = "ace9f28b-3081-40c1-8353-4cc3e3014072"
+
+func newTestMounter(t *testing.T, opts Options) *mounter {
+	t.Helper()
+
+	m, ok := New(opts).(*mounter)
+	if !ok {
+		t.Fatal("New did not return a *mounter")
+	}
+	m.SafeFormatAndMount = &mount.SafeFormatAndMount{
+		Interface: mount.NewFakeMounter([]mount.MountPoint{}),
+		Exec:      &exec.FakeExec{DisableScripts: true},
+	}
+	m.diskIDPath = t.TempDir()
+	m.diskPathPath = t.TempDir()
+	m.nvmeSysPath = t.TempDir()
+	m.scsiHostPath = t.TempDir()
+
+	return m
+}
+
+func createDiskIDEntry(t *testing.T, dir, name string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatalf("failed to create %s: %v", path, err)
+	}
+
+	return path
+}
+
+func TestGetDevicePathCustomPrefix(t *testing.T) {
+	m := newTestMounter(t, Options{DiskIDPrefixes: []string{"wwn-0x"}})
+	expected := createDiskIDEntry(t, m.diskIDPath, "wwn-0x"+diskUUIDToSerial(testVolumeID))
+
+	path, err := m.GetDevicePath(context.Background(), testVolumeID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != expected {
+		t.Errorf("expected device path %s, got %s", expected, path)
+	}
+}
+
+func TestGetDevicePathDefaultPrefixes(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	expected := createDiskIDEntry(t, m.diskIDPath, "virtio-"+diskUUIDToSerial(testVolumeID))
+
+	path, err := m.GetDevicePath(context.Background(), testVolumeID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != expected {
+		t.Errorf("expected device path %s, got %s", expected, path)
+	}
+}
+
+func TestGetDevicePathBackoff(t *testing.T) {
+	for _, steps := range []int{1, 3, 5} {
+		t.Run(strconv.Itoa(steps), func(t *testing.T) {
+			m := newTestMounter(t, Options{
+				DevicePathBackoff: wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: steps},
+			})
+			fakeExec, log := newScriptedExec(make([]fakeCommand, 2*steps)...)
+			m.Exec = fakeExec
+
+			if _, err := m.GetDevicePath(context.Background(), testVolumeID); err == nil {
+				t.Fatal("expected an error")
+			}
+
+			// Each unsuccessful lookup triggers a probe, which runs udevadm twice.
+			if probes := len(log.cmds) / 2; probes != steps {
+				t.Errorf("expected %d probes, got %d", steps, probes)
+			}
+		})
+	}
+}
+
+func TestGetDevicePathByDeviceID(t *testing.T) {
+	serial := diskUUIDToSerial(testVolumeID)
+	cases := []struct {
+		name     string
+		deviceID string
+		// files are written in the sysfs block directory.
+		files    map[string]string
+		expected string
+	}{
+		{"virtio", "1", map[string]string{"vdb/serial": serial}, "/dev/vdb"},
+		{"virtio-scsi", "2", map[string]string{"sdc/device/vpd_pg80": "\x00\x80\x00\x14" + serial}, "/dev/sdc"},
+		{"other volume", "1", map[string]string{"vdb/serial": "0d7107a394d244e789b8"}, ""},
+		{"no device ID", "", map[string]string{"vdb/serial": serial}, ""},
+		{"invalid device ID", "x", nil, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := newTestMounter(t, Options{})
+			m.sysBlockPath = t.TempDir()
+			for name, content := range c.files {
+				path := filepath.Join(m.sysBlockPath, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// The serial-based lookup is the fallback.
+			byID := createDiskIDEntry(t, m.diskIDPath, "virtio-"+serial)
+			expected := c.expected
+			if expected == "" {
+				expected = byID
+			}
+
+			path, err := m.GetDevicePathByDeviceID(context.Background(), testVolumeID, c.deviceID, "")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if path != expected {
+				t.Errorf("expected device path %s, got %s", expected, path)
+			}
+		})
+	}
+}
+
+func TestGetDevicePathByPath(t *testing.T) {
+	cases := []struct {
+		name     string
+		deviceID string
+		entries  []string
+		expected string
+	}{
+		{"device ID", "3", []string{"pci-0000:00:05.0-scsi-0:0:1:0", "pci-0000:00:05.0-scsi-0:0:3:0"}, "pci-0000:00:05.0-scsi-0:0:3:0"},
+		{"other device ID", "2", []string{"pci-0000:00:05.0-scsi-0:0:3:0"}, ""},
+		{"several controllers", "3", []string{"pci-0000:00:05.0-scsi-0:0:3:0", "pci-0000:00:06.0-scsi-0:0:3:0"}, ""},
+		{"no device ID", "", []string{"pci-0000:00:05.0-scsi-0:0:3:0"}, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := newTestMounter(t, Options{
+				DevicePathBackoff: wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 1},
+			})
+			m.sysBlockPath = t.TempDir()
+			m.udevadmPath = ""
+			for _, name := range c.entries {
+				createDiskIDEntry(t, m.diskPathPath, name)
+			}
+
+			path, err := m.GetDevicePathByDeviceID(context.Background(), testVolumeID, c.deviceID, "")
+			if c.expected == "" {
+				if !errors.Is(err, ErrDeviceNotFound) {
+					t.Fatalf("expected ErrDeviceNotFound, got %v", err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if expected := filepath.Join(m.diskPathPath, c.expected); path != expected {
+				t.Errorf("expected device path %s, got %s", expected, path)
+			}
+		})
+	}
+
+	t.Run("serial first", func(t *testing.T) {
+		m := newTestMounter(t, Options{})
+		m.sysBlockPath = t.TempDir()
+		expected := createDiskIDEntry(t, m.diskIDPath, "virtio-"+diskUUIDToSerial(testVolumeID))
+		createDiskIDEntry(t, m.diskPathPath, "pci-0000:00:05.0-scsi-0:0:3:0")
+
+		path, err := m.GetDevicePathByDeviceID(context.Background(), testVolumeID, "3", "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if path != expected {
+			t.Errorf("expected device path %s, got %s", expected, path)
+		}
+	})
+}
+
+func TestGetDevicePathNVMeByID(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	expected := createDiskIDEntry(t, m.diskIDPath, "nvme-QEMU_NVMe_Ctrl_"+diskUUIDToSerial(testVolumeID))
+
+	path, err := m.GetDevicePath(context.Background(), testVolumeID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != expected {
+		t.Errorf("expected device path %s, got %s", expected, path)
+	}
+}
+
+func TestGetDevicePathNVMeSysfs(t *testing.T) {
+	m := newTestMounter(t, Options{})
+
+	// nvme0 is another volume, nvme1 holds the one we are looking for.
+	// Its serial is not truncated and is padded with spaces.
+	controllers := map[string]string{
+		"nvme0": "0d7107a394d244e789b8",
+		"nvme1": "ace9f28b308140c183534cc3e3014072    \n",
+	}
+	for name, serial := range controllers {
+		if err := os.MkdirAll(filepath.Join(m.nvmeSysPath, name, name+"n1"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(m.nvmeSysPath, name, "serial"), []byte(serial), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path, err := m.GetDevicePath(context.Background(), testVolumeID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "/dev/nvme1n1" {
+		t.Errorf("expected device path /dev/nvme1n1, got %s", path)
+	}
+}
+
+// fakeCommand is the scripted result of a command run through exec.
+type fakeCommand struct {
+	output string
+	err    error
+}
+
+// commandLog records the commands run through a scripted exec.
+type commandLog struct {
+	cmds []*exec.FakeCmd
+}
+
+// commands returns the command lines that were run.
+func (l *commandLog) commands() []string {
+	cmds := make([]string, 0, len(l.cmds))
+	for _, c := range l.cmds {
+		cmds = append(cmds, strings.Join(c.Argv, " "))
+	}
+
+	return cmds
+}
+
+// stdin returns what was written to the standard input of the i-th command.
+func (l *commandLog) stdin(t *testing.T, i int) string {
+	t.Helper()
+
+	if l.cmds[i].Stdin == nil {
+		return ""
+	}
+	b, err := io.ReadAll(l.cmds[i].Stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+// newScriptedExec returns a fake exec which runs the given commands in
+// order, and a log of the commands that were actually run.
+func newScriptedExec(cmds ...fakeCommand) (*exec.FakeExec, *commandLog) {
+	log := &commandLog{}
+	fakeExec := &exec.FakeExec{}
+	for _, c := range cmds {
+		c := c
+		action := func() ([]byte, []byte, error) { return []byte(c.output), nil, c.err }
+		fakeExec.CommandScript = append(fakeExec.CommandScript, func(cmd string, args ...string) kexec.Cmd {
+			fakeCmd := &exec.FakeCmd{
+				CombinedOutputScript: []exec.FakeAction{action},
+				OutputScript:         []exec.FakeAction{action},
+				RunScript:            []exec.FakeAction{action},
+			}
+			log.cmds = append(log.cmds, fakeCmd)
+
+			return exec.InitFakeCmd(fakeCmd, cmd, args...)
+		})
+	}
+
+	return fakeExec, log
+}
+
+func assertCommands(t *testing.T, log *commandLog, expected []string) {
+	t.Helper()
+
+	if got := log.commands(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected commands\ngot:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+const blkidArgs = "blkid -p -s TYPE -s PTTYPE -o export "
+
+func TestFormatAndMountWithEncryptionNewDevice(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	m.mapperPath = t.TempDir()
+	fakeExec, log := newScriptedExec(
+		fakeCommand{err: exec.FakeExitError{Status: 1}}, // cryptsetup isLuks
+		fakeCommand{err: exec.FakeExitError{Status: 2}}, // blkid: blank device
+		fakeCommand{}, // cryptsetup luksFormat
+		fakeCommand{}, // cryptsetup luksOpen
+		fakeCommand{err: exec.FakeExitError{Status: 2}}, // blkid: blank mapper device
+		fakeCommand{}, // mkfs.ext4
+	)
+	m.Exec = fakeExec
+
+	source := "/dev/disk/by-id/virtio-ace9f28b308140c18353"
+	mapperDevice := filepath.Join(m.mapperPath, luksMapperName(testVolumeID))
+
+	if err := m.FormatAndMountWithEncryption(testVolumeID, source, "/target", "ext4", nil, nil, "secret"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertCommands(t, log, []string{
+		"cryptsetup isLuks " + source,
+		blkidArgs + source,
+		"cryptsetup -q luksFormat --type luks2 --key-file - " + source,
+		"cryptsetup luksOpen --key-file - " + source + " " + luksMapperName(testVolumeID),
+		blkidArgs + mapperDevice,
+		"mkfs.ext4 -F -m0 " + mapperDevice,
+	})
+	for _, i := range []int{2, 3} {
+		if stdin := log.stdin(t, i); stdin != "secret" {
+			t.Errorf("command %d: expected passphrase on stdin, got %q", i, stdin)
+		}
+	}
+}
+
+func TestFormatAndMountWithEncryptionAlreadyOpened(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	m.mapperPath = t.TempDir()
+	source := "/dev/disk/by-id/virtio-ace9f28b308140c18353"
+	fakeExec, log := newScriptedExec(
+		fakeCommand{output: "  type:    LUKS2\n  device:  " + source + "\n"}, // cryptsetup status
+		fakeCommand{output: "TYPE=ext4\n"},                                   // blkid
+		fakeCommand{},                                                        // fsck
+	)
+	m.Exec = fakeExec
+
+	name := luksMapperName(testVolumeID)
+	mapperDevice := createDiskIDEntry(t, m.mapperPath, name)
+
+	if err := m.FormatAndMountWithEncryption(testVolumeID, source, "/target", "ext4", nil, nil, "secret"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertCommands(t, log, []string{
+		"cryptsetup status " + name,
+		blkidArgs + mapperDevice,
+		"fsck -a " + mapperDevice,
+	})
+}
+
+func TestFormatAndMountWithEncryptionOpenedOnOtherDevice(t *testing.T) {
+	source := "/dev/disk/by-id/virtio-ace9f28b308140c18353"
+	name := luksMapperName(testVolumeID)
+
+	t.Run("not mounted", func(t *testing.T) {
+		m := newTestMounter(t, Options{})
+		m.mapperPath = t.TempDir()
+		fakeExec, log := newScriptedExec(
+			fakeCommand{output: "  device:  /dev/vdb\n"}, // cryptsetup status
+			fakeCommand{},                      // cryptsetup luksClose
+			fakeCommand{},                      // cryptsetup isLuks
+			fakeCommand{},                      // cryptsetup luksOpen
+			fakeCommand{output: "TYPE=ext4\n"}, // blkid
+			fakeCommand{},                      // fsck
+		)
+		m.Exec = fakeExec
+		mapperDevice := createDiskIDEntry(t, m.mapperPath, name)
+
+		if err := m.FormatAndMountWithEncryption(testVolumeID, source, "/target", "ext4", nil, nil, "secret"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// The stale mapping is closed and the volume opened again.
+		assertCommands(t, log, []string{
+			"cryptsetup status " + name,
+			"cryptsetup luksClose " + name,
+			"cryptsetup isLuks " + source,
+			"cryptsetup luksOpen --key-file - " + source + " " + name,
+			blkidArgs + mapperDevice,
+			"fsck -a " + mapperDevice,
+		})
+	})
+
+	t.Run("mounted", func(t *testing.T) {
+		m := newTestMounter(t, Options{})
+		m.mapperPath = t.TempDir()
+		fakeExec, log := newScriptedExec(
+			fakeCommand{output: "  device:  /dev/vdb\n"}, // cryptsetup status
+		)
+		m.Exec = fakeExec
+		mapperDevice := createDiskIDEntry(t, m.mapperPath, name)
+		m.Interface = mount.NewFakeMounter([]mount.MountPoint{{Device: mapperDevice, Path: t.TempDir()}})
+
+		if err := m.FormatAndMountWithEncryption(testVolumeID, source, "/target", "ext4", nil, nil, "secret"); err == nil {
+			t.Fatal("expected an error")
+		}
+		assertCommands(t, log, []string{"cryptsetup status " + name})
+	})
+}
+
+func TestFormatAndMountWithEncryptionExistingData(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	m.mapperPath = t.TempDir()
+	fakeExec, log := newScriptedExec(
+		fakeCommand{err: exec.FakeExitError{Status: 1}}, // cryptsetup isLuks
+		fakeCommand{output: "TYPE=ext4\n"},              // blkid
+	)
+	m.Exec = fakeExec
+
+	source := "/dev/disk/by-id/virtio-ace9f28b308140c18353"
+	if err := m.FormatAndMountWithEncryption(testVolumeID, source, "/target", "ext4", nil, nil, "secret"); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	assertCommands(t, log, []string{
+		"cryptsetup isLuks " + source,
+		blkidArgs + source,
+	})
+}
+
+func TestUnstageClosesEncryptedDevice(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	m.mapperPath = "/dev/mapper"
+	target := t.TempDir()
+	m.Interface = mount.NewFakeMounter([]mount.MountPoint{
+		{Device: "/dev/mapper/" + luksMapperName(testVolumeID), Path: target},
+	})
+	// The LUKS device exists.
+	m.stat = func(string) (os.FileInfo, error) { return nil, nil }
+	fakeExec, log := newScriptedExec(
+		fakeCommand{}, // cryptsetup luksClose
+	)
+	m.Exec = fakeExec
+
+	if err := m.Unstage(target); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertCommands(t, log, []string{
+		"cryptsetup luksClose " + luksMapperName(testVolumeID),
+	})
+}
+
+func TestUnstageDeviceSettle(t *testing.T) {
+	for name, c := range map[string]struct {
+		// lookups is the number of lookups of the device after which
+		// it shows up again.
+		lookups int
+		delay   time.Duration
+		// forced tells whether the device is gone, and then lazily
+		// unmounted right away.
+		forced bool
+	}{
+		"reappeared":   {lookups: 1, delay: 10 * time.Millisecond},
+		"gone":         {lookups: 3, delay: 10 * time.Millisecond, forced: true},
+		"no delay set": {lookups: 1, forced: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := newTestMounter(t, Options{DeviceSettleDelay: c.delay})
+			target := filepath.Join(t.TempDir(), "staging")
+			if err := os.Mkdir(target, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			m.Interface = mount.NewFakeMounter([]mount.MountPoint{{Device: "/dev/sdb", Path: target}})
+			lookups := 0
+			m.stat = func(path string) (os.FileInfo, error) {
+				if lookups++; lookups <= c.lookups {
+					return nil, &os.PathError{Op: "stat", Path: path, Err: syscall.ENOENT}
+				}
+
+				return nil, nil
+			}
+			var commands []fakeCommand
+			expected := []string{}
+			if c.forced {
+				commands = []fakeCommand{{}} // umount -l -f
+				expected = []string{"umount -l -f " + target}
+			}
+			fakeExec, log := newScriptedExec(commands...)
+			m.Exec = fakeExec
+
+			if err := m.Unstage(target); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			assertCommands(t, log, expected)
+			if _, err := os.Stat(target); !os.IsNotExist(err) {
+				t.Errorf("target should be removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestCloseEncryptedVolume(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	m.mapperPath = t.TempDir()
+	leftover := createDiskIDEntry(t, m.mapperPath, luksMapperName(testVolumeID))
+	mounted := createDiskIDEntry(t, m.mapperPath, luksMapperName("5e0d3c1a-9b7f-4e2d-8c6a-1f2e3d4c5b6a"))
+	m.Interface = mount.NewFakeMounter([]mount.MountPoint{
+		{Device: mounted, Path: t.TempDir()},
+	})
+	fakeExec, log := newScriptedExec(
+		fakeCommand{}, // cryptsetup luksClose
+	)
+	m.Exec = fakeExec
+
+	if err := m.CloseEncryptedVolume(testVolumeID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The device of the other volume is mounted: it is left open.
+	if err := m.CloseEncryptedVolume("5e0d3c1a-9b7f-4e2d-8c6a-1f2e3d4c5b6a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertCommands(t, log, []string{
+		"cryptsetup luksClose " + filepath.Base(leftover),
+	})
+}
+
+func TestResizeEncryptedVolume(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	m.mapperPath = t.TempDir()
+	mapperDevice := createDiskIDEntry(t, m.mapperPath, luksMapperName(testVolumeID))
+	fakeExec, log := newScriptedExec(
+		fakeCommand{}, // cryptsetup resize
+		fakeCommand{}, // cryptsetup resize, with the passphrase
+	)
+	m.Exec = fakeExec
+
+	for _, passphrase := range []string{"", "secret"} {
+		path, err := m.ResizeEncryptedVolume(testVolumeID, passphrase)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if path != mapperDevice {
+			t.Errorf("expected LUKS device %s, got %s", mapperDevice, path)
+		}
+	}
+	// Volumes that are not encrypted have nothing to resize.
+	path, err := m.ResizeEncryptedVolume("5e0d3c1a-9b7f-4e2d-8c6a-1f2e3d4c5b6a", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "" {
+		t.Errorf("expected no LUKS device, got %s", path)
+	}
+
+	assertCommands(t, log, []string{
+		"cryptsetup resize " + luksMapperName(testVolumeID),
+		"cryptsetup resize --key-file - " + luksMapperName(testVolumeID),
+	})
+}
+
+func TestGetStatistics(t *testing.T) {
+	m := newTestMounter(t, Options{})
+
+	stats, err := m.GetStatistics(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if stats.TotalBytes <= 0 {
+		t.Errorf("expected positive total bytes, got %d", stats.TotalBytes)
+	}
+	if stats.UsedBytes+stats.AvailableBytes > stats.TotalBytes {
+		t.Errorf("used (%d) + available (%d) bytes exceed total (%d)", stats.UsedBytes, stats.AvailableBytes, stats.TotalBytes)
+	}
+	if stats.UsedInodes+stats.AvailableInodes != stats.TotalInodes {
+		t.Errorf("used (%d) + available (%d) inodes differ from total (%d)", stats.UsedInodes, stats.AvailableInodes, stats.TotalInodes)
+	}
+}
+
+func TestProbeVolumeSettlesUdev(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	fakeExec, log := newScriptedExec(
+		fakeCommand{},                           // udevadm trigger
+		fakeCommand{err: errors.New("timeout")}, // udevadm settle
+	)
+	m.Exec = fakeExec
+
+	m.probeVolume(context.Background())
+
+	assertCommands(t, log, []string{
+		"udevadm trigger",
+		"udevadm settle --timeout=10",
+	})
+}
+
+func TestProbeVolumeUdevadm(t *testing.T) {
+	for name, c := range map[string]struct {
+		opts     Options
+		expected []string
+	}{
+		"custom path": {
+			opts: Options{UdevadmPath: "/usr/bin/udevadm"},
+			expected: []string{
+				"/usr/bin/udevadm trigger",
+				"/usr/bin/udevadm settle --timeout=10",
+			},
+		},
+		"disabled": {
+			opts:     Options{UdevadmPath: "/usr/bin/udevadm", DisableUdevadm: true},
+			expected: []string{},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := newTestMounter(t, c.opts)
+			hostDir := filepath.Join(m.scsiHostPath, "host0")
+			if err := os.Mkdir(hostDir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			fakeExec, log := newScriptedExec(make([]fakeCommand, len(c.expected))...)
+			m.Exec = fakeExec
+
+			m.probeVolume(context.Background())
+
+			assertCommands(t, log, c.expected)
+			// The SCSI rescan happens anyway.
+			if data, err := os.ReadFile(filepath.Join(hostDir, "scan")); err != nil || string(data) != "- - -" {
+				t.Errorf("SCSI host was not rescanned: %q, %v", data, err)
+			}
+		})
+	}
+}
+
+func TestHostPaths(t *testing.T) {
+	procPath, sysPath := t.TempDir(), t.TempDir()
+	m, ok := New(Options{ProcPath: procPath, SysPath: sysPath, DisableUdevadm: true}).(*mounter)
+	if !ok {
+		t.Fatal("New did not return a *mounter")
+	}
+
+	t.Run("proc", func(t *testing.T) {
+		mounts := "/dev/sdb /var/lib/kubelet/staging ext4 rw,relatime 0 0\n" +
+			"/dev/sdb /var/lib/kubelet/pods/target ext4 rw,relatime 0 0\n"
+		if err := os.WriteFile(filepath.Join(procPath, "mounts"), []byte(mounts), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		device, refCount, err := m.GetDeviceName("/var/lib/kubelet/staging")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if device != "/dev/sdb" || refCount != 2 {
+			t.Errorf("expected /dev/sdb mounted twice, got %q mounted %d times", device, refCount)
+		}
+	})
+
+	t.Run("sys", func(t *testing.T) {
+		hostDir := filepath.Join(sysPath, "class", "scsi_host", "host0")
+		if err := os.MkdirAll(hostDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		m.probeVolume(context.Background())
+
+		if data, err := os.ReadFile(filepath.Join(hostDir, "scan")); err != nil || string(data) != "- - -" {
+			t.Errorf("SCSI host was not rescanned: %q, %v", data, err)
+		}
+	})
+}
+
+func TestProbeVolumeSCSIRescanDisabled(t *testing.T) {
+	m := newTestMounter(t, Options{DisableSCSIRescan: true})
+	hostDir := filepath.Join(m.scsiHostPath, "host0")
+	if err := os.Mkdir(hostDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	fakeExec, log := newScriptedExec(fakeCommand{}, fakeCommand{})
+	m.Exec = fakeExec
+
+	m.probeVolume(context.Background())
+
+	// udevadm runs anyway.
+	assertCommands(t, log, []string{
+		"udevadm trigger",
+		"udevadm settle --timeout=10",
+	})
+	if _, err := os.Stat(filepath.Join(hostDir, "scan")); !os.IsNotExist(err) {
+		t.Errorf("SCSI host was rescanned: %v", err)
+	}
+}
+
+func TestProbeVolumeCanceled(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	// Opening a FIFO without reader blocks, like a hung sysfs write.
+	hostDir := filepath.Join(m.scsiHostPath, "host0")
+	if err := os.Mkdir(hostDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(hostDir, "scan"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// No command is scripted: udevadm must not run.
+	m.Exec = &exec.FakeExec{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.probeVolume(ctx)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("probeVolume did not return after the context was canceled")
+	}
+
+	// Unblock the pending write.
+	if f, err := os.OpenFile(filepath.Join(hostDir, "scan"), os.O_RDONLY, 0); err == nil {
+		_, _ = io.Copy(io.Discard, f)
+		_ = f.Close()
+	}
+}
+
+func TestGetDevicePathMultipath(t *testing.T) {
+	serial := diskUUIDToSerial(testVolumeID)
+	for _, multipath := range []bool{true, false} {
+		t.Run(strconv.FormatBool(multipath), func(t *testing.T) {
+			m := newTestMounter(t, Options{Multipath: multipath})
+			m.mapperPath = "/dev/mapper"
+			m.sysBlockPath = t.TempDir()
+
+			// The multipath device and one of its paths.
+			devDir := t.TempDir()
+			dmDevice := createDiskIDEntry(t, devDir, "dm-3")
+			if err := os.Symlink(dmDevice, filepath.Join(m.diskIDPath, "dm-uuid-mpath-0QEMU_QEMU_HARDDISK_"+serial)); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.MkdirAll(filepath.Join(m.sysBlockPath, "dm-3", "dm"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(m.sysBlockPath, "dm-3", "dm", "name"), []byte("mpatha\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			singlePath := createDiskIDEntry(t, m.diskIDPath, "scsi-0QEMU_QEMU_HARDDISK_"+serial)
+
+			expected := singlePath
+			if multipath {
+				expected = "/dev/mapper/mpatha"
+			}
+
+			path, err := m.GetDevicePath(context.Background(), testVolumeID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if path != expected {
+				t.Errorf("expected device path %s, got %s", expected, path)
+			}
+		})
+	}
+}
+
+func TestBindBlockDevice(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	// No command is scripted: running mkfs, or anything else, panics.
+	m.Exec = &exec.FakeExec{}
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{})
+	m.Interface = fakeMounter
+
+	target := filepath.Join(t.TempDir(), "publish", "volume")
+	if err := m.BindBlockDevice("/dev/sdb", target, []string{"ro"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if isFile, err := isRegularFile(target); err != nil || !isFile {
+		t.Errorf("expected %s to be a file, err: %v", target, err)
+	}
+	expected := []mount.MountPoint{{Device: "/dev/sdb", Path: target, Type: "", Opts: []string{"bind", "ro"}}}
+	if mps := fakeMounter.MountPoints; !reflect.DeepEqual(mps, expected) {
+		t.Errorf("expected mount points %v, got %v", expected, mps)
+	}
+
+	if err := m.Unpublish(target); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, err: %v", target, err)
+	}
+}
+
+func isRegularFile(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+
+	return info.Mode().IsRegular(), nil
+}
+
+func TestFormatAndMountBtrfs(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	fakeExec, log := newScriptedExec(
+		fakeCommand{err: exec.FakeExitError{Status: 2}}, // blkid: blank device
+		fakeCommand{}, // mkfs.btrfs
+	)
+	m.Exec = fakeExec
+
+	if err := m.FormatAndMount("/dev/sdb", "/target", "btrfs", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertCommands(t, log, []string{
+		blkidArgs + "/dev/sdb",
+		"mkfs.btrfs -f /dev/sdb",
+	})
+}
+
+func TestResizeBtrfs(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	fakeExec, log := newScriptedExec(
+		fakeCommand{output: "TYPE=btrfs\n"}, // blkid
+		fakeCommand{},                       // btrfs filesystem resize
+	)
+	m.Exec = fakeExec
+
+	if _, err := m.Resize("/dev/sdb", "/target"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertCommands(t, log, []string{
+		blkidArgs + "/dev/sdb",
+		"btrfs filesystem resize max /target",
+	})
+}
+
+func TestResize(t *testing.T) {
+	for format, expected := range map[string]string{
+		"ext3": "resize2fs /dev/sdb",
+		"ext4": "resize2fs /dev/sdb",
+		"xfs":  "xfs_growfs -d /target",
+	} {
+		t.Run(format, func(t *testing.T) {
+			m := newTestMounter(t, Options{})
+			fakeExec, log := newScriptedExec(
+				fakeCommand{output: "TYPE=" + format + "\n"}, // blkid
+				fakeCommand{}, // resize
+			)
+			m.Exec = fakeExec
+
+			if _, err := m.Resize("/dev/sdb", "/target"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			assertCommands(t, log, []string{blkidArgs + "/dev/sdb", expected})
+		})
+	}
+}
+
+func TestResizeUnsupportedFormat(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	fakeExec, log := newScriptedExec(
+		fakeCommand{output: "TYPE=vfat\n"}, // blkid
+	)
+	m.Exec = fakeExec
+
+	if _, err := m.Resize("/dev/sdb", "/target"); err == nil || !strings.Contains(err.Error(), `"vfat"`) {
+		t.Errorf("expected an unsupported format error, got %v", err)
+	}
+	assertCommands(t, log, []string{blkidArgs + "/dev/sdb"})
+}
+
+func TestFormatAndMountVerifyDevice(t *testing.T) {
+	readable := filepath.Join(t.TempDir(), "sdb")
+	if err := os.WriteFile(readable, make([]byte, 4096), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		source  string
+		wantErr bool
+	}{
+		{"readable device", readable, false},
+		// Reading a directory fails like an unreadable device.
+		{"unreadable device", t.TempDir(), true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := newTestMounter(t, Options{VerifyDevice: true})
+			fakeExec, _ := newScriptedExec(
+				fakeCommand{err: exec.FakeExitError{Status: 2}}, // blkid: blank device
+				fakeCommand{}, // mkfs.ext4
+			)
+			m.Exec = fakeExec
+
+			err := m.FormatAndMount(c.source, "/target", "ext4", nil)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("expected error %v, got %v", c.wantErr, err)
+			}
+			mountPoints, listErr := m.List()
+			if listErr != nil {
+				t.Fatal(listErr)
+			}
+			if mounted := len(mountPoints) == 1; mounted == c.wantErr {
+				t.Errorf("expected the target to be mounted only when the device is readable, got %v", mountPoints)
+			}
+		})
+	}
+}
+
+func TestFormatAndMountWithFormatOptions(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	fakeExec, log := newScriptedExec(
+		fakeCommand{err: exec.FakeExitError{Status: 2}}, // blkid: blank device
+		fakeCommand{}, // mkfs.ext4
+	)
+	m.Exec = fakeExec
+
+	if err := m.FormatAndMountWithFormatOptions("/dev/sdb", "/target", "ext4", nil, []string{"-I", "512", "-O", "bigalloc"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertCommands(t, log, []string{
+		blkidArgs + "/dev/sdb",
+		"mkfs.ext4 -I 512 -O bigalloc -F -m0 /dev/sdb",
+	})
+}
+
+func TestWithExt4LazyInit(t *testing.T) {
+	cases := []struct {
+		name          string
+		fstype        string
+		formatOptions []string
+		lazy          bool
+		expected      []string
+	}{
+		{"lazy", "ext4", nil, true, []string{"-E", "lazy_itable_init=1,lazy_journal_init=1"}},
+		{"not lazy", "ext4", []string{"-I", "512"}, false, []string{"-E", "lazy_itable_init=0,lazy_journal_init=0", "-I", "512"}},
+		{"extended options", "ext4", []string{"-E", "stride=16"}, true, []string{"-E", "lazy_itable_init=1,lazy_journal_init=1,stride=16"}},
+		{"lazy options set", "ext4", []string{"-E", "lazy_itable_init=0"}, true, []string{"-E", "lazy_itable_init=0"}},
+		{"xfs", "xfs", []string{"-K"}, true, []string{"-K"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if formatOptions := WithExt4LazyInit(c.fstype, c.formatOptions, c.lazy); !reflect.DeepEqual(formatOptions, c.expected) {
+				t.Errorf("expected format options %v, got %v", c.expected, formatOptions)
+			}
+		})
+	}
+
+	t.Run("mkfs", func(t *testing.T) {
+		m := newTestMounter(t, Options{})
+		fakeExec, log := newScriptedExec(
+			fakeCommand{err: exec.FakeExitError{Status: 2}}, // blkid: blank device
+			fakeCommand{}, // mkfs.ext4
+		)
+		m.Exec = fakeExec
+
+		if err := m.FormatAndMountWithFormatOptions("/dev/sdb", "/target", "ext4", nil, WithExt4LazyInit("ext4", nil, true)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		assertCommands(t, log, []string{
+			blkidArgs + "/dev/sdb",
+			"mkfs.ext4 -E lazy_itable_init=1,lazy_journal_init=1 -F -m0 /dev/sdb",
+		})
+	})
+}
+
+func TestFormatAndMountWithInvalidFormatOptions(t *testing.T) {
+	for _, formatOptions := range [][]string{
+		{"-L", "data", "/dev/sdc"},
+		{"/dev/sdb"},
+		{"-d", "/target"},
+	} {
+		t.Run(strings.Join(formatOptions, " "), func(t *testing.T) {
+			m := newTestMounter(t, Options{})
+			// No command is scripted: nothing must run.
+			m.Exec = &exec.FakeExec{}
+
+			if err := m.FormatAndMountWithFormatOptions("/dev/sdb", "/target", "ext4", nil, formatOptions); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestMountWithProjectQuota(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	fakeExec, log := newScriptedExec(fakeCommand{}, fakeCommand{})
+	fakeExec.LookPathFunc = func(file string) (string, error) { return "/usr/sbin/" + file, nil }
+	m.Exec = fakeExec
+
+	if err := m.MountWithProjectQuota("/dev/sdb", "/target", "xfs", []string{"noatime"}, 42, 5*giB); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertCommands(t, log, []string{
+		"xfs_quota -x -c project -s -p /target 42 /target",
+		"xfs_quota -x -c limit -p bhard=5368709120 42 /target",
+	})
+
+	mountPoints, _ := m.List()
+	if len(mountPoints) != 1 || !reflect.DeepEqual(mountPoints[0].Opts, []string{"noatime", "prjquota"}) {
+		t.Errorf("unexpected mount points: %+v", mountPoints)
+	}
+}
+
+func TestMountWithProjectQuotaNotXFS(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	// No command is scripted: nothing must run.
+	m.Exec = &exec.FakeExec{}
+
+	if err := m.MountWithProjectQuota("/dev/sdb", "/target", "ext4", nil, 42, 5*giB); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mountPoints, _ := m.List()
+	if len(mountPoints) != 1 || len(mountPoints[0].Opts) != 0 {
+		t.Errorf("unexpected mount points: %+v", mountPoints)
+	}
+}
+
+func TestMountWithProjectQuotaMissingXFSQuota(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	m.Exec = &exec.FakeExec{
+		LookPathFunc: func(file string) (string, error) { return "", kexec.ErrExecutableNotFound },
+	}
+
+	if err := m.MountWithProjectQuota("/dev/sdb", "/target", "xfs", nil, 42, 5*giB); !errors.Is(err, kexec.ErrExecutableNotFound) {
+		t.Errorf("expected ErrExecutableNotFound, got %v", err)
+	}
+
+	if mountPoints, _ := m.List(); len(mountPoints) != 0 {
+		t.Errorf("volume should not be mounted: %+v", mountPoints)
+	}
+}
+
+func TestFormatAndMountRefuseFormatMismatch(t *testing.T) {
+	m := newTestMounter(t, Options{RefuseFormatMismatch: true})
+	fakeExec, log := newScriptedExec(
+		fakeCommand{output: "DEVNAME=/dev/sdb\nTYPE=ext4\n"}, // blkid
+	)
+	m.Exec = fakeExec
+
+	if err := m.FormatAndMount("/dev/sdb", "/target", "xfs", nil); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	assertCommands(t, log, []string{blkidArgs + "/dev/sdb"})
+	if mountPoints, _ := m.List(); len(mountPoints) != 0 {
+		t.Errorf("volume should not be mounted: %+v", mountPoints)
+	}
+}
+
+func TestFormatAndMountRefuseFormatMismatchSameFormat(t *testing.T) {
+	m := newTestMounter(t, Options{RefuseFormatMismatch: true})
+	fakeExec, _ := newScriptedExec(
+		fakeCommand{output: "DEVNAME=/dev/sdb\nTYPE=ext4\n"}, // blkid, by checkDiskFormat
+		fakeCommand{output: "DEVNAME=/dev/sdb\nTYPE=ext4\n"}, // blkid, by SafeFormatAndMount
+		fakeCommand{}, // fsck
+	)
+	m.Exec = fakeExec
+
+	if err := m.FormatAndMount("/dev/sdb", "/target", "ext4", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if mountPoints, _ := m.List(); len(mountPoints) != 1 {
+		t.Errorf("volume should be mounted: %+v", mountPoints)
+	}
+}
+
+func TestGetDevicePathErrors(t *testing.T) {
+	t.Run("not found", func(t *testing.T) {
+		m := newTestMounter(t, Options{DevicePathBackoff: wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 2}})
+
+		_, err := m.GetDevicePath(context.Background(), testVolumeID)
+		if !errors.Is(err, ErrDeviceNotFound) {
+			t.Errorf("expected ErrDeviceNotFound, got %v", err)
+		}
+		if errors.Is(err, ErrDeviceProbeFailed) {
+			t.Errorf("unexpected ErrDeviceProbeFailed: %v", err)
+		}
+	})
+
+	t.Run("wait ended", func(t *testing.T) {
+		m := newTestMounter(t, Options{DevicePathBackoff: wait.Backoff{Duration: time.Hour, Factor: 1, Steps: 2}})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := m.GetDevicePath(ctx, testVolumeID)
+		if !errors.Is(err, ErrDeviceNotFound) {
+			t.Errorf("expected ErrDeviceNotFound, got %v", err)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected the context error to be wrapped, got %v", err)
+		}
+	})
+
+	t.Run("probe failed", func(t *testing.T) {
+		m := newTestMounter(t, Options{})
+		// Looking up entries below a regular file fails with ENOTDIR.
+		m.diskIDPath = createDiskIDEntry(t, t.TempDir(), "by-id")
+
+		_, err := m.GetDevicePath(context.Background(), testVolumeID)
+		if !errors.Is(err, ErrDeviceProbeFailed) {
+			t.Errorf("expected ErrDeviceProbeFailed, got %v", err)
+		}
+		if !errors.Is(err, syscall.ENOTDIR) {
+			t.Errorf("expected the cause to be wrapped, got %v", err)
+		}
+		if errors.Is(err, ErrDeviceNotFound) {
+			t.Errorf("unexpected ErrDeviceNotFound: %v", err)
+		}
+	})
+}
+
+func TestDryRun(t *testing.T) {
+	m := newTestMounter(t, Options{DryRun: true})
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{{Device: "/dev/sdb", Path: "/staging"}})
+	m.Interface = fakeMounter
+	// No command is scripted: nothing must run.
+	m.Exec = &exec.FakeExec{}
+
+	dir := filepath.Join(t.TempDir(), "dir")
+	if err := m.MakeDir(dir); err != nil {
+		t.Errorf("MakeDir: %v", err)
+	}
+	if err := m.MakeFile(filepath.Join(dir, "file")); err != nil {
+		t.Errorf("MakeFile: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("%s should not have been created", dir)
+	}
+
+	if err := m.FormatAndMount("/dev/sdc", "/target", "ext4", nil); err != nil {
+		t.Errorf("FormatAndMount: %v", err)
+	}
+	if err := m.FormatAndMountWithEncryption(testVolumeID, "/dev/sdc", "/target", "ext4", nil, nil, "secret"); err != nil {
+		t.Errorf("FormatAndMountWithEncryption: %v", err)
+	}
+	if err := m.Mount("/staging", "/target", "", []string{"bind"}); err != nil {
+		t.Errorf("Mount: %v", err)
+	}
+	if err := m.Unpublish("/target"); err != nil {
+		t.Errorf("Unpublish: %v", err)
+	}
+	if err := m.Unstage("/staging"); err != nil {
+		t.Errorf("Unstage: %v", err)
+	}
+
+	if log := fakeMounter.GetLog(); len(log) != 0 {
+		t.Errorf("unexpected mount actions: %+v", log)
+	}
+	if mountPoints, _ := m.List(); len(mountPoints) != 1 {
+		t.Errorf("mount points should not have changed: %+v", mountPoints)
+	}
+}
+
+func TestDryRunGetDevicePath(t *testing.T) {
+	m := newTestMounter(t, Options{DryRun: true})
+	expected := createDiskIDEntry(t, m.diskIDPath, "virtio-"+diskUUIDToSerial(testVolumeID))
+
+	path, err := m.GetDevicePath(context.Background(), testVolumeID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != expected {
+		t.Errorf("expected %q, got %q", expected, path)
+	}
+}
+
+func TestGetDevicePathCustomDiskIDPath(t *testing.T) {
+	dir := t.TempDir()
+	m, ok := New(Options{DiskIDPath: dir}).(*mounter)
+	if !ok {
+		t.Fatal("New did not return a *mounter")
+	}
+	expected := createDiskIDEntry(t, dir, "virtio-"+diskUUIDToSerial(testVolumeID))
+
+	path, err := m.GetDevicePath(context.Background(), testVolumeID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != expected {
+		t.Errorf("expected %q, got %q", expected, path)
+	}
+}
+
+func TestGetBlockSizeBytes(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	fakeExec, log := newScriptedExec(fakeCommand{output: "10737418240\n"})
+	m.Exec = fakeExec
+
+	size, err := m.GetBlockSizeBytes("/dev/sdb")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if size != 10*giB {
+		t.Errorf("expected %d, got %d", 10*giB, size)
+	}
+	assertCommands(t, log, []string{"blockdev --getsize64 /dev/sdb"})
+}
+
+func TestRescanDevice(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	m.sysBlockPath = t.TempDir()
+	rescan := filepath.Join(m.sysBlockPath, "sdb", "device", "rescan")
+	if err := os.MkdirAll(filepath.Dir(rescan), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(rescan, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.RescanDevice("/dev/sdb"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, _ := os.ReadFile(rescan); string(data) != "1" {
+		t.Errorf("expected 1 to be written to %s, got %q", rescan, data)
+	}
+
+	// Virtio block devices cannot be rescanned.
+	if err := m.RescanDevice("/dev/vdb"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(m.sysBlockPath, "vdb")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no rescan file to be created, got %v", err)
+	}
+}
+
+func TestGetBlockSizeBytesErrors(t *testing.T) {
+	for name, c := range map[string]fakeCommand{
+		"missing blockdev": {err: kexec.ErrExecutableNotFound},
+		"failure":          {output: "blockdev: cannot open /dev/sdb", err: exec.FakeExitError{Status: 1}},
+		"invalid output":   {output: "not a number"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := newTestMounter(t, Options{})
+			m.Exec, _ = newScriptedExec(c)
+
+			if _, err := m.GetBlockSizeBytes("/dev/sdb"); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestForceCleanupMountPoint(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	target := filepath.Join(t.TempDir(), "staging")
+	if err := os.Mkdir(target, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	m.Interface = mount.NewFakeMounter([]mount.MountPoint{{Device: "/dev/sdb", Path: target}})
+	// No command is scripted: the regular unmount must be enough.
+	m.Exec = &exec.FakeExec{}
+
+	if err := m.ForceCleanupMountPoint(target); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if mountPoints, _ := m.List(); len(mountPoints) != 0 {
+		t.Errorf("target should be unmounted: %+v", mountPoints)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("target should be removed: %v", err)
+	}
+}
+
+func TestForceCleanupMountPointHungUnmount(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	m.unmountTimeout = 50 * time.Millisecond
+	target := filepath.Join(t.TempDir(), "staging")
+	if err := os.Mkdir(target, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The regular unmount hangs, like on a dead device.
+	release := make(chan struct{})
+	defer close(release)
+	fakeMounter := mount.NewFakeMounter([]mount.MountPoint{{Device: "/dev/sdb", Path: target}})
+	fakeMounter.UnmountFunc = func(string) error {
+		<-release
+
+		return nil
+	}
+	m.Interface = fakeMounter
+	fakeExec, log := newScriptedExec(fakeCommand{}) // umount -l -f
+	m.Exec = fakeExec
+
+	if err := m.ForceCleanupMountPoint(target); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertCommands(t, log, []string{"umount -l -f " + target})
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("target should be removed: %v", err)
+	}
+}
+
+func TestForceCleanupMountPointFailedUnmount(t *testing.T) {
+	for name, c := range map[string]struct {
+		err error
+		// corrupted tells whether the mount point is corrupted.
+		corrupted bool
+		forced    bool
+	}{
+		"busy":             {err: &os.PathError{Op: "unmount", Err: syscall.EBUSY}},
+		"corrupted":        {err: &os.PathError{Op: "unmount", Err: syscall.EIO}, forced: true},
+		"corrupted target": {err: errors.New("unmount failed: exit status 32"), corrupted: true, forced: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := newTestMounter(t, Options{})
+			target := filepath.Join(t.TempDir(), "staging")
+			if err := os.Mkdir(target, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			fakeMounter := mount.NewFakeMounter([]mount.MountPoint{{Device: "/dev/sdb", Path: target}})
+			fakeMounter.UnmountFunc = func(string) error { return c.err }
+			m.Interface = fakeMounter
+			m.stat = func(path string) (os.FileInfo, error) {
+				if c.corrupted {
+					return nil, &os.PathError{Op: "stat", Path: path, Err: syscall.ENOTCONN}
+				}
+
+				return nil, nil
+			}
+			var commands []fakeCommand
+			expected := []string{}
+			if c.forced {
+				commands = []fakeCommand{{}} // umount -l -f
+				expected = []string{"umount -l -f " + target}
+			}
+			fakeExec, log := newScriptedExec(commands...)
+			m.Exec = fakeExec
+
+			err := m.ForceCleanupMountPoint(target)
+			assertCommands(t, log, expected)
+			if !c.forced {
+				// A busy volume is still in use: the unmount is retried later.
+				if !errors.Is(err, syscall.EBUSY) {
+					t.Errorf("expected EBUSY, got %v", err)
+				}
+				if _, err := os.Stat(target); err != nil {
+					t.Errorf("target should be kept: %v", err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestFormatAndMountInvalidMountOptions(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	// No command is scripted: nothing must run.
+	m.Exec = &exec.FakeExec{}
+
+	if err := m.FormatAndMount("/dev/sdb", "/target", "ext4", []string{"noatime", "suid"}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if mountPoints, _ := m.List(); len(mountPoints) != 0 {
+		t.Errorf("volume should not be mounted: %+v", mountPoints)
+	}
+}
+
+func TestGetDevicePathCache(t *testing.T) {
+	m := newTestMounter(t, Options{
+		CacheDevicePaths:  true,
+		DevicePathBackoff: wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 1},
+	})
+	expected := createDiskIDEntry(t, m.diskIDPath, "virtio-"+diskUUIDToSerial(testVolumeID))
+
+	path, err := m.GetDevicePath(context.Background(), testVolumeID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != expected {
+		t.Fatalf("expected %q, got %q", expected, path)
+	}
+
+	// The second lookup must not scan /dev, nor probe the volume.
+	m.diskIDPath = t.TempDir()
+	fakeExec, log := newScriptedExec()
+	m.Exec = fakeExec
+
+	path, err = m.GetDevicePath(context.Background(), testVolumeID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != expected {
+		t.Errorf("expected %q, got %q", expected, path)
+	}
+	assertCommands(t, log, []string{})
+
+	// Once the device is gone, it is looked up again.
+	if err := os.Remove(expected); err != nil {
+		t.Fatal(err)
+	}
+	m.Exec, log = newScriptedExec(fakeCommand{}, fakeCommand{}) // udevadm trigger, settle
+
+	if _, err = m.GetDevicePath(context.Background(), testVolumeID); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("expected ErrDeviceNotFound, got %v", err)
+	}
+	if len(log.cmds) != 2 {
+		t.Errorf("expected the volume to be probed, got commands %q", log.commands())
+	}
+}
+
+func TestGetDevicePathCacheSerialOnly(t *testing.T) {
+	m := newTestMounter(t, Options{CacheDevicePaths: true})
+	m.sysBlockPath = t.TempDir()
+	serial := diskUUIDToSerial(testVolumeID)
+	if err := os.MkdirAll(filepath.Join(m.sysBlockPath, "vdb"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(m.sysBlockPath, "vdb", "serial"), []byte(serial), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// vdb may be the name of another volume once this one is detached.
+	path, err := m.GetDevicePathByDeviceID(context.Background(), testVolumeID, "1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "/dev/vdb" {
+		t.Fatalf("expected /dev/vdb, got %s", path)
+	}
+	if path, ok := m.devicePaths.get(testVolumeID); ok {
+		t.Errorf("expected no cached device path, got %s", path)
+	}
+
+	byID := createDiskIDEntry(t, m.diskIDPath, "virtio-"+serial)
+	if _, err := m.GetDevicePath(context.Background(), testVolumeID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path, ok := m.devicePaths.get(testVolumeID); !ok || path != byID {
+		t.Errorf("expected %s to be cached, got %q", byID, path)
+	}
+}
+
+func TestUnstageEvictsDevicePath(t *testing.T) {
+	for name, device := range map[string]func(m *mounter, byID string) string{
+		"device":      func(_ *mounter, byID string) string { return byID },
+		"LUKS device": func(m *mounter, _ string) string { return m.EncryptedDevicePath(testVolumeID) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := newTestMounter(t, Options{CacheDevicePaths: true})
+			m.mapperPath = t.TempDir()
+			byID := createDiskIDEntry(t, m.diskIDPath, "virtio-"+diskUUIDToSerial(testVolumeID))
+			if _, err := m.GetDevicePath(context.Background(), testVolumeID); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			target := t.TempDir()
+			m.Interface = mount.NewFakeMounter([]mount.MountPoint{{Device: device(m, byID), Path: target}})
+			fakeExec, _ := newScriptedExec(fakeCommand{}) // cryptsetup luksClose
+			m.Exec = fakeExec
+
+			if err := m.Unstage(target); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if path, ok := m.devicePaths.get(testVolumeID); ok {
+				t.Errorf("expected the device path to be evicted, got %s", path)
+			}
+		})
+	}
+}
+
+func TestIsCorruptedMnt(t *testing.T) {
+	m := newTestMounter(t, Options{})
+
+	for err, expected := range map[error]bool{
+		&os.PathError{Op: "stat", Path: "/target", Err: syscall.ESTALE}:   true,
+		&os.PathError{Op: "stat", Path: "/target", Err: syscall.EIO}:      true,
+		&os.PathError{Op: "stat", Path: "/target", Err: syscall.ENOTCONN}: true,
+		&os.PathError{Op: "stat", Path: "/target", Err: syscall.ENOENT}:   false,
+		errors.New("mount failed"):                                        false,
+	} {
+		if got := m.IsCorruptedMnt(err); got != expected {
+			t.Errorf("%v: expected %t, got %t", err, expected, got)
+		}
+	}
+}
+
+func TestGetDiskUUID(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	fakeExec, log := newScriptedExec(
+		fakeCommand{output: "4b3c6d9e-0d4a-4c8e-9d6a-2f1e7b5a3c21\n"},
+		fakeCommand{err: exec.FakeExitError{Status: 2}}, // blank device
+	)
+	m.Exec = fakeExec
+
+	for _, expected := range []string{"4b3c6d9e-0d4a-4c8e-9d6a-2f1e7b5a3c21", ""} {
+		uuid, err := m.GetDiskUUID("/dev/sdb")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if uuid != expected {
+			t.Errorf("expected %q, got %q", expected, uuid)
+		}
+	}
+	assertCommands(t, log, []string{
+		"blkid -p -s UUID -o value /dev/sdb",
+		"blkid -p -s UUID -o value /dev/sdb",
+	})
+}
+
+func TestCheckDiskUUID(t *testing.T) {
+	const uuid = "4b3c6d9e-0d4a-4c8e-9d6a-2f1e7b5a3c21"
+
+	for name, c := range map[string]struct {
+		expectedUUID string
+		blkid        []fakeCommand
+		valid        bool
+	}{
+		"match":            {expectedUUID: uuid, blkid: []fakeCommand{{output: uuid + "\n"}}, valid: true},
+		"case-insensitive": {expectedUUID: strings.ToUpper(uuid), blkid: []fakeCommand{{output: uuid + "\n"}}, valid: true},
+		"mismatch":         {expectedUUID: uuid, blkid: []fakeCommand{{output: "0a1b2c3d-0000-4000-8000-000000000000\n"}}},
+		"blank device":     {expectedUUID: uuid, blkid: []fakeCommand{{err: exec.FakeExitError{Status: 2}}}},
+		"blkid failure":    {expectedUUID: uuid, blkid: []fakeCommand{{err: exec.FakeExitError{Status: 4}}}},
+		"no expectation":   {valid: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := newTestMounter(t, Options{})
+			m.Exec, _ = newScriptedExec(c.blkid...)
+
+			err := m.CheckDiskUUID("/dev/sdb", c.expectedUUID)
+			if c.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if !c.valid && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestDiskUUIDToSerial(t *testing.T) {
+	for _, c := range []struct {
+		uuid     string
+		expected string
+	}{
+		{"ace9f28b-3081-40c1-8353-4cc3e3014072", "ace9f28b308140c18353"},
+		{"ACE9F28B-3081-40C1-8353-4CC3E3014072", "ace9f28b308140c18353"},
+		{"Ace9F28b-3081-40c1-8353-4cc3E3014072", "ace9f28b308140c18353"},
+		{"ace9f28b308140c183534cc3e3014072", "ace9f28b308140c18353"},
+		{" ace9f28b-3081-40c1-8353-4cc3e3014072\n", "ace9f28b308140c18353"},
+		{"ace9f28b-3081-40c1-8353", "ace9f28b308140c18353"},
+		{"ace9f28b-3081", "ace9f28b3081"},
+		{"ACE9F28B", "ace9f28b"},
+		{"", ""},
+	} {
+		if got := diskUUIDToSerial(c.uuid); got != c.expected {
+			t.Errorf("diskUUIDToSerial(%q): expected %q, got %q", c.uuid, c.expected, got)
+		}
+	}
+}
+
+func TestGetDeviceName(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	staging := t.TempDir()
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(staging, link); err != nil {
+		t.Fatal(err)
+	}
+	m.Interface = mount.NewFakeMounter([]mount.MountPoint{
+		{Device: "/dev/sdb", Path: staging, Type: "ext4"},
+		// Bind mount of the staged volume.
+		{Device: "/dev/sdb", Path: "/publish/fs", Type: "ext4"},
+		// Bind mounts of raw block volumes.
+		{Device: "udev", Path: "/publish/block", Type: "devtmpfs"},
+		{Device: "udev", Path: "/publish/block2", Type: "devtmpfs"},
+		// Bind mounts of a device file recreated by udev in between.
+		{Device: "udev", Path: "/publish/block3", Type: "devtmpfs"},
+		{Device: "udev", Path: "/publish/block4", Type: "devtmpfs"},
+		{Device: "udev", Path: "/dev", Type: "devtmpfs"},
+	})
+	m.mountInfoPath = filepath.Join(t.TempDir(), "mountinfo")
+	mountInfo := `25 1 253:1 / / rw,relatime shared:1 - ext4 /dev/vda1 rw
+26 25 0:5 / /dev rw,nosuid shared:2 - devtmpfs udev rw,mode=755
+3252 25 0:5 /sdc /publish/block rw,nosuid shared:2 - devtmpfs udev rw,mode=755
+3253 25 0:5 /sdd /publish/block2 rw,nosuid shared:2 - devtmpfs udev rw,mode=755
+3254 25 0:5 /sde//deleted /publish/block3 rw,nosuid shared:2 - devtmpfs udev rw,mode=755
+3255 25 0:5 /sde /publish/block4 rw,nosuid shared:2 - devtmpfs udev rw,mode=755
+`
+	if err := os.WriteFile(m.mountInfoPath, []byte(mountInfo), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expected := range map[string]struct {
+		device   string
+		refCount int
+	}{
+		staging:           {"/dev/sdb", 2},
+		link:              {"/dev/sdb", 2},
+		"/publish/fs":     {"/dev/sdb", 2},
+		"/publish/block":  {"/dev/sdc", 1},
+		"/publish/block3": {"/dev/sde", 2},
+		"/publish/block4": {"/dev/sde", 2},
+		"/not/mounted":    {"", 0},
+	} {
+		device, refCount, err := m.GetDeviceName(path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", path, err)
+
+			continue
+		}
+		if device != expected.device || refCount != expected.refCount {
+			t.Errorf("%s: expected %s (%d references), got %s (%d references)", path, expected.device, expected.refCount, device, refCount)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package mount
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// blockedMountOptions are the mount options refused by ValidateMountOptions:
+//   - suid and dev would let users of the volume gain privileges
+//     on the node through setuid binaries or device files;
+//   - bind, rbind, move and remount change what is mounted, instead
+//     of how the volume is mounted.
+var blockedMountOptions = []string{"suid", "dev", "bind", "rbind", "move", "remount"}
+
+// conflictingMountOptions maps mount options to the option
+// cancelling them. Only the last of two conflicting options is kept.
+var conflictingMountOptions = map[string]string{
+	"ro":         "rw",
+	"rw":         "ro",
+	"sync":       "async",
+	"async":      "sync",
+	"atime":      "noatime",
+	"noatime":    "atime",
+	"exec":       "noexec",
+	"noexec":     "exec",
+	"diratime":   "nodiratime",
+	"nodiratime": "diratime",
+}
+
+// invalidMountOptionChars are the characters refused in mount options.
+// A comma would smuggle extra options, and the others have no use in
+// a mount option but to abuse a shell.
+const invalidMountOptionChars = ",;|&$`<>\\\"' \t\n\x00"
+
+// ValidateMountOptions checks the mount options of a volume. It refuses
+// the options listed in blockedMountOptions and the options containing
+// characters from invalidMountOptionChars, except for the quotes and
+// commas of valid SELinux context options. The other options, including
+// the ones unknown to the driver, are returned verbatim and in the same
+// order, as some filesystems are sensitive to it: only the repeated
+// options are dropped and, of two conflicting options such as ro and
+// rw, only the last one is kept, which is the one mount would apply.
+func ValidateMountOptions(options []string) ([]string, error) {
+	validated := make([]string, 0, len(options))
+	for _, o := range options {
+		if o == "" {
+			continue
+		}
+		if name, label, ok := parseSELinuxContextOption(o); ok {
+			if !isValidSELinuxContextOption(o, name, label) {
+				return nil, fmt.Errorf("invalid SELinux mount option %q", o)
+			}
+			validated = slices.DeleteFunc(validated, func(v string) bool {
+				return strings.HasPrefix(v, name+"=")
+			})
+			validated = append(validated, o)
+
+			continue
+		}
+		if strings.ContainsAny(o, invalidMountOptionChars) {
+			return nil, fmt.Errorf("invalid mount option %q: forbidden character", o)
+		}
+		if slices.Contains(blockedMountOptions, o) {
+			return nil, fmt.Errorf("mount option %q is not allowed", o)
+		}
+
+		if slices.Contains(validated, o) {
+			continue
+		}
+		validated = slices.DeleteFunc(validated, func(v string) bool {
+			return v == conflictingMountOptions[o]
+		})
+		validated = append(validated, o)
+	}
+
+	return validated, nil
+}
@@ -0,0 +1,55 @@
+package mount
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateMountOptions(t *testing.T) {
+	for name, c := range map[string]struct {
+		options  []string
+		expected []string
+	}{
+		"none":                 {options: nil, expected: []string{}},
+		"valid":                {options: []string{"noatime", "discard", "errors=remount-ro"}, expected: []string{"noatime", "discard", "errors=remount-ro"}},
+		"empty entries":        {options: []string{"", "noatime", ""}, expected: []string{"noatime"}},
+		"duplicates":           {options: []string{"noatime", "discard", "noatime"}, expected: []string{"noatime", "discard"}},
+		"order kept":           {options: []string{"nconnect=4", "vers=4.1", "_netdev", "noatime", "x-systemd.requires=network-online.target"}, expected: []string{"nconnect=4", "vers=4.1", "_netdev", "noatime", "x-systemd.requires=network-online.target"}},
+		"ro rw ro":             {options: []string{"ro", "discard", "rw", "ro"}, expected: []string{"discard", "ro"}},
+		"ro then rw":           {options: []string{"ro", "noexec", "rw"}, expected: []string{"noexec", "rw"}},
+		"rw then ro":           {options: []string{"rw", "ro"}, expected: []string{"ro"}},
+		"atime conflict":       {options: []string{"atime", "sync", "noatime", "async"}, expected: []string{"noatime", "async"}},
+		"key=value kept as is": {options: []string{"context=system_u:object_r:container_file_t:s0"}, expected: []string{"context=system_u:object_r:container_file_t:s0"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := ValidateMountOptions(c.options)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("expected %q, got %q", c.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidateMountOptionsInvalid(t *testing.T) {
+	for name, options := range map[string][]string{
+		"comma":       {"noatime,suid"},
+		"semicolon":   {"noatime;reboot"},
+		"command":     {"$(reboot)"},
+		"space":       {"noatime suid"},
+		"newline":     {"noatime\nsuid"},
+		"suid":        {"noatime", "suid"},
+		"dev":         {"dev"},
+		"bind":        {"bind"},
+		"remount":     {"remount"},
+		"after valid": {"noatime", "discard", "move"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got, err := ValidateMountOptions(options); err == nil {
+				t.Errorf("expected an error, got %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package mount
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// ReleaseOrphanedDevices looks for the devices of the volumes which are
+// not in activeVolumeIDs: disk symlinks in /dev/disk/by-id, and the LUKS
+// devices opened by the driver. It returns their paths.
+//
+// When clean is true, the orphaned LUKS devices which are not mounted are
+// closed, and the symlinks left behind by detached volumes are removed.
+func (m *mounter) ReleaseOrphanedDevices(activeVolumeIDs []string, clean bool) ([]string, error) {
+	serials := make([]string, 0, len(activeVolumeIDs))
+	for _, id := range activeVolumeIDs {
+		serials = append(serials, m.diskSerial(id))
+	}
+	isOrphan := func(name string) bool {
+		return !slices.ContainsFunc(serials, func(serial string) bool { return strings.Contains(name, serial) })
+	}
+	isOrphanMapper := func(name string) bool {
+		return !slices.ContainsFunc(activeVolumeIDs, func(id string) bool { return name == luksMapperName(id) })
+	}
+
+	var orphans []string
+
+	entries, err := os.ReadDir(m.diskIDPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", m.diskIDPath, err)
+	}
+	for _, e := range entries {
+		if !m.isVolumeDiskID(e.Name()) || !isOrphan(e.Name()) {
+			continue
+		}
+		path := filepath.Join(m.diskIDPath, e.Name())
+		orphans = append(orphans, path)
+		klog.InfoS("Found orphaned device", "path", path)
+
+		// Symlinks to devices still present are removed by udev on detach.
+		if _, err := os.Stat(path); clean && os.IsNotExist(err) {
+			klog.InfoS("Removing dangling symlink", "path", path)
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return orphans, fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+		}
+	}
+
+	mappers, err := os.ReadDir(m.mapperPath)
+	if err != nil && !os.IsNotExist(err) {
+		return orphans, fmt.Errorf("failed to list %s: %w", m.mapperPath, err)
+	}
+	for _, e := range mappers {
+		if !strings.HasPrefix(e.Name(), luksMapperPrefix) || !isOrphanMapper(e.Name()) {
+			continue
+		}
+		path := filepath.Join(m.mapperPath, e.Name())
+		orphans = append(orphans, path)
+		klog.InfoS("Found orphaned LUKS device", "path", path)
+
+		if !clean {
+			continue
+		}
+		mounted, err := m.isDeviceMounted(path)
+		if err != nil {
+			return orphans, err
+		}
+		if mounted {
+			klog.InfoS("Orphaned LUKS device is still mounted, leaving it open", "path", path)
+
+			continue
+		}
+		if err := m.closeEncryptedDevice(path); err != nil {
+			return orphans, err
+		}
+	}
+
+	return orphans, nil
+}
+
+// isVolumeDiskID tells whether the /dev/disk/by-id entry name
+// may belong to a volume.
+func (m *mounter) isVolumeDiskID(name string) bool {
+	if strings.HasPrefix(name, nvmeIDPrefix) {
+		return true
+	}
+	if m.multipath && strings.HasPrefix(name, multipathIDPrefix) {
+		return true
+	}
+
+	return slices.ContainsFunc(m.diskIDPrefixes, func(prefix string) bool { return strings.HasPrefix(name, prefix) })
+}
+
+// isDeviceMounted tells whether device is mounted anywhere.
+func (m *mounter) isDeviceMounted(device string) (bool, error) {
+	mountPoints, err := m.List()
+	if err != nil {
+		return false, fmt.Errorf("failed to list mount points: %w", err)
+	}
+	for _, mp := range mountPoints {
+		if mp.Device == device {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
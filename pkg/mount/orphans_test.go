@@ -0,0 +1,90 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/mount-utils"
+	exec "k8s.io/utils/exec/testing"
+)
+
+const orphanVolumeID = "5e0d3c1a-9b7f-4e2d-8c6a-1f2e3d4c5b6a"
+
+// createOrphans creates the by-id entries and LUKS devices of an active
+// volume, and of an orphaned one whose disk was detached. It returns
+// the paths of the orphaned devices.
+func createOrphans(t *testing.T, m *mounter) []string {
+	t.Helper()
+
+	m.mapperPath = t.TempDir()
+	active := "virtio-" + diskUUIDToSerial(testVolumeID)
+	orphan := "virtio-" + diskUUIDToSerial(orphanVolumeID)
+
+	createDiskIDEntry(t, m.diskIDPath, active)
+	// Not a volume.
+	createDiskIDEntry(t, m.diskIDPath, "wwn-0x5000c500a1b2c3d4")
+	// Left behind by the detached volume.
+	orphanLink := filepath.Join(m.diskIDPath, orphan)
+	if err := os.Symlink("../../vdz", orphanLink); err != nil {
+		t.Fatal(err)
+	}
+	createDiskIDEntry(t, m.mapperPath, luksMapperName(testVolumeID))
+	orphanMapper := createDiskIDEntry(t, m.mapperPath, luksMapperName(orphanVolumeID))
+
+	return []string{orphanLink, orphanMapper}
+}
+
+func TestReleaseOrphanedDevicesReport(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	expected := createOrphans(t, m)
+	// No command is scripted: nothing must run.
+	m.Exec = &exec.FakeExec{}
+
+	orphans, err := m.ReleaseOrphanedDevices([]string{testVolumeID}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(orphans, expected) {
+		t.Errorf("expected orphans %q, got %q", expected, orphans)
+	}
+	if _, err := os.Lstat(expected[0]); err != nil {
+		t.Errorf("orphans must not be removed without clean: %v", err)
+	}
+}
+
+func TestReleaseOrphanedDevicesClean(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	expected := createOrphans(t, m)
+	fakeExec, log := newScriptedExec(fakeCommand{}) // cryptsetup luksClose
+	m.Exec = fakeExec
+
+	orphans, err := m.ReleaseOrphanedDevices([]string{testVolumeID}, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(orphans, expected) {
+		t.Errorf("expected orphans %q, got %q", expected, orphans)
+	}
+
+	if _, err := os.Lstat(expected[0]); !os.IsNotExist(err) {
+		t.Errorf("dangling symlink should be removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(m.diskIDPath, "virtio-"+diskUUIDToSerial(testVolumeID))); err != nil {
+		t.Errorf("active device should be kept: %v", err)
+	}
+	assertCommands(t, log, []string{"cryptsetup luksClose " + filepath.Base(expected[1])})
+}
+
+func TestReleaseOrphanedDevicesMounted(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	expected := createOrphans(t, m)
+	m.Interface = mount.NewFakeMounter([]mount.MountPoint{{Device: expected[1], Path: "/staging"}})
+	// No command is scripted: the mounted LUKS device must stay open.
+	m.Exec = &exec.FakeExec{}
+
+	if _, err := m.ReleaseOrphanedDevices([]string{testVolumeID}, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
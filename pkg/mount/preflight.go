@@ -0,0 +1,34 @@
+package mount
+
+import (
+	"fmt"
+	"os"
+)
+
+// Preflight checks that the node provides what the mounter needs to
+// stage volumes of the given filesystem types: the commands it runs and
+// the directory of the disk symlinks. It returns one error per missing
+// item, telling how to fix it.
+func (m *mounter) Preflight(fsTypes []string) []error {
+	var errs []error
+	lookPath := func(cmd, hint string) {
+		if _, err := m.Exec.LookPath(cmd); err != nil {
+			errs = append(errs, fmt.Errorf("%s not found in $PATH: %s", cmd, hint))
+		}
+	}
+
+	lookPath("blkid", "install util-linux, it is required to detect the filesystem of the volumes")
+	lookPath("blockdev", "install util-linux, it is required to get the size of the volumes")
+	if m.udevadmPath != "" {
+		lookPath(m.udevadmPath, "install udev, set --udevadm-path, or disable it with --disable-udevadm")
+	}
+	for _, fsType := range fsTypes {
+		lookPath("mkfs."+fsType, fmt.Sprintf("install the tools of %s, or do not use it for the volumes", fsType))
+	}
+
+	if info, err := os.Stat(m.diskIDPath); err != nil || !info.IsDir() {
+		errs = append(errs, fmt.Errorf("directory %s not found: mount the /dev directory of the host, or set --disk-id-path", m.diskIDPath))
+	}
+
+	return errs
+}
@@ -0,0 +1,76 @@
+package mount
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/mount-utils"
+	kexec "k8s.io/utils/exec"
+	exec "k8s.io/utils/exec/testing"
+)
+
+func TestPreflight(t *testing.T) {
+	newPreflightMounter := func(t *testing.T, missing ...string) *mounter {
+		t.Helper()
+
+		m := newTestMounter(t, Options{})
+		m.SafeFormatAndMount = &mount.SafeFormatAndMount{
+			Interface: mount.NewFakeMounter([]mount.MountPoint{}),
+			Exec: &exec.FakeExec{
+				DisableScripts: true,
+				LookPathFunc: func(file string) (string, error) {
+					for _, cmd := range missing {
+						if file == cmd {
+							return "", kexec.ErrExecutableNotFound
+						}
+					}
+
+					return "/usr/sbin/" + file, nil
+				},
+			},
+		}
+
+		return m
+	}
+
+	t.Run("all present", func(t *testing.T) {
+		m := newPreflightMounter(t)
+		if errs := m.Preflight([]string{"ext4", "xfs"}); len(errs) != 0 {
+			t.Errorf("unexpected errors: %v", errs)
+		}
+	})
+
+	t.Run("missing commands", func(t *testing.T) {
+		m := newPreflightMounter(t, "blkid", "udevadm", "mkfs.xfs")
+		errs := m.Preflight([]string{"ext4", "xfs"})
+		if len(errs) != 3 {
+			t.Fatalf("expected 3 errors, got %v", errs)
+		}
+		for i, expected := range []string{"blkid not found", "udevadm not found", "mkfs.xfs not found"} {
+			if !strings.Contains(errs[i].Error(), expected) {
+				t.Errorf("error %d: expected %q, got %v", i, expected, errs[i])
+			}
+		}
+		if !strings.Contains(errs[1].Error(), "--disable-udevadm") {
+			t.Errorf("expected the udevadm error to mention --disable-udevadm, got %v", errs[1])
+		}
+	})
+
+	t.Run("udevadm disabled", func(t *testing.T) {
+		m := newPreflightMounter(t, "udevadm")
+		m.udevadmPath = ""
+		if errs := m.Preflight(nil); len(errs) != 0 {
+			t.Errorf("unexpected errors: %v", errs)
+		}
+	})
+
+	t.Run("missing disk id directory", func(t *testing.T) {
+		m := newPreflightMounter(t)
+		m.diskIDPath = filepath.Join(t.TempDir(), "by-id")
+		errs := m.Preflight(nil)
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), m.diskIDPath) {
+			t.Errorf("expected an error about %s, got %v", m.diskIDPath, errs)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package mount
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	xfsQuotaCmd = "xfs_quota"
+
+	// prjQuotaOption is the xfs mount option enabling project quotas.
+	prjQuotaOption = "prjquota"
+)
+
+// MountWithProjectQuota mounts source at target. For xfs filesystems,
+// project quotas are enabled and the usage of target is limited to
+// quotaBytes, accounted under projectID. Other filesystems are mounted
+// without any quota.
+func (m *mounter) MountWithProjectQuota(source, target, fstype string, options []string, projectID uint32, quotaBytes int64) error {
+	if fstype != "xfs" {
+		return m.Mount(source, target, fstype, options)
+	}
+
+	if m.skipDryRun("mount with project quota", "source", source, "target", target, "options", options, "projectID", projectID, "quotaBytes", quotaBytes) {
+		return nil
+	}
+
+	if _, err := m.Exec.LookPath(xfsQuotaCmd); err != nil {
+		return fmt.Errorf("%s is required to enforce project quotas: %w", xfsQuotaCmd, err)
+	}
+
+	if err := m.Mount(source, target, fstype, projectQuotaOptions(options)); err != nil {
+		return err
+	}
+
+	return m.setProjectQuota(target, projectID, quotaBytes)
+}
+
+// projectQuotaOptions returns options with project quotas enabled.
+func projectQuotaOptions(options []string) []string {
+	if slices.Contains(options, prjQuotaOption) {
+		return options
+	}
+
+	return append(slices.Clone(options), prjQuotaOption)
+}
+
+// setProjectQuota assigns the directory tree at path to projectID,
+// and sets the hard block limit of the project to quotaBytes.
+func (m *mounter) setProjectQuota(path string, projectID uint32, quotaBytes int64) error {
+	id := strconv.FormatUint(uint64(projectID), 10)
+
+	klog.V(4).InfoS("Setting project quota", "path", path, "projectID", projectID, "quotaBytes", quotaBytes)
+	for _, command := range []string{
+		fmt.Sprintf("project -s -p %s %s", path, id),
+		fmt.Sprintf("limit -p bhard=%d %s", quotaBytes, id),
+	} {
+		if output, err := m.Exec.Command(xfsQuotaCmd, "-x", "-c", command, path).CombinedOutput(); err != nil {
+			return fmt.Errorf("%s %q on %s failed: %w, output: %s", xfsQuotaCmd, command, path, err, output)
+		}
+	}
+
+	return nil
+}
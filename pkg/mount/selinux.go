@@ -0,0 +1,67 @@
+package mount
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// selinuxContextOptions are the mount options setting SELinux labels.
+var selinuxContextOptions = []string{"context", "fscontext", "defcontext", "rootcontext"}
+
+// selinuxLabelRegexp matches SELinux labels such as
+// system_u:object_r:container_file_t:s0:c12,c34.
+var selinuxLabelRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+(:[A-Za-z0-9_.,-]+){2,4}$`)
+
+// SELinuxMountOption returns the context mount option giving the
+// SELinux label to all the files of a volume. The label is quoted, as
+// it may contain commas, which would otherwise separate mount options.
+func SELinuxMountOption(label string) (string, error) {
+	if !selinuxLabelRegexp.MatchString(label) {
+		return "", fmt.Errorf("invalid SELinux label %q", label)
+	}
+
+	return fmt.Sprintf("context=%q", label), nil
+}
+
+// WithSELinuxLabel returns options with the context mount option set
+// to label, replacing any previous context option. The options are
+// returned unchanged when label is empty.
+func WithSELinuxLabel(options []string, label string) ([]string, error) {
+	if label == "" {
+		return options, nil
+	}
+	contextOption, err := SELinuxMountOption(label)
+	if err != nil {
+		return nil, err
+	}
+
+	options = slices.DeleteFunc(slices.Clone(options), func(o string) bool {
+		return strings.HasPrefix(o, "context=")
+	})
+
+	return append(options, contextOption), nil
+}
+
+// parseSELinuxContextOption splits a SELinux context mount option, as
+// set by WithSELinuxLabel or by the kubelet, into its name and label.
+// ok is false if option is not such an option.
+func parseSELinuxContextOption(option string) (name, label string, ok bool) {
+	name, value, found := strings.Cut(option, "=")
+	if !found || !slices.Contains(selinuxContextOptions, name) {
+		return "", "", false
+	}
+
+	return name, strings.Trim(value, `"`), true
+}
+
+// isValidSELinuxContextOption tells whether option, parsed into name and
+// label, is well-formed. Labels with commas must be quoted.
+func isValidSELinuxContextOption(option, name, label string) bool {
+	if !selinuxLabelRegexp.MatchString(label) {
+		return false
+	}
+
+	return option == fmt.Sprintf("%s=%q", name, label) || !strings.Contains(label, ",") && option == name+"="+label
+}
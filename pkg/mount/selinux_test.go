@@ -0,0 +1,80 @@
+package mount
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWithSELinuxLabel(t *testing.T) {
+	for name, c := range map[string]struct {
+		options  []string
+		label    string
+		expected []string
+	}{
+		"no label": {
+			options:  []string{"noatime"},
+			expected: []string{"noatime"},
+		},
+		"label": {
+			options:  []string{"noatime"},
+			label:    "system_u:object_r:container_file_t:s0:c12,c34",
+			expected: []string{"noatime", `context="system_u:object_r:container_file_t:s0:c12,c34"`},
+		},
+		"replaced label": {
+			options:  []string{`context="system_u:object_r:container_file_t:s0:c1,c2"`, "noatime"},
+			label:    "system_u:object_r:container_file_t:s0",
+			expected: []string{"noatime", `context="system_u:object_r:container_file_t:s0"`},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := WithSELinuxLabel(c.options, c.label)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Errorf("expected %q, got %q", c.expected, got)
+			}
+
+			// The options must go through the validation untouched.
+			validated, err := ValidateMountOptions(got)
+			if err != nil {
+				t.Fatalf("unexpected validation error: %v", err)
+			}
+			if !reflect.DeepEqual(validated, c.expected) {
+				t.Errorf("validation changed the options to %q", validated)
+			}
+		})
+	}
+}
+
+func TestWithSELinuxLabelInvalid(t *testing.T) {
+	for _, label := range []string{
+		"container_file_t",
+		`system_u:object_r:container_file_t:s0" ,suid`,
+		"system_u:object_r:container_file_t:s0;reboot",
+		"system_u:object_r:container file_t:s0",
+	} {
+		if got, err := WithSELinuxLabel(nil, label); err == nil {
+			t.Errorf("%q: expected an error, got %q", label, got)
+		}
+	}
+}
+
+func TestValidateMountOptionsSELinux(t *testing.T) {
+	for option, valid := range map[string]bool{
+		`context="system_u:object_r:container_file_t:s0:c12,c34"`:   true,
+		`fscontext="system_u:object_r:container_file_t:s0:c12,c34"`: true,
+		"context=system_u:object_r:container_file_t:s0":             true,
+		"context=system_u:object_r:container_file_t:s0:c12,c34":     false,
+		`context="system_u:object_r:container_file_t:s0",suid`:      false,
+		`context="system_u:object_r:container_file_t:s0;reboot"`:    false,
+		`context="container_file_t"`:                                false,
+	} {
+		_, err := ValidateMountOptions([]string{option})
+		if valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", option, err)
+		} else if !valid && err == nil {
+			t.Errorf("%s: expected an error", option)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package mount
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Hypervisors whose disk serials are known, as named by CloudStack.
+const (
+	HypervisorKVM       = "kvm"
+	HypervisorXenServer = "xenserver"
+)
+
+// SerialFunc translates a CloudStack volume ID to the serial of its disk
+// in the VM, which depends on the hypervisor.
+type SerialFunc func(volumeID string) string
+
+// SerialFuncs are the serial translations of the supported hypervisors,
+// by lowercase hypervisor name. KVM is used when the hypervisor is not
+// known.
+var SerialFuncs = map[string]SerialFunc{
+	HypervisorKVM:       diskUUIDToSerial,
+	HypervisorXenServer: xenDiskUUIDToSerial,
+}
+
+// xenDiskUUIDToSerial translates a volume UUID to its disk serial with
+// XenServer: as with KVM, without hyphens and lowercased, but not
+// truncated, the 20 characters limit being the one of virtio.
+func xenDiskUUIDToSerial(uuid string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(uuid), "-", ""))
+}
+
+// serialFuncFor returns the serial translation of the given hypervisor,
+// the one of KVM when empty.
+func serialFuncFor(hypervisor string) (SerialFunc, error) {
+	if hypervisor == "" {
+		hypervisor = HypervisorKVM
+	}
+	serial, ok := SerialFuncs[strings.ToLower(hypervisor)]
+	if !ok {
+		names := make([]string, 0, len(SerialFuncs))
+		for name := range SerialFuncs {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		return nil, fmt.Errorf("unsupported hypervisor %q, supported: %v", hypervisor, names)
+	}
+
+	return serial, nil
+}
+
+// SetHypervisor selects the serial translation used to find the disks of
+// the volumes, e.g. once the hypervisor of the node is known.
+func (m *mounter) SetHypervisor(hypervisor string) error {
+	serial, err := serialFuncFor(hypervisor)
+	if err != nil {
+		return err
+	}
+	m.serial.Store(&serial)
+
+	return nil
+}
+
+// serialFunc returns the serial translation of the given hypervisor, the
+// one of the volume, or the one of the node when empty or not supported.
+func (m *mounter) serialFunc(hypervisor string) SerialFunc {
+	if hypervisor != "" {
+		if serial, err := serialFuncFor(hypervisor); err == nil {
+			return serial
+		}
+	}
+	if serial := m.serial.Load(); serial != nil {
+		return *serial
+	}
+
+	return diskUUIDToSerial
+}
+
+// diskSerial returns the disk serial of the volume with the hypervisor of
+// the node.
+func (m *mounter) diskSerial(volumeID string) string {
+	return m.serialFunc("")(volumeID)
+}
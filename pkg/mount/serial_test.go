@@ -0,0 +1,88 @@
+package mount
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestSerialFuncs(t *testing.T) {
+	cases := []struct {
+		hypervisor string
+		expected   string
+	}{
+		{"", "ace9f28b308140c18353"},
+		{"kvm", "ace9f28b308140c18353"},
+		{"KVM", "ace9f28b308140c18353"},
+		{"XenServer", "ace9f28b308140c183534cc3e3014072"},
+	}
+	for _, c := range cases {
+		t.Run(c.hypervisor, func(t *testing.T) {
+			serial, err := serialFuncFor(c.hypervisor)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := serial("ACE9F28B-3081-40C1-8353-4CC3E3014072"); got != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, got)
+			}
+		})
+	}
+
+	if _, err := serialFuncFor("VMware"); err == nil {
+		t.Error("expected an error for an unsupported hypervisor")
+	}
+}
+
+func TestGetDevicePathHypervisor(t *testing.T) {
+	m := newTestMounter(t, Options{Hypervisor: HypervisorXenServer})
+	m.devicePathBackoff = wait.Backoff{Duration: time.Millisecond, Steps: 1}
+	expected := createDiskIDEntry(t, m.diskIDPath, "scsi-"+xenDiskUUIDToSerial(testVolumeID))
+
+	path, err := m.GetDevicePath(context.Background(), testVolumeID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != expected {
+		t.Errorf("expected device path %s, got %s", expected, path)
+	}
+
+	// Back to KVM, the truncated serial is looked up.
+	if err := m.SetHypervisor(HypervisorKVM); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := m.GetDevicePath(context.Background(), testVolumeID); err == nil {
+		t.Error("expected an error, the device has the serial of XenServer")
+	}
+
+	if err := m.SetHypervisor("VMware"); err == nil {
+		t.Error("expected an error for an unsupported hypervisor")
+	}
+	if serial := m.diskSerial(testVolumeID); serial != diskUUIDToSerial(testVolumeID) {
+		t.Errorf("expected the KVM serial to be kept, got %q", serial)
+	}
+}
+
+func TestGetDevicePathVolumeHypervisor(t *testing.T) {
+	m := newTestMounter(t, Options{})
+	m.devicePathBackoff = wait.Backoff{Duration: time.Millisecond, Steps: 1}
+	expected := createDiskIDEntry(t, m.diskIDPath, "scsi-"+xenDiskUUIDToSerial(testVolumeID))
+
+	path, err := m.GetDevicePathByDeviceID(context.Background(), testVolumeID, "", "XenServer")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != expected {
+		t.Errorf("expected device path %s, got %s", expected, path)
+	}
+	// The serials of the node are left alone.
+	if serial := m.diskSerial(testVolumeID); serial != diskUUIDToSerial(testVolumeID) {
+		t.Errorf("expected the KVM serial to be kept, got %q", serial)
+	}
+
+	// Unsupported hypervisors use the serials of the node.
+	if _, err := m.GetDevicePathByDeviceID(context.Background(), testVolumeID, "", "VMware"); err == nil {
+		t.Error("expected an error, the device has the serial of XenServer")
+	}
+}
@@ -0,0 +1,7 @@
+package mount
+
+import "go.opentelemetry.io/otel"
+
+// tracer creates the spans of the device lookups. They are only exported
+// when a tracer provider is set, e.g. with --otlp-endpoint.
+var tracer = otel.Tracer("github.com/leaseweb/cloudstack-csi-driver/pkg/mount")
@@ -0,0 +1,56 @@
+package mount
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestGetDevicePathSpan(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+	m := newTestMounter(t, Options{
+		DevicePathBackoff: wait.Backoff{Duration: 0, Factor: 1, Steps: 1},
+	})
+	m.udevadmPath = ""
+	createDiskIDEntry(t, m.diskIDPath, "virtio-"+diskUUIDToSerial(testVolumeID))
+	if _, err := m.GetDevicePath(context.Background(), testVolumeID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := m.GetDevicePath(context.Background(), "0d7107a3-94d2-44e7-89b8-8930881309a5"); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	for i, s := range spans {
+		if s.Name != "GetDevicePath" {
+			t.Errorf("expected a GetDevicePath span, got %s", s.Name)
+		}
+		if failed := s.Status.Code == codes.Error; failed != (i == 1) {
+			t.Errorf("span %d: unexpected status %v", i, s.Status)
+		}
+	}
+	if volumeID := attributeValue(spans[0].Attributes, "volume.id"); volumeID != testVolumeID {
+		t.Errorf("expected volume.id %s, got %q", testVolumeID, volumeID)
+	}
+}
+
+func attributeValue(attrs []attribute.KeyValue, key attribute.Key) string {
+	for _, a := range attrs {
+		if a.Key == key {
+			return a.Value.AsString()
+		}
+	}
+
+	return ""
+}
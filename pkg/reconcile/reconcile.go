@@ -0,0 +1,121 @@
+// Package reconcile provides the logic of the reconcile subcommand of
+// cloudstack-csi-driver.
+//
+// It finds the CloudStack volumes created by the driver whose
+// PersistentVolume no longer exists, e.g. because it was deleted with the
+// Retain reclaim policy or out-of-band, and optionally deletes them.
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud"
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/driver"
+)
+
+// DefaultMinAge is the default age under which volumes are never
+// orphans, as their PersistentVolume may not be created yet.
+const DefaultMinAge = time.Hour
+
+const pageSize = 500
+
+// Config holds the reconcile configuration.
+type Config struct {
+	// ClusterID restricts the volumes to the ones tagged with this
+	// cluster ID, as set by the controller with --cluster-id. When empty,
+	// the volumes tagged with any cluster ID are skipped.
+	ClusterID string
+
+	// MinAge is the age under which volumes are never orphans.
+	MinAge time.Duration
+
+	// DryRun only reports the orphaned volumes, without deleting them.
+	DryRun bool
+}
+
+// Run finds the orphaned volumes, and deletes them unless running in
+// dry-run mode. It returns the orphaned volumes.
+func Run(ctx context.Context, connector cloud.Interface, kubeClient kubernetes.Interface, config Config) ([]*cloud.Volume, error) {
+	logger := klog.FromContext(ctx)
+
+	volumes, err := listVolumes(ctx, connector)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list CloudStack volumes: %w", err)
+	}
+	pvs, err := kubeClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list PersistentVolumes: %w", err)
+	}
+	handles := make(map[string]struct{}, len(pvs.Items))
+	for _, pv := range pvs.Items {
+		if csi := pv.Spec.CSI; csi != nil && csi.Driver == driver.DriverName {
+			handles[csi.VolumeHandle] = struct{}{}
+		}
+	}
+
+	orphans := findOrphans(volumes, handles, config.ClusterID, config.MinAge, time.Now())
+	for _, vol := range orphans {
+		keysAndValues := []interface{}{"volumeID", vol.ID, "name", vol.Name, "pv", vol.Tags[driver.PVNameTag], "pvc", vol.Tags[driver.PVCNamespaceTag] + "/" + vol.Tags[driver.PVCNameTag]}
+		if config.DryRun {
+			logger.Info("Found orphaned volume", keysAndValues...)
+
+			continue
+		}
+		logger.Info("Deleting orphaned volume", keysAndValues...)
+		if err := connector.DeleteVolume(ctx, vol.ID); err != nil {
+			return orphans, fmt.Errorf("cannot delete volume %s: %w", vol.ID, err)
+		}
+	}
+
+	return orphans, nil
+}
+
+func listVolumes(ctx context.Context, connector cloud.Interface) ([]*cloud.Volume, error) {
+	var volumes []*cloud.Volume
+	for page := 1; ; page++ {
+		vols, total, err := connector.ListVolumes(ctx, page, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, vols...)
+		if len(vols) == 0 || len(volumes) >= total {
+			return volumes, nil
+		}
+	}
+}
+
+// findOrphans returns the volumes created by the driver, i.e. tagged with
+// their PersistentVolume or the cluster ID, which no PersistentVolume
+// refers to. Volumes attached to a VM, or younger than minAge, are never
+// orphans. Neither are the volumes tagged with another cluster ID than
+// clusterID, nor, when clusterID is empty, with any cluster ID: their
+// PersistentVolumes may be in another cluster.
+func findOrphans(volumes []*cloud.Volume, handles map[string]struct{}, clusterID string, minAge time.Duration, now time.Time) []*cloud.Volume {
+	var orphans []*cloud.Volume
+	for _, vol := range volumes {
+		if tag := vol.Tags[driver.ClusterIDTag]; tag != clusterID && (clusterID != "" || tag != "") {
+			continue
+		}
+		if vol.Tags[driver.PVNameTag] == "" && vol.Tags[driver.ClusterIDTag] == "" {
+			continue
+		}
+		if _, ok := handles[vol.ID]; ok {
+			continue
+		}
+		if vol.VirtualMachineID != "" {
+			continue
+		}
+		if minAge > 0 && (vol.CreatedAt.IsZero() || now.Sub(vol.CreatedAt) < minAge) {
+			continue
+		}
+		orphans = append(orphans, vol)
+	}
+
+	return orphans
+}
@@ -0,0 +1,105 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud"
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/cloud/fake"
+	"github.com/leaseweb/cloudstack-csi-driver/pkg/driver"
+)
+
+func TestFindOrphans(t *testing.T) {
+	now := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	old := now.Add(-24 * time.Hour)
+	volumes := []*cloud.Volume{
+		{ID: "orphan", Tags: map[string]string{driver.PVNameTag: "pvc-1"}, CreatedAt: old},
+		{ID: "bound", Tags: map[string]string{driver.PVNameTag: "pvc-2"}, CreatedAt: old},
+		{ID: "untagged", CreatedAt: old},
+		{ID: "attached", Tags: map[string]string{driver.PVNameTag: "pvc-3"}, VirtualMachineID: "vm", CreatedAt: old},
+		{ID: "recent", Tags: map[string]string{driver.PVNameTag: "pvc-4"}, CreatedAt: now.Add(-time.Minute)},
+		{ID: "other cluster", Tags: map[string]string{driver.PVNameTag: "pvc-5", driver.ClusterIDTag: "other"}, CreatedAt: old},
+		{ID: "cluster only", Tags: map[string]string{driver.ClusterIDTag: "mine"}, CreatedAt: old},
+	}
+	handles := map[string]struct{}{"bound": {}}
+
+	cases := []struct {
+		name      string
+		clusterID string
+		minAge    time.Duration
+		expected  []string
+	}{
+		// The volumes tagged with a cluster ID may belong to another cluster.
+		{"no cluster ID", "", time.Hour, []string{"orphan"}},
+		{"cluster ID", "mine", time.Hour, []string{"cluster only"}},
+		{"no minimum age", "", 0, []string{"orphan", "recent"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var ids []string
+			for _, vol := range findOrphans(volumes, handles, c.clusterID, c.minAge, now) {
+				ids = append(ids, vol.ID)
+			}
+			if len(ids) != len(c.expected) {
+				t.Fatalf("expected orphans %v, got %v", c.expected, ids)
+			}
+			for i := range ids {
+				if ids[i] != c.expected[i] {
+					t.Errorf("expected orphans %v, got %v", c.expected, ids)
+				}
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	connector := fake.New()
+	var ids []string
+	for _, pvName := range []string{"pvc-1", "pvc-2"} {
+		id, err := connector.CreateVolume(ctx, "9743fd77-0f5d-4ef9-b2f8-f194235c769c", "a1887604-237c-4212-a9cd-94620b7880fa", pvName, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := connector.CreateVolumeTags(ctx, id, map[string]string{driver.PVNameTag: pvName}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	kubeClient := kubefake.NewSimpleClientset(&corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: driver.DriverName, VolumeHandle: ids[0]},
+			},
+		},
+	})
+
+	// Dry run.
+	orphans, err := Run(ctx, connector, kubeClient, Config{DryRun: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(orphans) != 1 || orphans[0].ID != ids[1] {
+		t.Fatalf("expected volume %s to be orphaned, got %v", ids[1], orphans)
+	}
+	if _, err := connector.GetVolumeByID(ctx, ids[1]); err != nil {
+		t.Errorf("expected the orphaned volume to be kept: %v", err)
+	}
+
+	if _, err := Run(ctx, connector, kubeClient, Config{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := connector.GetVolumeByID(ctx, ids[1]); !errors.Is(err, cloud.ErrNotFound) {
+		t.Errorf("expected the orphaned volume to be deleted, got %v", err)
+	}
+	if _, err := connector.GetVolumeByID(ctx, ids[0]); err != nil {
+		t.Errorf("expected the bound volume to be kept: %v", err)
+	}
+}
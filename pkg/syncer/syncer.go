@@ -7,7 +7,9 @@ package syncer
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/apache/cloudstack-go/v2/cloudstack"
 	"k8s.io/apimachinery/pkg/labels"
@@ -69,7 +71,14 @@ func createCloudStackClient(cloudstackconfig string) (*cloudstack.CloudStackClie
 	if err != nil {
 		return nil, err
 	}
-	client := cloudstack.NewAsyncClient(config.APIURL, config.APIKey, config.SecretKey, config.VerifySSL)
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: config.TLSConfig,
+		},
+		Timeout: 60 * time.Second,
+	}
+	client := cloudstack.NewAsyncClient(config.APIURL, config.APIKey, config.SecretKey, config.VerifySSL, cloudstack.WithHTTPClient(httpClient))
 
 	return client, nil
 }
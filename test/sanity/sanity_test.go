@@ -45,6 +45,8 @@ func TestSanity(t *testing.T) {
 		Mode:     driver.AllMode,
 		Endpoint: endpoint,
 		NodeName: "node",
+
+		ReservedVolumeAttachments: driver.DefaultReservedVolumeAttachments,
 	}
 	csiDriver, err := driver.New(ctx, fake.New(), &options, mount.NewFake())
 	if err != nil {